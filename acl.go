@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+// aclFile is a JSON file of grants applied to every uploaded
+// object, for access control beyond what canned ACLs allow.
+// Example granting another account read access:
+//
+//	{
+//	  "grants": [
+//	    {
+//	      "grantee": {"type": "CanonicalUser", "id": "79a59df9..."},
+//	      "permission": "READ"
+//	    }
+//	  ]
+//	}
+//
+// Grantees of type "Group" take a "uri" and grantees of type
+// "AmazonCustomerByEmail" an "email" instead of the "id". An
+// optional "owner": {"id": "..."} is used as the policy's owner,
+// otherwise the owner of each object is kept.
+var aclFile string
+
+type aclFileGrantee struct {
+	Type  string `json:"type"`
+	ID    string `json:"id,omitempty"`
+	URI   string `json:"uri,omitempty"`
+	Email string `json:"email,omitempty"`
+}
+
+type aclFileGrant struct {
+	Grantee    aclFileGrantee `json:"grantee"`
+	Permission string         `json:"permission"`
+}
+
+type aclFileContents struct {
+	Owner *struct {
+		ID string `json:"id"`
+	} `json:"owner"`
+	Grants []aclFileGrant `json:"grants"`
+}
+
+// loadACLPolicy parses and validates an -acl-from-file
+func loadACLPolicy(path string) (*s3.AccessControlPolicy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ACL file: %w", err)
+	}
+	var contents aclFileContents
+	if err := json.Unmarshal(data, &contents); err != nil {
+		return nil, fmt.Errorf("failed to parse ACL file '%s': %w", path, err)
+	}
+	policy, err := contents.toPolicy()
+	if err != nil {
+		return nil, fmt.Errorf("ACL file '%s': %w", path, err)
+	}
+	return policy, nil
+}
+
+// toPolicy validates the contents of an ACL file
+func (c *aclFileContents) toPolicy() (*s3.AccessControlPolicy, error) {
+	if len(c.Grants) == 0 {
+		return nil, fmt.Errorf("no grants")
+	}
+	policy := &s3.AccessControlPolicy{}
+	if c.Owner != nil {
+		if c.Owner.ID == "" {
+			return nil, fmt.Errorf("owner is missing an id")
+		}
+		policy.Owner = &s3.Owner{ID: aws.String(c.Owner.ID)}
+	}
+	for i, g := range c.Grants {
+		grant, err := g.toGrant()
+		if err != nil {
+			return nil, fmt.Errorf("grant %d: %w", i, err)
+		}
+		policy.Grants = append(policy.Grants, grant)
+	}
+	return policy, nil
+}
+
+// fromGrant converts a grant of an object's ACL to the format
+// of ACL files
+func fromGrant(g *s3.Grant) aclFileGrant {
+	return aclFileGrant{
+		Grantee: aclFileGrantee{
+			Type:  aws.StringValue(g.Grantee.Type),
+			ID:    aws.StringValue(g.Grantee.ID),
+			URI:   aws.StringValue(g.Grantee.URI),
+			Email: aws.StringValue(g.Grantee.EmailAddress),
+		},
+		Permission: aws.StringValue(g.Permission),
+	}
+}
+
+func (g *aclFileGrant) toGrant() (*s3.Grant, error) {
+	switch g.Permission {
+	case s3.PermissionFullControl, s3.PermissionRead, s3.PermissionReadAcp, s3.PermissionWriteAcp:
+	default:
+		return nil, fmt.Errorf("invalid permission '%s' (expected FULL_CONTROL, READ, READ_ACP or WRITE_ACP)", g.Permission)
+	}
+	grantee := &s3.Grantee{Type: aws.String(g.Grantee.Type)}
+	switch g.Grantee.Type {
+	case s3.TypeCanonicalUser:
+		if g.Grantee.ID == "" {
+			return nil, fmt.Errorf("grantee of type %s requires an id", g.Grantee.Type)
+		}
+		grantee.ID = aws.String(g.Grantee.ID)
+	case s3.TypeGroup:
+		if g.Grantee.URI == "" {
+			return nil, fmt.Errorf("grantee of type %s requires a uri", g.Grantee.Type)
+		}
+		grantee.URI = aws.String(g.Grantee.URI)
+	case s3.TypeAmazonCustomerByEmail:
+		if g.Grantee.Email == "" {
+			return nil, fmt.Errorf("grantee of type %s requires an email", g.Grantee.Type)
+		}
+		grantee.EmailAddress = aws.String(g.Grantee.Email)
+	default:
+		return nil, fmt.Errorf("invalid grantee type '%s' (expected CanonicalUser, Group or AmazonCustomerByEmail)", g.Grantee.Type)
+	}
+	return &s3.Grant{
+		Grantee:    grantee,
+		Permission: aws.String(g.Permission),
+	}, nil
+}
+
+// putObjectACL replaces the ACL of an object with the policy.
+// A policy without an owner keeps the object's current owner,
+// which costs an extra request to look up.
+func putObjectACL(
+	client s3iface.S3API,
+	bucket *string,
+	key *string,
+	policy *s3.AccessControlPolicy,
+) error {
+	owner := policy.Owner
+	if owner == nil {
+		current, err := client.GetObjectAcl(&s3.GetObjectAclInput{
+			Bucket: bucket,
+			Key:    key,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to get ACL of '%s': %w", *key, err)
+		}
+		owner = current.Owner
+	}
+	if _, err := client.PutObjectAcl(&s3.PutObjectAclInput{
+		Bucket: bucket,
+		Key:    key,
+		AccessControlPolicy: &s3.AccessControlPolicy{
+			Owner:  owner,
+			Grants: policy.Grants,
+		},
+	}); err != nil {
+		return fmt.Errorf("failed to set ACL of '%s': %w", *key, err)
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadACLPolicy(t *testing.T) {
+	dir := t.TempDir()
+	for _, tt := range []struct {
+		contents string
+		err      string
+	}{
+		{`{"grants": [{"grantee": {"type": "CanonicalUser", "id": "abc"}, "permission": "READ"}]}`, ""},
+		{`{"grants": [{"grantee": {"type": "Group", "uri": "http://acs.amazonaws.com/groups/global/AllUsers"}, "permission": "READ"}]}`, ""},
+		{`{"owner": {"id": "me"}, "grants": [{"grantee": {"type": "AmazonCustomerByEmail", "email": "a@example.com"}, "permission": "FULL_CONTROL"}]}`, ""},
+		{`{"grants": []}`, "no grants"},
+		{`{"owner": {}, "grants": [{"grantee": {"type": "CanonicalUser", "id": "abc"}, "permission": "READ"}]}`, "owner is missing an id"},
+		{`{"grants": [{"grantee": {"type": "CanonicalUser"}, "permission": "READ"}]}`, "grant 0: grantee of type CanonicalUser requires an id"},
+		{`{"grants": [{"grantee": {"type": "Group"}, "permission": "READ"}]}`, "requires a uri"},
+		{`{"grants": [{"grantee": {"type": "Nobody", "id": "abc"}, "permission": "READ"}]}`, "invalid grantee type 'Nobody'"},
+		{`{"grants": [{"grantee": {"type": "CanonicalUser", "id": "abc"}, "permission": "WRITE"}]}`, "invalid permission 'WRITE'"},
+		{`not json`, "failed to parse"},
+	} {
+		path := filepath.Join(dir, "acl.json")
+		if err := os.WriteFile(path, []byte(tt.contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+		_, err := loadACLPolicy(path)
+		if tt.err == "" && err != nil {
+			t.Errorf("%s: %v", tt.contents, err)
+		} else if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+			t.Errorf("%s: got %v, want %q", tt.contents, err, tt.err)
+		}
+	}
+}
+
+func TestUploadACLFromFile(t *testing.T) {
+	s := newFakeS3(t, "b")
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"acl.json": `{"grants": [{"grantee": {"type": "CanonicalUser", "id": "reader"}, "permission": "READ"}]}`,
+		"up/a.txt": "a",
+	})
+	if _, err := run(t, "-acl-from-file", filepath.Join(dir, "acl.json"), filepath.Join(dir, "up"), "s3://b/"); err != nil {
+		t.Fatal(err)
+	}
+	obj := s.object("b", "a.txt")
+	if obj == nil {
+		t.Fatal("nothing was uploaded")
+	}
+	acl := string(obj.acl)
+	for _, want := range []string{"<ID>" + fakeOwner + "</ID>", "<ID>reader</ID>", "<Permission>READ</Permission>"} {
+		if !strings.Contains(acl, want) {
+			t.Errorf("ACL %s is missing %s", acl, want)
+		}
+	}
+	if puts := s.received(http.MethodPut, "acl"); len(puts) != 1 {
+		t.Errorf("the ACL was put %d times", len(puts))
+	}
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+// expandArchive uploads each file in a .tar, .tar.gz, .tgz or
+// .zip source as an object of its own beneath the destination
+// prefix, streaming it out of the archive without extracting it
+// to disk first. Directories are implied by the keys of the files
+// in them, and symlinks are skipped as S3 has nothing like them.
+var expandArchive bool
+
+// Reasons archive entries are skipped
+const (
+	skipSymlink    = "symlink"
+	skipNotRegular = "not a regular file"
+)
+
+// uploadArchive uploads the files in an archive. Entries of a zip
+// file can be read again and are retried like any other upload,
+// while those of a tar stream are only read once and can't be.
+func uploadArchive(source string, bucket string, key string) error {
+	var walk func(string, func(string, os.FileMode, func() (io.ReadCloser, error))) error
+	switch {
+	case strings.HasSuffix(source, ".zip"):
+		walk = walkZip
+	case strings.HasSuffix(source, ".tar"), strings.HasSuffix(source, ".tar.gz"), strings.HasSuffix(source, ".tgz"):
+		walk = walkTar
+	default:
+		return usageErrorf("-expand-archive requires a .tar, .tar.gz, .tgz or .zip source")
+	}
+	if lowercaseKeys {
+		// Lowercased names can collide, which a first pass over
+		// the entries finds before anything is uploaded
+		lowered := make(map[string]string)
+		var collision error
+		if err := walk(source, func(name string, mode os.FileMode, _ func() (io.ReadCloser, error)) {
+			if collision != nil || !mode.IsRegular() {
+				return
+			}
+			if entryKey, err := archiveEntryKey(name); err == nil {
+				_, collision = lowercaseKey(lowered, trimKey(entryKey))
+			}
+		}); err != nil {
+			return fmt.Errorf("failed to read archive '%s': %w", source, err)
+		}
+		if collision != nil {
+			return collision
+		}
+	}
+
+	keyPrefix := strings.TrimSuffix(key, "/")
+	uploader := newUploader(createSession())
+	prog := startProgress(0)
+	var skipped skipTally
+	var errs []error
+	numEntries := 0
+	lowered := make(map[string]string)
+
+	uploadEntry := func(name string, mode os.FileMode, open func() (io.ReadCloser, error)) {
+		switch {
+		case mode&os.ModeSymlink != 0:
+			skipped.skip(skipSymlink)
+			return
+		case mode.IsDir():
+			return
+		case !mode.IsRegular():
+			skipped.skip(skipNotRegular)
+			return
+		}
+		numEntries++
+		entryKey, err := archiveEntryKey(name)
+		if err == nil {
+			entryKey, err = lowercaseKey(lowered, trimKey(entryKey))
+		}
+		if err == nil {
+			err = uploadArchiveEntry(uploader, bucket, joinKey(keyPrefix, entryKey), name, mode, open, prog)
+		}
+		prog.fileDone(err)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	readErr := walk(source, uploadEntry)
+	prog.finish()
+	skipped.report()
+	for _, err := range errs {
+		logError(err)
+	}
+	if readErr != nil {
+		return fmt.Errorf("failed to read archive '%s': %w", source, readErr)
+	}
+	if len(errs) > 0 {
+		return &batchError{failed: len(errs), total: numEntries, what: "uploads"}
+	}
+	return nil
+}
+
+// walkZip calls fn for each entry of a zip file
+func walkZip(source string, fn func(string, os.FileMode, func() (io.ReadCloser, error))) error {
+	r, err := zip.OpenReader(source)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	for _, f := range r.File {
+		fn(f.Name, f.Mode(), f.Open)
+	}
+	return nil
+}
+
+// walkTar calls fn for each entry of a tar file, which may be
+// compressed with gzip
+func walkTar(source string, fn func(string, os.FileMode, func() (io.ReadCloser, error))) error {
+	f, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var r io.Reader = f
+	if !strings.HasSuffix(source, ".tar") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		opened := false
+		fn(hdr.Name, hdr.FileInfo().Mode(), func() (io.ReadCloser, error) {
+			if opened {
+				return nil, fmt.Errorf("'%s' can't be read again from a tar stream", hdr.Name)
+			}
+			opened = true
+			return io.NopCloser(tr), nil
+		})
+	}
+}
+
+// archiveEntryKey turns the name of an archive entry into a key,
+// refusing names that would escape the destination prefix
+func archiveEntryKey(name string) (string, error) {
+	cleaned := path.Clean("/" + name)[1:]
+	if cleaned == "" || cleaned != strings.TrimPrefix(strings.TrimPrefix(name, "./"), "/") {
+		return "", fmt.Errorf("refusing to upload archive entry '%s' (unsafe name)", name)
+	}
+	return cleaned, nil
+}
+
+// uploadArchiveEntry uploads a single file out of an archive
+func uploadArchiveEntry(
+	uploader *s3manager.Uploader,
+	bucket string,
+	key string,
+	name string,
+	mode os.FileMode,
+	open func() (io.ReadCloser, error),
+	prog *progress,
+) error {
+	return withRetriesProgress(prog, func(prog *progress) error {
+		rc, err := open()
+		if err != nil {
+			return fmt.Errorf("failed to read '%s' from archive: %w", name, err)
+		}
+		defer rc.Close()
+		var body io.Reader = bufio.NewReaderSize(rc, bufferSize)
+		if prog != nil {
+			body = &progressReader{r: body, p: prog}
+		}
+		if limiters := fileLimiters(); limiters != nil {
+			body = &limitedReader{r: body, limiters: limiters}
+		}
+		var metadata map[string]*string
+		if clientSideKey != nil {
+			if body, metadata, err = newEncryptingReader(clientSideKey, body); err != nil {
+				return err
+			}
+		}
+		if preserveMode {
+			metadata = setModeMetadata(metadata, mode)
+		}
+		ctx, cancel := jobContext()
+		defer cancel()
+		if err := checkUploadPreconditions(ctx, uploader.S3, aws.String(bucket), aws.String(key)); err != nil {
+			return err
+		}
+		if _, err := uploader.UploadWithContext(ctx, &s3manager.UploadInput{
+			Bucket:          aws.String(bucket),
+			Key:             aws.String(key),
+			Body:            body,
+			ContentType:     uploadContentType(name),
+			ContentLanguage: optionalString(contentLanguage),
+			Metadata:        metadata,
+			StorageClass:    optionalString(storageClass),
+			ACL:             optionalString(cannedACL),
+
+			WebsiteRedirectLocation: optionalString(websiteRedirect),
+
+			ServerSideEncryption:    serverSideEncryption.algorithm,
+			SSEKMSKeyId:             serverSideEncryption.kmsKeyID,
+			SSEKMSEncryptionContext: serverSideEncryption.encryptionContext,
+
+			SSECustomerAlgorithm: sseCustomer.algorithm,
+			SSECustomerKey:       sseCustomer.key,
+			SSECustomerKeyMD5:    sseCustomer.keyMD5,
+
+			ObjectLockMode:            objectLock.mode,
+			ObjectLockRetainUntilDate: objectLock.retainUntil,
+			ObjectLockLegalHoldStatus: objectLock.legalHold,
+		}); err != nil {
+			return fmt.Errorf("failed to upload '%s' from archive: %w", name, err)
+		}
+		logSuccess("upload: %s to s3://%s/%s", name, bucket, key)
+		return nil
+	})
+}
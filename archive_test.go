@@ -0,0 +1,137 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestArchiveEntryKey(t *testing.T) {
+	for _, tt := range []struct {
+		name, want string
+	}{
+		{"a.txt", "a.txt"},
+		{"dir/a.txt", "dir/a.txt"},
+		{"./dir/a.txt", "dir/a.txt"},
+		{"/dir/a.txt", "dir/a.txt"},
+		{"../a.txt", ""},
+		{"dir/../../a.txt", ""},
+		{"dir//a.txt", ""},
+		{".", ""},
+	} {
+		got, err := archiveEntryKey(tt.name)
+		if got != tt.want || (tt.want == "") != (err != nil) {
+			t.Errorf("archiveEntryKey(%q) = %q, %v, want %q", tt.name, got, err, tt.want)
+		}
+	}
+}
+
+func TestUploadTarArchive(t *testing.T) {
+	s := newFakeS3(t, "b")
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, entry := range []struct {
+		hdr  tar.Header
+		body string
+	}{
+		{tar.Header{Name: "dist/", Typeflag: tar.TypeDir, Mode: 0755}, ""},
+		{tar.Header{Name: "dist/index.html", Typeflag: tar.TypeReg, Mode: 0644}, "<html>"},
+		{tar.Header{Name: "dist/js/app.js", Typeflag: tar.TypeReg, Mode: 0644}, "app()"},
+		{tar.Header{Name: "dist/latest.js", Typeflag: tar.TypeSymlink, Linkname: "js/app.js"}, ""},
+	} {
+		entry.hdr.Size = int64(len(entry.body))
+		if err := tw.WriteHeader(&entry.hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(entry.body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tw.Close()
+	gz.Close()
+	archive := filepath.Join(t.TempDir(), "build.tar.gz")
+	if err := os.WriteFile(archive, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := run(t, "-expand-archive", archive, "s3://b/site/"); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(s.keys("b"), " "); got != "site/dist/index.html site/dist/js/app.js" {
+		t.Errorf("uploaded %s", got)
+	}
+	if o := s.object("b", "site/dist/js/app.js"); o == nil || string(o.data) != "app()" {
+		t.Errorf("site/dist/js/app.js holds %+v", o)
+	}
+	if o := s.object("b", "site/dist/index.html"); o == nil || !strings.HasPrefix(o.header.Get("Content-Type"), "text/html") {
+		t.Errorf("site/dist/index.html was uploaded as %+v", o)
+	}
+}
+
+func TestUploadZipArchive(t *testing.T) {
+	s := newFakeS3(t, "b")
+	archive := filepath.Join(t.TempDir(), "build.zip")
+	f, err := os.Create(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(f)
+	if _, err := zw.Create("docs/"); err != nil {
+		t.Fatal(err)
+	}
+	for name, body := range map[string]string{"docs/a.txt": "a", "b.txt": "b", "../escape.txt": "x"} {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(body))
+	}
+	zw.Close()
+	f.Close()
+
+	_, err = run(t, "-expand-archive", archive, "s3://b/")
+	if code := exitCode(err); code != exitPartial {
+		t.Errorf("exited with %d (%v), want %d for the unsafe entry", code, err, exitPartial)
+	}
+	if got := strings.Join(s.keys("b"), " "); got != "b.txt docs/a.txt" {
+		t.Errorf("uploaded %s", got)
+	}
+
+	other := filepath.Join(t.TempDir(), "build.rar")
+	writeFiles(t, filepath.Dir(other), map[string]string{"build.rar": ""})
+	if _, err := run(t, "-expand-archive", other, "s3://b/"); exitCode(err) != exitUsage {
+		t.Errorf("a .rar source exited with %d (%v), want %d", exitCode(err), err, exitUsage)
+	}
+}
+
+func TestUploadArchiveLowercaseCollision(t *testing.T) {
+	s := newFakeS3(t, "b")
+	archive := filepath.Join(t.TempDir(), "site.zip")
+	f, err := os.Create(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(f)
+	for _, name := range []string{"about.html", "Index.html", "index.html"} {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(name))
+	}
+	zw.Close()
+	f.Close()
+
+	if _, err := run(t, "-expand-archive", "-lowercase-keys", archive, "s3://b/"); err == nil {
+		t.Fatal("expected the entries to clash")
+	}
+	if keys := s.keys("b"); len(keys) != 0 {
+		t.Errorf("uploaded %v before the clash was found", keys)
+	}
+}
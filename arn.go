@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws/arn"
+)
+
+// Access points are addressed by their ARN in place of the bucket
+// name, e.g.
+//
+//	s3://arn:aws:s3:us-west-2:123456789012:accesspoint/myap/mykey
+//
+// The SDK routes requests for such "buckets" to the access point,
+// in the region named by the ARN.
+
+// splitAccessPointARN splits an access point ARN, followed by an
+// optional key, into the ARN and the key. Outpost access points
+// have two more components than regular ones.
+func splitAccessPointARN(path string) (string, string, error) {
+	parts := strings.SplitN(path, "/", 5)
+	n := 2 // accesspoint/name
+	if strings.Contains(parts[0], ":outpost") {
+		n = 4 // outpost/id/accesspoint/name
+	}
+	if len(parts) < n {
+		return "", "", fmt.Errorf("incomplete access point ARN '%s'", path)
+	}
+	bucket := strings.Join(parts[:n], "/")
+	parsed, err := arn.Parse(bucket)
+	if err != nil {
+		return "", "", err
+	}
+	if !strings.HasPrefix(parsed.Service, "s3") || !strings.Contains(parsed.Resource, "accesspoint/") {
+		return "", "", fmt.Errorf("'%s' is not an S3 access point ARN", bucket)
+	}
+	key := ""
+	if len(parts) > n {
+		key = strings.Join(parts[n:], "/")
+	}
+	return bucket, key, nil
+}
+
+// copySource returns the CopySource of a copy request. Objects in
+// access points are named <arn>/object/<key> rather than
+// <bucket>/<key>.
+func copySource(bucket string, key string) string {
+	if arn.IsARN(bucket) {
+		return url.PathEscape(bucket + "/object/" + key)
+	}
+	return url.PathEscape(bucket + "/" + key)
+}
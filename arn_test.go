@@ -0,0 +1,79 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+const testAccessPoint = "arn:aws:s3:us-west-2:123456789012:accesspoint/myap"
+
+func TestSplitNamePartsAccessPoint(t *testing.T) {
+	outpost := "arn:aws:s3-outposts:us-west-2:123456789012:outpost/op-01234567890123456/accesspoint/myap"
+	for _, tt := range []struct {
+		path        string
+		bucket, key string
+	}{
+		{"s3://" + testAccessPoint, testAccessPoint, ""},
+		{"s3://" + testAccessPoint + "/", testAccessPoint, ""},
+		{"s3://" + testAccessPoint + "/dir/a.txt", testAccessPoint, "dir/a.txt"},
+		{"s3://" + outpost + "/a.txt", outpost, "a.txt"},
+	} {
+		bucket, key, err := splitNameParts(tt.path)
+		if err != nil || bucket != tt.bucket || key != tt.key {
+			t.Errorf("splitNameParts(%q) = %q, %q, %v, want %q, %q", tt.path, bucket, key, err, tt.bucket, tt.key)
+		}
+	}
+	for _, path := range []string{
+		"s3://arn:aws:s3:us-west-2:123456789012:accesspoint",
+		"s3://arn:aws:sqs:us-west-2:123456789012:accesspoint/myap",
+		"s3://arn:aws:s3:::mybucket/a.txt",
+		"s3://arn:nonsense/a.txt",
+	} {
+		if bucket, key, err := splitNameParts(path); err == nil {
+			t.Errorf("splitNameParts(%q) = %q, %q, expected an error", path, bucket, key)
+		}
+	}
+}
+
+func TestCopySource(t *testing.T) {
+	for _, tt := range []struct {
+		bucket, key, want string
+	}{
+		{"b", "dir/a b.txt", "b%2Fdir%2Fa%20b.txt"},
+		{testAccessPoint, "a.txt", "arn:aws:s3:us-west-2:123456789012:accesspoint%2Fmyap%2Fobject%2Fa.txt"},
+	} {
+		if got := copySource(tt.bucket, tt.key); got != tt.want {
+			t.Errorf("copySource(%q, %q) = %q, want %q", tt.bucket, tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestAccessPointRequestTarget(t *testing.T) {
+	defer func(r, e, p string) { region, endpoint, provider = r, e, p }(region, endpoint, provider)
+	region, endpoint, provider = "", "", "aws"
+	for name, value := range map[string]string{
+		"AWS_ENDPOINT_URL":      "",
+		"AWS_S3_ENDPOINT":       "",
+		"AWS_REGION":            "us-east-1",
+		"AWS_ACCESS_KEY_ID":     "AKIDTEST",
+		"AWS_SECRET_ACCESS_KEY": "secret",
+	} {
+		t.Setenv(name, value)
+	}
+	bucket, key, err := splitNameParts("s3://" + testAccessPoint + "/dir/a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ := s3.New(createSession()).GetObjectRequest(&s3.GetObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)})
+	if err := req.Build(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := req.HTTPRequest.URL.Host, "myap-123456789012.s3-accesspoint.us-west-2.amazonaws.com"; got != want {
+		t.Errorf("request sent to %s, want the access point %s", got, want)
+	}
+	if got := req.HTTPRequest.URL.Path; got != "/dir/a.txt" {
+		t.Errorf("request for %s, want /dir/a.txt", got)
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// autoConcurrency starts with a few jobs at a time and raises the
+// limit for as long as that improves throughput, up to
+// -parallelism. Throttled requests still halve it as usual.
+var autoConcurrency bool
+
+const (
+	// autoTuneInterval is how long throughput is measured at
+	// each limit
+	autoTuneInterval = 2 * time.Second
+
+	// autoTuneStart is the limit tuning starts at
+	autoTuneStart = 2
+
+	// autoTuneGain is how much faster a higher limit has to be
+	// to count as an improvement rather than noise
+	autoTuneGain = 1.1
+)
+
+// transferredBytes counts the bytes of every transfer, which is
+// the throughput -concurrency-auto tunes for
+var transferredBytes int64
+
+// concurrencyTuner searches for the limit with the best
+// throughput. The limit doubles until that stops paying off, and
+// the range between the best limit and the first one that didn't
+// improve on it is then bisected.
+type concurrencyTuner struct {
+	max      int
+	limit    int
+	best     int
+	bestRate float64
+	// upper is the lowest limit known to be no better than
+	// best, zero until one is found
+	upper   int
+	settled bool
+}
+
+func newConcurrencyTuner(max int) *concurrencyTuner {
+	start := autoTuneStart
+	if start > max {
+		start = max
+	}
+	return &concurrencyTuner{max: max, limit: start, best: start}
+}
+
+// next takes the throughput measured at the current limit and
+// returns the limit to measure next
+func (c *concurrencyTuner) next(rate float64) int {
+	if c.settled {
+		return c.limit
+	}
+	if c.limit == c.best || rate >= c.bestRate*autoTuneGain {
+		c.best, c.bestRate = c.limit, rate
+	} else {
+		c.upper = c.limit
+	}
+	switch {
+	case c.upper == 0 && c.best < c.max:
+		c.limit = c.best * 2
+		if c.limit > c.max {
+			c.limit = c.max
+		}
+	case c.upper == 0 || c.upper-c.best <= 1:
+		c.limit = c.best
+		c.settled = true
+	default:
+		c.limit = (c.best + c.upper) / 2
+	}
+	return c.limit
+}
+
+// startConcurrencyTuner measures the throughput every interval
+// and sets the throttle's limit to what the tuner picks next,
+// until it settles. The returned function stops it.
+func startConcurrencyTuner(t *throttle) func() {
+	tuner := newConcurrencyTuner(t.max)
+	t.setMax(tuner.limit)
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(autoTuneInterval)
+		defer ticker.Stop()
+		last := atomic.LoadInt64(&transferredBytes)
+		for !tuner.settled {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+			n := atomic.LoadInt64(&transferredBytes)
+			if n == last {
+				// Nothing was transferred, e.g. as files are
+				// still being listed
+				continue
+			}
+			rate := float64(n-last) / autoTuneInterval.Seconds()
+			last = n
+			t.setMax(tuner.next(rate))
+		}
+	}()
+	return func() {
+		close(stop)
+		<-done
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import "testing"
+
+// tune runs a tuner against a simulated throughput curve until it
+// settles, returning the limit it settled on
+func tune(t *testing.T, max int, rate func(limit int) float64) int {
+	t.Helper()
+	c := newConcurrencyTuner(max)
+	limit := c.limit
+	for i := 0; i < 50 && !c.settled; i++ {
+		if limit < 1 || limit > max {
+			t.Fatalf("tried the limit %d, outside 1 to %d", limit, max)
+		}
+		limit = c.next(rate(limit))
+	}
+	if !c.settled {
+		t.Fatalf("didn't settle, last at %d", limit)
+	}
+	return limit
+}
+
+func TestConcurrencyTuner(t *testing.T) {
+	// Throughput peaks at 12 jobs and drops with more
+	peaked := func(limit int) float64 {
+		if limit <= 12 {
+			return float64(limit) * 100
+		}
+		return 1200 - float64(limit-12)*50
+	}
+	if got := tune(t, 64, peaked); peaked(got) < 0.8*peaked(12) {
+		t.Errorf("settled at %d with %.0f bytes/s, far from the optimum at 12", got, peaked(got))
+	}
+
+	// Throughput levels off at 20 jobs
+	leveled := func(limit int) float64 {
+		if limit > 20 {
+			limit = 20
+		}
+		return float64(limit) * 100
+	}
+	if got := tune(t, 256, leveled); got < 16 || got > 32 {
+		t.Errorf("settled at %d, want near 20", got)
+	}
+
+	linear := func(limit int) float64 { return float64(limit) * 100 }
+	if got := tune(t, 5, linear); got != 5 {
+		t.Errorf("settled at %d, want -parallelism 5", got)
+	}
+	if got := tune(t, 1, linear); got != 1 {
+		t.Errorf("settled at %d, want -parallelism 1", got)
+	}
+}
+
+func TestConcurrencyTunerKeepsStartWithoutGain(t *testing.T) {
+	flat := func(int) float64 { return 1000 }
+	if got := tune(t, 64, flat); got != autoTuneStart {
+		t.Errorf("settled at %d though more jobs didn't help, want %d", got, autoTuneStart)
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// Bandwidth caps in bytes per second, zero meaning no limit.
+// -max-bandwidth is shared by all transfers while -bwlimit-per-file
+// applies to each file on its own, so with both set every file
+// gets at most its own cap and all of them together at most the
+// global one.
+var (
+	maxBandwidth   int64
+	bwLimitPerFile int64
+)
+
+// globalLimiter enforces -max-bandwidth across all transfers
+var globalLimiter *rateLimiter
+
+// rateLimiter delays callers so that the bytes passed to wait
+// don't exceed the rate. It keeps the time by which everything
+// reserved so far will have been sent, so concurrent callers
+// queue up behind each other. Methods are no-ops on a nil
+// *rateLimiter.
+type rateLimiter struct {
+	mu   sync.Mutex
+	rate int64
+	next time.Time
+}
+
+// newRateLimiter returns a limiter for the rate in bytes per
+// second, or nil if the rate is zero.
+func newRateLimiter(rate int64) *rateLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	return &rateLimiter{rate: rate}
+}
+
+// wait blocks until n more bytes may be transferred
+func (l *rateLimiter) wait(n int) {
+	if l == nil || n <= 0 {
+		return
+	}
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		// Unused bandwidth is not saved up for later bursts.
+		l.next = now
+	}
+	delay := l.next.Sub(now)
+	l.next = l.next.Add(time.Duration(int64(n) * int64(time.Second) / l.rate))
+	l.mu.Unlock()
+	time.Sleep(delay)
+}
+
+// limitChunk bounds the size of each read or write, so that the
+// limit is applied smoothly rather than to whole parts at once.
+const limitChunk = 32 * 1024
+
+// fileLimiters returns the limiters a single file is subject to,
+// or nil if there are none.
+func fileLimiters() []*rateLimiter {
+	var limiters []*rateLimiter
+	if globalLimiter != nil {
+		limiters = append(limiters, globalLimiter)
+	}
+	if perFile := newRateLimiter(bwLimitPerFile); perFile != nil {
+		limiters = append(limiters, perFile)
+	}
+	return limiters
+}
+
+// limitedReader throttles the bytes read through it
+type limitedReader struct {
+	r        io.Reader
+	limiters []*rateLimiter
+}
+
+func (r *limitedReader) Read(b []byte) (int, error) {
+	if len(b) > limitChunk {
+		b = b[:limitChunk]
+	}
+	n, err := r.r.Read(b)
+	for _, l := range r.limiters {
+		l.wait(n)
+	}
+	return n, err
+}
+
+// limitedWriterAt throttles the bytes written through it
+type limitedWriterAt struct {
+	w        io.WriterAt
+	limiters []*rateLimiter
+}
+
+func (w *limitedWriterAt) WriteAt(b []byte, off int64) (int, error) {
+	written := 0
+	for len(b) > 0 {
+		chunk := b
+		if len(chunk) > limitChunk {
+			chunk = chunk[:limitChunk]
+		}
+		for _, l := range w.limiters {
+			l.wait(len(chunk))
+		}
+		n, err := w.w.WriteAt(chunk, off)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		b = b[n:]
+		off += int64(n)
+	}
+	return written, nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// transferTime returns how long reading size bytes of each of
+// files concurrently through their limiters takes
+func transferTime(files int, size int, limiters func() []*rateLimiter) time.Duration {
+	started := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < files; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r := &limitedReader{r: strings.NewReader(strings.Repeat("x", size)), limiters: limiters()}
+			io.Copy(io.Discard, r)
+		}()
+	}
+	wg.Wait()
+	return time.Since(started)
+}
+
+func TestRateLimiterDisabled(t *testing.T) {
+	if l := newRateLimiter(0); l != nil {
+		t.Fatal("expected no limiter without a rate")
+	}
+	var l *rateLimiter
+	l.wait(1 << 30)
+}
+
+func TestBandwidthLimits(t *testing.T) {
+	defer func(global *rateLimiter, perFile int64) { globalLimiter, bwLimitPerFile = global, perFile }(globalLimiter, bwLimitPerFile)
+	const size = 256 << 10
+	for _, tt := range []struct {
+		name          string
+		global        int64
+		perFile       int64
+		atLeast, most time.Duration
+	}{
+		// Each file waits for its own 224 KiB beyond the first chunk
+		{"per file", 16 << 20, 1 << 20, 200 * time.Millisecond, 400 * time.Millisecond},
+		// Together the files wait for 480 KiB beyond the first chunk
+		{"global", 1 << 20, 16 << 20, 450 * time.Millisecond, time.Second},
+	} {
+		globalLimiter, bwLimitPerFile = newRateLimiter(tt.global), tt.perFile
+		elapsed := transferTime(2, size, fileLimiters)
+		if elapsed < tt.atLeast || elapsed > tt.most {
+			t.Errorf("%s: transferred two files in %s, want between %s and %s", tt.name, elapsed, tt.atLeast, tt.most)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// forceRemoveBucket makes rb delete the objects in the bucket
+// first, as S3 only deletes empty buckets
+var forceRemoveBucket bool
+
+// bucketName returns the bucket of an s3 path that mustn't have
+// a key
+func bucketName(target string) (string, error) {
+	bucket, key, err := splitNameParts(target)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse '%s': %w", target, err)
+	}
+	if key != "" {
+		return "", usageErrorf("'%s' is not a bucket (expected s3://bucket)", target)
+	}
+	return bucket, nil
+}
+
+// mb creates a bucket in the -region
+func mb(target string) error {
+	bucket, err := bucketName(target)
+	if err != nil {
+		return err
+	}
+	input := &s3.CreateBucketInput{
+		Bucket: aws.String(bucket),
+	}
+	// Buckets are created in us-east-1 unless told otherwise,
+	// and S3 rejects it as an explicit location constraint.
+	if region := resolveRegion(); region != "us-east-1" {
+		input.CreateBucketConfiguration = &s3.CreateBucketConfiguration{
+			LocationConstraint: aws.String(region),
+		}
+	}
+	if _, err := s3.New(createSession()).CreateBucket(input); err != nil {
+		return fmt.Errorf("failed to create '%s': %w", target, err)
+	}
+	logSuccess("make bucket: %s", target)
+	return nil
+}
+
+// rb deletes a bucket, and with -force every object in it first.
+// Buckets with versioning keep the old versions of deleted objects,
+// which have to be deleted some other way.
+func rb(target string) error {
+	bucket, err := bucketName(target)
+	if err != nil {
+		return err
+	}
+	s3Client := s3.New(createSession())
+	if forceRemoveBucket {
+		keys, err := listKeys(s3Client, bucket, "")
+		if err != nil {
+			return err
+		}
+		if len(keys) > 0 {
+			if err := confirm("delete %d objects and then the bucket %s", len(keys), target); err != nil {
+				return err
+			}
+			if err := deleteKeys(s3Client, bucket, keys); err != nil {
+				return err
+			}
+		}
+	}
+	if _, err := s3Client.DeleteBucket(&s3.DeleteBucketInput{
+		Bucket: aws.String(bucket),
+	}); err != nil {
+		return fmt.Errorf("failed to delete '%s': %w", target, err)
+	}
+	logSuccess("remove bucket: %s", target)
+	return nil
+}
+
+// buckets prints the name and creation date of every bucket
+// owned by the caller
+func buckets() error {
+	out, err := s3.New(createSession()).ListBuckets(&s3.ListBucketsInput{})
+	if err != nil {
+		return fmt.Errorf("failed to list buckets: %w", err)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, b := range out.Buckets {
+		fmt.Fprintf(w, "%s\t%s\t\n", formatTime(b.CreationDate), aws.StringValue(b.Name))
+	}
+	return w.Flush()
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestMb(t *testing.T) {
+	s := newFakeS3(t)
+	var bodies []string
+	s.intercept = func(w http.ResponseWriter, r *http.Request) bool {
+		body, _ := io.ReadAll(r.Body)
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		bodies = append(bodies, string(body))
+		return false
+	}
+	for _, tt := range []struct {
+		region, bucket string
+		constraint     string
+	}{
+		{"eu-west-1", "europe", "<LocationConstraint>eu-west-1</LocationConstraint>"},
+		// us-east-1 must not be given as a constraint
+		{"us-east-1", "virginia", ""},
+	} {
+		bodies = nil
+		if _, err := run(t, "mb", "-region", tt.region, "s3://"+tt.bucket); err != nil {
+			t.Fatalf("-region %s: %v", tt.region, err)
+		}
+		if len(bodies) != 1 {
+			t.Fatalf("-region %s sent %d requests", tt.region, len(bodies))
+		}
+		if tt.constraint == "" && bodies[0] != "" || !strings.Contains(bodies[0], tt.constraint) {
+			t.Errorf("-region %s created the bucket with %q", tt.region, bodies[0])
+		}
+	}
+	if got := s.received(http.MethodPut, ""); len(got) != 2 || got[0].bucket != "europe" || got[1].bucket != "virginia" {
+		t.Errorf("created %+v", got)
+	}
+
+	if _, err := run(t, "mb", "s3://europe"); err == nil {
+		t.Error("expected creating an existing bucket to fail")
+	}
+	if _, err := run(t, "mb", "s3://europe/key"); exitCode(err) != exitUsage {
+		t.Errorf("a key exited with %d (%v), want %d", exitCode(err), err, exitUsage)
+	}
+}
+
+func TestRb(t *testing.T) {
+	s := newFakeS3(t, "empty", "full")
+	s.put("full", "a.txt", "a")
+	s.put("full", "dir/b.txt", "b")
+	if _, err := run(t, "rb", "s3://empty"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := run(t, "rb", "s3://full"); err == nil {
+		t.Error("expected deleting a bucket that isn't empty to fail")
+	}
+	if _, err := run(t, "rb", "-force", "-yes", "s3://full"); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(s.received(http.MethodPost, "delete")); got != 1 {
+		t.Errorf("sent %d batch deletes to empty the bucket, want 1", got)
+	}
+	s.mu.Lock()
+	remaining := len(s.buckets)
+	s.mu.Unlock()
+	if remaining != 0 {
+		t.Errorf("%d buckets are left", remaining)
+	}
+}
+
+func TestBuckets(t *testing.T) {
+	newFakeS3(t, "logs", "assets")
+	out, err := run(t, "buckets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) != 2 || !strings.HasSuffix(strings.TrimSpace(lines[0]), "assets") ||
+		!strings.HasSuffix(strings.TrimSpace(lines[1]), "logs") || !strings.Contains(lines[0], "2024-01-02") {
+		t.Errorf("printed %q", out)
+	}
+	if ls, err := run(t, "ls", "s3://"); err != nil || ls != out {
+		t.Errorf("ls s3:// printed %q, %v, want the buckets", ls, err)
+	}
+}
@@ -0,0 +1,26 @@
+package main
+
+import "io"
+
+// bufferSize is the size of the buffer data is copied through
+// between files and S3 streams. io.Copy uses 32 KiB, which can be
+// too small to keep a fast link busy.
+var bufferSize int
+
+// defaultBufferSize is used by commands without -buffer-size
+const defaultBufferSize = 32 * 1024
+
+// copyBuffered copies src to dst through a buffer of -buffer-size.
+// The writer and reader are wrapped so that neither can take over
+// the copy with its own ReadFrom or WriteTo and buffer size.
+func copyBuffered(dst io.Writer, src io.Reader) (int64, error) {
+	size := bufferSize
+	if size < 1 {
+		size = defaultBufferSize
+	}
+	return io.CopyBuffer(
+		struct{ io.Writer }{dst},
+		struct{ io.Reader }{src},
+		make([]byte, size),
+	)
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// writeSizes records the size of every write
+type writeSizes struct {
+	bytes.Buffer
+	sizes []int
+}
+
+func (w *writeSizes) Write(p []byte) (int, error) {
+	w.sizes = append(w.sizes, len(p))
+	return w.Buffer.Write(p)
+}
+
+func TestCopyBuffered(t *testing.T) {
+	defer func(old int) { bufferSize = old }(bufferSize)
+	data := strings.Repeat("x", 100000)
+	for _, tt := range []struct {
+		bufferSize, want int
+	}{
+		{0, defaultBufferSize},
+		{1000, 1000},
+		{64 * 1024, 64 * 1024},
+	} {
+		bufferSize = tt.bufferSize
+		// A strings.Reader would hand the copy its own WriteTo
+		// and bytes.Buffer its own ReadFrom if copyBuffered let them
+		var w writeSizes
+		n, err := copyBuffered(&w, strings.NewReader(data))
+		if err != nil || n != int64(len(data)) || w.String() != data {
+			t.Errorf("-buffer-size %d copied %d bytes, %v", tt.bufferSize, n, err)
+			continue
+		}
+		if w.sizes[0] != tt.want {
+			t.Errorf("-buffer-size %d copied %d bytes at a time, want %d", tt.bufferSize, w.sizes[0], tt.want)
+		}
+	}
+}
+
+func TestBufferSizeValidation(t *testing.T) {
+	newFakeS3(t, "b")
+	if _, err := run(t, "-buffer-size", "0", "s3://b/a.txt", t.TempDir()); exitCode(err) != exitUsage {
+		t.Errorf("-buffer-size 0 exited with %d (%v), want %d", exitCode(err), err, exitUsage)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+// contentAddressed uploads files under the SHA-256 of their
+// contents rather than their names, e.g. sha256/ab/cdef... beneath
+// the destination prefix, so identical files are only stored once.
+// Files whose key already exists are skipped.
+var contentAddressed bool
+
+// skipStored is the reason content addressed files are skipped
+const skipStored = "already stored"
+
+// contentKey returns the key of a file with -content-addressed.
+// The first byte of the hash is a directory of its own so that
+// listings stay manageable.
+func contentKey(path string) (string, error) {
+	f, err := openWithRetry(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read source file '%s': %w", path, err)
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, &retryingFile{f}); err != nil {
+		return "", fmt.Errorf("failed to hash '%s': %w", path, err)
+	}
+	sum := hex.EncodeToString(h.Sum(nil))
+	return "sha256/" + sum[:2] + "/" + sum[2:], nil
+}
+
+// objectExists reports whether there is an object at the key
+func objectExists(client s3iface.S3API, bucket *string, key *string) (bool, error) {
+	ctx, cancel := jobContext()
+	defer cancel()
+	if _, err := client.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket:       bucket,
+		Key:          key,
+		RequestPayer: optionalString(requestPayer),
+
+		SSECustomerAlgorithm: sseCustomer.algorithm,
+		SSECustomerKey:       sseCustomer.key,
+		SSECustomerKeyMD5:    sseCustomer.keyMD5,
+	}); err != nil {
+		if isNotFound(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to check whether '%s' exists: %w", aws.StringValue(key), err)
+	}
+	return true, nil
+}
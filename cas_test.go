@@ -0,0 +1,54 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestContentKey(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"a.txt": "hello"})
+	sum := sha256.Sum256([]byte("hello"))
+	want := "sha256/" + hex.EncodeToString(sum[:1]) + "/" + hex.EncodeToString(sum[1:])
+	if got, err := contentKey(filepath.Join(dir, "a.txt")); err != nil || got != want {
+		t.Errorf("contentKey = %q, %v, want %q", got, err, want)
+	}
+	if _, err := contentKey(filepath.Join(dir, "missing.txt")); err == nil {
+		t.Error("expected a missing file to fail")
+	}
+}
+
+func TestUploadContentAddressed(t *testing.T) {
+	s := newFakeS3(t, "b")
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"a.txt": "hello", "sub/copy.txt": "hello", "b.txt": "world"})
+	hello, _ := contentKey(filepath.Join(dir, "a.txt"))
+	world, _ := contentKey(filepath.Join(dir, "b.txt"))
+
+	if _, err := run(t, "-content-addressed", "-parallelism", "1", dir, "s3://b/cas/"); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"cas/" + hello, "cas/" + world}
+	sort.Strings(want)
+	if got := s.keys("b"); strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("uploaded %s, want %s", got, want)
+	}
+	if o := s.object("b", "cas/"+hello); o == nil || string(o.data) != "hello" {
+		t.Errorf("cas/%s holds %+v", hello, o)
+	}
+	if got := len(s.received(http.MethodPut, "")); got != 2 {
+		t.Errorf("uploaded %d times, want identical content only once", got)
+	}
+
+	if _, err := run(t, "-content-addressed", dir, "s3://b/cas/"); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(s.received(http.MethodPut, "")); got != 2 {
+		t.Errorf("uploaded %d times, want stored content to be skipped", got)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// checksumMode is "ENABLED" to verify downloads against the
+// checksum S3 stored when the object was uploaded
+var checksumMode string
+
+// objectChecksum is an additional checksum of an object's data
+type objectChecksum struct {
+	algorithm string
+	value     string // base64 encoded
+	newHash   func() hash.Hash
+}
+
+// fullObjectChecksum returns the checksum in a HEAD response, or
+// nil if it has none covering the whole object. Objects uploaded
+// in parts usually only have a checksum of their part checksums,
+// suffixed with the number of parts, which can't be checked
+// without knowing where the parts began.
+func fullObjectChecksum(out *s3.HeadObjectOutput) *objectChecksum {
+	candidates := []objectChecksum{
+		{"SHA256", aws.StringValue(out.ChecksumSHA256), sha256.New},
+		{"SHA1", aws.StringValue(out.ChecksumSHA1), sha1.New},
+		{"CRC32C", aws.StringValue(out.ChecksumCRC32C), func() hash.Hash {
+			return crc32.New(crc32.MakeTable(crc32.Castagnoli))
+		}},
+		{"CRC32", aws.StringValue(out.ChecksumCRC32), func() hash.Hash {
+			return crc32.NewIEEE()
+		}},
+	}
+	for i := range candidates {
+		c := &candidates[i]
+		if c.value != "" && !strings.Contains(c.value, "-") {
+			return c
+		}
+	}
+	return nil
+}
+
+// verify returns an error if the file's contents don't match
+// the checksum.
+func (c *objectChecksum) verify(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	h := c.newHash()
+	if _, err := io.Copy(h, f); err != nil {
+		return err
+	}
+	if actual := base64.StdEncoding.EncodeToString(h.Sum(nil)); actual != c.value {
+		return fmt.Errorf("%s checksum mismatch: expected %s, got %s", c.algorithm, c.value, actual)
+	}
+	return nil
+}
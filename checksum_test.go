@@ -0,0 +1,51 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+func TestFullObjectChecksum(t *testing.T) {
+	if c := fullObjectChecksum(&s3.HeadObjectOutput{}); c != nil {
+		t.Errorf("found a %s checksum in an empty response", c.algorithm)
+	}
+	if c := fullObjectChecksum(&s3.HeadObjectOutput{ChecksumSHA256: aws.String("abc=-3")}); c != nil {
+		t.Error("found a checksum of a multipart upload's parts")
+	}
+	c := fullObjectChecksum(&s3.HeadObjectOutput{
+		ChecksumCRC32:  aws.String("crc="),
+		ChecksumSHA256: aws.String("sha="),
+	})
+	if c == nil || c.algorithm != "SHA256" || c.value != "sha=" {
+		t.Errorf("found %+v, want the SHA256 checksum", c)
+	}
+}
+
+func TestDownloadChecksumMode(t *testing.T) {
+	s := newFakeS3(t, "b")
+	sum := sha256.Sum256([]byte("hello"))
+	s.put("b", "good.txt", "hello", "X-Amz-Checksum-Sha256", base64.StdEncoding.EncodeToString(sum[:]))
+	s.put("b", "bad.txt", "hello", "X-Amz-Checksum-Sha256", base64.StdEncoding.EncodeToString(make([]byte, 32)))
+	dir := t.TempDir()
+	if _, err := run(t, "-checksum-mode", "ENABLED", "s3://b/good.txt", filepath.Join(dir, "good.txt")); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, filepath.Join(dir, "good.txt")); got != "hello" {
+		t.Errorf("downloaded %q", got)
+	}
+	if _, err := run(t, "-checksum-mode", "ENABLED", "-max-retries", "0", "s3://b/bad.txt", filepath.Join(dir, "bad.txt")); err == nil {
+		t.Error("expected a checksum mismatch")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "bad.txt")); err == nil {
+		t.Error("kept the download that failed verification")
+	}
+	if _, err := run(t, "s3://b/bad.txt", filepath.Join(dir, "bad.txt")); err != nil {
+		t.Errorf("the checksum was checked without -checksum-mode: %v", err)
+	}
+}
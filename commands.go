@@ -0,0 +1,407 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+// command is a subcommand of s3util. Besides the global flags,
+// each command only accepts the flags that apply to it.
+type command struct {
+	name     string
+	synopsis string // flags and arguments shown in the usage
+	nargs    int
+	flags    []func(fs *flag.FlagSet)
+	run      func(args []string) error
+	fs       *flag.FlagSet
+}
+
+// commands in the order they are listed in the usage. The first
+// one is the default, so that `s3util <input> <output>` copies.
+var commands = []*command{
+	{
+		name:     "cp",
+		synopsis: "<input> <output>",
+		nargs:    2,
+		flags:    []func(*flag.FlagSet){addTransferFlags, addObjectFlags, addUploadFlags, addDownloadFlags, addCopyFlags, addCustomerKeyFlags},
+		run: func(args []string) error {
+			return cp(args[0], args[1])
+		},
+	},
+	{
+		name:     "sync",
+		synopsis: "[-delete] [-dry-run] <directory> s3://bucket/prefix",
+		nargs:    2,
+		flags: []func(*flag.FlagSet){addTransferFlags, addObjectFlags, addConfirmFlags, addCustomerKeyFlags, func(fs *flag.FlagSet) {
+			fs.BoolVar(&syncDelete, "delete", false, "delete objects that don't exist locally")
+			fs.BoolVar(&dryRun, "dry-run", false, "print what would change without changing anything")
+		}},
+		run: func(args []string) error {
+			if err := prepareTransfers(); err != nil {
+				return err
+			}
+			return syncDir(args[0], args[1])
+		},
+	},
+	{
+		name:     "mv",
+		synopsis: "s3://bucket/key s3://bucket/newkey",
+		nargs:    2,
+		flags:    []func(*flag.FlagSet){addConfirmFlags},
+		run: func(args []string) error {
+			return mv(args[0], args[1])
+		},
+	},
+	{
+		name:     "download-from-list",
+		synopsis: "s3://bucket <directory> < keys",
+		nargs:    2,
+		flags: []func(*flag.FlagSet){addTransferFlags, addCustomerKeyFlags, func(fs *flag.FlagSet) {
+			fs.StringVar(&tempDir, "temp-dir", "", "directory to stage downloads in before moving them into place (defaults to the destination's directory, must be on the same filesystem)")
+			fs.StringVar(&checksumMode, "checksum-mode", "", "set to ENABLED to verify downloads against the checksum stored with the object, if it covers the whole object")
+		}},
+		run: func(args []string) error {
+			return downloadFromList(args[0], args[1])
+		},
+	},
+	{
+		name:     "download-tar",
+		synopsis: "s3://bucket/prefix <file.tar or - for stdout>",
+		nargs:    2,
+		flags:    []func(*flag.FlagSet){addTransferFlags, addCustomerKeyFlags},
+		run: func(args []string) error {
+			return downloadTar(args[0], args[1])
+		},
+	},
+	{
+		name:     "ls",
+		synopsis: "[-versions] [-output-format table|json|csv] [-start-after key] [-max-keys n] [-list-delimiter-recursive] s3://bucket/prefix",
+		nargs:    1,
+		flags:    []func(*flag.FlagSet){addListFlags},
+		run: func(args []string) error {
+			return ls(args[0])
+		},
+	},
+	{
+		name:     "stat",
+		synopsis: "[-version-id id] s3://bucket/key",
+		nargs:    1,
+		flags: []func(*flag.FlagSet){addCustomerKeyFlags, func(fs *flag.FlagSet) {
+			fs.StringVar(&versionID, "version-id", "", "version of the object in a versioned bucket")
+		}},
+		run: func(args []string) error {
+			return stat(args[0])
+		},
+	},
+	{
+		name:     "modify",
+		synopsis: "[-storage-class class] [-acl acl] s3://bucket/key",
+		nargs:    1,
+		flags: []func(*flag.FlagSet){func(fs *flag.FlagSet) {
+			fs.StringVar(&storageClass, "storage-class", "", "new storage class, e.g. STANDARD_IA or GLACIER")
+			fs.StringVar(&cannedACL, "acl", "", "new canned ACL, e.g. private or public-read")
+		}},
+		run: func(args []string) error {
+			return modify(args[0])
+		},
+	},
+	{
+		name:     "rm",
+		synopsis: "[-r] s3://bucket/key",
+		nargs:    1,
+		flags: []func(*flag.FlagSet){addConfirmFlags, func(fs *flag.FlagSet) {
+			fs.BoolVar(&recursive, "r", false, "delete every object in the directory, i.e. under the key followed by a slash")
+		}},
+		run: func(args []string) error {
+			return rm(args[0])
+		},
+	},
+	{
+		name: "buckets",
+		run: func(args []string) error {
+			return buckets()
+		},
+	},
+	{
+		name:     "mb",
+		synopsis: "s3://bucket",
+		nargs:    1,
+		run: func(args []string) error {
+			return mb(args[0])
+		},
+	},
+	{
+		name:     "rb",
+		synopsis: "[-force] s3://bucket",
+		nargs:    1,
+		flags: []func(*flag.FlagSet){func(fs *flag.FlagSet) {
+			fs.BoolVar(&forceRemoveBucket, "force", false, "delete every object in the bucket first")
+			fs.BoolVar(&assumeYes, "yes", false, "delete the objects without asking for confirmation")
+		}},
+		run: func(args []string) error {
+			return rb(args[0])
+		},
+	},
+	{
+		name:     "fixup-content-type",
+		synopsis: "[-dry-run] s3://bucket/prefix",
+		nargs:    1,
+		flags: []func(*flag.FlagSet){addCustomerKeyFlags, func(fs *flag.FlagSet) {
+			fs.BoolVar(&dryRun, "dry-run", false, "print what would change without changing anything")
+		}},
+		run: func(args []string) error {
+			return fixupContentTypes(args[0])
+		},
+	},
+	{
+		name:     "restore",
+		synopsis: "[-days n] [-tier Standard|Bulk|Expedited] [-wait] s3://bucket/key",
+		nargs:    1,
+		flags: []func(*flag.FlagSet){func(fs *flag.FlagSet) {
+			fs.Int64Var(&restoreDays, "days", 1, "number of days the restored copy is kept")
+			fs.StringVar(&restoreTier, "tier", s3.TierStandard, "retrieval tier: Standard, Bulk or Expedited")
+			fs.BoolVar(&restoreWait, "wait", false, "wait until the restored copy can be downloaded")
+			fs.DurationVar(&waitTimeout, "wait-timeout", 0, "maximum duration of -wait, e.g. 12h (0 means no limit)")
+		}},
+		run: func(args []string) error {
+			return restore(args[0])
+		},
+	},
+	{
+		name:     "presign-post",
+		synopsis: "[-expires duration] [-max-size n] s3://bucket/prefix/",
+		nargs:    1,
+		flags: []func(*flag.FlagSet){func(fs *flag.FlagSet) {
+			fs.DurationVar(&presignExpires, "expires", time.Hour, "how long the form may be used for")
+			fs.Int64Var(&presignMaxSize, "max-size", 0, "largest file in bytes the form accepts (0 means no limit)")
+		}},
+		run: func(args []string) error {
+			return presignPost(args[0])
+		},
+	},
+	{
+		name:     "verify",
+		synopsis: "[-part-size n] <file> s3://bucket/key",
+		nargs:    2,
+		flags: []func(*flag.FlagSet){addCustomerKeyFlags, func(fs *flag.FlagSet) {
+			fs.Int64Var(&partSize, "part-size", s3manager.DefaultUploadPartSize, "size in bytes of the parts the object was uploaded in, if it was uploaded in parts")
+		}},
+		run: func(args []string) error {
+			return verifyObject(args[0], args[1])
+		},
+	},
+	{
+		name: "version",
+		run: func(args []string) error {
+			printVersion()
+			return nil
+		},
+	},
+}
+
+// globalFlags parses the flags given before the command's name
+var globalFlags = flag.NewFlagSet("s3util", flag.ContinueOnError)
+
+// findCommand returns the named command, or nil if there is none
+func findCommand(name string) *command {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd
+		}
+	}
+	return nil
+}
+
+// usage prints the usage of a single command to stderr. The
+// default command is described by the general usage instead.
+func (c *command) usage() {
+	if c == commands[0] {
+		usage()
+		return
+	}
+	fmt.Fprintf(os.Stderr, "usage: s3util %s %s\n", c.name, c.synopsis)
+	fmt.Fprint(os.Stderr, "Flags:\n")
+	c.fs.PrintDefaults()
+}
+
+// parseCommandLine picks the command named in the arguments and
+// parses its flags, returning the command and its positional
+// arguments. Global flags may also precede the command's name.
+// Arguments that don't name a command belong to the default one.
+func parseCommandLine(arguments []string) (*command, []string, error) {
+	// Registering a flag resets it to its default value, so
+	// every flag set is created before any of them is parsed.
+	addGlobalFlags(globalFlags)
+	for _, cmd := range commands {
+		cmd.fs = flag.NewFlagSet("s3util "+cmd.name, flag.ContinueOnError)
+		addGlobalFlags(cmd.fs)
+		for _, add := range cmd.flags {
+			add(cmd.fs)
+		}
+		cmd.fs.Usage = cmd.usage
+	}
+
+	cmd, rest := commands[0], arguments
+	// Errors are left to the default command, which may well
+	// accept the flags that aren't global.
+	globalFlags.SetOutput(io.Discard)
+	if err := globalFlags.Parse(arguments); err == nil {
+		args := globalFlags.Args()
+		consumed := len(arguments) - len(args)
+		afterDashes := consumed > 0 && arguments[consumed-1] == "--"
+		if len(args) > 0 && !afterDashes {
+			if named := findCommand(args[0]); named != nil {
+				cmd, rest = named, args[1:]
+				globalFlags.Visit(recordPassedFlag)
+			}
+		}
+	}
+	args, err := parseArgs(cmd.fs, rest)
+	if err != nil {
+		return nil, nil, err
+	}
+	cmd.fs.Visit(recordPassedFlag)
+	return cmd, args, nil
+}
+
+// addGlobalFlags registers the flags every command accepts
+func addGlobalFlags(fs *flag.FlagSet) {
+	fs.StringVar(&region, "region", "", "region, or datacenter with -provider do (defaults to $AWS_REGION or $AWS_DEFAULT_REGION, or to the signing region of a known -endpoint such as nyc3.digitaloceanspaces.com)")
+	fs.StringVar(&endpoint, "endpoint", "", "S3 endpoint (defaults to $AWS_ENDPOINT_URL or $AWS_S3_ENDPOINT)")
+	fs.StringVar(&provider, "provider", "aws", "derive the endpoint from -region for a provider when none is given: "+providerNames())
+	fs.BoolVar(&forcePathStyle, "force-path-style", false, "use path-style addressing (defaults to $AWS_S3_FORCE_PATH_STYLE)")
+	fs.StringVar(&signatureVersion, "signature-version", signatureV4, "how to sign requests: v4, or v2 for old S3 compatible servers (implies -force-path-style)")
+	fs.StringVar(&caBundle, "ca-bundle", "", "PEM file of CA certificates to trust (defaults to $AWS_CA_BUNDLE)")
+	fs.DurationVar(&httpTimeout, "http-timeout", 0, "maximum duration of each HTTP request including its body, e.g. 1m (0 means no limit)")
+	fs.StringVar(&expectedBucketOwner, "expected-bucket-owner", "", "account ID that must own every bucket accessed, or requests fail")
+	fs.Var(&customHeaders, "header", "header 'Name: Value' to send with every request (repeatable)")
+	fs.StringVar(&requestPayer, "request-payer", "", "set to requester to read from requester pays buckets at your own expense")
+	fs.IntVar(&parallelism, "parallelism", 10, "maximum number of concurrent transfers")
+	fs.BoolVar(&autoConcurrency, "concurrency-auto", false, "start with few concurrent transfers and add more while throughput improves, up to -parallelism")
+	fs.BoolVar(&singleThreaded, "single-threaded", false, "run jobs one at a time and in order without any worker goroutines, e.g. for debugging (implies -part-concurrency 1)")
+	fs.IntVar(&maxConnsPerHost, "max-conns-per-host", 0, "maximum connections to each host, all kept open for reuse (0 means no limit, keeping -parallelism times -part-concurrency)")
+	fs.IntVar(&listParallelism, "list-parallelism", 1, "number of directories beneath a prefix to list concurrently when listing everything under it, including with ls")
+	fs.DurationVar(&perFileTimeout, "per-file-timeout", 0, "maximum duration of each individual transfer, e.g. 5m (0 means no limit)")
+	fs.BoolVar(&quiet, "quiet", false, "do not print anything, not even errors")
+	fs.BoolVar(&onlyShowErrors, "only-show-errors", false, "only print errors and the final summary")
+	fs.BoolVar(&awsErrorExitCodes, "only-errors-exit-code", false, "exit with 10 on AccessDenied, 11 on NoSuchBucket, 12 on NoSuchKey and 13 on SlowDown")
+	fs.StringVar(&metricsAddr, "metrics-addr", "", "serve Prometheus metrics on this address during the transfer, e.g. :9090")
+	fs.StringVar(&summaryOut, "summary-out", "", "write a JSON summary of the run to this file, e.g. summary.json")
+	fs.BoolVar(&showVersion, "version", false, "print version information and exit")
+}
+
+// addTransferFlags registers the flags of commands that transfer files
+func addTransferFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&showProgress, "progress", false, "show aggregate transfer progress on stderr")
+	fs.BoolVar(&showProgressBar, "progress-bar", false, "draw a progress bar on stderr if it's a terminal, and log the progress every 10s otherwise")
+	fs.Int64Var(&partSize, "part-size", s3manager.DefaultUploadPartSize, "size in bytes of the parts of multipart uploads and ranged downloads")
+	fs.IntVar(&partConcurrency, "part-concurrency", s3manager.DefaultUploadConcurrency, "number of parts of each file to transfer concurrently")
+	fs.IntVar(&maxRetries, "max-retries", 0, "number of times to retry a failed transfer")
+	fs.DurationVar(&retryBaseDelay, "retry-base-delay", time.Second, "upper bound of the random delay before the first retry, doubling with each further retry")
+	fs.DurationVar(&retryMaxDelay, "retry-max-delay", 30*time.Second, "upper bound of the random delay before any retry")
+	fs.BoolVar(&encryptClientSide, "encrypt-client-side", false, "encrypt uploads and decrypt downloads with AES-256-GCM (objects are only readable by s3util)")
+	fs.StringVar(&keyFile, "key-file", "", "file containing the 32 byte key for -encrypt-client-side")
+	fs.Int64Var(&maxBandwidth, "max-bandwidth", 0, "maximum bytes per second of all transfers together (0 means no limit)")
+	fs.Int64Var(&bwLimitPerFile, "bwlimit-per-file", 0, "maximum bytes per second of each file's transfer (0 means no limit)")
+	fs.IntVar(&bufferSize, "buffer-size", defaultBufferSize, "size in bytes of the buffer streams are copied through, e.g. between archives and S3")
+	fs.BoolVar(&preserveMode, "preserve-mode", false, "store the permission bits of uploaded files in their metadata and restore them on download")
+}
+
+// addObjectFlags registers the flags that set properties of uploaded objects
+func addObjectFlags(fs *flag.FlagSet) {
+	fs.StringVar(&storageClass, "storage-class", "", "storage class of uploaded or modified objects, e.g. STANDARD_IA or GLACIER")
+	fs.StringVar(&cannedACL, "acl", "", "canned ACL of uploaded or modified objects, e.g. private or public-read")
+	fs.StringVar(&ifMatch, "if-match", "", "only upload if the existing object's ETag matches (\"*\" for any existing object)")
+	fs.StringVar(&ifNoneMatch, "if-none-match", "", "only upload if no existing object's ETag matches (\"*\" to never overwrite)")
+	fs.StringVar(&objectLockMode, "object-lock-mode", "", "object lock retention mode of uploads: GOVERNANCE or COMPLIANCE")
+	fs.StringVar(&objectLockRetainUntil, "object-lock-retain-until", "", "RFC3339 date until which uploads are locked, required with -object-lock-mode")
+	fs.StringVar(&legalHold, "legal-hold", "", "legal hold status of uploads: on or off")
+	fs.BoolVar(&sendContentMD5, "content-md5", false, "send the Content-MD5 header with single part uploads so S3 rejects corrupted data")
+	fs.StringVar(&contentType, "content-type", "", "content type of uploaded objects instead of guessing it from their extension")
+	fs.BoolVar(&noGuessContentType, "no-guess-content-type", false, "don't set the content type of uploads from their file extension")
+	fs.StringVar(&contentLanguage, "content-language", "", "Content-Language of uploaded objects, e.g. en-US")
+	fs.StringVar(&websiteRedirect, "website-redirect", "", "make uploads redirects of a static website bucket to this path, e.g. /new, or URL")
+	fs.StringVar(&sse, "sse", "", "server-side encryption of uploads: AES256 or aws:kms")
+	fs.StringVar(&sseKMSKeyID, "sse-kms-key-id", "", "KMS key to encrypt uploads with, for -sse aws:kms (defaults to the AWS managed key)")
+	fs.StringVar(&sseKMSEncryptionContext, "sse-kms-encryption-context", "", "KMS encryption context of uploads as key=value,..., for -sse aws:kms")
+}
+
+// addCustomerKeyFlags registers the key of objects encrypted
+// with SSE-C, for the commands that read or write objects
+func addCustomerKeyFlags(fs *flag.FlagSet) {
+	fs.StringVar(&sseCustomerKey, "sse-customer-key", "", "base64 encoded 32 byte key to encrypt uploads with and decrypt downloads of objects using SSE-C")
+}
+
+// addUploadFlags registers the flags that choose what cp uploads
+func addUploadFlags(fs *flag.FlagSet) {
+	fs.StringVar(&aclFile, "acl-from-file", "", "JSON file of grants to apply to each uploaded object")
+	fs.BoolVar(&noFollow, "no-follow", false, "fail instead of uploading the target when the source path is a symlink")
+	fs.StringVar(&trimExtension, "trim-extension", "", "remove this extension from the keys of uploaded files, e.g. .html")
+	fs.BoolVar(&keepEmptyDirs, "keep-empty-dirs", false, "upload empty directories as marker objects ending in / and recreate such markers as directories on download")
+	fs.BoolVar(&verifyUploads, "verify", false, "check the size and checksum or ETag of every uploaded object against its file afterwards")
+	fs.BoolVar(&expandArchive, "expand-archive", false, "upload each file in a .tar, .tar.gz, .tgz or .zip source as an object beneath the prefix")
+	fs.BoolVar(&lowercaseKeys, "lowercase-keys", false, "lowercase the keys generated for uploaded files, failing if two files would get the same key")
+	fs.Var(&uploadExts, "ext", "only upload the files of a directory with this extension, e.g. .jpg (repeatable)")
+	fs.IntVar(&mismatchRetries, "retry-on-checksum-mismatch", 0, "number of times to upload a file again if -verify finds its object doesn't match it")
+	fs.BoolVar(&precomputeTotal, "precompute-total", false, "measure a source directory before uploading it so -progress can show a percentage and ETA")
+	fs.BoolVar(&contentAddressed, "content-addressed", false, "upload files as sha256/<hash> beneath the prefix, skipping those already stored")
+	fs.StringVar(&overwritePolicy, "overwrite-policy", overwriteAlways, "when to replace existing objects: always, never, if-newer or if-different")
+	fs.BoolVar(&resumableUpload, "resumable-upload", false, "record the parts of multipart uploads in a state file so an interrupted upload resumes where it left off")
+	fs.StringVar(&explicitKey, "key", "", "key to upload a single file to, instead of giving it in the destination")
+	fs.IntVar(&maxFiles, "max-files", 0, "refuse to upload a directory of more than this many files (0 means no limit)")
+	fs.BoolVar(&restoreSidecar, "from-metadata-sidecar", false, "upload files with the attributes in their <file>.meta.json written by -with-metadata-sidecar")
+	fs.Int64Var(&splitSize, "split-size", 0, "upload a file as objects <key>.part0000, <key>.part0001, ... of this many bytes each, for providers capping object size (0 means whole)")
+	fs.Int64Var(&contentLength, "content-length", -1, "size in bytes of stdin uploaded with source -, so a single PutObject can be used instead of a multipart upload")
+	fs.BoolVar(&flatten, "flatten", false, "upload the files of a directory directly beneath the prefix, dropping their subdirectories")
+	fs.StringVar(&onCollision, "on-collision", collisionError, "what -flatten does with files of the same name: error, rename, skip or overwrite")
+}
+
+// addDownloadFlags registers the flags of cp for downloads
+func addDownloadFlags(fs *flag.FlagSet) {
+	fs.StringVar(&tempDir, "temp-dir", "", "directory to stage downloads in before moving them into place (defaults to the destination's directory, must be on the same filesystem)")
+	fs.StringVar(&versionID, "version-id", "", "version of the object to download in a versioned bucket")
+	fs.BoolVar(&listOnly, "list-only", false, "print the objects matching a wildcard download and their total size without downloading them")
+	fs.StringVar(&checksumMode, "checksum-mode", "", "set to ENABLED to verify downloads against the checksum stored with the object, if it covers the whole object")
+	fs.StringVar(&newerThan, "newer-than", "", "download only the objects of a wildcard download modified after this RFC 3339 time or this long ago, e.g. 24h")
+	fs.BoolVar(&metadataSidecar, "with-metadata-sidecar", false, "write the content type, metadata, ETag, storage class and ACL of each download to <file>.meta.json")
+	fs.BoolVar(&renameOnCollision, "rename-on-collision", false, "save downloads whose destination exists as e.g. \"a (1).txt\" instead of replacing the file")
+	fs.BoolVar(&reassemble, "reassemble", false, "download the pieces of a file uploaded with -split-size and join them into the file")
+	fs.BoolVar(&stripPrefix, "strip-prefix", false, "save objects of a wildcard download relative to the prefix instead of the bucket root")
+}
+
+// addCopyFlags registers the flags of cp for copies between buckets
+func addCopyFlags(fs *flag.FlagSet) {
+	fs.StringVar(&srcEndpoint, "src-endpoint", "", "endpoint of the source bucket when copying between buckets")
+	fs.StringVar(&srcProfile, "src-profile", "", "shared config profile for the source bucket when copying between buckets")
+	fs.StringVar(&dstEndpoint, "dst-endpoint", "", "endpoint of the destination bucket when copying between buckets")
+	fs.StringVar(&dstProfile, "dst-profile", "", "shared config profile for the destination bucket when copying between buckets")
+	fs.StringVar(&copyIfMatch, "copy-if-match", "", "only copy if the source's ETag matches")
+	fs.StringVar(&copyIfNoneMatch, "copy-if-none-match", "", "only copy if the source's ETag doesn't match")
+	fs.StringVar(&copyIfModifiedSince, "copy-if-modified-since", "", "only copy if the source was modified since this RFC 3339 time")
+	fs.StringVar(&copyIfUnmodifiedSince, "copy-if-unmodified-since", "", "only copy if the source wasn't modified since this RFC 3339 time")
+	fs.BoolVar(&copyACL, "copy-acl", false, "give copied objects the grants of the source's ACL, which S3 doesn't copy")
+	fs.BoolVar(&replaceTags, "replace-tags", false, "replace the tags of copied objects with the -tag flags instead of keeping the source's")
+	fs.Var(&copyTags, "tag", "tag key=value of copied objects with -replace-tags (repeatable)")
+}
+
+// addConfirmFlags registers the flags of commands that ask before deleting
+func addConfirmFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&assumeYes, "yes", false, "delete without asking for confirmation")
+	fs.BoolVar(&assumeYes, "force", false, "same as -yes")
+}
+
+// addListFlags registers the flags of ls
+func addListFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&listVersions, "versions", false, "list all versions of each object")
+	fs.StringVar(&outputFormat, "output-format", "table", "output format: table, json or csv")
+	fs.StringVar(&startAfter, "start-after", "", "list only keys after this one")
+	fs.Int64Var(&maxKeys, "max-keys", 0, "list at most this many objects (0 means no limit)")
+	fs.StringVar(&newerThan, "newer-than", "", "list only objects modified after this RFC 3339 time or this long ago, e.g. 24h")
+	fs.BoolVar(&folderSizes, "list-delimiter-recursive", false, "print the number and size of the objects under each directory as a tree")
+}
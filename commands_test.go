@@ -0,0 +1,50 @@
+package main
+
+import (
+	"flag"
+	"strings"
+	"testing"
+)
+
+func TestParseCommandLine(t *testing.T) {
+	for _, tt := range []struct {
+		args []string
+		name string
+		rest []string
+	}{
+		{[]string{"a.txt", "s3://b/a.txt"}, "cp", []string{"a.txt", "s3://b/a.txt"}},
+		{[]string{"cp", "a.txt", "s3://b/a.txt"}, "cp", []string{"a.txt", "s3://b/a.txt"}},
+		{[]string{"-region", "eu-west-1", "ls", "s3://b"}, "ls", []string{"s3://b"}},
+		{[]string{"rm", "-r", "s3://b/dir/"}, "rm", []string{"s3://b/dir/"}},
+		{[]string{"sync", "-delete", "dir", "s3://b"}, "sync", []string{"dir", "s3://b"}},
+		{[]string{"--", "ls", "s3://b/ls"}, "cp", []string{"ls", "s3://b/ls"}},
+		{[]string{"frobnicate", "s3://b"}, "cp", []string{"frobnicate", "s3://b"}},
+	} {
+		globalFlags = flag.NewFlagSet("s3util", flag.ContinueOnError)
+		cmd, rest, err := parseCommandLine(tt.args)
+		if err != nil {
+			t.Errorf("%q: %v", tt.args, err)
+			continue
+		}
+		if cmd.name != tt.name || strings.Join(rest, " ") != strings.Join(tt.rest, " ") {
+			t.Errorf("%q dispatched to %s %q, want %s %q", tt.args, cmd.name, rest, tt.name, tt.rest)
+		}
+	}
+}
+
+func TestParseCommandLineRejectsOtherCommandsFlags(t *testing.T) {
+	for _, args := range [][]string{
+		{"ls", "-delete", "s3://b"},
+		{"rm", "-part-size", "5242880", "s3://b/a"},
+		{"-no-such-flag", "a", "b"},
+	} {
+		globalFlags = flag.NewFlagSet("s3util", flag.ContinueOnError)
+		var err error
+		usage := captureStderr(t, func() { _, _, err = parseCommandLine(args) })
+		if err == nil {
+			t.Errorf("%q was accepted", args)
+		} else if !strings.Contains(usage, "usage:") {
+			t.Errorf("%q printed %q instead of the usage", args, usage)
+		}
+	}
+}
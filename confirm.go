@@ -0,0 +1,44 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// assumeYes is set by -yes or -force to skip confirmation
+var assumeYes bool
+
+// promptInput is where answers to confirmation prompts are read
+var promptInput io.Reader = os.Stdin
+
+// interactive reports whether anyone is there to answer
+// confirmation prompts
+var interactive = func() bool { return isTerminal(os.Stdin) }
+
+// isTerminal reports whether f is attached to a terminal
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// confirm asks whether to go ahead with deleting objects and
+// returns an error unless the answer is yes. Only interactive
+// runs are asked, so scripts work as before.
+func confirm(format string, args ...interface{}) error {
+	if assumeYes || !interactive() {
+		return nil
+	}
+	fmt.Fprintf(os.Stderr, format+"? [y/N] ", args...)
+	answer, err := bufio.NewReader(promptInput).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return err
+	}
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return nil
+	}
+	return fmt.Errorf("aborted")
+}
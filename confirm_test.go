@@ -0,0 +1,46 @@
+package main
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestConfirmDestructiveCommands(t *testing.T) {
+	defer func(old func() bool) { interactive = old }(interactive)
+	defer func(old io.Reader) { promptInput = old }(promptInput)
+	interactive = func() bool { return true }
+
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"a.txt": "a"})
+	for _, tt := range []struct {
+		answer  string
+		flags   []string
+		deleted bool
+	}{
+		{"n\n", nil, false},
+		{"\n", nil, false},
+		{"y\n", nil, true},
+		{"YES\n", nil, true},
+		{"", []string{"-yes"}, true},
+		{"", []string{"-force"}, true},
+	} {
+		for _, args := range [][]string{
+			{"rm", "-r", "s3://b/dir/"},
+			{"mv", "s3://b/dir/", "s3://b/moved/"},
+			{"sync", "-delete", dir, "s3://b/dir/"},
+		} {
+			s := newFakeS3(t, "b")
+			s.put("b", "dir/old.txt", "old")
+			promptInput = strings.NewReader(tt.answer)
+			args = append(append(args[:1:1], tt.flags...), args[1:]...)
+			_, err := run(t, args...)
+			if deleted := s.object("b", "dir/old.txt") == nil; deleted != tt.deleted {
+				t.Errorf("%v answered with %q deleted the object: %v, want %v", args, tt.answer, deleted, tt.deleted)
+			}
+			if tt.deleted != (err == nil) {
+				t.Errorf("%v answered with %q: %v", args, tt.answer, err)
+			}
+		}
+	}
+}
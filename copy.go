@@ -0,0 +1,244 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+// Endpoints and shared config profiles used for each side of
+// an S3-to-S3 copy. Empty values select the defaults.
+var (
+	srcEndpoint string
+	srcProfile  string
+	dstEndpoint string
+	dstProfile  string
+)
+
+// Preconditions on the source of an S3-to-S3 copy, which fails
+// with 412 Precondition Failed unless they hold. The times are in
+// RFC 3339 format, e.g. 2024-01-02T15:04:05Z.
+var (
+	copyIfMatch           string
+	copyIfNoneMatch       string
+	copyIfModifiedSince   string
+	copyIfUnmodifiedSince string
+)
+
+// copyACL gives copies the grants of the source object's ACL,
+// e.g. to keep objects public-read. Copies otherwise get the
+// default private ACL, whichever way they are made.
+var copyACL bool
+
+// copyConditions are the validated preconditions, with unset flags
+// left nil so they are omitted from requests
+type copyConditions struct {
+	ifMatch           *string
+	ifNoneMatch       *string
+	ifModifiedSince   *time.Time
+	ifUnmodifiedSince *time.Time
+}
+
+// copyCondition is parsed from the flags by parseCopyConditions
+var copyCondition copyConditions
+
+// parseCopyConditions validates the copy precondition flags
+func parseCopyConditions() (copyConditions, error) {
+	conditions := copyConditions{
+		ifMatch:     optionalString(copyIfMatch),
+		ifNoneMatch: optionalString(copyIfNoneMatch),
+	}
+	for _, c := range []struct {
+		name  string
+		value string
+		dest  **time.Time
+	}{
+		{"-copy-if-modified-since", copyIfModifiedSince, &conditions.ifModifiedSince},
+		{"-copy-if-unmodified-since", copyIfUnmodifiedSince, &conditions.ifUnmodifiedSince},
+	} {
+		if c.value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, c.value)
+		if err != nil {
+			return conditions, fmt.Errorf("invalid %s '%s' (expected e.g. 2024-01-02T15:04:05Z)", c.name, c.value)
+		}
+		*c.dest = &t
+	}
+	return conditions, nil
+}
+
+// copyObject copies a single object between two s3 paths. If the
+// destination key is empty or ends in a slash, the base name of
+// the source key is appended to it.
+func copyObject(source string, dest string) error {
+	srcBucket, srcKey, err := splitNameParts(source)
+	if err != nil {
+		return fmt.Errorf("failed to parse source: %w", err)
+	}
+	if srcKey == "" {
+		return fmt.Errorf("source '%s' does not specify a key", source)
+	}
+	dstBucket, dstKey, err := splitNameParts(dest)
+	if err != nil {
+		return fmt.Errorf("failed to parse destination: %w", err)
+	}
+	if dstKey == "" || strings.HasSuffix(dstKey, "/") {
+		dstKey += path.Base(srcKey)
+	}
+
+	srcSess := createSessionFor(srcEndpoint, srcProfile)
+	dstSess := createSessionFor(dstEndpoint, dstProfile)
+
+	if !canCopyServerSide(srcSess, dstSess) {
+		// CopyObject is issued against the destination and can
+		// only read objects reachable from the same endpoint with
+		// the same credentials. Otherwise the object has to pass
+		// through this machine.
+		if err := copyViaStream(srcSess, dstSess, srcBucket, srcKey, dstBucket, dstKey); err != nil {
+			return err
+		}
+		if copyACL {
+			if err := copyObjectACL(srcSess, dstSess, srcBucket, srcKey, dstBucket, dstKey); err != nil {
+				return err
+			}
+		}
+		logSuccess("copy: %s to s3://%s/%s", source, dstBucket, dstKey)
+		return nil
+	}
+
+	ctx, cancel := jobContext()
+	defer cancel()
+	directive, tagging := taggingDirective()
+	if _, err := s3.New(dstSess).CopyObjectWithContext(ctx, &s3.CopyObjectInput{
+		Bucket:                      aws.String(dstBucket),
+		Key:                         aws.String(dstKey),
+		CopySource:                  aws.String(copySource(srcBucket, srcKey)),
+		CopySourceIfMatch:           copyCondition.ifMatch,
+		CopySourceIfNoneMatch:       copyCondition.ifNoneMatch,
+		CopySourceIfModifiedSince:   copyCondition.ifModifiedSince,
+		CopySourceIfUnmodifiedSince: copyCondition.ifUnmodifiedSince,
+		TaggingDirective:            directive,
+		Tagging:                     tagging,
+		RequestPayer:                optionalString(requestPayer),
+	}); err != nil {
+		return fmt.Errorf("failed to copy '%s' to '%s': %w", source, dest, err)
+	}
+	if copyACL {
+		if err := copyObjectACL(srcSess, dstSess, srcBucket, srcKey, dstBucket, dstKey); err != nil {
+			return err
+		}
+	}
+	logSuccess("copy: %s to s3://%s/%s", source, dstBucket, dstKey)
+	return nil
+}
+
+// copyObjectACL reads the ACL of the source object and applies its
+// grants to the copy, which keeps its own owner so that copies to
+// another account's bucket work
+func copyObjectACL(
+	srcSess *session.Session,
+	dstSess *session.Session,
+	srcBucket string,
+	srcKey string,
+	dstBucket string,
+	dstKey string,
+) error {
+	acl, err := s3.New(srcSess).GetObjectAcl(&s3.GetObjectAclInput{
+		Bucket:       aws.String(srcBucket),
+		Key:          aws.String(srcKey),
+		RequestPayer: optionalString(requestPayer),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to get ACL of '%s': %w", srcKey, err)
+	}
+	return putObjectACL(s3.New(dstSess), aws.String(dstBucket), aws.String(dstKey), &s3.AccessControlPolicy{
+		Grants: acl.Grants,
+	})
+}
+
+// canCopyServerSide reports whether the source and destination
+// share an endpoint and credentials, in which case the copy can
+// be performed by S3 itself.
+func canCopyServerSide(srcSess *session.Session, dstSess *session.Session) bool {
+	return aws.StringValue(srcSess.Config.Endpoint) == aws.StringValue(dstSess.Config.Endpoint) &&
+		srcProfile == dstProfile
+}
+
+// copyViaStream pipes the body of the source object, fetched
+// with the source session, straight into an upload made with the
+// destination session. Nothing is written to disk and memory use
+// is bounded by the uploader's part buffers regardless of the
+// object's size.
+func copyViaStream(
+	srcSess *session.Session,
+	dstSess *session.Session,
+	srcBucket string,
+	srcKey string,
+	dstBucket string,
+	dstKey string,
+) error {
+	// The upload has no tagging directive, so tags are carried
+	// over by hand.
+	tagging := optionalString(encodeTags(copyTags))
+	if !replaceTags {
+		var err error
+		if tagging, err = tagsOf(s3.New(srcSess), srcBucket, srcKey); err != nil {
+			return err
+		}
+	}
+
+	ctx, cancel := jobContext()
+	defer cancel()
+	// The same preconditions apply to reading the source.
+	out, err := s3.New(srcSess).GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket:            aws.String(srcBucket),
+		Key:               aws.String(srcKey),
+		IfMatch:           copyCondition.ifMatch,
+		IfNoneMatch:       copyCondition.ifNoneMatch,
+		IfModifiedSince:   copyCondition.ifModifiedSince,
+		IfUnmodifiedSince: copyCondition.ifUnmodifiedSince,
+		RequestPayer:      optionalString(requestPayer),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to download '%s': %w", srcKey, err)
+	}
+	defer out.Body.Close()
+
+	pr, pw := io.Pipe()
+	go func() {
+		var body io.Reader = out.Body
+		if limiters := fileLimiters(); limiters != nil {
+			body = &limitedReader{r: body, limiters: limiters}
+		}
+		_, err := copyBuffered(pw, body)
+		pw.CloseWithError(err)
+	}()
+	// CopyObject keeps the attributes of the object, which the
+	// upload has to be given explicitly
+	if _, err := newUploader(dstSess).UploadWithContext(ctx, &s3manager.UploadInput{
+		Bucket:             aws.String(dstBucket),
+		Key:                aws.String(dstKey),
+		Body:               pr,
+		Tagging:            tagging,
+		ContentType:        out.ContentType,
+		ContentEncoding:    out.ContentEncoding,
+		ContentDisposition: out.ContentDisposition,
+		ContentLanguage:    out.ContentLanguage,
+		CacheControl:       out.CacheControl,
+		Metadata:           out.Metadata,
+		StorageClass:       out.StorageClass,
+	}); err != nil {
+		// Unblock the copying goroutine.
+		pr.CloseWithError(err)
+		return fmt.Errorf("failed to upload '%s': %w", dstKey, err)
+	}
+	return nil
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCopySameEndpoint(t *testing.T) {
+	s := newFakeS3(t, "src", "dst")
+	s.put("src", "dir/a.txt", "hello")
+	if _, err := run(t, "s3://src/dir/a.txt", "s3://dst/copies/"); err != nil {
+		t.Fatal(err)
+	}
+	if obj := s.object("dst", "copies/a.txt"); obj == nil || string(obj.data) != "hello" {
+		t.Fatalf("copy is %v", obj)
+	}
+	if copies := s.received(http.MethodPut, ""); len(copies) != 1 || copies[0].header.Get("X-Amz-Copy-Source") == "" {
+		t.Errorf("expected a single CopyObject, got %v", copies)
+	}
+}
+
+func TestCopyAcrossEndpoints(t *testing.T) {
+	src := newFakeS3(t, "src")
+	dst := newFakeS3(t, "dst")
+	src.put("src", "a.txt", "hello")
+	if _, err := run(t, "-src-endpoint", src.URL, "-dst-endpoint", dst.URL, "s3://src/a.txt", "s3://dst/b.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if obj := dst.object("dst", "b.txt"); obj == nil || string(obj.data) != "hello" {
+		t.Fatalf("copy is %v", obj)
+	}
+	for _, r := range dst.received(http.MethodPut, "") {
+		if r.header.Get("X-Amz-Copy-Source") != "" {
+			t.Errorf("CopyObject sent to the destination endpoint, which can't read the source")
+		}
+	}
+}
+
+func TestCopyAcrossProfiles(t *testing.T) {
+	s := newFakeS3(t, "src", "dst")
+	s.put("src", "a.txt", "hello")
+	credentials := filepath.Join(t.TempDir(), "credentials")
+	if err := os.WriteFile(credentials, []byte("[other]\naws_access_key_id = AKIDOTHER\naws_secret_access_key = secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", credentials)
+	if _, err := run(t, "-src-profile", "other", "s3://src/a.txt", "s3://dst/a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if obj := s.object("dst", "a.txt"); obj == nil || string(obj.data) != "hello" {
+		t.Fatalf("copy is %v", obj)
+	}
+	if gets := s.received(http.MethodGet, ""); len(gets) == 0 {
+		t.Errorf("expected the source to be read with its own profile")
+	}
+}
+
+func TestCopyMissingProfile(t *testing.T) {
+	newFakeS3(t, "src", "dst")
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(t.TempDir(), "credentials"))
+	if _, err := run(t, "-src-profile", "missing", "s3://src/a.txt", "s3://dst/a.txt"); err == nil {
+		t.Fatal("expected an error for a profile without credentials")
+	}
+}
+
+func TestCopyViaStreamKeepsAttributes(t *testing.T) {
+	src := newFakeS3(t, "src")
+	dst := newFakeS3(t, "dst")
+	src.put("src", "a.json", "{}",
+		"Content-Type", "application/json",
+		"Content-Language", "en",
+		"Cache-Control", "max-age=60",
+		"X-Amz-Meta-Owner", "alice",
+		"X-Amz-Storage-Class", "STANDARD_IA")
+	if _, err := run(t, "-src-endpoint", src.URL, "-dst-endpoint", dst.URL, "s3://src/a.json", "s3://dst/a.json"); err != nil {
+		t.Fatal(err)
+	}
+	obj := dst.object("dst", "a.json")
+	if obj == nil {
+		t.Fatal("nothing was copied")
+	}
+	for name, want := range map[string]string{
+		"Content-Type":        "application/json",
+		"Content-Language":    "en",
+		"Cache-Control":       "max-age=60",
+		"X-Amz-Meta-Owner":    "alice",
+		"X-Amz-Storage-Class": "STANDARD_IA",
+	} {
+		if got := obj.header.Get(name); got != want {
+			t.Errorf("%s of the copy is %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestTaggingDirective(t *testing.T) {
+	defer func() { replaceTags, copyTags = false, nil }()
+	replaceTags, copyTags = false, nil
+	if directive, tagging := taggingDirective(); *directive != "COPY" || tagging != nil {
+		t.Errorf("copies with %s and %v by default", *directive, tagging)
+	}
+	replaceTags, copyTags = true, tagList{"env=prod", "team=a b"}
+	if directive, tagging := taggingDirective(); *directive != "REPLACE" || tagging == nil || *tagging != "env=prod&team=a+b" {
+		t.Errorf("copies with %s and %v with -replace-tags", *directive, tagging)
+	}
+}
+
+func TestCopyTags(t *testing.T) {
+	for _, tt := range []struct {
+		flags []string
+		want  string
+	}{
+		{nil, "<Tag><Key>env</Key><Value>dev</Value></Tag>"},
+		{[]string{"-replace-tags", "-tag", "env=prod", "-tag", "team=a"},
+			"<Tag><Key>env</Key><Value>prod</Value></Tag><Tag><Key>team</Key><Value>a</Value></Tag>"},
+		{[]string{"-replace-tags"}, ""},
+	} {
+		for _, acrossEndpoints := range []bool{false, true} {
+			src := newFakeS3(t, "src", "dst")
+			dst := src
+			if acrossEndpoints {
+				dst = newFakeS3(t, "dst")
+			}
+			src.put("src", "a.txt", "a")
+			src.object("src", "a.txt").tagging = taggingXML("env=dev")
+			args := append(tt.flags, "-src-endpoint", src.URL, "-dst-endpoint", dst.URL, "s3://src/a.txt", "s3://dst/a.txt")
+			if _, err := run(t, args...); err != nil {
+				t.Fatal(err)
+			}
+			obj := dst.object("dst", "a.txt")
+			if obj == nil {
+				t.Fatal("nothing was copied")
+			}
+			got := string(obj.tagging)
+			if obj.tagging == nil {
+				got = "<Tagging><TagSet></TagSet></Tagging>"
+			}
+			if want := "<Tagging><TagSet>" + tt.want + "</TagSet></Tagging>"; got != want {
+				t.Errorf("%v across endpoints: %v copied the tags %s, want %s", tt.flags, acrossEndpoints, got, want)
+			}
+		}
+	}
+	newFakeS3(t, "src", "dst")
+	_, err := run(t, "-tag", "env=prod", "s3://src/a.txt", "s3://dst/a.txt")
+	if code := exitCode(err); code != exitUsage {
+		t.Errorf("-tag without -replace-tags exited with %d (%v), want %d", code, err, exitUsage)
+	}
+}
+
+func TestCopyWithoutTagging(t *testing.T) {
+	for _, code := range []string{"NotImplemented", "AccessDenied"} {
+		src := newFakeS3(t, "src")
+		dst := newFakeS3(t, "dst")
+		src.put("src", "a.txt", "a")
+		src.intercept = func(w http.ResponseWriter, r *http.Request) bool {
+			if _, ok := r.URL.Query()["tagging"]; ok {
+				fakeError(w, http.StatusNotImplemented, code)
+				return true
+			}
+			return false
+		}
+		var err error
+		stderr := captureStderr(t, func() {
+			_, err = run(t, "-src-endpoint", src.URL, "-dst-endpoint", dst.URL, "s3://src/a.txt", "s3://dst/a.txt")
+		})
+		if err != nil {
+			t.Fatalf("%s: %v", code, err)
+		}
+		if obj := dst.object("dst", "a.txt"); obj == nil || string(obj.data) != "a" || obj.tagging != nil {
+			t.Errorf("%s: copied %+v", code, obj)
+		}
+		if !strings.Contains(stderr, "warning: copying 'a.txt' without its tags: "+code) {
+			t.Errorf("%s: warned %q", code, stderr)
+		}
+	}
+}
+
+func TestCopyConditions(t *testing.T) {
+	s := newFakeS3(t, "src", "dst")
+	s.put("src", "a.txt", "a")
+	for _, tt := range []struct {
+		flag, value, header string
+	}{
+		{"-copy-if-match", `"abc"`, "X-Amz-Copy-Source-If-Match"},
+		{"-copy-if-none-match", `"abc"`, "X-Amz-Copy-Source-If-None-Match"},
+		{"-copy-if-modified-since", "2024-01-02T15:04:05Z", "X-Amz-Copy-Source-If-Modified-Since"},
+		{"-copy-if-unmodified-since", "2024-01-02T15:04:05Z", "X-Amz-Copy-Source-If-Unmodified-Since"},
+	} {
+		// Whether the copy succeeds is up to the condition
+		run(t, tt.flag, tt.value, "-max-retries", "0", "s3://src/a.txt", "s3://dst/a.txt")
+		copies := s.received(http.MethodPut, "")
+		header := copies[len(copies)-1].header
+		want := tt.value
+		if strings.HasSuffix(tt.flag, "-since") {
+			want = "Tue, 02 Jan 2024 15:04:05 GMT"
+		}
+		if got := header.Get(tt.header); got != want {
+			t.Errorf("%s %s sent %s: %q, want %q", tt.flag, tt.value, tt.header, got, want)
+		}
+	}
+	_, err := run(t, "-copy-if-modified-since", "yesterday", "s3://src/a.txt", "s3://dst/a.txt")
+	if code := exitCode(err); code != exitUsage {
+		t.Errorf("an invalid time exited with %d (%v), want %d", code, err, exitUsage)
+	}
+}
+
+func TestCopyIfMatch(t *testing.T) {
+	s := newFakeS3(t, "src", "dst")
+	s.put("src", "a.txt", "a")
+	etag := s.object("src", "a.txt").etag
+	if _, err := run(t, "-copy-if-match", `"wrong"`, "-max-retries", "0", "s3://src/a.txt", "s3://dst/a.txt"); err == nil {
+		t.Error("copied despite the ETag not matching")
+	}
+	if _, err := run(t, "-copy-if-match", etag, "s3://src/a.txt", "s3://dst/a.txt"); err != nil {
+		t.Errorf("-copy-if-match with the source's ETag: %v", err)
+	}
+}
+
+func TestCopyACL(t *testing.T) {
+	s := newFakeS3(t, "b", "c")
+	s.put("b", "public.txt", "public")
+	s.object("b", "public.txt").acl = []byte(readerACL)
+	if _, err := run(t, "s3://b/public.txt", "s3://c/plain.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(s.received(http.MethodGet, "acl")) + len(s.received(http.MethodPut, "acl")); got != 0 {
+		t.Errorf("sent %d ACL requests without -copy-acl", got)
+	}
+	if acl := s.object("c", "plain.txt").acl; acl != nil {
+		t.Errorf("the copy got the ACL %s without -copy-acl", acl)
+	}
+
+	if _, err := run(t, "-copy-acl", "s3://b/public.txt", "s3://c/public.txt"); err != nil {
+		t.Fatal(err)
+	}
+	// The copy's own ACL is read for its owner
+	if got := s.received(http.MethodGet, "acl"); len(got) != 2 || got[0].bucket != "b" || got[1].bucket != "c" {
+		t.Errorf("read the ACLs of %+v, want the source's and then the copy's", got)
+	}
+	if got := s.received(http.MethodPut, "acl"); len(got) != 1 || got[0].bucket != "c" || got[0].key != "public.txt" {
+		t.Errorf("put the ACLs of %+v, want the copy's", got)
+	}
+	acl := string(s.object("c", "public.txt").acl)
+	for _, want := range []string{"<ID>reader</ID>", "<Permission>READ</Permission>", "<Permission>FULL_CONTROL</Permission>"} {
+		if !strings.Contains(acl, want) {
+			t.Errorf("the copy's ACL %s lacks %s", acl, want)
+		}
+	}
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+// Client-side encryption. With -encrypt-client-side, uploads are
+// encrypted with AES-256-GCM using the 32 byte key in -key-file
+// before they leave the machine, and downloads are decrypted
+// with it. The algorithm and nonce are stored in the object's
+// metadata. The object is sealed in fixed size chunks, so that
+// neither direction has to hold a whole object in memory, which
+// also means only s3util (given the same key) can read it.
+var (
+	encryptClientSide bool
+	keyFile           string
+)
+
+// clientSideKey is loaded from -key-file when
+// -encrypt-client-side is given and nil otherwise
+var clientSideKey cipher.AEAD
+
+const (
+	cseAlgorithm = "AES256-GCM-CHUNKED"
+
+	// cseChunkSize is the size of each sealed plaintext chunk
+	cseChunkSize = 64 * 1024
+
+	metaCSEAlgorithm = "s3util-cse-algorithm"
+	metaCSENonce     = "s3util-cse-nonce"
+)
+
+// loadClientSideKey reads a raw AES-256 key from a file
+func loadClientSideKey(path string) (cipher.AEAD, error) {
+	if path == "" {
+		return nil, fmt.Errorf("-encrypt-client-side requires -key-file")
+	}
+	key, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %w", err)
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("key file '%s' must contain exactly 32 bytes, found %d", path, len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// chunkNonce derives the nonce of the chunk at index from the
+// object's random base nonce, so no nonce is used twice.
+func chunkNonce(base []byte, index uint64) []byte {
+	nonce := make([]byte, len(base))
+	copy(nonce, base)
+	counter := nonce[len(nonce)-8:]
+	binary.BigEndian.PutUint64(counter, binary.BigEndian.Uint64(counter)^index)
+	return nonce
+}
+
+// chunkAdditionalData authenticates whether a chunk is the last
+// one, so a truncated object fails to decrypt rather than
+// silently producing a truncated file.
+func chunkAdditionalData(last bool) []byte {
+	if last {
+		return []byte{1}
+	}
+	return []byte{0}
+}
+
+// encryptingReader seals the plaintext read from src
+type encryptingReader struct {
+	aead  cipher.AEAD
+	src   *bufio.Reader
+	base  []byte
+	index uint64
+	plain []byte
+	buf   []byte
+	out   []byte
+	done  bool
+}
+
+// newEncryptingReader returns a reader of the ciphertext of src
+// and the metadata needed to decrypt it again.
+func newEncryptingReader(aead cipher.AEAD, src io.Reader) (io.Reader, map[string]*string, error) {
+	base := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(base); err != nil {
+		return nil, nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	metadata := map[string]*string{
+		metaCSEAlgorithm: aws.String(cseAlgorithm),
+		metaCSENonce:     aws.String(base64.StdEncoding.EncodeToString(base)),
+	}
+	return &encryptingReader{
+		aead:  aead,
+		src:   bufio.NewReader(src),
+		base:  base,
+		plain: make([]byte, cseChunkSize),
+	}, metadata, nil
+}
+
+func (r *encryptingReader) Read(b []byte) (int, error) {
+	for len(r.out) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		n, err := io.ReadFull(r.src, r.plain)
+		last := false
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			last = true
+		} else if err != nil {
+			return 0, err
+		} else if _, err := r.src.Peek(1); err == io.EOF {
+			last = true
+		} else if err != nil {
+			return 0, err
+		}
+		r.buf = r.aead.Seal(r.buf[:0], chunkNonce(r.base, r.index), r.plain[:n], chunkAdditionalData(last))
+		r.out = r.buf
+		r.index++
+		r.done = last
+	}
+	n := copy(b, r.out)
+	r.out = r.out[n:]
+	return n, nil
+}
+
+// decryptingReader opens the ciphertext read from src
+type decryptingReader struct {
+	aead   cipher.AEAD
+	src    *bufio.Reader
+	base   []byte
+	index  uint64
+	sealed []byte
+	buf    []byte
+	out    []byte
+	done   bool
+}
+
+// newDecryptingReader returns a reader of the plaintext of an
+// object written by newEncryptingReader, given its metadata.
+func newDecryptingReader(aead cipher.AEAD, src io.Reader, metadata map[string]*string) (io.Reader, error) {
+	if algorithm := lookupMetadata(metadata, metaCSEAlgorithm); algorithm != cseAlgorithm {
+		if algorithm == "" {
+			return nil, fmt.Errorf("object is not encrypted client-side")
+		}
+		return nil, fmt.Errorf("unsupported client-side encryption algorithm '%s'", algorithm)
+	}
+	base, err := base64.StdEncoding.DecodeString(lookupMetadata(metadata, metaCSENonce))
+	if err != nil || len(base) != aead.NonceSize() {
+		return nil, fmt.Errorf("object has an invalid client-side encryption nonce")
+	}
+	return &decryptingReader{
+		aead:   aead,
+		src:    bufio.NewReader(src),
+		base:   base,
+		sealed: make([]byte, cseChunkSize+aead.Overhead()),
+	}, nil
+}
+
+func (r *decryptingReader) Read(b []byte) (int, error) {
+	for len(r.out) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		n, err := io.ReadFull(r.src, r.sealed)
+		last := false
+		if err == io.EOF {
+			// The last chunk always carries at least a tag,
+			// so running out of data here means truncation.
+			return 0, io.ErrUnexpectedEOF
+		} else if err == io.ErrUnexpectedEOF {
+			last = true
+		} else if err != nil {
+			return 0, err
+		} else if _, err := r.src.Peek(1); err == io.EOF {
+			last = true
+		} else if err != nil {
+			return 0, err
+		}
+		r.buf, err = r.aead.Open(r.buf[:0], chunkNonce(r.base, r.index), r.sealed[:n], chunkAdditionalData(last))
+		if err != nil {
+			return 0, fmt.Errorf("failed to decrypt chunk %d: %w", r.index, err)
+		}
+		r.out = r.buf
+		r.index++
+		r.done = last
+	}
+	n := copy(b, r.out)
+	r.out = r.out[n:]
+	return n, nil
+}
+
+// lookupMetadata returns the value of a user metadata entry. The
+// SDK canonicalizes the case of metadata keys, hence the folding.
+func lookupMetadata(metadata map[string]*string, name string) string {
+	for k, v := range metadata {
+		if strings.EqualFold(k, name) {
+			return aws.StringValue(v)
+		}
+	}
+	return ""
+}
+
+// downloadDecrypted streams a client-side encrypted object into
+// w, decrypting it on the way. Unlike the s3manager.Downloader
+// this fetches the object sequentially, as chunks can only be
+// opened in order.
+func downloadDecrypted(
+	ctx context.Context,
+	client s3iface.S3API,
+	input *s3.GetObjectInput,
+	w io.Writer,
+	prog *progress,
+) error {
+	out, err := client.GetObjectWithContext(ctx, input)
+	if err != nil {
+		return err
+	}
+	defer out.Body.Close()
+	plain, err := newDecryptingReader(clientSideKey, out.Body, out.Metadata)
+	if err != nil {
+		return err
+	}
+	if prog != nil {
+		plain = &progressReader{r: plain, p: prog}
+	}
+	_, err = copyBuffered(w, plain)
+	return err
+}
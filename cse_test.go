@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testClientSideKey writes a random key to a file and returns
+// its path
+func testClientSideKey(t *testing.T) string {
+	t.Helper()
+	key := make([]byte, 32)
+	rand.Read(key)
+	path := filepath.Join(t.TempDir(), "key")
+	if err := os.WriteFile(path, key, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func encryptForTest(t *testing.T, plain []byte) ([]byte, map[string]*string) {
+	t.Helper()
+	path := testClientSideKey(t)
+	aead, err := loadClientSideKey(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, metadata, err := newEncryptingReader(aead, bytes.NewReader(plain))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientSideKey = aead
+	t.Cleanup(func() { clientSideKey = nil })
+	return sealed, metadata
+}
+
+func TestClientSideEncryptionRoundTrip(t *testing.T) {
+	for _, size := range []int{0, 1, cseChunkSize - 1, cseChunkSize, cseChunkSize + 1, 3 * cseChunkSize} {
+		plain := make([]byte, size)
+		rand.Read(plain)
+		sealed, metadata := encryptForTest(t, plain)
+		if size >= 16 && bytes.Contains(sealed, plain) {
+			t.Errorf("%d bytes: the ciphertext contains the plaintext", size)
+		}
+		r, err := newDecryptingReader(clientSideKey, bytes.NewReader(sealed), metadata)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("%d bytes: %v", size, err)
+		}
+		if !bytes.Equal(got, plain) {
+			t.Errorf("%d bytes: decrypted %d different bytes", size, len(got))
+		}
+	}
+}
+
+func TestClientSideDecryptionFails(t *testing.T) {
+	plain := make([]byte, 2*cseChunkSize+10)
+	sealed, metadata := encryptForTest(t, plain)
+	chunk := cseChunkSize + clientSideKey.Overhead()
+	tampered := append([]byte(nil), sealed...)
+	tampered[10] ^= 1
+	for name, ciphertext := range map[string][]byte{
+		"tampered":            tampered,
+		"truncated":           sealed[:len(sealed)-5],
+		"missing last chunk":  sealed[:2*chunk],
+		"missing first chunk": sealed[chunk:],
+		"empty":               nil,
+	} {
+		r, err := newDecryptingReader(clientSideKey, bytes.NewReader(ciphertext), metadata)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.ReadAll(r); err == nil {
+			t.Errorf("%s: expected decryption to fail", name)
+		}
+	}
+	if _, err := newDecryptingReader(clientSideKey, bytes.NewReader(sealed), nil); err == nil {
+		t.Error("expected an object without metadata to be refused")
+	}
+}
+
+func TestLoadClientSideKey(t *testing.T) {
+	if _, err := loadClientSideKey(""); err == nil {
+		t.Error("expected an error without -key-file")
+	}
+	short := filepath.Join(t.TempDir(), "short")
+	os.WriteFile(short, []byte("too short"), 0600)
+	if _, err := loadClientSideKey(short); err == nil {
+		t.Error("expected a short key to be refused")
+	}
+}
+
+func TestClientSideEncryptionTransfer(t *testing.T) {
+	s := newFakeS3(t, "b")
+	keyFile := testClientSideKey(t)
+	dir := t.TempDir()
+	plain := bytes.Repeat([]byte("secret "), cseChunkSize/3)
+	writeFiles(t, dir, map[string]string{"a.txt": string(plain)})
+	if _, err := run(t, "-encrypt-client-side", "-key-file", keyFile, filepath.Join(dir, "a.txt"), "s3://b/a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if obj := s.object("b", "a.txt"); obj == nil || bytes.Contains(obj.data, []byte("secret")) {
+		t.Fatal("the object wasn't encrypted")
+	}
+	dest := filepath.Join(dir, "b.txt")
+	if _, err := run(t, "-encrypt-client-side", "-key-file", keyFile, "s3://b/a.txt", dest); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, dest); got != string(plain) {
+		t.Errorf("decrypted %d bytes, want %d", len(got), len(plain))
+	}
+	other := testClientSideKey(t)
+	if _, err := run(t, "-encrypt-client-side", "-key-file", other, "-max-retries", "0", "s3://b/a.txt", dest); err == nil {
+		t.Error("expected decrypting with another key to fail")
+	}
+}
@@ -0,0 +1,392 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+// versionID selects a specific version of the object to
+// download or stat in a versioned bucket
+var versionID string
+
+// tempDir is the directory downloads are staged in before being
+// renamed to their final path. Empty means the directory of the
+// destination file.
+var tempDir string
+
+// listOnly prints the objects a wildcard download would fetch
+// instead of downloading them
+var listOnly bool
+
+// stripPrefix recreates keys beneath the destination of a
+// wildcard download relative to the listed prefix rather than
+// the root of the bucket
+var stripPrefix bool
+
+// downloadSingleFile downloads an object to a temporary file and
+// renames it to destPath once the download has completed, so an
+// interrupted or failed download never leaves a partial file at
+// the destination. The size of the object is -1 if unknown.
+func downloadSingleFile(
+	downloader *s3manager.Downloader,
+	bucket *string,
+	key *string,
+	size int64,
+	destPath string,
+	prog *progress,
+) (err error) {
+	defer func() { prog.fileDone(err) }()
+	return withRetriesProgress(prog, func(prog *progress) error {
+		return downloadAttempt(downloader, bucket, key, size, destPath, prog)
+	})
+}
+
+// downloadAttempt makes a single attempt at downloading an object.
+func downloadAttempt(
+	downloader *s3manager.Downloader,
+	bucket *string,
+	key *string,
+	size int64,
+	destPath string,
+	prog *progress,
+) error {
+	destDir := filepath.Dir(destPath)
+	if err := os.MkdirAll(destDir, 0755); err != nil {
+		return fmt.Errorf("failed to create directory '%s': %w", destDir, err)
+	}
+	stagingDir := tempDir
+	if stagingDir == "" {
+		stagingDir = destDir
+	}
+	f, err := os.CreateTemp(stagingDir, "."+filepath.Base(destPath)+".s3util-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file for '%s': %w", destPath, err)
+	}
+	tmpPath := f.Name()
+	// Removing the temporary file fails harmlessly once
+	// it has been renamed into place.
+	defer os.Remove(tmpPath)
+	defer f.Close()
+	ctx, cancel := jobContext()
+	defer cancel()
+	input := &s3.GetObjectInput{
+		Bucket:       bucket,
+		Key:          key,
+		VersionId:    optionalString(versionID),
+		RequestPayer: optionalString(requestPayer),
+
+		SSECustomerAlgorithm: sseCustomer.algorithm,
+		SSECustomerKey:       sseCustomer.key,
+		SSECustomerKeyMD5:    sseCustomer.keyMD5,
+	}
+	var checksum *objectChecksum
+	var mode os.FileMode
+	var hasMode bool
+	var sidecar *objectSidecar
+	if checksumMode != "" || preserveMode || metadataSidecar {
+		// The downloader fetches ranges of the object and doesn't
+		// expose its metadata, and S3 only returns the checksum
+		// with a response of the whole object, so both are read
+		// up front. Matching the ETag ensures that the object
+		// doesn't change in between.
+		head, err := downloader.S3.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+			Bucket:       bucket,
+			Key:          key,
+			VersionId:    optionalString(versionID),
+			RequestPayer: optionalString(requestPayer),
+			ChecksumMode: optionalString(checksumMode),
+
+			SSECustomerAlgorithm: sseCustomer.algorithm,
+			SSECustomerKey:       sseCustomer.key,
+			SSECustomerKeyMD5:    sseCustomer.keyMD5,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to stat '%s': %w", *key, err)
+		}
+		input.IfMatch = head.ETag
+		if checksumMode != "" {
+			checksum = fullObjectChecksum(head)
+			input.ChecksumMode = aws.String(checksumMode)
+		}
+		if preserveMode {
+			mode, hasMode = modeFromMetadata(head.Metadata)
+		}
+		if metadataSidecar {
+			if sidecar, err = newObjectSidecar(ctx, downloader.S3, bucket, key, head); err != nil {
+				return err
+			}
+		}
+		size = aws.Int64Value(head.ContentLength)
+	}
+	if clientSideKey != nil {
+		err = downloadDecrypted(ctx, downloader.S3, input, f, prog)
+	} else {
+		// The parts are written wherever they belong as they
+		// arrive. Allocating the whole file first spares the
+		// filesystem from growing it piece by piece.
+		if size > 0 {
+			if err := f.Truncate(size); err != nil {
+				return fmt.Errorf("failed to allocate '%s': %w", tmpPath, err)
+			}
+		}
+		var w io.WriterAt = f
+		if prog != nil {
+			w = &progressWriterAt{w: f, p: prog}
+		}
+		if limiters := fileLimiters(); limiters != nil {
+			w = &limitedWriterAt{w: w, limiters: limiters}
+		}
+		var n int64
+		n, err = downloader.DownloadWithContext(ctx, w, input)
+		if err == nil && size > 0 && n != size {
+			// The object was replaced since it was listed
+			err = f.Truncate(n)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to download '%s': %w", *key, err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to write '%s': %w", tmpPath, err)
+	}
+	if checksum != nil {
+		if err := checksum.verify(tmpPath); err != nil {
+			return fmt.Errorf("failed to verify '%s': %w", *key, err)
+		}
+	}
+	if hasMode {
+		if err := os.Chmod(tmpPath, mode); err != nil {
+			return fmt.Errorf("failed to set mode of '%s': %w", destPath, err)
+		}
+	}
+	if destPath, err = moveIntoPlace(tmpPath, destPath); err != nil {
+		return fmt.Errorf("failed to move download into place at '%s': %w", destPath, err)
+	}
+	if sidecar != nil {
+		if err := writeSidecar(destPath, sidecar); err != nil {
+			return fmt.Errorf("failed to write metadata of '%s': %w", destPath, err)
+		}
+	}
+	logSuccess("download: s3://%s/%s to %s", *bucket, *key, destPath)
+	return nil
+}
+
+// renameOnCollision saves downloads whose destination already
+// exists under the first free name with a number appended, e.g.
+// "a (1).txt", instead of replacing the file
+var renameOnCollision bool
+
+// moveIntoPlace renames a finished download to its destination,
+// returning where it ended up
+func moveIntoPlace(tmpPath string, destPath string) (string, error) {
+	if !renameOnCollision {
+		return destPath, os.Rename(tmpPath, destPath)
+	}
+	ext := filepath.Ext(destPath)
+	if ext == filepath.Base(destPath) {
+		// e.g. .bashrc
+		ext = ""
+	}
+	base := strings.TrimSuffix(destPath, ext)
+	path := destPath
+	for i := 1; ; i++ {
+		// Unlike renaming, linking fails if the name is taken,
+		// so concurrent downloads can't claim the same one
+		err := os.Link(tmpPath, path)
+		if err == nil {
+			return path, os.Remove(tmpPath)
+		}
+		if !os.IsExist(err) {
+			return path, err
+		}
+		path = fmt.Sprintf("%s (%d)%s", base, i, ext)
+	}
+}
+
+// keyPath returns where beneath dest the object with the key is
+// saved, refusing keys that would escape dest, e.g. ones with ..
+// segments or absolute paths
+func keyPath(dest string, key string) (string, error) {
+	rel := filepath.FromSlash(strings.TrimSuffix(key, "/"))
+	if !filepath.IsLocal(rel) {
+		return "", fmt.Errorf("refusing to download '%s' (unsafe key)", key)
+	}
+	return filepath.Join(dest, rel), nil
+}
+
+// downloadJob is an object to download and where to save it.
+// A size of -1 means the size is unknown.
+type downloadJob struct {
+	key     string
+	size    int64
+	outPath string
+	done    chan error
+}
+
+func download(source string, dest string) error {
+	bucket, key, err := splitNameParts(source)
+	if err != nil {
+		return fmt.Errorf("failed to parse source: %w", err)
+	}
+	sess := createSession()
+	s3Client := s3.New(sess)
+	downloader := newDownloader(sess)
+
+	var jobs []downloadJob
+	var refused []error
+
+	if strings.HasSuffix(key, "*") {
+		if versionID != "" {
+			return fmt.Errorf("-version-id cannot be used with a wildcard source")
+		}
+		if reassemble {
+			return usageErrorf("-reassemble cannot be used with a wildcard source")
+		}
+		// Wildcard input: download all keys with this prefix,
+		// recreating each key's path beneath the destination.
+		// With -strip-prefix the path is relative to the prefix's
+		// last slash instead, e.g. s3://mybucket/a/b/* saves
+		// a/b/c.txt as c.txt rather than a/b/c.txt. Keys that
+		// would escape the destination are refused and count as
+		// failed, without stopping the other downloads.
+		prefix := strings.TrimSuffix(key, "*")
+		stripped := ""
+		if stripPrefix {
+			stripped = prefix[:strings.LastIndex(prefix, "/")+1]
+		}
+		objects, err := listObjects(s3Client, bucket, prefix)
+		if err != nil {
+			return err
+		}
+		var emptyDirs []string
+		for _, obj := range objects {
+			if !isNewer(obj.LastModified) {
+				continue
+			}
+			if strings.HasSuffix(*obj.Key, "/") {
+				// Folder placeholder, nothing to download.
+				if keepEmptyDirs {
+					dir, err := keyPath(dest, strings.TrimPrefix(*obj.Key, stripped))
+					if err != nil {
+						refused = append(refused, err)
+						continue
+					}
+					emptyDirs = append(emptyDirs, dir)
+				}
+				continue
+			}
+			outPath, err := keyPath(dest, strings.TrimPrefix(*obj.Key, stripped))
+			if err != nil {
+				refused = append(refused, err)
+				continue
+			}
+			jobs = append(jobs, downloadJob{
+				key:     *obj.Key,
+				size:    aws.Int64Value(obj.Size),
+				outPath: outPath,
+				done:    make(chan error, 1),
+			})
+		}
+		if listOnly {
+			var total int64
+			for _, job := range jobs {
+				fmt.Printf("%d\ts3://%s/%s\n", job.size, bucket, job.key)
+				total += job.size
+			}
+			fmt.Printf("%d objects, %s\n", len(jobs), formatBytes(total))
+			for _, err := range refused {
+				logError(err)
+			}
+			if len(refused) > 0 {
+				return &batchError{failed: len(refused), total: len(jobs) + len(refused), what: "downloads"}
+			}
+			return nil
+		}
+		for _, dir := range emptyDirs {
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				return fmt.Errorf("failed to create directory '%s': %w", dir, err)
+			}
+		}
+	} else if listOnly {
+		return fmt.Errorf("-list-only requires a wildcard source")
+	} else {
+		outPath := dest
+		if info, err := os.Stat(dest); err == nil && info.IsDir() {
+			// Destination is a directory. Use the last
+			// component of the key as the file name.
+			outPath = filepath.Join(dest, path.Base(key))
+		}
+		if reassemble {
+			return downloadSplit(s3Client, downloader, bucket, key, outPath)
+		}
+		jobs = []downloadJob{
+			downloadJob{
+				key:     key,
+				size:    -1,
+				outPath: outPath,
+				done:    make(chan error, 1),
+			},
+		}
+	}
+
+	return runDownloads(downloader, bucket, jobs, refused)
+}
+
+// runDownloads downloads the jobs from the bucket in parallel.
+// The keys that were refused before any job started are reported
+// along with the jobs that failed.
+func runDownloads(downloader *s3manager.Downloader, bucket string, jobs []downloadJob, refused []error) error {
+	prog := startProgress(len(jobs))
+
+	pool := newJobPool(parallelism, func(payload interface{}) interface{} {
+		j := payload.(*downloadJob)
+		return downloadSingleFile(
+			downloader,
+			aws.String(bucket),
+			aws.String(j.key),
+			j.size,
+			j.outPath,
+			prog,
+		)
+	})
+	defer pool.Close()
+
+	var wg sync.WaitGroup
+	for i := range jobs {
+		job := &jobs[i]
+		startJob(&wg, func() {
+			job.done <- func() error {
+				if err, ok := pool.Process(job).(error); ok && err != nil {
+					return err
+				}
+				return nil
+			}()
+		})
+	}
+	wg.Wait()
+
+	errs := refused
+	for i := range jobs {
+		if err := <-jobs[i].done; err != nil {
+			errs = append(errs, err)
+		}
+	}
+	prog.finish()
+	for _, err := range errs {
+		logError(err)
+	}
+	if len(errs) > 0 {
+		return &batchError{failed: len(errs), total: len(jobs) + len(refused), what: "downloads"}
+	}
+
+	return nil
+}
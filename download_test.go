@@ -0,0 +1,272 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestKeyPath(t *testing.T) {
+	dest := filepath.Join("out", "dir")
+	for _, tt := range []struct {
+		key  string
+		want string
+	}{
+		{"a.txt", filepath.Join(dest, "a.txt")},
+		{"a/b/c.txt", filepath.Join(dest, "a", "b", "c.txt")},
+		{"a/b/", filepath.Join(dest, "a", "b")},
+		{"a/../b.txt", filepath.Join(dest, "b.txt")},
+		{"../a.txt", ""},
+		{"a/../../b.txt", ""},
+		{"/etc/passwd", ""},
+		{"", ""},
+	} {
+		got, err := keyPath(dest, tt.key)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("keyPath(%q) = %q, expected an error", tt.key, got)
+			}
+		} else if err != nil || got != tt.want {
+			t.Errorf("keyPath(%q) = %q, %v, want %q", tt.key, got, err, tt.want)
+		}
+	}
+}
+
+func TestDownloadStagesInTempDir(t *testing.T) {
+	s := newFakeS3(t, "b")
+	s.put("b", "a.txt", "hello")
+	dest := t.TempDir()
+	staging := t.TempDir()
+	if _, err := run(t, "-temp-dir", staging, "s3://b/a.txt", dest); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, filepath.Join(dest, "a.txt")); got != "hello" {
+		t.Errorf("downloaded %q", got)
+	}
+	for _, dir := range []string{dest, staging} {
+		entries, _ := os.ReadDir(dir)
+		for _, e := range entries {
+			if strings.Contains(e.Name(), ".s3util-") {
+				t.Errorf("temporary file %s left in %s", e.Name(), dir)
+			}
+		}
+	}
+}
+
+func TestDownloadFailureLeavesNoFile(t *testing.T) {
+	newFakeS3(t, "b")
+	dest := filepath.Join(t.TempDir(), "a.txt")
+	if _, err := run(t, "s3://b/a.txt", dest); err == nil {
+		t.Fatal("expected downloading a missing object to fail")
+	}
+	entries, _ := os.ReadDir(filepath.Dir(dest))
+	if len(entries) != 0 {
+		t.Errorf("failed download left %d files behind", len(entries))
+	}
+}
+
+func TestWildcardDownloadRefusesUnsafeKeys(t *testing.T) {
+	s := newFakeS3(t, "b")
+	s.put("b", "dir/a.txt", "a")
+	s.put("b", "dir/../../escaped.txt", "x")
+	s.put("b", "dir/../../escaped/", "")
+	parent := t.TempDir()
+	dest := filepath.Join(parent, "dest")
+	var err error
+	stderr := captureStderr(t, func() { _, err = run(t, "-keep-empty-dirs", "s3://b/dir/*", dest) })
+	if code := exitCode(err); code != exitPartial {
+		t.Fatalf("exited with %d (%v), want %d", code, err, exitPartial)
+	}
+	if err.Error() != "2 of 3 downloads failed" {
+		t.Errorf("error is %q", err)
+	}
+	if strings.Count(stderr, "unsafe key") != 2 {
+		t.Errorf("the unsafe keys weren't reported: %q", stderr)
+	}
+	for _, name := range []string{"escaped.txt", "escaped"} {
+		if _, err := os.Stat(filepath.Join(parent, name)); err == nil {
+			t.Errorf("%s was written outside the destination", name)
+		}
+	}
+	if got := readFile(t, filepath.Join(dest, "dir", "a.txt")); got != "a" {
+		t.Errorf("the safe key was downloaded as %q", got)
+	}
+}
+
+func TestDownloadVersion(t *testing.T) {
+	s := newFakeS3(t, "b")
+	s.put("b", "a.txt", "hello")
+	dest := filepath.Join(t.TempDir(), "a.txt")
+	if _, err := run(t, "-version-id", "v1", "s3://b/a.txt", dest); err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range s.received(http.MethodGet, "") {
+		if r.query.Get("versionId") != "v1" {
+			t.Errorf("requested %v without the version", r.query)
+		}
+	}
+	if _, err := run(t, "-version-id", "v2", "-max-retries", "0", "s3://b/a.txt", dest); err == nil {
+		t.Error("expected downloading a missing version to fail")
+	}
+	if _, err := run(t, "-version-id", "v1", "s3://b/*", t.TempDir()); err == nil {
+		t.Error("expected -version-id to be refused with a wildcard")
+	}
+}
+
+func TestDownloadListOnly(t *testing.T) {
+	s := newFakeS3(t, "b")
+	s.put("b", "logs/a.log", "aaaa")
+	s.put("b", "logs/b.log", "bb")
+	s.put("b", "other/c.log", "c")
+	dest := t.TempDir()
+	stdout, err := run(t, "-list-only", "s3://b/logs/*", dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "4\ts3://b/logs/a.log\n2\ts3://b/logs/b.log\n2 objects, 6 B\n"
+	if stdout != want {
+		t.Errorf("printed %q, want %q", stdout, want)
+	}
+	if entries, _ := os.ReadDir(dest); len(entries) != 0 {
+		t.Errorf("-list-only wrote %d files", len(entries))
+	}
+	for _, r := range s.received(http.MethodGet, "") {
+		if r.key != "" {
+			t.Errorf("-list-only downloaded %s", r.key)
+		}
+	}
+	if _, err := run(t, "-list-only", "s3://b/logs/a.log", dest); err == nil {
+		t.Error("expected -list-only to be refused without a wildcard")
+	}
+}
+
+func TestDownloadRequesterPays(t *testing.T) {
+	s := newFakeS3(t, "b")
+	s.put("b", "dir/a.txt", "a")
+	s.put("b", "dir/b.txt", "b")
+	if _, err := run(t, "-request-payer", "requester", "s3://b/dir/*", t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	requests := append(s.received(http.MethodGet, ""), s.received(http.MethodHead, "")...)
+	if len(requests) < 3 {
+		t.Fatalf("made only %d requests", len(requests))
+	}
+	for _, r := range requests {
+		if got := r.header.Get("X-Amz-Request-Payer"); got != "requester" {
+			t.Errorf("%s %s/%s sent X-Amz-Request-Payer %q", r.method, r.bucket, r.key, got)
+		}
+	}
+	_, err := run(t, "-request-payer", "owner", "s3://b/dir/a.txt", t.TempDir())
+	if code := exitCode(err); code != exitUsage {
+		t.Errorf("-request-payer owner exited with %d (%v), want %d", code, err, exitUsage)
+	}
+}
+
+func TestDownloadAssemblesRangesOutOfOrder(t *testing.T) {
+	s := newFakeS3(t, "b")
+	data := make([]byte, 12<<20)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	s.put("b", "big.bin", string(data))
+	s.intercept = func(w http.ResponseWriter, r *http.Request) bool {
+		if strings.HasPrefix(r.Header.Get("Range"), "bytes=0-") {
+			// The first part arrives last
+			time.Sleep(200 * time.Millisecond)
+		}
+		return false
+	}
+	dest := filepath.Join(t.TempDir(), "big.bin")
+	if _, err := run(t, "-part-size", "5242880", "-part-concurrency", "3", "s3://b/big.bin", dest); err != nil {
+		t.Fatal(err)
+	}
+	if ranges := s.received(http.MethodGet, ""); len(ranges) != 3 {
+		t.Errorf("downloaded in %d requests, want 3", len(ranges))
+	}
+	if got := readFile(t, dest); got != string(data) {
+		t.Error("the parts were assembled incorrectly")
+	}
+}
+
+func TestDownloadStripPrefix(t *testing.T) {
+	s := newFakeS3(t, "b")
+	s.put("b", "a/b/file.txt", "f")
+	s.put("b", "a/b/sub/deep.txt", "d")
+	for _, tt := range []struct {
+		flags  []string
+		want   []string
+		absent string
+	}{
+		{nil, []string{"a/b/file.txt", "a/b/sub/deep.txt"}, "file.txt"},
+		{[]string{"-strip-prefix"}, []string{"file.txt", "sub/deep.txt"}, "a"},
+	} {
+		dest := t.TempDir()
+		args := append(tt.flags, "s3://b/a/b/*", dest)
+		if _, err := run(t, args...); err != nil {
+			t.Fatal(err)
+		}
+		for _, name := range tt.want {
+			if _, err := os.Stat(filepath.Join(dest, filepath.FromSlash(name))); err != nil {
+				t.Errorf("%v: %v", tt.flags, err)
+			}
+		}
+		if _, err := os.Stat(filepath.Join(dest, tt.absent)); err == nil {
+			t.Errorf("%v created %s", tt.flags, tt.absent)
+		}
+	}
+}
+
+func TestMoveIntoPlace(t *testing.T) {
+	defer func() { renameOnCollision = false }()
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"a.txt": "old", ".bashrc": "old", "b.tar.gz": "old"})
+	renameOnCollision = true
+	for _, tt := range []struct {
+		dest, want string
+	}{
+		{"a.txt", "a (1).txt"},
+		{"a.txt", "a (2).txt"},
+		{".bashrc", ".bashrc (1)"},
+		{"b.tar.gz", "b.tar (1).gz"},
+		{"new.txt", "new.txt"},
+	} {
+		tmp := filepath.Join(dir, "download.tmp")
+		if err := os.WriteFile(tmp, []byte("new"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		got, err := moveIntoPlace(tmp, filepath.Join(dir, tt.dest))
+		if err != nil || got != filepath.Join(dir, tt.want) {
+			t.Errorf("moveIntoPlace to %s = %s, %v, want %s", tt.dest, got, err, tt.want)
+			continue
+		}
+		if data := readFile(t, got); data != "new" {
+			t.Errorf("%s holds %q", tt.want, data)
+		}
+		if _, err := os.Stat(tmp); !os.IsNotExist(err) {
+			t.Errorf("the temporary file was left behind: %v", err)
+		}
+	}
+	if data := readFile(t, filepath.Join(dir, "a.txt")); data != "old" {
+		t.Errorf("a.txt was replaced with %q", data)
+	}
+}
+
+func TestDownloadRenameOnCollision(t *testing.T) {
+	s := newFakeS3(t, "b")
+	s.put("b", "a.txt", "remote")
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"a.txt": "local"})
+	for i := 0; i < 2; i++ {
+		if _, err := run(t, "-rename-on-collision", "s3://b/a.txt", dir); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, want := range map[string]string{"a.txt": "local", "a (1).txt": "remote", "a (2).txt": "remote"} {
+		if got := readFile(t, filepath.Join(dir, name)); got != want {
+			t.Errorf("%s holds %q, want %q", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// downloadFromList downloads the keys listed on stdin from a
+// bucket into a directory, recreating each key's path beneath it,
+// so that listing and downloading can be composed e.g.
+//
+//	s3util ls -output-format json s3://mybucket/logs/ |
+//	    grep -v debug | s3util download-from-list s3://mybucket ./out
+//
+// The list is either JSON, as written by ls -output-format json
+// or as one object per line, in which case only "key" and "size"
+// are used; or plain text with one key per line. Keys are always
+// relative to the bucket, never s3:// URIs.
+func downloadFromList(source string, dest string) error {
+	if err := prepareTransfers(); err != nil {
+		return err
+	}
+	bucket, key, err := splitNameParts(source)
+	if err != nil {
+		return fmt.Errorf("failed to parse source: %w", err)
+	}
+	if key != "" {
+		return usageErrorf("'%s' must be a bucket without a key, as listed keys include their prefix", source)
+	}
+	entries, err := readKeyList(os.Stdin)
+	if err != nil {
+		return fmt.Errorf("failed to read list from stdin: %w", err)
+	}
+	var jobs []downloadJob
+	var refused []error
+	for _, e := range entries {
+		if strings.HasSuffix(e.Key, "/") || e.DeleteMarker {
+			// Folder placeholder or deleted object, nothing to
+			// download.
+			continue
+		}
+		outPath, err := keyPath(dest, e.Key)
+		if err != nil {
+			refused = append(refused, err)
+			continue
+		}
+		jobs = append(jobs, downloadJob{
+			key:     e.Key,
+			size:    e.Size,
+			outPath: outPath,
+			done:    make(chan error, 1),
+		})
+	}
+	return runDownloads(newDownloader(createSession()), bucket, jobs, refused)
+}
+
+// readKeyList parses the input of download-from-list. The sizes
+// of plain keys are unknown and given as -1.
+func readKeyList(r io.Reader) ([]listEntry, error) {
+	br := bufio.NewReader(r)
+	var entries []listEntry
+	first, err := peekNonSpace(br)
+	if err == io.EOF {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	switch first {
+	case '[':
+		if err := json.NewDecoder(br).Decode(&entries); err != nil {
+			return nil, err
+		}
+	case '{':
+		dec := json.NewDecoder(br)
+		for {
+			var e listEntry
+			if err := dec.Decode(&e); err == io.EOF {
+				break
+			} else if err != nil {
+				return nil, err
+			}
+			entries = append(entries, e)
+		}
+	default:
+		scanner := bufio.NewScanner(br)
+		for scanner.Scan() {
+			if key := strings.TrimSpace(scanner.Text()); key != "" {
+				entries = append(entries, listEntry{Key: key, Size: -1})
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+	for i, e := range entries {
+		if e.Key == "" {
+			return nil, fmt.Errorf("entry %d has no key", i+1)
+		}
+	}
+	return entries, nil
+}
+
+// peekNonSpace skips leading whitespace and returns the next byte
+// without consuming it.
+func peekNonSpace(br *bufio.Reader) (byte, error) {
+	for {
+		b, err := br.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		if b != ' ' && b != '\t' && b != '\r' && b != '\n' {
+			return b, br.UnreadByte()
+		}
+	}
+}
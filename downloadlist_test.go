@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReadKeyList(t *testing.T) {
+	for _, tt := range []struct {
+		input string
+		keys  string
+		sizes []int64
+	}{
+		{"", "", nil},
+		{`[{"key":"a.txt","size":3},{"key":"b/c.txt","size":5}]`, "a.txt b/c.txt", []int64{3, 5}},
+		{"  \n[]", "", nil},
+		{"{\"key\":\"a.txt\",\"size\":3}\n{\"key\":\"b.txt\"}\n", "a.txt b.txt", []int64{3, 0}},
+		{"a.txt\n\n  b/c.txt  \n", "a.txt b/c.txt", []int64{-1, -1}},
+	} {
+		entries, err := readKeyList(strings.NewReader(tt.input))
+		if err != nil {
+			t.Errorf("%q: %v", tt.input, err)
+			continue
+		}
+		var keys []string
+		for i, e := range entries {
+			keys = append(keys, e.Key)
+			if e.Size != tt.sizes[i] {
+				t.Errorf("%q: %s has the size %d, want %d", tt.input, e.Key, e.Size, tt.sizes[i])
+			}
+		}
+		if got := strings.Join(keys, " "); got != tt.keys {
+			t.Errorf("%q: read %s, want %s", tt.input, got, tt.keys)
+		}
+	}
+	for _, input := range []string{`[{"size":3}]`, `{"key":"a"} {`, `[`} {
+		if _, err := readKeyList(strings.NewReader(input)); err == nil {
+			t.Errorf("%q was accepted", input)
+		}
+	}
+}
+
+func TestDownloadFromList(t *testing.T) {
+	s := newFakeS3(t, "b")
+	s.put("b", "logs/a.log", "a")
+	s.put("b", "logs/sub/b.log", "b")
+	s.put("b", "logs/debug.log", "debug")
+	s.put("b", "logs/dir/", "")
+	listing, err := run(t, "ls", "-output-format", "json", "s3://b/logs/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Drop debug.log from the listing, as grep -v would
+	var entries []listEntry
+	if err := json.Unmarshal([]byte(listing), &entries); err != nil {
+		t.Fatal(err)
+	}
+	var filtered []listEntry
+	for _, e := range entries {
+		if e.Key != "logs/debug.log" {
+			filtered = append(filtered, e)
+		}
+	}
+	filteredJSON, _ := json.Marshal(filtered)
+	for _, list := range []string{
+		string(filteredJSON),
+		"logs/a.log\nlogs/sub/b.log\n",
+	} {
+		dest := t.TempDir()
+		withStdin(t, list, func() {
+			if _, err := run(t, "download-from-list", "s3://b", dest); err != nil {
+				t.Fatal(err)
+			}
+		})
+		for name, want := range map[string]string{"logs/a.log": "a", "logs/sub/b.log": "b"} {
+			if got := readFile(t, filepath.Join(dest, filepath.FromSlash(name))); got != want {
+				t.Errorf("%s holds %q, want %q", name, got, want)
+			}
+		}
+		for _, name := range []string{"logs/debug.log", "logs/dir"} {
+			if _, err := os.Stat(filepath.Join(dest, filepath.FromSlash(name))); err == nil {
+				t.Errorf("downloaded %s, which isn't listed", name)
+			}
+		}
+	}
+}
+
+func TestDownloadFromListRefusesUnsafeKeys(t *testing.T) {
+	s := newFakeS3(t, "b")
+	parent := t.TempDir()
+	dest := filepath.Join(parent, "dest")
+	s.put("b", "a.txt", "a")
+	withStdin(t, "../escaped.txt\na.txt\n", func() {
+		if _, err := run(t, "download-from-list", "s3://b", dest); exitCode(err) != exitPartial {
+			t.Errorf("exited with %d (%v), want %d for the unsafe key", exitCode(err), err, exitPartial)
+		}
+	})
+	if _, err := os.Stat(filepath.Join(parent, "escaped.txt")); err == nil {
+		t.Error("object was written outside the destination")
+	}
+	if got := readFile(t, filepath.Join(dest, "a.txt")); got != "a" {
+		t.Errorf("the safe key was downloaded as %q", got)
+	}
+	withStdin(t, "a.txt\n", func() {
+		if _, err := run(t, "download-from-list", "s3://b/logs/", dest); exitCode(err) != exitUsage {
+			t.Errorf("a source with a key exited with %d (%v), want %d", exitCode(err), err, exitUsage)
+		}
+	})
+}
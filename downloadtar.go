@@ -0,0 +1,122 @@
+package main
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// downloadTar writes every object under a prefix into a single
+// tar archive, named by their keys, so a whole prefix can be
+// fetched as one file. The archive is written to stdout if dest
+// is "-". Objects are streamed into the archive one at a time,
+// as a tar has to be written in order.
+func downloadTar(source string, dest string) error {
+	if err := prepareTransfers(); err != nil {
+		return err
+	}
+	if encryptClientSide {
+		return usageErrorf("download-tar cannot decrypt objects encrypted with -encrypt-client-side")
+	}
+	bucket, prefix, err := splitNameParts(source)
+	if err != nil {
+		return fmt.Errorf("failed to parse source: %w", err)
+	}
+	s3Client := s3.New(createSession())
+	objects, err := listObjects(s3Client, bucket, prefix)
+	if err != nil {
+		return err
+	}
+
+	if dest == "-" {
+		// Lines about the objects would end up in the archive
+		onlyShowErrors = true
+		return writeTar(s3Client, bucket, objects, os.Stdout)
+	}
+	f, err := os.Create(dest)
+	if err != nil {
+		return fmt.Errorf("failed to create '%s': %w", dest, err)
+	}
+	if err := writeTar(s3Client, bucket, objects, f); err != nil {
+		f.Close()
+		os.Remove(dest)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(dest)
+		return fmt.Errorf("failed to write '%s': %w", dest, err)
+	}
+	return nil
+}
+
+// writeTar streams the objects into a tar archive. Any failure
+// aborts the archive, which would otherwise silently lack files.
+func writeTar(s3Client *s3.S3, bucket string, objects []*s3.Object, w io.Writer) error {
+	tw := tar.NewWriter(w)
+	prog := startProgress(len(objects))
+	defer prog.finish()
+	for _, obj := range objects {
+		key := aws.StringValue(obj.Key)
+		if strings.HasSuffix(key, "/") {
+			// Folder placeholder, nothing to archive.
+			continue
+		}
+		err := writeTarEntry(s3Client, bucket, key, tw, prog)
+		prog.fileDone(err)
+		if err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// writeTarEntry appends a single object to the archive. The
+// header is written from the GET response rather than the
+// listing, in case the object changed in between.
+func writeTarEntry(s3Client *s3.S3, bucket string, key string, tw *tar.Writer, prog *progress) error {
+	ctx, cancel := jobContext()
+	defer cancel()
+	resp, err := s3Client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket:       aws.String(bucket),
+		Key:          aws.String(key),
+		RequestPayer: optionalString(requestPayer),
+
+		SSECustomerAlgorithm: sseCustomer.algorithm,
+		SSECustomerKey:       sseCustomer.key,
+		SSECustomerKeyMD5:    sseCustomer.keyMD5,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to download '%s': %w", key, err)
+	}
+	defer resp.Body.Close()
+	mode := int64(0644)
+	if m, ok := modeFromMetadata(resp.Metadata); ok && preserveMode {
+		mode = int64(m)
+	}
+	if err := tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     key,
+		Size:     aws.Int64Value(resp.ContentLength),
+		Mode:     mode,
+		ModTime:  aws.TimeValue(resp.LastModified),
+	}); err != nil {
+		return fmt.Errorf("failed to archive '%s': %w", key, err)
+	}
+	var body io.Reader = resp.Body
+	if prog != nil {
+		body = &progressReader{r: body, p: prog}
+	}
+	if limiters := fileLimiters(); limiters != nil {
+		body = &limitedReader{r: body, limiters: limiters}
+	}
+	if _, err := copyBuffered(tw, body); err != nil {
+		return fmt.Errorf("failed to archive '%s': %w", key, err)
+	}
+	logSuccess("download: s3://%s/%s to archive", bucket, key)
+	return nil
+}
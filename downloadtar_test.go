@@ -0,0 +1,80 @@
+package main
+
+import (
+	"archive/tar"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// readTar returns the files in a tar archive by name
+func readTar(t *testing.T, r io.Reader) map[string]string {
+	t.Helper()
+	files := make(map[string]string)
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return files
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[hdr.Name] = string(data)
+	}
+}
+
+func TestDownloadTar(t *testing.T) {
+	s := newFakeS3(t, "b")
+	s.put("b", "logs/", "")
+	s.put("b", "logs/a.log", "a")
+	s.put("b", "logs/2024/b.log", "bb")
+	s.put("b", "other.txt", "other")
+	dest := filepath.Join(t.TempDir(), "logs.tar")
+	if _, err := run(t, "download-tar", "s3://b/logs/", dest); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	files := readTar(t, f)
+	if len(files) != 2 || files["logs/a.log"] != "a" || files["logs/2024/b.log"] != "bb" {
+		t.Errorf("the archive holds %q", files)
+	}
+
+	out, err := run(t, "download-tar", "s3://b/logs/", "-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if files := readTar(t, strings.NewReader(out)); len(files) != 2 || files["logs/a.log"] != "a" {
+		t.Errorf("the archive written to stdout holds %q", files)
+	}
+}
+
+func TestDownloadTarFailure(t *testing.T) {
+	s := newFakeS3(t, "b")
+	s.put("b", "a.txt", "a")
+	s.put("b", "b.txt", "b")
+	s.intercept = func(w http.ResponseWriter, r *http.Request) bool {
+		if r.Method == http.MethodGet && r.URL.Path == "/b/b.txt" {
+			fakeError(w, http.StatusForbidden, "AccessDenied")
+			return true
+		}
+		return false
+	}
+	dest := filepath.Join(t.TempDir(), "b.tar")
+	if _, err := run(t, "download-tar", "-max-retries", "0", "s3://b/", dest); err == nil {
+		t.Fatal("expected the archive to fail")
+	}
+	if _, err := os.Stat(dest); !os.IsNotExist(err) {
+		t.Errorf("the incomplete archive was left behind: %v", err)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+// keepEmptyDirs uploads empty directories as zero-byte marker
+// objects whose keys end in a slash, e.g. prefix/emptydir/, and
+// recreates such markers as empty directories on download. S3
+// has no directories, so they would otherwise be lost.
+var keepEmptyDirs bool
+
+// isEmptyDir reports whether a directory has no entries
+func isEmptyDir(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	if _, err := f.Readdirnames(1); err == io.EOF {
+		return true, nil
+	} else if err != nil {
+		return false, fmt.Errorf("failed to read directory '%s': %w", path, err)
+	}
+	return false, nil
+}
+
+// putDirMarker creates the marker object of an empty directory
+func putDirMarker(client s3iface.S3API, bucket *string, key *string, prog *progress) (err error) {
+	defer func() { prog.fileDone(err) }()
+	return withRetries(func() error {
+		ctx, cancel := jobContext()
+		defer cancel()
+		if _, err := client.PutObjectWithContext(ctx, &s3.PutObjectInput{
+			Bucket:                  bucket,
+			Key:                     key,
+			Body:                    strings.NewReader(""),
+			StorageClass:            optionalString(storageClass),
+			ACL:                     optionalString(cannedACL),
+			RequestPayer:            optionalString(requestPayer),
+			ServerSideEncryption:    serverSideEncryption.algorithm,
+			SSEKMSKeyId:             serverSideEncryption.kmsKeyID,
+			SSEKMSEncryptionContext: serverSideEncryption.encryptionContext,
+		}); err != nil {
+			return fmt.Errorf("failed to create directory marker '%s': %w", aws.StringValue(key), err)
+		}
+		logSuccess("upload: empty directory to s3://%s/%s", *bucket, *key)
+		return nil
+	})
+}
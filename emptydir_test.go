@@ -0,0 +1,52 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestKeepEmptyDirsRoundTrip(t *testing.T) {
+	s := newFakeS3(t, "b")
+	src := t.TempDir()
+	writeFiles(t, src, map[string]string{"full/a.txt": "a"})
+	for _, dir := range []string{"empty", "full/nested/empty"} {
+		if err := os.MkdirAll(filepath.Join(src, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := run(t, src, "s3://b/plain/"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := run(t, "-keep-empty-dirs", src, "s3://b/up/"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := strings.Join(s.keys("b"), " "),
+		"plain/full/a.txt up/empty/ up/full/a.txt up/full/nested/empty/"; got != want {
+		t.Errorf("uploaded %s, want %s", got, want)
+	}
+
+	for _, tt := range []struct {
+		flags []string
+		kept  bool
+	}{
+		{nil, false},
+		{[]string{"-keep-empty-dirs"}, true},
+	} {
+		dest := t.TempDir()
+		args := append(tt.flags, "-strip-prefix", "s3://b/up/*", dest)
+		if _, err := run(t, args...); err != nil {
+			t.Fatal(err)
+		}
+		for _, dir := range []string{"empty", "full/nested/empty"} {
+			info, err := os.Stat(filepath.Join(dest, filepath.FromSlash(dir)))
+			if kept := err == nil && info.IsDir(); kept != tt.kept {
+				t.Errorf("%v recreated %s: %v, want %v", tt.flags, dir, kept, tt.kept)
+			}
+		}
+		if got := readFile(t, filepath.Join(dest, "full", "a.txt")); got != "a" {
+			t.Errorf("%v downloaded %q", tt.flags, got)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
+// isNotFound reports whether err is an S3 404 response
+func isNotFound(err error) bool {
+	if reqErr, ok := err.(awserr.RequestFailure); ok {
+		return reqErr.StatusCode() == http.StatusNotFound
+	}
+	return false
+}
+
+// explainRegionRedirect is a request handler that replaces the
+// error of a 301 PermanentRedirect, which S3 returns when the
+// bucket lives in another region, with one telling the user
+// which -region to pass. It runs after the retry handlers, so
+// it only sees the final error of a request.
+func explainRegionRedirect(r *request.Request) {
+	if r.Error == nil ||
+		r.HTTPResponse == nil ||
+		r.HTTPResponse.StatusCode != http.StatusMovedPermanently {
+		return
+	}
+	bucketRegion := r.HTTPResponse.Header.Get("x-amz-bucket-region")
+	if bucketRegion == "" {
+		return
+	}
+	r.Error = awserr.NewRequestFailure(
+		awserr.New(
+			"PermanentRedirect",
+			fmt.Sprintf(
+				"the bucket is in region '%s', not '%s' (try again with -region %s)",
+				bucketRegion,
+				aws.StringValue(r.Config.Region),
+				bucketRegion,
+			),
+			nil,
+		),
+		r.HTTPResponse.StatusCode,
+		r.RequestID,
+	)
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestExplainRegionRedirect(t *testing.T) {
+	s := newFakeS3(t, "b")
+	s.intercept = func(w http.ResponseWriter, r *http.Request) bool {
+		w.Header().Set("X-Amz-Bucket-Region", "eu-west-1")
+		fakeError(w, http.StatusMovedPermanently, "PermanentRedirect")
+		return true
+	}
+	_, err := run(t, "ls", "-region", "us-east-1", "s3://b")
+	if err == nil {
+		t.Fatal("expected the listing to fail")
+	}
+	want := "the bucket is in region 'eu-west-1', not 'us-east-1' (try again with -region eu-west-1)"
+	if !strings.Contains(err.Error(), want) {
+		t.Errorf("error is %q, want it to contain %q", err, want)
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+)
+
+// Exit codes, so scripts can tell a partial failure, which may be
+// worth retrying, apart from a complete one:
+//
+//	0  everything succeeded
+//	1  everything failed, or the command failed as a whole
+//	2  some of the files or objects failed
+//	3  the command line was invalid
+//
+// With -only-errors-exit-code, failures caused by some well-known
+// S3 errors exit with codes of their own instead of 1 or 2:
+//
+//	10  AccessDenied
+//	11  NoSuchBucket
+//	12  NoSuchKey
+//	13  SlowDown, S3 kept throttling the requests
+//
+// If jobs failed with several of them, the last one logged decides.
+const (
+	exitOK           = 0
+	exitFailed       = 1
+	exitPartial      = 2
+	exitUsage        = 3
+	exitAccessDenied = 10
+	exitNoSuchBucket = 11
+	exitNoSuchKey    = 12
+	exitSlowDown     = 13
+)
+
+// awsErrorExitCodes enables the exit codes for AWS errors
+var awsErrorExitCodes bool
+
+// awsErrorCodes maps the AWS error codes with exit codes of their
+// own to them
+var awsErrorCodes = map[string]int{
+	"AccessDenied": exitAccessDenied,
+	"NoSuchBucket": exitNoSuchBucket,
+	"NoSuchKey":    exitNoSuchKey,
+	"SlowDown":     exitSlowDown,
+}
+
+// lastAWSErrorExitCode is the exit code of the last job logged as
+// failed by one of awsErrorCodes, zero if none was
+var lastAWSErrorExitCode int64
+
+// awsErrorExitCode returns the exit code of the AWS error wrapped
+// by err, zero if it wraps none with a code of its own. Only the
+// error that failed a job counts: a request failing may still be
+// retried, or its error expected. HEAD responses have no body to
+// carry a code, so their errors never match.
+func awsErrorExitCode(err error) int {
+	var aerr awserr.Error
+	if !errors.As(err, &aerr) {
+		return 0
+	}
+	return awsErrorCodes[aerr.Code()]
+}
+
+// recordJobError remembers the exit code of the AWS error that
+// failed a job, if it has one
+func recordJobError(err error) {
+	if !awsErrorExitCodes {
+		return
+	}
+	if code := awsErrorExitCode(err); code != 0 {
+		atomic.StoreInt64(&lastAWSErrorExitCode, int64(code))
+	}
+}
+
+// batchError reports how many of a batch of jobs failed
+type batchError struct {
+	failed int
+	total  int
+	what   string // e.g. "uploads"
+}
+
+func (e *batchError) Error() string {
+	return fmt.Sprintf("%d of %d %s failed", e.failed, e.total, e.what)
+}
+
+// usageError is an error in the command line
+type usageError struct {
+	err error
+}
+
+func (e *usageError) Error() string {
+	return e.err.Error()
+}
+
+// usageErrorf formats a usageError
+func usageErrorf(format string, args ...interface{}) error {
+	return &usageError{fmt.Errorf(format, args...)}
+}
+
+// exitCode returns the exit code for the error entry returned
+func exitCode(err error) int {
+	var batch *batchError
+	var usage *usageError
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.As(err, &usage):
+		return exitUsage
+	case awsErrorExitCodes && awsErrorExitCode(err) != 0:
+		// The command failed as a whole because of the request
+		return awsErrorExitCode(err)
+	case awsErrorExitCodes && atomic.LoadInt64(&lastAWSErrorExitCode) != 0:
+		return int(atomic.LoadInt64(&lastAWSErrorExitCode))
+	case errors.As(err, &batch) && batch.failed < batch.total:
+		return exitPartial
+	default:
+		return exitFailed
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+)
+
+func TestExitCode(t *testing.T) {
+	for _, tt := range []struct {
+		err  error
+		want int
+	}{
+		{nil, exitOK},
+		{errors.New("failed"), exitFailed},
+		{&batchError{failed: 1, total: 3, what: "uploads"}, exitPartial},
+		{&batchError{failed: 3, total: 3, what: "uploads"}, exitFailed},
+		{fmt.Errorf("wrapped: %w", &batchError{failed: 1, total: 2}), exitPartial},
+		{usageErrorf("invalid"), exitUsage},
+		{fmt.Errorf("wrapped: %w", usageErrorf("invalid")), exitUsage},
+	} {
+		if got := exitCode(tt.err); got != tt.want {
+			t.Errorf("exitCode(%v) = %d, want %d", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestExitCodeOfRuns(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"a.txt": "a", "b.txt": "b", "c.txt": "c"})
+	for _, tt := range []struct {
+		name    string
+		failing string // suffix of the keys the fake refuses
+		args    []string
+		want    int
+	}{
+		{"all succeeded", "-", []string{dir, "s3://b/up/"}, exitOK},
+		{"some failed", "/b.txt", []string{dir, "s3://b/up/"}, exitPartial},
+		{"all failed", ".txt", []string{dir, "s3://b/up/"}, exitFailed},
+		{"usage", "-", []string{"-legal-hold", "maybe", dir, "s3://b/up/"}, exitUsage},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newFakeS3(t, "b")
+			s.intercept = func(w http.ResponseWriter, r *http.Request) bool {
+				if r.Method == http.MethodPut && strings.HasSuffix(r.URL.Path, tt.failing) {
+					fakeError(w, http.StatusForbidden, "AccessDenied")
+					return true
+				}
+				return false
+			}
+			_, err := run(t, tt.args...)
+			if code := exitCode(err); code != tt.want {
+				t.Errorf("exited with %d (%v), want %d", code, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestAWSErrorExitCode(t *testing.T) {
+	defer func() {
+		awsErrorExitCodes = false
+		lastAWSErrorExitCode = 0
+	}()
+	awsErrorExitCodes = true
+	slowDown := awserr.NewRequestFailure(awserr.New("SlowDown", "Please reduce your request rate.", nil), http.StatusServiceUnavailable, "REQ1")
+	missing := awserr.NewRequestFailure(awserr.New("NotFound", "Not Found", nil), http.StatusNotFound, "REQ2")
+	other := awserr.New("InternalError", "We encountered an internal error.", nil)
+
+	if code := exitCode(fmt.Errorf("failed to upload 'a.txt': %w", slowDown)); code != exitSlowDown {
+		t.Errorf("SlowDown exited with %d, want %d", code, exitSlowDown)
+	}
+	if code := exitCode(fmt.Errorf("failed to stat 'a.txt': %w", missing)); code != exitFailed {
+		t.Errorf("a HEAD request's NotFound exited with %d, want %d", code, exitFailed)
+	}
+	if code := exitCode(fmt.Errorf("failed: %w", other)); code != exitFailed {
+		t.Errorf("InternalError exited with %d, want %d", code, exitFailed)
+	}
+	// Only the error wrapped counts, not one that merely has the
+	// same message
+	if code := exitCode(errors.New("failed to upload 'a.txt': " + slowDown.Error())); code != exitFailed {
+		t.Errorf("an unwrapped SlowDown exited with %d, want %d", code, exitFailed)
+	}
+
+	recordJobError(fmt.Errorf("failed to download 'b.txt': %w", slowDown))
+	if code := exitCode(&batchError{failed: 1, total: 2, what: "downloads"}); code != exitSlowDown {
+		t.Errorf("a batch with a SlowDown job exited with %d, want %d", code, exitSlowDown)
+	}
+
+	awsErrorExitCodes = false
+	if code := exitCode(fmt.Errorf("failed to upload 'a.txt': %w", slowDown)); code != exitFailed {
+		t.Errorf("SlowDown exited with %d without -only-errors-exit-code, want %d", code, exitFailed)
+	}
+}
+
+func TestOnlyErrorsExitCode(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"a.txt": "a", "b.txt": "b"})
+	for _, tt := range []struct {
+		name string
+		args []string
+		want int
+	}{
+		{"AccessDenied", []string{dir, "s3://b/denied/"}, exitAccessDenied},
+		{"NoSuchBucket", []string{"ls", "-only-errors-exit-code", "s3://missing"}, exitNoSuchBucket},
+		{"NoSuchKey", []string{"s3://b/missing.txt", dir}, exitNoSuchKey},
+		{"partial AccessDenied", []string{filepath.Join(dir, "*.txt"), "s3://b/half/"}, exitAccessDenied},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newFakeS3(t, "b")
+			s.intercept = func(w http.ResponseWriter, r *http.Request) bool {
+				if r.Method == http.MethodPut && (strings.HasPrefix(r.URL.Path, "/b/denied/") || r.URL.Path == "/b/half/b.txt") {
+					fakeError(w, http.StatusForbidden, "AccessDenied")
+					return true
+				}
+				return false
+			}
+			args := tt.args
+			if args[0] != "ls" {
+				args = append([]string{"-only-errors-exit-code", "-max-retries", "0"}, args...)
+			}
+			_, err := run(t, args...)
+			if code := exitCode(err); code != tt.want {
+				t.Errorf("exited with %d (%v), want %d", code, err, tt.want)
+			}
+			awsErrorExitCodes = false
+			if code := exitCode(err); code != exitFailed && code != exitPartial {
+				t.Errorf("exited with %d without -only-errors-exit-code", code)
+			}
+		})
+	}
+}
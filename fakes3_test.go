@@ -0,0 +1,691 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeOwner is the canonical ID owning everything in a fakeS3
+const fakeOwner = "fake-owner"
+
+// fakeObject is an object stored by fakeS3
+type fakeObject struct {
+	data     []byte
+	etag     string
+	header   http.Header // the object's headers, e.g. Content-Type
+	acl      []byte      // AccessControlPolicy XML, nil for private
+	tagging  []byte      // Tagging XML, nil without tags
+	modified time.Time
+}
+
+// fakeUpload is a multipart upload in progress
+type fakeUpload struct {
+	bucket string
+	key    string
+	header http.Header
+	parts  map[int][]byte
+}
+
+// fakeRequest is a request received by fakeS3
+type fakeRequest struct {
+	method string
+	bucket string
+	key    string
+	query  url.Values
+	header http.Header
+}
+
+// fakeS3 is an in-memory S3 server covering the operations s3util
+// uses, with path-style addressing
+type fakeS3 struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	buckets  map[string]map[string]*fakeObject
+	uploads  map[string]*fakeUpload
+	requests []fakeRequest
+	nextID   int
+
+	// undeletable keys fail to be deleted by DeleteObjects
+	undeletable map[string]bool
+
+	// intercept, if set, is called before every request is
+	// handled and reports whether it wrote a response itself
+	intercept func(w http.ResponseWriter, r *http.Request) bool
+}
+
+// newFakeS3 starts a fake S3 server with the named buckets and
+// points s3util at it through the environment, along with test
+// credentials, so that every command run by the test talks to it
+func newFakeS3(t *testing.T, buckets ...string) *fakeS3 {
+	t.Helper()
+	s := &fakeS3{
+		buckets: make(map[string]map[string]*fakeObject),
+		uploads: make(map[string]*fakeUpload),
+	}
+	for _, b := range buckets {
+		s.buckets[b] = make(map[string]*fakeObject)
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	t.Cleanup(s.Close)
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDTEST")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	t.Setenv("AWS_ENDPOINT_URL", s.URL)
+	t.Setenv("AWS_S3_FORCE_PATH_STYLE", "true")
+	return s
+}
+
+// put stores an object with the given headers, given as name and
+// value pairs
+func (s *fakeS3) put(bucket string, key string, data string, header ...string) {
+	h := make(http.Header)
+	for i := 0; i+1 < len(header); i += 2 {
+		h.Set(header[i], header[i+1])
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.buckets[bucket][key] = &fakeObject{
+		data:     []byte(data),
+		etag:     md5ETag([]byte(data)),
+		header:   h,
+		modified: time.Now().UTC(),
+	}
+}
+
+// object returns a stored object, or nil if there is none
+func (s *fakeS3) object(bucket string, key string) *fakeObject {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.buckets[bucket][key]
+}
+
+// keys returns the keys in a bucket in order
+func (s *fakeS3) keys(bucket string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var keys []string
+	for k := range s.buckets[bucket] {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// received returns the requests made with the given method and
+// query parameter, an empty parameter matching any request
+func (s *fakeS3) received(method string, param string) []fakeRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var matching []fakeRequest
+	for _, r := range s.requests {
+		if _, ok := r.query[param]; r.method == method && (param == "" || ok) {
+			matching = append(matching, r)
+		}
+	}
+	return matching
+}
+
+func md5ETag(data []byte) string {
+	sum := md5.Sum(data)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+// fakeError writes an S3 error response
+func fakeError(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "<Error><Code>%s</Code><Message>%s</Message><RequestId>%d</RequestId></Error>", code, code, time.Now().UnixNano())
+}
+
+func writeXML(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/xml")
+	data, err := xml.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	w.Write(data)
+}
+
+// objectHeaders are stored with an object and returned with it
+var objectHeaders = []string{
+	"Cache-Control",
+	"Content-Disposition",
+	"Content-Encoding",
+	"Content-Language",
+	"Content-Type",
+	"X-Amz-Object-Lock-Legal-Hold",
+	"X-Amz-Object-Lock-Mode",
+	"X-Amz-Object-Lock-Retain-Until-Date",
+	"X-Amz-Server-Side-Encryption",
+	"X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id",
+	"X-Amz-Server-Side-Encryption-Customer-Algorithm",
+	"X-Amz-Storage-Class",
+	"X-Amz-Website-Redirect-Location",
+}
+
+// storedHeader picks the headers of a request that describe the
+// object it uploads
+func storedHeader(r *http.Request) http.Header {
+	h := make(http.Header)
+	for name, values := range r.Header {
+		if strings.HasPrefix(strings.ToLower(name), "x-amz-meta-") ||
+			strings.HasPrefix(strings.ToLower(name), "x-amz-checksum-") {
+			h[name] = values
+		}
+	}
+	for _, name := range objectHeaders {
+		if v := r.Header.Get(name); v != "" {
+			h.Set(name, v)
+		}
+	}
+	return h
+}
+
+func (s *fakeS3) serve(w http.ResponseWriter, r *http.Request) {
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	query := r.URL.Query()
+	s.mu.Lock()
+	s.requests = append(s.requests, fakeRequest{
+		method: r.Method,
+		bucket: bucket,
+		key:    key,
+		query:  query,
+		header: r.Header.Clone(),
+	})
+	intercept := s.intercept
+	s.mu.Unlock()
+	if intercept != nil && intercept(w, r) {
+		return
+	}
+	body, _ := io.ReadAll(r.Body)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if bucket == "" {
+		s.listBuckets(w)
+		return
+	}
+	objects, ok := s.buckets[bucket]
+	if r.Method == http.MethodPut && key == "" {
+		if ok {
+			fakeError(w, http.StatusConflict, "BucketAlreadyOwnedByYou")
+			return
+		}
+		s.buckets[bucket] = make(map[string]*fakeObject)
+		return
+	}
+	if !ok {
+		if r.Method == http.MethodHead {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fakeError(w, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+	if key == "" {
+		s.serveBucket(w, r, bucket, objects, query, body)
+		return
+	}
+	_, hasUploadID := query["uploadId"]
+	switch {
+	case r.Method == http.MethodPost && hasParam(query, "uploads"):
+		s.nextID++
+		id := strconv.Itoa(s.nextID)
+		s.uploads[id] = &fakeUpload{bucket: bucket, key: key, header: storedHeader(r), parts: make(map[int][]byte)}
+		writeXML(w, struct {
+			XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+			Bucket   string
+			Key      string
+			UploadId string
+		}{Bucket: bucket, Key: key, UploadId: id})
+	case r.Method == http.MethodPut && hasUploadID:
+		upload, ok := s.uploads[query.Get("uploadId")]
+		if !ok {
+			fakeError(w, http.StatusNotFound, "NoSuchUpload")
+			return
+		}
+		n, _ := strconv.Atoi(query.Get("partNumber"))
+		upload.parts[n] = body
+		w.Header().Set("ETag", md5ETag(body))
+	case r.Method == http.MethodPost && hasUploadID:
+		s.completeUpload(w, query.Get("uploadId"), objects, body)
+	case r.Method == http.MethodDelete && hasUploadID:
+		delete(s.uploads, query.Get("uploadId"))
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodPost && hasParam(query, "restore"):
+		if _, ok := objects[key]; !ok {
+			fakeError(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	case hasParam(query, "acl"):
+		obj, ok := objects[key]
+		if !ok {
+			fakeError(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		if r.Method == http.MethodPut {
+			// A canned ACL is kept as the header it was given in
+			obj.acl = nil
+			obj.header.Del("X-Amz-Acl")
+			if canned := r.Header.Get("X-Amz-Acl"); canned != "" {
+				obj.header.Set("X-Amz-Acl", canned)
+			} else {
+				obj.acl = body
+			}
+			return
+		}
+		acl := obj.acl
+		if acl == nil {
+			acl = []byte(`<AccessControlPolicy><Owner><ID>` + fakeOwner + `</ID></Owner><AccessControlList><Grant><Grantee xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xsi:type="CanonicalUser"><ID>` + fakeOwner + `</ID></Grantee><Permission>FULL_CONTROL</Permission></Grant></AccessControlList></AccessControlPolicy>`)
+		}
+		w.Header().Set("Content-Type", "application/xml")
+		w.Write(acl)
+	case hasParam(query, "tagging"):
+		obj, ok := objects[key]
+		if !ok {
+			fakeError(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		if r.Method == http.MethodPut {
+			obj.tagging = body
+			return
+		}
+		tagging := obj.tagging
+		if tagging == nil {
+			tagging = []byte("<Tagging><TagSet></TagSet></Tagging>")
+		}
+		w.Header().Set("Content-Type", "application/xml")
+		w.Write(tagging)
+	case r.Method == http.MethodPut && r.Header.Get("X-Amz-Copy-Source") != "":
+		s.copyObject(w, r, objects, key)
+	case r.Method == http.MethodPut:
+		obj := &fakeObject{
+			data:     body,
+			etag:     md5ETag(body),
+			header:   storedHeader(r),
+			modified: time.Now().UTC(),
+		}
+		if tagging := r.Header.Get("X-Amz-Tagging"); tagging != "" {
+			obj.tagging = taggingXML(tagging)
+		}
+		objects[key] = obj
+		w.Header().Set("ETag", obj.etag)
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		s.getObject(w, r, objects[key])
+	case r.Method == http.MethodDelete:
+		delete(objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		fakeError(w, http.StatusNotImplemented, "NotImplemented")
+	}
+}
+
+func hasParam(query url.Values, name string) bool {
+	_, ok := query[name]
+	return ok
+}
+
+// taggingXML turns the URL encoded tags of an x-amz-tagging header
+// into the XML returned by GetObjectTagging
+func taggingXML(encoded string) []byte {
+	values, _ := url.ParseQuery(encoded)
+	var names []string
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b bytes.Buffer
+	b.WriteString("<Tagging><TagSet>")
+	for _, name := range names {
+		fmt.Fprintf(&b, "<Tag><Key>%s</Key><Value>%s</Value></Tag>", name, values.Get(name))
+	}
+	b.WriteString("</TagSet></Tagging>")
+	return b.Bytes()
+}
+
+func (s *fakeS3) getObject(w http.ResponseWriter, r *http.Request, obj *fakeObject) {
+	if obj == nil {
+		if r.Method == http.MethodHead {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fakeError(w, http.StatusNotFound, "NoSuchKey")
+		return
+	}
+	// Every object has a single version, which listVersions calls v1
+	if v := r.URL.Query().Get("versionId"); v != "" {
+		if v != "v1" {
+			if r.Method == http.MethodHead {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			fakeError(w, http.StatusNotFound, "NoSuchVersion")
+			return
+		}
+		w.Header().Set("X-Amz-Version-Id", v)
+	}
+	if m := r.Header.Get("If-Match"); m != "" && m != obj.etag {
+		if r.Method == http.MethodHead {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+		fakeError(w, http.StatusPreconditionFailed, "PreconditionFailed")
+		return
+	}
+	for name, values := range obj.header {
+		if strings.HasPrefix(strings.ToLower(name), "x-amz-checksum-") &&
+			r.Header.Get("X-Amz-Checksum-Mode") != "ENABLED" {
+			continue
+		}
+		w.Header()[name] = values
+	}
+	if w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", "binary/octet-stream")
+	}
+	w.Header().Set("ETag", obj.etag)
+	w.Header().Set("Last-Modified", obj.modified.Format(http.TimeFormat))
+	w.Header().Set("Accept-Ranges", "bytes")
+	data := obj.data
+	status := http.StatusOK
+	if rng := r.Header.Get("Range"); rng != "" {
+		var first, last int
+		if _, err := fmt.Sscanf(rng, "bytes=%d-%d", &first, &last); err == nil && first < len(data) {
+			if last >= len(data) {
+				last = len(data) - 1
+			}
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", first, last, len(data)))
+			data = data[first : last+1]
+			status = http.StatusPartialContent
+		}
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.WriteHeader(status)
+	if r.Method == http.MethodGet {
+		w.Write(data)
+	}
+}
+
+func (s *fakeS3) copyObject(w http.ResponseWriter, r *http.Request, objects map[string]*fakeObject, key string) {
+	source, _ := url.PathUnescape(r.Header.Get("X-Amz-Copy-Source"))
+	srcBucket, srcKey, _ := strings.Cut(strings.TrimPrefix(source, "/"), "/")
+	src, ok := s.buckets[srcBucket][srcKey]
+	if !ok {
+		fakeError(w, http.StatusNotFound, "NoSuchKey")
+		return
+	}
+	if m := r.Header.Get("X-Amz-Copy-Source-If-Match"); m != "" && m != src.etag {
+		fakeError(w, http.StatusPreconditionFailed, "PreconditionFailed")
+		return
+	}
+	obj := &fakeObject{
+		data:     src.data,
+		etag:     src.etag,
+		header:   src.header.Clone(),
+		tagging:  src.tagging,
+		modified: time.Now().UTC(),
+	}
+	if r.Header.Get("X-Amz-Metadata-Directive") == "REPLACE" {
+		obj.header = storedHeader(r)
+	} else {
+		// Only the storage class and encryption may change
+		for _, name := range []string{"X-Amz-Storage-Class", "X-Amz-Server-Side-Encryption"} {
+			if v := r.Header.Get(name); v != "" {
+				obj.header.Set(name, v)
+			}
+		}
+	}
+	if r.Header.Get("X-Amz-Tagging-Directive") == "REPLACE" {
+		obj.tagging = taggingXML(r.Header.Get("X-Amz-Tagging"))
+	}
+	if acl := r.Header.Get("X-Amz-Acl"); acl != "" {
+		obj.header.Set("X-Amz-Acl", acl)
+	}
+	objects[key] = obj
+	writeXML(w, struct {
+		XMLName xml.Name `xml:"CopyObjectResult"`
+		ETag    string
+	}{ETag: obj.etag})
+}
+
+func (s *fakeS3) completeUpload(w http.ResponseWriter, id string, objects map[string]*fakeObject, body []byte) {
+	upload, ok := s.uploads[id]
+	if !ok {
+		fakeError(w, http.StatusNotFound, "NoSuchUpload")
+		return
+	}
+	var complete struct {
+		Parts []struct {
+			PartNumber int
+		} `xml:"Part"`
+	}
+	if err := xml.Unmarshal(body, &complete); err != nil {
+		fakeError(w, http.StatusBadRequest, "MalformedXML")
+		return
+	}
+	var data, sums []byte
+	for _, p := range complete.Parts {
+		part := upload.parts[p.PartNumber]
+		sum := md5.Sum(part)
+		data = append(data, part...)
+		sums = append(sums, sum[:]...)
+	}
+	sum := md5.Sum(sums)
+	obj := &fakeObject{
+		data:     data,
+		etag:     fmt.Sprintf(`"%s-%d"`, hex.EncodeToString(sum[:]), len(complete.Parts)),
+		header:   upload.header,
+		modified: time.Now().UTC(),
+	}
+	objects[upload.key] = obj
+	delete(s.uploads, id)
+	writeXML(w, struct {
+		XMLName xml.Name `xml:"CompleteMultipartUploadResult"`
+		Bucket  string
+		Key     string
+		ETag    string
+	}{Bucket: upload.bucket, Key: upload.key, ETag: obj.etag})
+}
+
+func (s *fakeS3) listBuckets(w http.ResponseWriter) {
+	type bucket struct {
+		Name         string
+		CreationDate string
+	}
+	var names []string
+	for name := range s.buckets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	result := struct {
+		XMLName xml.Name `xml:"ListAllMyBucketsResult"`
+		Owner   struct{ ID string }
+		Buckets []bucket `xml:"Buckets>Bucket"`
+	}{}
+	result.Owner.ID = fakeOwner
+	for _, name := range names {
+		result.Buckets = append(result.Buckets, bucket{name, "2024-01-02T15:04:05.000Z"})
+	}
+	writeXML(w, result)
+}
+
+// serveBucket handles the requests to a bucket rather than an
+// object in it
+func (s *fakeS3) serveBucket(
+	w http.ResponseWriter,
+	r *http.Request,
+	bucket string,
+	objects map[string]*fakeObject,
+	query url.Values,
+	body []byte,
+) {
+	switch {
+	case r.Method == http.MethodHead:
+	case r.Method == http.MethodDelete:
+		if len(objects) > 0 {
+			fakeError(w, http.StatusConflict, "BucketNotEmpty")
+			return
+		}
+		delete(s.buckets, bucket)
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodPost && hasParam(query, "delete"):
+		var del struct {
+			Objects []struct{ Key string } `xml:"Object"`
+		}
+		xml.Unmarshal(body, &del)
+		type deleted struct{ Key string }
+		type deleteError struct{ Key, Code, Message string }
+		result := struct {
+			XMLName xml.Name      `xml:"DeleteResult"`
+			Deleted []deleted     `xml:"Deleted"`
+			Errors  []deleteError `xml:"Error"`
+		}{}
+		for _, o := range del.Objects {
+			if s.undeletable[o.Key] {
+				result.Errors = append(result.Errors, deleteError{o.Key, "AccessDenied", "Access Denied"})
+				continue
+			}
+			delete(objects, o.Key)
+			result.Deleted = append(result.Deleted, deleted{o.Key})
+		}
+		writeXML(w, result)
+	case r.Method == http.MethodGet && hasParam(query, "versions"):
+		s.listVersions(w, objects, query)
+	case r.Method == http.MethodGet:
+		s.listObjects(w, bucket, objects, query)
+	default:
+		fakeError(w, http.StatusNotImplemented, "NotImplemented")
+	}
+}
+
+// fakeListPage is how many keys a fakeS3 listing returns at most
+const fakeListPage = 3
+
+func (s *fakeS3) listObjects(w http.ResponseWriter, bucket string, objects map[string]*fakeObject, query url.Values) {
+	prefix := query.Get("prefix")
+	delimiter := query.Get("delimiter")
+	after := query.Get("start-after")
+	if token := query.Get("continuation-token"); token != "" {
+		after = token
+	}
+	maxKeys := fakeListPage
+	if n, err := strconv.Atoi(query.Get("max-keys")); err == nil && n < maxKeys {
+		maxKeys = n
+	}
+	var keys []string
+	for k := range objects {
+		if strings.HasPrefix(k, prefix) && k > after {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	type content struct {
+		Key          string
+		LastModified string
+		ETag         string
+		Size         int
+		StorageClass string
+	}
+	type commonPrefix struct{ Prefix string }
+	result := struct {
+		XMLName               xml.Name `xml:"ListBucketResult"`
+		Name                  string
+		Prefix                string
+		IsTruncated           bool
+		NextContinuationToken string         `xml:",omitempty"`
+		Contents              []content      `xml:"Contents"`
+		CommonPrefixes        []commonPrefix `xml:"CommonPrefixes"`
+	}{Name: bucket, Prefix: prefix}
+	seen := make(map[string]bool)
+	listed := 0
+	for _, k := range keys {
+		if listed == maxKeys {
+			result.IsTruncated = true
+			break
+		}
+		if delimiter != "" {
+			if i := strings.Index(k[len(prefix):], delimiter); i >= 0 {
+				p := k[:len(prefix)+i+len(delimiter)]
+				if !seen[p] {
+					seen[p] = true
+					result.CommonPrefixes = append(result.CommonPrefixes, commonPrefix{p})
+					listed++
+				}
+				result.NextContinuationToken = k
+				continue
+			}
+		}
+		obj := objects[k]
+		storageClass := obj.header.Get("X-Amz-Storage-Class")
+		if storageClass == "" {
+			storageClass = "STANDARD"
+		}
+		result.Contents = append(result.Contents, content{
+			Key:          k,
+			LastModified: obj.modified.Format(time.RFC3339),
+			ETag:         obj.etag,
+			Size:         len(obj.data),
+			StorageClass: storageClass,
+		})
+		result.NextContinuationToken = k
+		listed++
+	}
+	if !result.IsTruncated {
+		result.NextContinuationToken = ""
+	} else if delimiter != "" {
+		// Skip the rest of the last common prefix
+		last := result.NextContinuationToken
+		if i := strings.Index(last[len(prefix):], delimiter); i >= 0 {
+			result.NextContinuationToken = last[:len(prefix)+i+len(delimiter)] + "\xff"
+		}
+	}
+	writeXML(w, result)
+}
+
+func (s *fakeS3) listVersions(w http.ResponseWriter, objects map[string]*fakeObject, query url.Values) {
+	type version struct {
+		Key          string
+		VersionId    string
+		IsLatest     bool
+		LastModified string
+		ETag         string
+		Size         int
+	}
+	result := struct {
+		XMLName     xml.Name `xml:"ListVersionsResult"`
+		IsTruncated bool
+		Versions    []version `xml:"Version"`
+	}{}
+	var keys []string
+	for k := range objects {
+		if strings.HasPrefix(k, query.Get("prefix")) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		obj := objects[k]
+		result.Versions = append(result.Versions, version{
+			Key:          k,
+			VersionId:    "v1",
+			IsLatest:     true,
+			LastModified: obj.modified.Format(time.RFC3339),
+			ETag:         obj.etag,
+			Size:         len(obj.data),
+		})
+	}
+	writeXML(w, result)
+}
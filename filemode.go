@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// preserveMode stores the permission bits of uploaded files in
+// their metadata and restores them on download
+var preserveMode bool
+
+// modeMetadataKey is the user metadata entry holding the
+// permission bits in octal, sent as x-amz-meta-mode
+const modeMetadataKey = "mode"
+
+// setModeMetadata records the permission bits of a file in the
+// metadata of its upload.
+func setModeMetadata(metadata map[string]*string, mode os.FileMode) map[string]*string {
+	if metadata == nil {
+		metadata = make(map[string]*string)
+	}
+	metadata[modeMetadataKey] = aws.String(fmt.Sprintf("%o", mode.Perm()))
+	return metadata
+}
+
+// modeFromMetadata returns the permission bits recorded in an
+// object's metadata, if there are any.
+func modeFromMetadata(metadata map[string]*string) (os.FileMode, bool) {
+	value := lookupMetadata(metadata, modeMetadataKey)
+	if value == "" {
+		return 0, false
+	}
+	mode, err := strconv.ParseUint(value, 8, 32)
+	if err != nil {
+		return 0, false
+	}
+	return os.FileMode(mode).Perm(), true
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+func TestModeFromMetadata(t *testing.T) {
+	for _, tt := range []struct {
+		metadata map[string]*string
+		mode     os.FileMode
+		ok       bool
+	}{
+		{nil, 0, false},
+		{map[string]*string{"Mode": aws.String("755")}, 0755, true},
+		{map[string]*string{"mode": aws.String("600")}, 0600, true},
+		{map[string]*string{"Mode": aws.String("4755")}, 0755, true},
+		{map[string]*string{"Mode": aws.String("rwx")}, 0, false},
+	} {
+		mode, ok := modeFromMetadata(tt.metadata)
+		if mode != tt.mode || ok != tt.ok {
+			t.Errorf("modeFromMetadata(%v) = %o, %v, want %o, %v", tt.metadata, mode, ok, tt.mode, tt.ok)
+		}
+	}
+}
+
+func TestPreserveModeRoundTrip(t *testing.T) {
+	s := newFakeS3(t, "b")
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"run.sh": "#!/bin/sh\n"})
+	script := filepath.Join(dir, "run.sh")
+	if err := os.Chmod(script, 0750); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := run(t, "-preserve-mode", script, "s3://b/run.sh"); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.object("b", "run.sh").header.Get("X-Amz-Meta-Mode"); got != "750" {
+		t.Errorf("stored the mode %q, want 750", got)
+	}
+	for _, tt := range []struct {
+		preserve bool
+		want     os.FileMode
+	}{
+		{true, 0750},
+		{false, 0644},
+	} {
+		dest := filepath.Join(t.TempDir(), "run.sh")
+		if _, err := run(t, fmt.Sprintf("-preserve-mode=%v", tt.preserve), "s3://b/run.sh", dest); err != nil {
+			t.Fatal(err)
+		}
+		info, err := os.Stat(dest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm()&0111 != tt.want&0111 {
+			t.Errorf("-preserve-mode=%v downloaded with the mode %o, want %o", tt.preserve, info.Mode().Perm(), tt.want)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"mime"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// isGenericContentType reports whether a content type says
+// nothing about the data, as is the case for objects uploaded
+// without one.
+func isGenericContentType(contentType string) bool {
+	switch contentType {
+	case "", "application/octet-stream", "binary/octet-stream":
+		return true
+	}
+	return false
+}
+
+// fixupContentTypes sets the content type of every object under
+// the prefix that is stored as binary data but whose extension
+// has a known type, repairing objects uploaded before content
+// types were guessed. With -dry-run the changes are only printed.
+func fixupContentTypes(target string) error {
+	bucket, prefix, err := splitNameParts(target)
+	if err != nil {
+		return fmt.Errorf("failed to parse '%s': %w", target, err)
+	}
+	s3Client := s3.New(createSession())
+	keys, err := listKeys(s3Client, bucket, prefix)
+	if err != nil {
+		return err
+	}
+
+	var fixed int64
+	pool := newJobPool(parallelism, func(payload interface{}) interface{} {
+		key := payload.(string)
+		changed, err := fixupContentType(s3Client, bucket, key)
+		if changed {
+			atomic.AddInt64(&fixed, 1)
+		}
+		return err
+	})
+	defer pool.Close()
+
+	var (
+		wg     sync.WaitGroup
+		errsMu sync.Mutex
+		errs   []error
+		total  int
+	)
+	for _, key := range keys {
+		if strings.HasSuffix(key, "/") || mime.TypeByExtension(path.Ext(key)) == "" {
+			// Nothing to infer the type from
+			continue
+		}
+		total++
+		key := key
+		startJob(&wg, func() {
+			if err, ok := pool.Process(key).(error); ok && err != nil {
+				errsMu.Lock()
+				errs = append(errs, err)
+				errsMu.Unlock()
+			}
+		})
+	}
+	wg.Wait()
+	for _, err := range errs {
+		logError(err)
+	}
+	if !quiet {
+		verb := "fixed"
+		if dryRun {
+			verb = "would fix"
+		}
+		fmt.Printf("%s content type of %d of %d objects\n", verb, fixed, len(keys))
+	}
+	if len(errs) > 0 {
+		return &batchError{failed: len(errs), total: total, what: "fixups"}
+	}
+	return nil
+}
+
+// fixupContentType corrects the content type of a single object
+// and reports whether it needed to. The type can only be changed
+// by copying the object onto itself, replacing its metadata, so
+// the other headers are carried over and, as with modify, the
+// ACL is read beforehand and put back afterwards. The copy is
+// encrypted the same way as the object, for which objects
+// encrypted with a customer key need -sse-customer-key.
+func fixupContentType(s3Client *s3.S3, bucket string, key string) (bool, error) {
+	target := fmt.Sprintf("s3://%s/%s", bucket, key)
+	ctx, cancel := jobContext()
+	defer cancel()
+	head, err := s3Client.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+
+		SSECustomerAlgorithm: sseCustomer.algorithm,
+		SSECustomerKey:       sseCustomer.key,
+		SSECustomerKeyMD5:    sseCustomer.keyMD5,
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to stat '%s': %w", target, err)
+	}
+	current := aws.StringValue(head.ContentType)
+	if !isGenericContentType(current) {
+		return false, nil
+	}
+	contentType := mime.TypeByExtension(path.Ext(key))
+	if dryRun {
+		fmt.Printf("~ %s (%s -> %s)\n", target, current, contentType)
+		return true, nil
+	}
+
+	acl, err := s3Client.GetObjectAclWithContext(ctx, &s3.GetObjectAclInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to get ACL of '%s': %w", target, err)
+	}
+	if _, err := s3Client.CopyObjectWithContext(ctx, &s3.CopyObjectInput{
+		Bucket:                  aws.String(bucket),
+		Key:                     aws.String(key),
+		CopySource:              aws.String(copySource(bucket, key)),
+		CopySourceIfMatch:       head.ETag,
+		MetadataDirective:       aws.String(s3.MetadataDirectiveReplace),
+		ContentType:             aws.String(contentType),
+		Metadata:                head.Metadata,
+		CacheControl:            head.CacheControl,
+		ContentDisposition:      head.ContentDisposition,
+		ContentEncoding:         head.ContentEncoding,
+		ContentLanguage:         head.ContentLanguage,
+		WebsiteRedirectLocation: head.WebsiteRedirectLocation,
+		StorageClass:            head.StorageClass,
+
+		ServerSideEncryption: head.ServerSideEncryption,
+		SSEKMSKeyId:          head.SSEKMSKeyId,
+		BucketKeyEnabled:     head.BucketKeyEnabled,
+
+		SSECustomerAlgorithm:           sseCustomer.algorithm,
+		SSECustomerKey:                 sseCustomer.key,
+		SSECustomerKeyMD5:              sseCustomer.keyMD5,
+		CopySourceSSECustomerAlgorithm: sseCustomer.algorithm,
+		CopySourceSSECustomerKey:       sseCustomer.key,
+		CopySourceSSECustomerKeyMD5:    sseCustomer.keyMD5,
+	}); err != nil {
+		return false, fmt.Errorf("failed to change content type of '%s': %w", target, err)
+	}
+	if _, err := s3Client.PutObjectAclWithContext(ctx, &s3.PutObjectAclInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+		AccessControlPolicy: &s3.AccessControlPolicy{
+			Owner:  acl.Owner,
+			Grants: acl.Grants,
+		},
+	}); err != nil {
+		return true, fmt.Errorf("failed to restore ACL of '%s': %w", target, err)
+	}
+	logSuccess("fixup: %s (%s -> %s)", target, current, contentType)
+	return true, nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestFixupContentType(t *testing.T) {
+	s := newFakeS3(t, "b")
+	s.put("b", "site/index.html", "<p>", "Content-Type", "application/octet-stream",
+		"X-Amz-Meta-Owner", "alice", "Cache-Control", "max-age=60")
+	s.put("b", "site/style.css", "p{}", "Content-Type", "text/css; charset=utf-8")
+	s.put("b", "site/data.json", "{}")
+	s.put("b", "site/custom.css", "p{}", "Content-Type", "text/x-custom")
+	s.put("b", "site/blob.unknownext", "", "Content-Type", "application/octet-stream")
+	s.put("b", "other/a.html", "<p>", "Content-Type", "application/octet-stream")
+
+	out, err := run(t, "fixup-content-type", "-dry-run", "s3://b/site/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "would fix content type of 2 of 5 objects") {
+		t.Errorf("-dry-run printed %q", out)
+	}
+	if copies := s.received(http.MethodPut, ""); len(copies) != 0 {
+		t.Fatalf("-dry-run changed %d objects", len(copies))
+	}
+
+	out, err = run(t, "fixup-content-type", "s3://b/site/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "fixed content type of 2 of 5 objects") {
+		t.Errorf("printed %q", out)
+	}
+	for key, want := range map[string]string{
+		"site/index.html":      "text/html; charset=utf-8",
+		"site/data.json":       "application/json",
+		"site/style.css":       "text/css; charset=utf-8",
+		"site/custom.css":      "text/x-custom",
+		"site/blob.unknownext": "application/octet-stream",
+		"other/a.html":         "application/octet-stream",
+	} {
+		if got := s.object("b", key).header.Get("Content-Type"); got != want {
+			t.Errorf("%s has the content type %q, want %q", key, got, want)
+		}
+	}
+	index := s.object("b", "site/index.html")
+	if index.header.Get("X-Amz-Meta-Owner") != "alice" || index.header.Get("Cache-Control") != "max-age=60" {
+		t.Errorf("fixing index.html lost its headers: %v", index.header)
+	}
+	var copied []string
+	for _, r := range s.received(http.MethodPut, "") {
+		if r.header.Get("X-Amz-Copy-Source") != "" {
+			copied = append(copied, r.key)
+		}
+	}
+	if len(copied) != 2 {
+		t.Errorf("copied %v, want only the mistyped objects", copied)
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// folderSizes makes ls print how many objects and bytes are under
+// each "directory" beneath the prefix instead of the objects
+var folderSizes bool
+
+// folderTotal is the number and size of the objects under a prefix
+type folderTotal struct {
+	objects int64
+	bytes   int64
+}
+
+// sumFolders totals the objects under every directory beneath the
+// prefix, including those nested in other directories. The prefix
+// itself is keyed by the empty string.
+func sumFolders(objects []*s3.Object, prefix string) map[string]*folderTotal {
+	totals := map[string]*folderTotal{"": {}}
+	for _, obj := range objects {
+		if !isNewer(obj.LastModified) {
+			continue
+		}
+		rel := strings.TrimPrefix(aws.StringValue(obj.Key), prefix)
+		size := aws.Int64Value(obj.Size)
+		dir := ""
+		for {
+			t := totals[dir]
+			if t == nil {
+				t = &folderTotal{}
+				totals[dir] = t
+			}
+			t.objects++
+			t.bytes += size
+			i := strings.Index(rel[len(dir):], "/")
+			if i < 0 {
+				break
+			}
+			dir = rel[:len(dir)+i+1]
+		}
+	}
+	return totals
+}
+
+// writeFolderTree prints the totals as a tree, indenting each
+// directory beneath its parent, followed by the grand total
+func writeFolderTree(w io.Writer, totals map[string]*folderTotal, prefix string) error {
+	dirs := make([]string, 0, len(totals))
+	for dir := range totals {
+		if dir != "" {
+			dirs = append(dirs, dir)
+		}
+	}
+	sort.Strings(dirs)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	for _, dir := range dirs {
+		t := totals[dir]
+		depth := strings.Count(dir, "/") - 1
+		name := dir[strings.LastIndex(dir[:len(dir)-1], "/")+1:]
+		if _, err := fmt.Fprintf(tw, "%d\t%s\t  %s%s\n", t.objects, formatBytes(t.bytes), strings.Repeat("  ", depth), name); err != nil {
+			return err
+		}
+	}
+	t := totals[""]
+	if _, err := fmt.Fprintf(tw, "%d\t%s\t  total %s\n", t.objects, formatBytes(t.bytes), prefix); err != nil {
+		return err
+	}
+	return tw.Flush()
+}
+
+// lsFolders lists everything under the prefix, concurrently per
+// directory with -list-parallelism, and prints the folder tree
+func lsFolders(s3Client *s3.S3, bucket string, prefix string, w io.Writer) error {
+	objects, err := listObjects(s3Client, bucket, prefix)
+	if err != nil {
+		return err
+	}
+	return writeFolderTree(w, sumFolders(objects, prefix), fmt.Sprintf("s3://%s/%s", bucket, prefix))
+}
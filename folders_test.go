@@ -0,0 +1,74 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+func TestSumFolders(t *testing.T) {
+	var objects []*s3.Object
+	for key, size := range map[string]int64{
+		"data/a.txt":             1,
+		"data/logs/b.log":        10,
+		"data/logs/2024/c.log":   100,
+		"data/images/d.png":      1000,
+		"data/images/thumbs/e":   10000,
+		"data/images/thumbs/f/g": 100000,
+	} {
+		objects = append(objects, &s3.Object{Key: aws.String(key), Size: aws.Int64(size)})
+	}
+	totals := sumFolders(objects, "data/")
+	for _, tt := range []struct {
+		dir     string
+		objects int64
+		bytes   int64
+	}{
+		{"", 6, 111111},
+		{"logs/", 2, 110},
+		{"logs/2024/", 1, 100},
+		{"images/", 3, 111000},
+		{"images/thumbs/", 2, 110000},
+		{"images/thumbs/f/", 1, 100000},
+	} {
+		if got := totals[tt.dir]; got == nil || got.objects != tt.objects || got.bytes != tt.bytes {
+			t.Errorf("%q totals %+v, want %d objects of %d bytes", tt.dir, got, tt.objects, tt.bytes)
+		}
+	}
+	if len(totals) != 6 {
+		t.Errorf("got %d folders, want 6", len(totals))
+	}
+}
+
+func TestLsFolderTree(t *testing.T) {
+	s := newFakeS3(t, "b")
+	s.put("b", "data/a.txt", "a")
+	s.put("b", "data/logs/b.log", "bb")
+	s.put("b", "data/logs/2024/c.log", "ccc")
+	s.put("b", "other/d.txt", "dddd")
+	out, err := run(t, "ls", "-list-delimiter-recursive", "s3://b/data/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lines []string
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		lines = append(lines, strings.Join(strings.Fields(line), " "))
+	}
+	want := []string{
+		"2 5 B logs/",
+		"1 3 B 2024/",
+		"3 6 B total s3://b/data/",
+	}
+	if strings.Join(lines, "\n") != strings.Join(want, "\n") {
+		t.Errorf("printed\n%s\nwant\n%s", out, strings.Join(want, "\n"))
+	}
+	if !strings.Contains(out, "    2024/") {
+		t.Errorf("2024/ isn't indented beneath logs/:\n%s", out)
+	}
+
+	if _, err := run(t, "ls", "-list-delimiter-recursive", "-versions", "s3://b/"); exitCode(err) != exitUsage {
+		t.Errorf("-list-delimiter-recursive -versions exited with %d (%v), want %d", exitCode(err), err, exitUsage)
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"syscall"
+	"time"
+)
+
+// Network filesystems such as NFS and SMB occasionally fail to
+// open or read a file for reasons that go away by themselves.
+// Those operations are retried a few times before giving up.
+const (
+	fsRetries    = 3
+	fsRetryDelay = 100 * time.Millisecond
+)
+
+// isTransientFSError reports whether a filesystem error is worth
+// retrying. Errors such as a missing file or a lack of permission
+// are not.
+func isTransientFSError(err error) bool {
+	return errors.Is(err, syscall.EAGAIN) ||
+		errors.Is(err, syscall.EINTR) ||
+		errors.Is(err, syscall.ESTALE)
+}
+
+// openWithRetry opens a file for reading, retrying transient
+// failures.
+func openWithRetry(name string) (*os.File, error) {
+	f, err := os.Open(name)
+	for i := 0; i < fsRetries && err != nil && isTransientFSError(err); i++ {
+		time.Sleep(fsRetryDelay)
+		f, err = os.Open(name)
+	}
+	return f, err
+}
+
+// retryingFile retries reads that fail transiently, picking up
+// after the bytes that were read before the failure. It keeps
+// the file's ReadAt and Seek, which the uploader relies on to
+// read parts concurrently without buffering them. The file is
+// not embedded, so that io.Copy can't bypass Read via WriteTo.
+type retryingFile struct {
+	f readSeekerAt
+}
+
+// readSeekerAt is the part of *os.File that retryingFile wraps
+type readSeekerAt interface {
+	io.Reader
+	io.ReaderAt
+	io.Seeker
+}
+
+func (f *retryingFile) Read(p []byte) (int, error) {
+	n, err := f.f.Read(p)
+	for i := 0; i < fsRetries && err != nil && isTransientFSError(err); i++ {
+		time.Sleep(fsRetryDelay)
+		var m int
+		m, err = f.f.Read(p[n:])
+		n += m
+	}
+	return n, err
+}
+
+func (f *retryingFile) ReadAt(p []byte, off int64) (int, error) {
+	n, err := f.f.ReadAt(p, off)
+	for i := 0; i < fsRetries && err != nil && isTransientFSError(err); i++ {
+		time.Sleep(fsRetryDelay)
+		var m int
+		m, err = f.f.ReadAt(p[n:], off+int64(n))
+		n += m
+	}
+	return n, err
+}
+
+func (f *retryingFile) Seek(offset int64, whence int) (int64, error) {
+	return f.f.Seek(offset, whence)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestIsTransientFSError(t *testing.T) {
+	for _, tt := range []struct {
+		err  error
+		want bool
+	}{
+		{syscall.EAGAIN, true},
+		{syscall.ESTALE, true},
+		{&os.PathError{Op: "read", Path: "a", Err: syscall.EINTR}, true},
+		{fmt.Errorf("wrapped: %w", syscall.ESTALE), true},
+		{os.ErrNotExist, false},
+		{syscall.EACCES, false},
+		{io.EOF, false},
+	} {
+		if got := isTransientFSError(tt.err); got != tt.want {
+			t.Errorf("isTransientFSError(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+// flakyFile fails its first reads with EAGAIN after reading a
+// single byte
+type flakyFile struct {
+	*strings.Reader
+	failures int
+}
+
+func (f *flakyFile) Read(p []byte) (int, error) {
+	if f.failures > 0 {
+		f.failures--
+		n, _ := f.Reader.Read(p[:1])
+		return n, &os.PathError{Op: "read", Path: "flaky", Err: syscall.EAGAIN}
+	}
+	return f.Reader.Read(p)
+}
+
+func (f *flakyFile) ReadAt(p []byte, off int64) (int, error) {
+	if f.failures > 0 {
+		f.failures--
+		return 0, syscall.EAGAIN
+	}
+	return f.Reader.ReadAt(p, off)
+}
+
+func TestRetryingFile(t *testing.T) {
+	r := &retryingFile{&flakyFile{Reader: strings.NewReader("hello world"), failures: 2}}
+	data, err := io.ReadAll(r)
+	if err != nil || string(data) != "hello world" {
+		t.Errorf("read %q, %v", data, err)
+	}
+	r = &retryingFile{&flakyFile{Reader: strings.NewReader("hello world"), failures: 1}}
+	p := make([]byte, 5)
+	if n, err := r.ReadAt(p, 6); err != nil || string(p[:n]) != "world" {
+		t.Errorf("read %q, %v at offset 6", p[:n], err)
+	}
+	r = &retryingFile{&flakyFile{Reader: strings.NewReader("hello world"), failures: fsRetries + 1}}
+	if _, err := io.ReadAll(r); !errors.Is(err, syscall.EAGAIN) {
+		t.Errorf("got %v after running out of retries, want EAGAIN", err)
+	}
+}
+
+func TestOpenWithRetryMissingFile(t *testing.T) {
+	_, err := openWithRetry(filepath.Join(t.TempDir(), "missing"))
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("got %v, want a missing file", err)
+	}
+}
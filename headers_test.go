@@ -0,0 +1,119 @@
+package main
+
+import (
+	"flag"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseHeader(t *testing.T) {
+	for _, tt := range []struct {
+		header      string
+		name, value string
+	}{
+		{"X-Gateway-Auth: token", "X-Gateway-Auth", "token"},
+		{"x-route:shard-1", "X-Route", "shard-1"},
+		{"  X-Empty :  ", "X-Empty", ""},
+		{"X-Url: https://a.example/b", "X-Url", "https://a.example/b"},
+		{"X-Odd~Name: v", "X-Odd~name", "v"},
+	} {
+		name, value, err := parseHeader(tt.header)
+		if err != nil || name != tt.name || value != tt.value {
+			t.Errorf("parseHeader(%q) = %q, %q, %v, want %q, %q", tt.header, name, value, err, tt.name, tt.value)
+		}
+	}
+	for _, header := range []string{
+		"X-No-Colon",
+		": value",
+		"X Space: value",
+		"X-Ü: value",
+		"X-Split: a\r\nX-Injected: b",
+	} {
+		if name, value, err := parseHeader(header); err == nil {
+			t.Errorf("parseHeader(%q) = %q, %q, expected an error", header, name, value)
+		}
+	}
+}
+
+func TestCustomHeaders(t *testing.T) {
+	s := newFakeS3(t, "b")
+	if _, err := run(t, "ls", "-header", "X-Gateway-Auth: token", "-header", "x-route: shard-1", "s3://b"); err != nil {
+		t.Fatal(err)
+	}
+	requests := s.received(http.MethodGet, "")
+	if len(requests) == 0 {
+		t.Fatal("no request reached the endpoint")
+	}
+	for _, r := range requests {
+		if r.header.Get("X-Gateway-Auth") != "token" || r.header.Get("X-Route") != "shard-1" {
+			t.Errorf("sent the headers %v", r.header)
+		}
+		if auth := r.header.Get("Authorization"); !strings.Contains(auth, "x-gateway-auth") {
+			t.Errorf("the custom headers weren't signed: %s", auth)
+		}
+	}
+
+	globalFlags = flag.NewFlagSet("s3util", flag.ContinueOnError)
+	var err error
+	captureStderr(t, func() { _, _, err = parseCommandLine([]string{"ls", "-header", "X-No-Colon", "s3://b"}) })
+	if err == nil {
+		t.Error("an invalid -header was accepted")
+	}
+}
+
+func TestCheckExpectedBucketOwner(t *testing.T) {
+	defer func() { expectedBucketOwner = "" }()
+	for _, tt := range []struct {
+		owner string
+		ok    bool
+	}{
+		{"", true},
+		{"123456789012", true},
+		{"12345678901", false},
+		{"1234567890123", false},
+		{"12345678901a", false},
+	} {
+		expectedBucketOwner = tt.owner
+		if err := checkExpectedBucketOwner(); tt.ok != (err == nil) {
+			t.Errorf("-expected-bucket-owner %q: %v", tt.owner, err)
+		}
+	}
+}
+
+func TestExpectedBucketOwner(t *testing.T) {
+	s := newFakeS3(t, "b")
+	s.put("b", "a.txt", "hello")
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"b.txt": "hi"})
+	for _, args := range [][]string{
+		{"-expected-bucket-owner", "123456789012", "-verify", dir, "s3://b/"},
+		{"-expected-bucket-owner", "123456789012", "s3://b/a.txt", filepath.Join(dir, "a.txt")},
+		{"ls", "-expected-bucket-owner", "123456789012", "s3://b"},
+		{"rm", "-expected-bucket-owner", "123456789012", "s3://b/b.txt"},
+	} {
+		if _, err := run(t, args...); err != nil {
+			t.Fatalf("%q: %v", args, err)
+		}
+	}
+	s.mu.Lock()
+	requests := s.requests
+	s.mu.Unlock()
+	methods := make(map[string]bool)
+	for _, r := range requests {
+		methods[r.method] = true
+		if got := r.header.Get("X-Amz-Expected-Bucket-Owner"); got != "123456789012" {
+			t.Errorf("%s %s/%s was sent with the expected bucket owner %q", r.method, r.bucket, r.key, got)
+		}
+	}
+	for _, method := range []string{http.MethodGet, http.MethodPut, http.MethodHead, http.MethodDelete} {
+		if !methods[method] {
+			t.Errorf("no %s request was sent", method)
+		}
+	}
+
+	if _, err := run(t, "ls", "-expected-bucket-owner", "owner", "s3://b"); exitCode(err) != exitUsage {
+		t.Errorf("an invalid account ID exited with %d (%v), want %d", exitCode(err), err, exitUsage)
+	}
+}
@@ -0,0 +1,469 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// listVersions is set by the -versions flag
+var listVersions bool
+
+// outputFormat selects how ls prints its listing, see newListWriter
+var outputFormat string
+
+// listEntry is a single line of ls output
+type listEntry struct {
+	Key          string `json:"key"`
+	Size         int64  `json:"size"`
+	LastModified string `json:"last_modified"`
+	ETag         string `json:"etag,omitempty"`
+	StorageClass string `json:"storage_class,omitempty"`
+	VersionID    string `json:"version_id,omitempty"`
+	IsLatest     bool   `json:"is_latest,omitempty"`
+	DeleteMarker bool   `json:"delete_marker,omitempty"`
+}
+
+// listWriter renders list entries in one of the output formats
+type listWriter interface {
+	write(e *listEntry) error
+	flush() error
+}
+
+// newListWriter returns a writer for the given -output-format:
+// "table" aligns columns for humans, "json" writes an array of
+// objects and "csv" writes a header row followed by one row
+// per entry. Timestamps are always RFC3339.
+func newListWriter(w io.Writer, format string, versions bool) (listWriter, error) {
+	switch format {
+	case "", "table":
+		return &tableListWriter{
+			w:        tabwriter.NewWriter(w, 0, 0, 2, ' ', 0),
+			versions: versions,
+		}, nil
+	case "json":
+		return &jsonListWriter{w: w}, nil
+	case "csv":
+		return &csvListWriter{w: csv.NewWriter(w), versions: versions}, nil
+	}
+	return nil, fmt.Errorf("unknown output format '%s' (expected table, json or csv)", format)
+}
+
+type tableListWriter struct {
+	w        *tabwriter.Writer
+	versions bool
+}
+
+func (t *tableListWriter) write(e *listEntry) error {
+	size := strconv.FormatInt(e.Size, 10)
+	if e.DeleteMarker {
+		size = "DELETED"
+	}
+	var err error
+	if t.versions {
+		latest := ""
+		if e.IsLatest {
+			latest = "(latest)"
+		}
+		_, err = fmt.Fprintf(t.w, "%s\t%s\t%s\t%s\t%s\t\n", e.LastModified, size, e.VersionID, e.Key, latest)
+	} else {
+		_, err = fmt.Fprintf(t.w, "%s\t%s\t%s\t\n", e.LastModified, size, e.Key)
+	}
+	return err
+}
+
+func (t *tableListWriter) flush() error {
+	return t.w.Flush()
+}
+
+type jsonListWriter struct {
+	w       io.Writer
+	entries int
+}
+
+func (j *jsonListWriter) write(e *listEntry) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	sep := ",\n"
+	if j.entries == 0 {
+		sep = "[\n"
+	}
+	j.entries++
+	_, err = fmt.Fprintf(j.w, "%s  %s", sep, data)
+	return err
+}
+
+func (j *jsonListWriter) flush() error {
+	if j.entries == 0 {
+		_, err := fmt.Fprintln(j.w, "[]")
+		return err
+	}
+	_, err := fmt.Fprintln(j.w, "\n]")
+	return err
+}
+
+type csvListWriter struct {
+	w             *csv.Writer
+	versions      bool
+	headerWritten bool
+}
+
+func (c *csvListWriter) write(e *listEntry) error {
+	if !c.headerWritten {
+		c.headerWritten = true
+		header := []string{"key", "size", "last_modified", "etag", "storage_class"}
+		if c.versions {
+			header = append(header, "version_id", "is_latest", "delete_marker")
+		}
+		if err := c.w.Write(header); err != nil {
+			return err
+		}
+	}
+	row := []string{
+		e.Key,
+		strconv.FormatInt(e.Size, 10),
+		e.LastModified,
+		e.ETag,
+		e.StorageClass,
+	}
+	if c.versions {
+		row = append(
+			row,
+			e.VersionID,
+			strconv.FormatBool(e.IsLatest),
+			strconv.FormatBool(e.DeleteMarker),
+		)
+	}
+	return c.w.Write(row)
+}
+
+func (c *csvListWriter) flush() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// Where to start and how many objects to list with ls, e.g. to
+// resume a listing or to sample a large bucket. Zero -max-keys
+// means no limit.
+var (
+	startAfter string
+	maxKeys    int64
+)
+
+// maxListPage is the most objects a single list request returns
+const maxListPage = 1000
+
+// newerThan limits ls and wildcard downloads to objects modified
+// after a time, given in RFC 3339 format or as a duration before
+// now, e.g. 24h. S3 can't filter by time itself, so the objects
+// are still listed and filtered afterwards.
+var newerThan string
+
+// newerThanTime is parsed from -newer-than by parseNewerThan and
+// zero if it isn't given
+var newerThanTime time.Time
+
+// parseNewerThan validates -newer-than
+func parseNewerThan() error {
+	if newerThan == "" {
+		return nil
+	}
+	t, err := time.Parse(time.RFC3339, newerThan)
+	if err != nil {
+		d, durErr := time.ParseDuration(newerThan)
+		if durErr != nil {
+			return usageErrorf("invalid -newer-than '%s' (expected e.g. 2024-01-02T15:04:05Z or 24h)", newerThan)
+		}
+		t = time.Now().Add(-d)
+	}
+	newerThanTime = t
+	return nil
+}
+
+// isNewer reports whether an object modified at t passes
+// -newer-than
+func isNewer(t *time.Time) bool {
+	return newerThanTime.IsZero() || aws.TimeValue(t).After(newerThanTime)
+}
+
+// ls prints every object under an s3 path, or the buckets given
+// just s3://
+func ls(target string) error {
+	if target == "s3://" {
+		return buckets()
+	}
+	bucket, prefix, err := splitNameParts(target)
+	if err != nil {
+		return fmt.Errorf("failed to parse '%s': %w", target, err)
+	}
+	out, err := newListWriter(os.Stdout, outputFormat, listVersions)
+	if err != nil {
+		return err
+	}
+	if listVersions && (startAfter != "" || maxKeys > 0) {
+		return usageErrorf("-start-after and -max-keys cannot be used with -versions")
+	}
+	if err := parseNewerThan(); err != nil {
+		return err
+	}
+	s3Client := s3.New(createSession())
+	if folderSizes {
+		if listVersions || startAfter != "" || maxKeys > 0 || outputFormat != "table" {
+			return usageErrorf("-list-delimiter-recursive cannot be used with -versions, -start-after, -max-keys or -output-format")
+		}
+		return lsFolders(s3Client, bucket, prefix, os.Stdout)
+	}
+	if listVersions {
+		err = lsVersions(s3Client, bucket, prefix, out)
+	} else {
+		err = lsObjects(s3Client, bucket, prefix, out)
+	}
+	if err != nil {
+		return err
+	}
+	return out.flush()
+}
+
+// lsObjects lists the objects under the prefix in key order,
+// starting after -start-after and stopping after -max-keys.
+func lsObjects(s3Client *s3.S3, bucket string, prefix string, out listWriter) error {
+	if listParallelism > 1 {
+		return lsObjectsConcurrently(s3Client, bucket, prefix, out)
+	}
+	input := &s3.ListObjectsV2Input{
+		Bucket:       aws.String(bucket),
+		Prefix:       aws.String(prefix),
+		StartAfter:   optionalString(startAfter),
+		RequestPayer: optionalString(requestPayer),
+	}
+	if maxKeys > 0 && maxKeys < maxListPage {
+		input.MaxKeys = aws.Int64(maxKeys)
+	}
+	var writeErr error
+	var listed int64
+	if err := s3Client.ListObjectsV2Pages(
+		input,
+		func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+			for _, obj := range page.Contents {
+				if !isNewer(obj.LastModified) {
+					continue
+				}
+				if maxKeys > 0 && listed == maxKeys {
+					return false
+				}
+				listed++
+				if writeErr = out.write(objectEntry(obj)); writeErr != nil {
+					return false
+				}
+			}
+			return true
+		},
+	); err != nil {
+		return fmt.Errorf("failed to list 's3://%s/%s': %w", bucket, prefix, err)
+	}
+	return writeErr
+}
+
+// lsObjectsConcurrently lists the objects under the prefix like
+// lsObjects, listing the directories beneath it concurrently with
+// -list-parallelism. The listing has to be merged before anything
+// is written, so -start-after and -max-keys only filter it then.
+func lsObjectsConcurrently(s3Client *s3.S3, bucket string, prefix string, out listWriter) error {
+	objects, err := listObjects(s3Client, bucket, prefix)
+	if err != nil {
+		return err
+	}
+	var listed int64
+	for _, obj := range objects {
+		if aws.StringValue(obj.Key) <= startAfter || !isNewer(obj.LastModified) {
+			continue
+		}
+		if maxKeys > 0 && listed == maxKeys {
+			break
+		}
+		listed++
+		if err := out.write(objectEntry(obj)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// objectEntry returns the listing of an object
+func objectEntry(obj *s3.Object) *listEntry {
+	return &listEntry{
+		Key:          aws.StringValue(obj.Key),
+		Size:         aws.Int64Value(obj.Size),
+		LastModified: formatTime(obj.LastModified),
+		ETag:         aws.StringValue(obj.ETag),
+		StorageClass: aws.StringValue(obj.StorageClass),
+	}
+}
+
+// lsVersions lists every version of every object under the
+// prefix, including delete markers.
+func lsVersions(s3Client *s3.S3, bucket string, prefix string, out listWriter) error {
+	var writeErr error
+	if err := s3Client.ListObjectVersionsPages(
+		&s3.ListObjectVersionsInput{
+			Bucket:       aws.String(bucket),
+			Prefix:       aws.String(prefix),
+			RequestPayer: optionalString(requestPayer),
+		},
+		func(page *s3.ListObjectVersionsOutput, lastPage bool) bool {
+			for _, v := range page.Versions {
+				if !isNewer(v.LastModified) {
+					continue
+				}
+				if writeErr = out.write(&listEntry{
+					Key:          aws.StringValue(v.Key),
+					Size:         aws.Int64Value(v.Size),
+					LastModified: formatTime(v.LastModified),
+					ETag:         aws.StringValue(v.ETag),
+					StorageClass: aws.StringValue(v.StorageClass),
+					VersionID:    aws.StringValue(v.VersionId),
+					IsLatest:     aws.BoolValue(v.IsLatest),
+				}); writeErr != nil {
+					return false
+				}
+			}
+			for _, m := range page.DeleteMarkers {
+				if !isNewer(m.LastModified) {
+					continue
+				}
+				if writeErr = out.write(&listEntry{
+					Key:          aws.StringValue(m.Key),
+					LastModified: formatTime(m.LastModified),
+					VersionID:    aws.StringValue(m.VersionId),
+					IsLatest:     aws.BoolValue(m.IsLatest),
+					DeleteMarker: true,
+				}); writeErr != nil {
+					return false
+				}
+			}
+			return true
+		},
+	); err != nil {
+		return fmt.Errorf("failed to list versions of 's3://%s/%s': %w", bucket, prefix, err)
+	}
+	return writeErr
+}
+
+// listParallelism is how many prefixes are listed concurrently
+var listParallelism int
+
+// listKeys returns the key of every object under the prefix
+func listKeys(s3Client *s3.S3, bucket string, prefix string) ([]string, error) {
+	objects, err := listObjects(s3Client, bucket, prefix)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, len(objects))
+	for i, obj := range objects {
+		keys[i] = aws.StringValue(obj.Key)
+	}
+	return keys, nil
+}
+
+// listObjects returns every object under the prefix in key order.
+// S3 can only page through a listing sequentially, so with
+// -list-parallelism the prefix is split into the "directories"
+// beneath it, which are then listed concurrently. This only
+// speeds things up if the keys are spread across directories.
+func listObjects(s3Client *s3.S3, bucket string, prefix string) ([]*s3.Object, error) {
+	if listParallelism <= 1 {
+		objects, _, err := listPrefix(s3Client, bucket, prefix, "")
+		return objects, err
+	}
+	// A delimited listing returns the objects directly beneath
+	// the prefix along with the prefixes of the directories.
+	objects, prefixes, err := listPrefix(s3Client, bucket, prefix, "/")
+	if err != nil {
+		return nil, err
+	}
+
+	type result struct {
+		objects []*s3.Object
+		err     error
+	}
+	pool := newJobPool(listParallelism, func(payload interface{}) interface{} {
+		objects, _, err := listPrefix(s3Client, bucket, payload.(string), "")
+		return result{objects, err}
+	})
+	defer pool.Close()
+	results := make([]result, len(prefixes))
+	var wg sync.WaitGroup
+	for i, p := range prefixes {
+		if singleThreaded {
+			results[i] = pool.Process(p).(result)
+			continue
+		}
+		wg.Add(1)
+		go func(i int, p string) {
+			defer wg.Done()
+			results[i] = pool.Process(p).(result)
+		}(i, p)
+	}
+	wg.Wait()
+	for _, r := range results {
+		if r.err != nil {
+			return nil, r.err
+		}
+		objects = append(objects, r.objects...)
+	}
+	// The prefixes don't overlap, so merging only takes sorting
+	sort.Slice(objects, func(i, j int) bool {
+		return aws.StringValue(objects[i].Key) < aws.StringValue(objects[j].Key)
+	})
+	return objects, nil
+}
+
+// listPrefix lists the objects under a prefix, and with a
+// delimiter the common prefixes of the keys beneath it.
+func listPrefix(
+	s3Client *s3.S3,
+	bucket string,
+	prefix string,
+	delimiter string,
+) ([]*s3.Object, []string, error) {
+	var objects []*s3.Object
+	var prefixes []string
+	if err := s3Client.ListObjectsV2Pages(
+		&s3.ListObjectsV2Input{
+			Bucket:       aws.String(bucket),
+			Prefix:       aws.String(prefix),
+			Delimiter:    optionalString(delimiter),
+			RequestPayer: optionalString(requestPayer),
+		},
+		func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+			objects = append(objects, page.Contents...)
+			for _, p := range page.CommonPrefixes {
+				prefixes = append(prefixes, aws.StringValue(p.Prefix))
+			}
+			return true
+		},
+	); err != nil {
+		return nil, nil, fmt.Errorf("failed to list 's3://%s/%s': %w", bucket, prefix, err)
+	}
+	return objects, prefixes, nil
+}
+
+// formatTime formats an optional timestamp as RFC3339 in UTC
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+func TestLsVersions(t *testing.T) {
+	s := newFakeS3(t, "b")
+	s.put("b", "a.txt", "hello")
+	s.put("b", "b.txt", "hi")
+	out, err := run(t, "ls", "-versions", "s3://b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("ls printed %q", out)
+	}
+	for i, want := range []string{"5  v1  a.txt  (latest)", "2  v1  b.txt  (latest)"} {
+		if !strings.Contains(lines[i], want) {
+			t.Errorf("line %d is %q, want it to contain %q", i, lines[i], want)
+		}
+	}
+}
+
+func TestLsOutputFormats(t *testing.T) {
+	s := newFakeS3(t, "b")
+	s.put("b", "a.txt", "hello")
+	modified := formatTime(&s.object("b", "a.txt").modified)
+	etag := s.object("b", "a.txt").etag
+
+	out, err := run(t, "ls", "-output-format", "json", "s3://b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entries []listEntry
+	if err := json.Unmarshal([]byte(out), &entries); err != nil {
+		t.Fatalf("invalid JSON %q: %v", out, err)
+	}
+	want := listEntry{Key: "a.txt", Size: 5, LastModified: modified, ETag: etag, StorageClass: "STANDARD"}
+	if len(entries) != 1 || entries[0] != want {
+		t.Errorf("ls printed %+v, want %+v", entries, want)
+	}
+
+	out, err = run(t, "ls", "-output-format", "csv", "s3://b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(strings.NewReader(out)).ReadAll()
+	if err != nil {
+		t.Fatalf("invalid CSV %q: %v", out, err)
+	}
+	if len(rows) != 2 || strings.Join(rows[0], ",") != "key,size,last_modified,etag,storage_class" ||
+		strings.Join(rows[1], ",") != "a.txt,5,"+modified+","+etag+",STANDARD" {
+		t.Errorf("ls printed %q", rows)
+	}
+
+	if out, err = run(t, "ls", "s3://b"); err != nil || out != modified+"  5  a.txt  \n" {
+		t.Errorf("ls printed %q, %v", out, err)
+	}
+	if _, err := run(t, "ls", "-output-format", "yaml", "s3://b"); err == nil {
+		t.Error("expected an unknown format to be refused")
+	}
+}
+
+func TestLsEmptyJSON(t *testing.T) {
+	newFakeS3(t, "b")
+	if out, err := run(t, "ls", "-output-format", "json", "s3://b"); err != nil || out != "[]\n" {
+		t.Errorf("ls printed %q, %v", out, err)
+	}
+}
+
+func TestLsStartAfterMaxKeys(t *testing.T) {
+	s := newFakeS3(t, "b")
+	for i := 0; i < 7; i++ {
+		s.put("b", fmt.Sprintf("k%d", i), "")
+	}
+	for _, tt := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"-start-after", "k1", "-max-keys", "2"}, "k2 k3"},
+		{[]string{"-max-keys", "5"}, "k0 k1 k2 k3 k4"},
+		{[]string{"-start-after", "k4"}, "k5 k6"},
+	} {
+		args := append(append([]string{"ls", "-output-format", "json"}, tt.args...), "s3://b")
+		out, err := run(t, args...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var entries []listEntry
+		if err := json.Unmarshal([]byte(out), &entries); err != nil {
+			t.Fatalf("%v printed %q: %v", tt.args, out, err)
+		}
+		var keys []string
+		for _, e := range entries {
+			keys = append(keys, e.Key)
+		}
+		if got := strings.Join(keys, " "); got != tt.want {
+			t.Errorf("%v listed %s, want %s", tt.args, got, tt.want)
+		}
+	}
+	lists := s.received(http.MethodGet, "list-type")
+	if first := lists[0].query; first.Get("start-after") != "k1" || first.Get("max-keys") != "2" {
+		t.Errorf("listed with %v, want start-after and max-keys forwarded", first)
+	}
+}
+
+func TestLsListParallelism(t *testing.T) {
+	s := newFakeS3(t, "b")
+	var want []string
+	for _, key := range []string{"top.txt", "zz.txt"} {
+		s.put("b", key, "")
+		want = append(want, key)
+	}
+	for d := 0; d < 4; d++ {
+		for i := 0; i < 4; i++ {
+			key := fmt.Sprintf("d%d/sub/f%d", d, i)
+			s.put("b", key, "")
+			want = append(want, key)
+		}
+	}
+	sort.Strings(want)
+	var inFlight, maxInFlight int32
+	s.intercept = func(w http.ResponseWriter, r *http.Request) bool {
+		if r.URL.Query().Get("list-type") != "" && r.URL.Query().Get("delimiter") == "" {
+			n := atomic.AddInt32(&inFlight, 1)
+			defer atomic.AddInt32(&inFlight, -1)
+			for {
+				max := atomic.LoadInt32(&maxInFlight)
+				if n <= max || atomic.CompareAndSwapInt32(&maxInFlight, max, n) {
+					break
+				}
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+		return false
+	}
+
+	list := func(args ...string) []string {
+		args = append(append([]string{"ls", "-output-format", "json"}, args...), "s3://b")
+		out, err := run(t, args...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var entries []listEntry
+		if err := json.Unmarshal([]byte(out), &entries); err != nil {
+			t.Fatalf("%v printed %q: %v", args, out, err)
+		}
+		var keys []string
+		for _, e := range entries {
+			keys = append(keys, e.Key)
+		}
+		return keys
+	}
+	if got := list("-list-parallelism", "4"); strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("listed %v, want %v", got, want)
+	}
+	if maxInFlight < 2 {
+		t.Errorf("listed at most %d prefixes at once", maxInFlight)
+	}
+	// Each prefix takes two pages
+	var prefixes []string
+	for _, r := range s.received(http.MethodGet, "list-type") {
+		if r.query.Get("delimiter") == "" && r.query.Get("continuation-token") == "" {
+			prefixes = append(prefixes, r.query.Get("prefix"))
+		}
+	}
+	sort.Strings(prefixes)
+	if got := strings.Join(prefixes, " "); got != "d0/ d1/ d2/ d3/" {
+		t.Errorf("listed the prefixes %s", got)
+	}
+	if got := list("-list-parallelism", "4", "-start-after", "d1/sub/f3", "-max-keys", "2"); strings.Join(got, " ") != "d2/sub/f0 d2/sub/f1" {
+		t.Errorf("-start-after and -max-keys listed %v", got)
+	}
+}
+
+func TestParseNewerThan(t *testing.T) {
+	defer func() { newerThan, newerThanTime = "", time.Time{} }()
+	for _, tt := range []struct {
+		flag string
+		want time.Time
+		ok   bool
+	}{
+		{"", time.Time{}, true},
+		{"2024-01-02T15:04:05Z", time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC), true},
+		{"yesterday", time.Time{}, false},
+	} {
+		newerThan, newerThanTime = tt.flag, time.Time{}
+		err := parseNewerThan()
+		if tt.ok != (err == nil) || !newerThanTime.Equal(tt.want) {
+			t.Errorf("-newer-than %q gave %s, %v", tt.flag, newerThanTime, err)
+		}
+	}
+	newerThan = "24h"
+	if err := parseNewerThan(); err != nil {
+		t.Fatal(err)
+	}
+	if ago := time.Since(newerThanTime); ago < 24*time.Hour || ago > 25*time.Hour {
+		t.Errorf("-newer-than 24h is %s ago", ago)
+	}
+	if isNewer(aws.Time(time.Now().Add(-48*time.Hour))) || !isNewer(aws.Time(time.Now())) {
+		t.Error("isNewer disagrees with -newer-than 24h")
+	}
+}
+
+func TestNewerThan(t *testing.T) {
+	s := newFakeS3(t, "b")
+	for key, age := range map[string]time.Duration{"old.txt": 72 * time.Hour, "new.txt": time.Hour, "dir/new.txt": 0} {
+		s.put("b", key, key)
+		s.object("b", key).modified = time.Now().UTC().Add(-age)
+	}
+	out, err := run(t, "ls", "-newer-than", "48h", "s3://b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "new.txt") || !strings.Contains(out, "dir/new.txt") || strings.Contains(out, "old.txt") {
+		t.Errorf("listed %q", out)
+	}
+
+	dest := t.TempDir()
+	if _, err := run(t, "-newer-than", "48h", "s3://b/*", dest); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dest, "old.txt")); !os.IsNotExist(err) {
+		t.Errorf("old.txt was downloaded: %v", err)
+	}
+	if got := readFile(t, filepath.Join(dest, "new.txt")); got != "new.txt" {
+		t.Errorf("new.txt holds %q", got)
+	}
+}
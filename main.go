@@ -1,42 +1,69 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"os"
-	"path/filepath"
 	"strings"
+	"time"
 
-	"github.com/Jeffail/tunny"
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/credentials"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 )
 
-// e.g. "us-east-1"
-var region string
-
 var parallelism int
 
+// perFileTimeout bounds the duration of each individual transfer
+// so a single hung file doesn't stall the rest. Zero means no limit.
+var perFileTimeout time.Duration
+
+// jobContext returns the context a single transfer runs in.
+func jobContext() (context.Context, context.CancelFunc) {
+	if perFileTimeout > 0 {
+		return context.WithTimeout(context.Background(), perFileTimeout)
+	}
+	return context.WithCancel(context.Background())
+}
+
+// optionalString returns nil for empty strings so that unset
+// flags are omitted from requests rather than sent empty.
+func optionalString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return aws.String(s)
+}
+
 func usage() {
-	fmt.Print("usage: s3util <input> <output>\n")
+	for i, cmd := range commands {
+		prefix := "       "
+		name := cmd.name
+		if i == 0 {
+			prefix = "usage: "
+			name = "[" + name + "]"
+		}
+		fmt.Println(strings.TrimRight(prefix+"s3util "+name+" "+cmd.synopsis, " "))
+	}
 	fmt.Print("One of the paths must start with s3://\n")
+	fmt.Print("Global flags may be given before the command, while the flags of a\n")
+	fmt.Print("command may be given before or after its arguments. Arguments after --\n")
+	fmt.Print("are never interpreted as flags, e.g. for paths beginning with a dash.\n")
+	fmt.Print("Run s3util <command> -h to list the flags of a command.\n")
 	fmt.Print("Example copy to s3:\n")
 	fmt.Print("    foo.txt s3://mybucket/foo.txt\n")
 	fmt.Print("Example copy from s3:\n")
 	fmt.Print("    s3util s3://mybucket/foo.txt foo.txt\n")
+	fmt.Print("Example copy between buckets:\n")
+	fmt.Print("    s3util s3://mybucket/foo.txt s3://otherbucket/foo.txt\n")
+	fmt.Print("Exits with 0 on success, 1 on failure, 2 if only some files failed\n")
+	fmt.Print("and 3 on invalid usage. With -only-errors-exit-code, failures due to\n")
+	fmt.Print("AccessDenied, NoSuchBucket, NoSuchKey and SlowDown exit with 10 to 13.\n")
 	fmt.Print("This app uses the Go AWS SDK library (github.com/aws/aws-sdk-go)\n")
 	fmt.Print("Visit github.com/thavlik/s3util for the source code and Dockerfile.\n")
-}
-
-func createSession() *session.Session {
-	sess := session.Must(session.NewSession())
-	sess.Config.Region = aws.String(region)
-	sess.Config.Credentials = credentials.NewEnvCredentials()
-	sess.Config.Endpoint = aws.String("nyc3.digitaloceanspaces.com")
-	return sess
+	fmt.Print("Flags of cp:\n")
+	commands[0].fs.PrintDefaults()
 }
 
 // splitNameParts splits an s3 path into its parts
@@ -44,9 +71,13 @@ func createSession() *session.Session {
 // s3://mybucket/mykey	=> "mybucket", "mykey", nil
 // s3://mybucket/mykey	=> "mybucket", "mykey", nil
 // s3://mybucket/		=> "mybucket", "", nil (trailing / is optional)
+// s3://arn:aws:s3:us-west-2:123456789012:accesspoint/myap/mykey	=> the ARN, "mykey", nil
 func splitNameParts(path string) (string, string, error) {
 	// get the path in `bucket/key` format
 	withoutProtocol := path[len("s3://"):]
+	if strings.HasPrefix(withoutProtocol, "arn:") {
+		return splitAccessPointARN(withoutProtocol)
+	}
 	firstSlash := strings.Index(withoutProtocol, "/")
 	if firstSlash == -1 {
 		// Interpret everything after the protocol as
@@ -65,246 +96,244 @@ func splitNameParts(path string) (string, string, error) {
 	return bucket, key, nil
 }
 
-func uploadSingleFile(
-	uploader *s3manager.Uploader,
-	bucket *string, // sent in as string pointer for effiency's sake
-	key *string,
-	sourcePath string,
-) error {
-	f, err := os.Open(sourcePath)
-	if err != nil {
-		return fmt.Errorf("failed to read source file '%s': %v", sourcePath, err)
-	}
-	defer f.Close()
-	if _, err := uploader.Upload(&s3manager.UploadInput{
-		Bucket: bucket,
-		Key:    key,
-		Body:   f,
-	}); err != nil {
-		return fmt.Errorf("failed to upload '%s': %v", sourcePath, err)
+// parseArgs parses the flags in arguments and returns the
+// positional arguments. Unlike flag.Parse, flags may also follow
+// the positional arguments, e.g.
+//
+//	s3util foo.txt s3://mybucket -region eu-west-1
+//
+// and everything after a "--" is taken as positional, so that
+// paths beginning with a dash can be given:
+//
+//	s3util -- -foo.txt s3://mybucket
+func parseArgs(fs *flag.FlagSet, arguments []string) ([]string, error) {
+	var positional []string
+	for len(arguments) > 0 {
+		if err := fs.Parse(arguments); err != nil {
+			return nil, err
+		}
+		rest := fs.Args()
+		// Parse stops either at the first positional argument
+		// or right after consuming a "--".
+		consumed := len(arguments) - len(rest)
+		if consumed > 0 && arguments[consumed-1] == "--" {
+			return append(positional, rest...), nil
+		}
+		if len(rest) == 0 {
+			break
+		}
+		positional = append(positional, rest[0])
+		arguments = rest[1:]
 	}
-	return fmt.Errorf("failed to upload '%s': %v", sourcePath, err)
+	return positional, nil
 }
 
-func upload(source string, dest string) error {
-	bucketName, key, err := splitNameParts(dest)
-	if err != nil {
-		return fmt.Errorf("failed to parse s3 output name parts: %v", err)
+// prepareTransfers checks the flags of the commands that
+// transfer files and loads what they need.
+func prepareTransfers() error {
+	if partSize < s3manager.MinUploadPartSize {
+		return usageErrorf("-part-size must be at least %d", s3manager.MinUploadPartSize)
 	}
-	bucket := aws.String(bucketName)
-
-	uploader := s3manager.NewUploader(createSession())
-
-	info, err := os.Stat(source)
-	if err != nil {
-		return fmt.Errorf("failed to stat input path '%s': %v", source, err)
+	if partConcurrency < 1 {
+		return usageErrorf("-part-concurrency must be at least 1")
 	}
-
-	sourcePath, err := filepath.Abs(source)
-	if err != nil {
-		return fmt.Errorf("failed to get absolute path of source: %v", err)
+	switch onCollision {
+	case "", collisionError, collisionRename, collisionSkip, collisionOverwrite:
+	default:
+		return usageErrorf("-on-collision must be error, rename, skip or overwrite")
 	}
-
-	keyPrefix := ""
-
-	type uploadJob struct {
-		inputFullPath string
-		outputKey     string
-		done          chan error
+	if maxFiles < 0 {
+		return usageErrorf("-max-files must not be negative")
 	}
-	var jobs []uploadJob
-
-	if info.IsDir() {
-		sourcePathLen := len(sourcePath)
-
-		// Specifying a target of s3://mybucket/myprefix and an input
-		// path that is a folder will result in some input file `foo.txt`
-		// being uploaded to s3://mybucket/myprefix/foo.txt
-		// Example (upload current directory, prefix all keys with "images")
-		//
-		//     s3util . s3://mybucket/images
-		//
-		// Result: s3://mybucket/images/foo.png
-		//         s3://mybucket/images/bar.png
-		//         s3://mybucket/images/subdirectory/baz.jpg
-		//         ...
-		keyPrefix = key
-
-		if err := filepath.Walk(
-			sourcePath,
-			func(path string, info os.FileInfo, err error) error {
-				if err != nil {
-					return err
-				}
-
-				fullPath, err := filepath.Abs(path)
-				if err != nil {
-					return fmt.Errorf("failed to get full path of '%s': %v", info.Name(), err)
-				}
-
-				jobs = append(jobs, uploadJob{
-					inputFullPath: fullPath,
-					outputKey:     fullPath[sourcePathLen:],
-					done:          make(chan error, 1),
-				})
-
-				return nil
-			},
-		); err != nil {
-			return fmt.Errorf("failed to walk source directory: %v", err)
+	if contentLength < -1 {
+		return usageErrorf("-content-length must not be negative")
+	}
+	if splitSize < 0 {
+		return usageErrorf("-split-size must not be negative")
+	}
+	if splitSize > 0 && (expandArchive || verifyUploads || resumableUpload) {
+		return usageErrorf("-split-size cannot be combined with -expand-archive, -verify or -resumable-upload")
+	}
+	if bufferSize < 1 {
+		return usageErrorf("-buffer-size must be at least 1")
+	}
+	if maxRetries < 0 {
+		return usageErrorf("-max-retries must not be negative")
+	}
+	if mismatchRetries < 0 {
+		return usageErrorf("-retry-on-checksum-mismatch must not be negative")
+	}
+	if mismatchRetries > 0 && !verifyUploads {
+		return usageErrorf("-retry-on-checksum-mismatch requires -verify")
+	}
+	if retryBaseDelay <= 0 || retryMaxDelay < retryBaseDelay {
+		return usageErrorf("-retry-base-delay must be positive and no more than -retry-max-delay")
+	}
+	if maxBandwidth < 0 || bwLimitPerFile < 0 {
+		return usageErrorf("-max-bandwidth and -bwlimit-per-file must not be negative")
+	}
+	globalLimiter = newRateLimiter(maxBandwidth)
+	if singleThreaded {
+		// The uploader and downloader transfer parts in
+		// goroutines of their own otherwise
+		partConcurrency = 1
+	}
+	if err := parseNewerThan(); err != nil {
+		return err
+	}
+	if err := checkOverwritePolicy(); err != nil {
+		return err
+	}
+	if err := checkWebsiteRedirect(); err != nil {
+		return err
+	}
+	if err := checkTags(); err != nil {
+		return err
+	}
+	var err error
+	if objectLock, err = parseObjectLock(); err != nil {
+		return &usageError{err}
+	}
+	if serverSideEncryption, err = parseSSE(); err != nil {
+		return &usageError{err}
+	}
+	if copyCondition, err = parseCopyConditions(); err != nil {
+		return &usageError{err}
+	}
+	if checksumMode != "" && checksumMode != s3.ChecksumModeEnabled {
+		return usageErrorf("-checksum-mode must be '%s'", s3.ChecksumModeEnabled)
+	}
+	if encryptClientSide {
+		if checksumMode != "" {
+			// S3 checksums the ciphertext, not the decrypted file.
+			return usageErrorf("-checksum-mode cannot be combined with -encrypt-client-side")
 		}
-	} else {
-		// Input is a specific file. Output path will either
-		// be just an s3 bucket - in which case we'll use
-		// the file name as the key - or it will be a key
-		// that we will use verbatim.
-		if key == "" {
-			// No key was specified. Use the file name as the key.
-			key = info.Name()
+		if verifyUploads {
+			// The objects hold the ciphertext
+			return usageErrorf("-verify cannot be combined with -encrypt-client-side")
 		}
-		jobs = []uploadJob{
-			uploadJob{
-				inputFullPath: sourcePath,
-				outputKey:     key,
-				done:          make(chan error, 1),
-			},
+		if resumableUpload {
+			// The parts are encrypted as one stream, so they
+			// can't be resumed individually.
+			return usageErrorf("-resumable-upload cannot be combined with -encrypt-client-side")
 		}
-	}
-
-	pool := tunny.NewFunc(parallelism, func(payload interface{}) interface{} {
-		j := payload.(*uploadJob)
-		return uploadSingleFile(
-			uploader,
-			bucket,
-			aws.String(fmt.Sprintf("%s/%s", keyPrefix, j.outputKey)),
-			j.inputFullPath)
-	})
-
-	for i := range jobs {
-		go func(job *uploadJob) {
-			job.done <- func() error {
-				if err, ok := pool.Process(job).(error); ok && err != nil {
-					return err
-				}
-				return nil
-			}()
-		}(&jobs[i])
-	}
-
-	for i := range jobs {
-		if err := <-jobs[i].done; err != nil {
+		if splitSize > 0 || reassemble {
+			// Each piece would be encrypted as a stream of its
+			// own
+			return usageErrorf("-split-size and -reassemble cannot be combined with -encrypt-client-side")
+		}
+		if sendContentMD5 {
+			// The checksum would have to cover the ciphertext,
+			// which isn't known before the upload.
+			return usageErrorf("-content-md5 cannot be combined with -encrypt-client-side")
+		}
+		if clientSideKey, err = loadClientSideKey(keyFile); err != nil {
+			return err
 		}
 	}
-
 	return nil
 }
 
-func download(source string, dest string) error {
-	bucket, key, err := splitNameParts(source)
-	if err != nil {
-		return fmt.Errorf("failed to parse source: %v", err)
+// cp uploads, downloads or copies depending on which of the
+// paths are in s3.
+func cp(inPath string, outPath string) error {
+	if err := prepareTransfers(); err != nil {
+		return err
 	}
-	sess := createSession()
-	s3Client := s3.New(sess)
-	sourceLen := len(source)
-
-	info, err := os.Stat(dest)
+	if strings.HasPrefix(inPath, "s3://") && strings.HasPrefix(outPath, "s3://") {
+		return copyObject(inPath, outPath)
+	} else if strings.HasPrefix(inPath, "s3://") {
+		return download(inPath, outPath)
+	} else if strings.HasPrefix(outPath, "s3://") {
+		return upload(inPath, outPath)
+	}
+	return usageErrorf("one of the paths must be an S3 URI (see usage)")
+}
 
+func entry(cmd *command, args []string) error {
+	if parallelism < 1 {
+		return usageErrorf("-parallelism must be at least 1")
+	}
+	if maxConnsPerHost < 0 {
+		return usageErrorf("-max-conns-per-host must not be negative")
+	}
+	if requestPayer != "" && requestPayer != s3.RequestPayerRequester {
+		return usageErrorf("-request-payer must be '%s'", s3.RequestPayerRequester)
+	}
+	if err := checkProvider(); err != nil {
+		return &usageError{err}
+	}
+	if err := checkExpectedBucketOwner(); err != nil {
+		return &usageError{err}
+	}
+	if err := checkSignatureVersion(); err != nil {
+		return &usageError{err}
+	}
+	if err := loadCABundle(); err != nil {
+		return &usageError{err}
+	}
+	settings, err := parseSSECustomer()
 	if err != nil {
-		if os.IsNotExist(err) {
-		}
-		return fmt.Errorf("failed to stat destination '%s': %v", dest, err)
+		return &usageError{err}
 	}
-
-	if info.IsDir() {
-		// Output might be key name
+	sseCustomer = settings
+	if showVersion {
+		printVersion()
+		return nil
 	}
-
-	type downloadJob struct {
-		key     string
-		outPath string
-		done    chan error
+	if len(args) != cmd.nargs {
+		cmd.usage()
+		os.Exit(exitUsage)
 	}
-
-	var jobs []downloadJob
-
-	if source[sourceLen-1] == '*' {
-		// Wildcard input: download all keys with this prefix
-		out, err := s3Client.ListObjects(&s3.ListObjectsInput{
-			Bucket: aws.String(bucket),
-			Prefix: aws.String(source[:sourceLen]),
-		})
-		if err != nil {
-			return fmt.Errorf("failed to list s3: %v", err)
+	if cmd.name != "version" {
+		// Copies between buckets only read the environment for
+		// a side without a profile
+		if err := checkCredentials(srcProfile); err != nil {
+			return err
 		}
-		jobs = make([]downloadJob, len(out.Contents))
-		for i, obj := range out.Contents {
-			//obj.Key()
-			jobs[i] = downloadJob{
-				key:     *obj.Key,
-				outPath: "",
+		if dstProfile != srcProfile {
+			if err := checkCredentials(dstProfile); err != nil {
+				return err
 			}
 		}
-	} else {
-		jobs = []downloadJob{
-			downloadJob{
-				key:     key,
-				outPath: dest,
-			},
-		}
 	}
-
-	pool := tunny.NewFunc(parallelism, func(payload interface{}) interface{} {
-		j := payload.(*downloadJob)
-		return uploadSingleFile(
-			uploader,
-			bucket,
-			aws.String(fmt.Sprintf("%s/%s", keyPrefix, j.outputKey)),
-			j.inputFullPath,
-		)
-	})
-
-	for i := range jobs {
-		go func(job *downloadJob) {
-			job.done <- func() error {
-				if err, ok := pool.Process(job).(error); ok && err != nil {
-					return err
-				}
-				return nil
-			}()
-		}(&jobs[i])
+	if autoConcurrency && !singleThreaded {
+		defer startConcurrencyTuner(jobThrottle)()
 	}
-
-	for i := range jobs {
-		if err := <-jobs[i].done; err != nil {
+	if metricsAddr != "" {
+		stopMetrics, err := startMetricsServer()
+		if err != nil {
+			return err
 		}
+		defer stopMetrics()
 	}
-
-	return nil
-}
-
-func entry() error {
-	args := flag.Args()
-	if len(args) != 2 {
-		usage()
-		os.Exit(1)
+	// Commands take precedence over local files of the same
+	// name, which can still be uploaded as e.g. ./ls
+	if summaryOut == "" {
+		return cmd.run(args)
 	}
-
-	inPath := args[0]
-	outPath := args[1]
-
-	if strings.HasPrefix(inPath, "s3://") {
-		return download(inPath, outPath)
-	} else if strings.HasPrefix(outPath, "s3://") {
-		return upload(inPath, outPath)
+	started := time.Now()
+	err = cmd.run(args)
+	if summaryErr := writeSummary(cmd.name, started, err); summaryErr != nil {
+		if err == nil {
+			return summaryErr
+		}
+		logError(summaryErr)
 	}
-
-	return fmt.Errorf("one of the paths must be an S3 URI (see usage)")
+	return err
 }
 
 func main() {
-	if err := entry(); err != nil {
-		fmt.Printf(err.Error())
-		os.Exit(1)
+	cmd, args, err := parseCommandLine(os.Args[1:])
+	if err == flag.ErrHelp {
+		os.Exit(exitOK)
+	} else if err != nil {
+		// The error has already been printed along with the usage
+		os.Exit(exitUsage)
+	}
+	jobThrottle = newThrottle(parallelism)
+	if err := entry(cmd, args); err != nil {
+		logError(err)
+		os.Exit(exitCode(err))
 	}
 }
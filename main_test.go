@@ -0,0 +1,180 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// run runs s3util with the given arguments the way main does,
+// returning what it printed to stdout and the error it would exit
+// with. The state left behind by earlier runs is reset first.
+func run(t *testing.T, args ...string) (string, error) {
+	t.Helper()
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDTEST")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	globalFlags = flag.NewFlagSet("s3util", flag.ContinueOnError)
+	passedFlags = make(map[string]bool)
+	customHeaders = nil
+	uploadExts = nil
+	copyTags = nil
+	caBundlePEM = nil
+	clientSideKey = nil
+	summaryProgresses = nil
+	summaryErrors = nil
+	retryCount = 0
+	lastAWSErrorExitCode = 0
+	oldSleep := sleep
+	sleep = func(time.Duration) {}
+	defer func() { sleep = oldSleep }()
+
+	if isTerminal(os.Stdin) {
+		// Nothing may wait for an answer to a prompt
+		var stdout string
+		var err error
+		withStdin(t, "", func() { stdout, err = run(t, args...) })
+		return stdout, err
+	}
+
+	var err error
+	stdout := captureStdout(t, func() {
+		var cmd *command
+		if cmd, args, err = parseCommandLine(args); err != nil {
+			return
+		}
+		if len(args) != cmd.nargs {
+			t.Fatalf("%s takes %d arguments, got %q", cmd.name, cmd.nargs, args)
+		}
+		jobThrottle = newThrottle(parallelism)
+		err = entry(cmd, args)
+	})
+	return stdout, err
+}
+
+// captureStdout returns what f prints to stdout
+func captureStdout(t *testing.T, f func()) string {
+	t.Helper()
+	out, err := os.CreateTemp(t.TempDir(), "stdout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	defer func(old *os.File) { os.Stdout = old }(os.Stdout)
+	os.Stdout = out
+	f()
+	if _, err := out.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// captureStderr returns what f prints to stderr
+func captureStderr(t *testing.T, f func()) string {
+	t.Helper()
+	defer func(old *os.File) { os.Stderr = old }(os.Stderr)
+	return captureStdout(t, func() {
+		os.Stderr = os.Stdout
+		f()
+	})
+}
+
+// withStdin runs f with the given text on stdin
+func withStdin(t *testing.T, text string, f func()) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "stdin")
+	if err := os.WriteFile(path, []byte(text), 0644); err != nil {
+		t.Fatal(err)
+	}
+	in, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer in.Close()
+	defer func(old *os.File) { os.Stdin = old }(os.Stdin)
+	os.Stdin = in
+	f()
+}
+
+// writeFiles creates the files under dir, given by their slash
+// separated paths, with the given content
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// readFile returns the content of a file, failing the test if it
+// can't be read
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestParseArgs(t *testing.T) {
+	for _, tt := range []struct {
+		args       []string
+		positional []string
+		verbose    bool
+	}{
+		{[]string{"a", "b"}, []string{"a", "b"}, false},
+		{[]string{"-v", "a", "b"}, []string{"a", "b"}, true},
+		{[]string{"a", "-v", "b"}, []string{"a", "b"}, true},
+		{[]string{"a", "b", "-v"}, []string{"a", "b"}, true},
+		{[]string{"--", "-v", "b"}, []string{"-v", "b"}, false},
+		{[]string{"a", "--", "-v"}, []string{"a", "-v"}, false},
+		{[]string{"-v", "--", "--", "b"}, []string{"--", "b"}, true},
+		{nil, nil, false},
+	} {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		verbose := fs.Bool("v", false, "")
+		positional, err := parseArgs(fs, tt.args)
+		if err != nil {
+			t.Errorf("%q: %v", tt.args, err)
+			continue
+		}
+		if strings.Join(positional, " ") != strings.Join(tt.positional, " ") || *verbose != tt.verbose {
+			t.Errorf("%q: got %q and -v=%v, want %q and -v=%v", tt.args, positional, *verbose, tt.positional, tt.verbose)
+		}
+	}
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	if _, err := parseArgs(fs, []string{"a", "-unknown"}); err == nil {
+		t.Error("expected an unknown flag after the arguments to be refused")
+	}
+}
+
+func TestUploadDashedPath(t *testing.T) {
+	s := newFakeS3(t, "b")
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"-a.txt": "a"})
+	wd, _ := os.Getwd()
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	if _, err := run(t, "--", "-a.txt", "s3://b/"); err != nil {
+		t.Fatal(err)
+	}
+	if s.object("b", "-a.txt") == nil {
+		t.Error("-a.txt wasn't uploaded")
+	}
+}
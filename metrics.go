@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
+// metricsAddr is where the Prometheus metrics endpoint listens,
+// e.g. ":9090". Empty disables it.
+var metricsAddr string
+
+var (
+	metricsProgressMu sync.Mutex
+	metricsProgress   *progress
+
+	// number of requests the SDK has retried
+	retryCount int64
+)
+
+// setMetricsProgress makes the endpoint report on a transfer
+func setMetricsProgress(p *progress) {
+	metricsProgressMu.Lock()
+	defer metricsProgressMu.Unlock()
+	metricsProgress = p
+}
+
+// countRetries is a send handler counting every attempt of a
+// request other than the first
+func countRetries(r *request.Request) {
+	if r.RetryCount > 0 {
+		atomic.AddInt64(&retryCount, 1)
+	}
+}
+
+// startMetricsServer serves /metrics on -metrics-addr and returns
+// a function shutting the server down again.
+func startMetricsServer() (func(), error) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", serveMetrics)
+	srv := &http.Server{Addr: metricsAddr, Handler: mux}
+	errc := make(chan error, 1)
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			errc <- err
+		}
+	}()
+	// Surface errors such as the address being in use before
+	// starting the transfer rather than failing silently.
+	select {
+	case err := <-errc:
+		return nil, fmt.Errorf("failed to serve metrics on '%s': %w", metricsAddr, err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(ctx)
+	}, nil
+}
+
+// serveMetrics writes the metrics in the Prometheus text
+// exposition format
+func serveMetrics(w http.ResponseWriter, r *http.Request) {
+	var bytes, succeeded, failed int64
+	metricsProgressMu.Lock()
+	if p := metricsProgress; p != nil {
+		bytes = atomic.LoadInt64(&p.bytes)
+		failed = atomic.LoadInt64(&p.failedFiles)
+		succeeded = atomic.LoadInt64(&p.doneFiles) - failed
+	}
+	metricsProgressMu.Unlock()
+	inFlight := 0
+	if jobThrottle != nil {
+		inFlight = jobThrottle.running()
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	writeMetric(w, "s3util_bytes_transferred_total", "counter", "Bytes uploaded or downloaded.", bytes)
+	writeMetric(w, "s3util_files_succeeded_total", "counter", "Files transferred successfully.", succeeded)
+	writeMetric(w, "s3util_files_failed_total", "counter", "Files that failed to transfer.", failed)
+	writeMetric(w, "s3util_retries_total", "counter", "Requests retried by the SDK.", atomic.LoadInt64(&retryCount))
+	writeMetric(w, "s3util_jobs_in_flight", "gauge", "Jobs currently running.", int64(inFlight))
+}
+
+func writeMetric(w http.ResponseWriter, name string, kind string, help string, value int64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %d\n", name, help, name, kind, name, value)
+}
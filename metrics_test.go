@@ -0,0 +1,67 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestServeMetrics(t *testing.T) {
+	p := &progress{}
+	p.add(2048)
+	p.fileDone(nil)
+	p.fileDone(nil)
+	p.fileDone(errors.New("failed"))
+	setMetricsProgress(p)
+	defer setMetricsProgress(nil)
+	defer func(old *throttle) { jobThrottle = old }(jobThrottle)
+	jobThrottle = newThrottle(4)
+	jobThrottle.acquire()
+
+	rec := httptest.NewRecorder()
+	serveMetrics(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := rec.Body.String()
+	for _, want := range []string{
+		"# TYPE s3util_bytes_transferred_total counter\ns3util_bytes_transferred_total 2048\n",
+		"s3util_files_succeeded_total 2\n",
+		"s3util_files_failed_total 1\n",
+		"# TYPE s3util_jobs_in_flight gauge\ns3util_jobs_in_flight 1\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics are missing %q:\n%s", want, body)
+		}
+	}
+}
+
+func TestMetricsServer(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	defer func(old string) { metricsAddr = old }(metricsAddr)
+	metricsAddr = addr
+	if _, err := startMetricsServer(); err == nil {
+		t.Fatal("expected serving on an address in use to fail")
+	}
+	l.Close()
+
+	stop, err := startMetricsServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stop()
+	resp, err := http.Get("http://" + addr + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if !strings.Contains(string(body), "s3util_retries_total") {
+		t.Errorf("served %q", body)
+	}
+}
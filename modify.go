@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// e.g. "STANDARD_IA" or "GLACIER"
+var storageClass string
+
+// canned ACL, e.g. "private" or "public-read"
+var cannedACL string
+
+// modify changes the storage class and/or canned ACL of an
+// existing object in place, without transferring its data. The
+// storage class can only be changed by copying the object onto
+// itself, which resets its ACL, so unless a new ACL is given the
+// current one is read beforehand and put back afterwards.
+func modify(target string) error {
+	bucket, key, err := splitNameParts(target)
+	if err != nil {
+		return fmt.Errorf("failed to parse '%s': %w", target, err)
+	}
+	if key == "" {
+		return fmt.Errorf("'%s' does not specify a key", target)
+	}
+	if storageClass == "" && cannedACL == "" {
+		return fmt.Errorf("nothing to modify (specify -storage-class and/or -acl)")
+	}
+	s3Client := s3.New(createSession())
+
+	aclInput := &s3.PutObjectAclInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	}
+	if cannedACL != "" {
+		aclInput.ACL = aws.String(cannedACL)
+	} else {
+		current, err := s3Client.GetObjectAcl(&s3.GetObjectAclInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to get ACL of '%s': %w", target, err)
+		}
+		aclInput.AccessControlPolicy = &s3.AccessControlPolicy{
+			Owner:  current.Owner,
+			Grants: current.Grants,
+		}
+	}
+
+	if storageClass != "" {
+		if _, err := s3Client.CopyObject(&s3.CopyObjectInput{
+			Bucket:            aws.String(bucket),
+			Key:               aws.String(key),
+			CopySource:        aws.String(copySource(bucket, key)),
+			StorageClass:      aws.String(storageClass),
+			MetadataDirective: aws.String(s3.MetadataDirectiveCopy),
+		}); err != nil {
+			return fmt.Errorf("failed to change storage class of '%s': %w", target, err)
+		}
+	}
+
+	if _, err := s3Client.PutObjectAcl(aclInput); err != nil {
+		return fmt.Errorf("failed to set ACL of '%s': %w", target, err)
+	}
+	logSuccess("modify: %s", target)
+	return nil
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestModifyStorageClassKeepsACL(t *testing.T) {
+	s := newFakeS3(t, "b")
+	s.put("b", "a.txt", "hello", "Content-Type", "text/plain", "X-Amz-Meta-Owner", "alice")
+	acl := `<AccessControlPolicy><Owner><ID>` + fakeOwner + `</ID></Owner><AccessControlList><Grant><Grantee xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xsi:type="CanonicalUser"><ID>reader</ID></Grantee><Permission>READ</Permission></Grant></AccessControlList></AccessControlPolicy>`
+	s.object("b", "a.txt").acl = []byte(acl)
+	if _, err := run(t, "modify", "-storage-class", "STANDARD_IA", "s3://b/a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	obj := s.object("b", "a.txt")
+	if got := obj.header.Get("X-Amz-Storage-Class"); got != "STANDARD_IA" {
+		t.Errorf("storage class is %q", got)
+	}
+	if obj.header.Get("Content-Type") != "text/plain" || obj.header.Get("X-Amz-Meta-Owner") != "alice" {
+		t.Errorf("the object lost its metadata: %v", obj.header)
+	}
+	if !strings.Contains(string(obj.acl), "<ID>reader</ID>") {
+		t.Errorf("the object lost its ACL: %s", obj.acl)
+	}
+}
+
+func TestModifyACL(t *testing.T) {
+	s := newFakeS3(t, "b")
+	s.put("b", "a.txt", "hello")
+	if _, err := run(t, "modify", "-acl", "public-read", "s3://b/a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	obj := s.object("b", "a.txt")
+	if got := obj.header.Get("X-Amz-Acl"); got != "public-read" {
+		t.Errorf("ACL is %q", got)
+	}
+	if got := obj.header.Get("X-Amz-Storage-Class"); got != "" {
+		t.Errorf("storage class changed to %q", got)
+	}
+}
+
+func TestModifyNothing(t *testing.T) {
+	newFakeS3(t, "b")
+	if _, err := run(t, "modify", "s3://b/a.txt"); err == nil {
+		t.Error("expected an error without -storage-class or -acl")
+	}
+	if _, err := run(t, "modify", "-acl", "private", "s3://b/"); err == nil {
+		t.Error("expected an error without a key")
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// mv moves a single object, or every object under a source prefix
+// ending in a slash, e.g.
+//
+//	s3util mv s3://mybucket/old/ s3://mybucket/new/
+//
+// renames s3://mybucket/old/foo.txt to s3://mybucket/new/foo.txt.
+// S3 has no rename, so each object is copied server-side and the
+// original is only deleted once its copy succeeded.
+func mv(source string, dest string) error {
+	srcBucket, srcKey, err := splitNameParts(source)
+	if err != nil {
+		return fmt.Errorf("failed to parse source: %w", err)
+	}
+	dstBucket, dstKey, err := splitNameParts(dest)
+	if err != nil {
+		return fmt.Errorf("failed to parse destination: %w", err)
+	}
+	s3Client := s3.New(createSession())
+
+	type moveJob struct {
+		srcKey string
+		dstKey string
+	}
+	var jobs []moveJob
+	if srcKey == "" || strings.HasSuffix(srcKey, "/") {
+		if dstKey != "" && !strings.HasSuffix(dstKey, "/") {
+			dstKey += "/"
+		}
+		keys, err := listKeys(s3Client, srcBucket, srcKey)
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			jobs = append(jobs, moveJob{
+				srcKey: key,
+				dstKey: dstKey + strings.TrimPrefix(key, srcKey),
+			})
+		}
+		if len(jobs) > 0 {
+			if err := confirm("move %d objects from %s to %s", len(jobs), source, dest); err != nil {
+				return err
+			}
+		}
+	} else {
+		if dstKey == "" || strings.HasSuffix(dstKey, "/") {
+			dstKey += path.Base(srcKey)
+		}
+		jobs = []moveJob{{srcKey: srcKey, dstKey: dstKey}}
+	}
+	if srcBucket == dstBucket {
+		for _, job := range jobs {
+			if job.srcKey == job.dstKey {
+				return fmt.Errorf("cannot move 's3://%s/%s' onto itself", srcBucket, job.srcKey)
+			}
+		}
+	}
+
+	pool := newJobPool(parallelism, func(payload interface{}) interface{} {
+		j := payload.(*moveJob)
+		return moveObject(s3Client, srcBucket, j.srcKey, dstBucket, j.dstKey)
+	})
+	defer pool.Close()
+
+	var (
+		wg     sync.WaitGroup
+		errsMu sync.Mutex
+		errs   []error
+	)
+	for _, job := range jobs {
+		job := job
+		startJob(&wg, func() {
+			if err, ok := pool.Process(&job).(error); ok && err != nil {
+				errsMu.Lock()
+				errs = append(errs, err)
+				errsMu.Unlock()
+			}
+		})
+	}
+	wg.Wait()
+	for _, err := range errs {
+		logError(err)
+	}
+	if len(errs) > 0 {
+		return &batchError{failed: len(errs), total: len(jobs), what: "moves"}
+	}
+	return nil
+}
+
+// moveObject copies an object to its new key and then deletes it.
+// If the copy fails the original is left untouched.
+func moveObject(s3Client *s3.S3, srcBucket, srcKey, dstBucket, dstKey string) error {
+	ctx, cancel := jobContext()
+	defer cancel()
+	if _, err := s3Client.CopyObjectWithContext(ctx, &s3.CopyObjectInput{
+		Bucket:     aws.String(dstBucket),
+		Key:        aws.String(dstKey),
+		CopySource: aws.String(copySource(srcBucket, srcKey)),
+	}); err != nil {
+		return fmt.Errorf("failed to copy 's3://%s/%s' to 's3://%s/%s': %w", srcBucket, srcKey, dstBucket, dstKey, err)
+	}
+	if _, err := s3Client.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(srcBucket),
+		Key:    aws.String(srcKey),
+	}); err != nil {
+		return fmt.Errorf("copied 's3://%s/%s' but failed to delete it: %w", srcBucket, srcKey, err)
+	}
+	logSuccess("move: s3://%s/%s to s3://%s/%s", srcBucket, srcKey, dstBucket, dstKey)
+	return nil
+}
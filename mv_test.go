@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestMvPrefix(t *testing.T) {
+	s := newFakeS3(t, "b")
+	s.put("b", "old/a.txt", "a")
+	s.put("b", "old/sub/b.txt", "b")
+	s.put("b", "older.txt", "c")
+	if _, err := run(t, "mv", "s3://b/old/", "s3://b/new"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := strings.Join(s.keys("b"), " "), "new/a.txt new/sub/b.txt older.txt"; got != want {
+		t.Errorf("left %s, want %s", got, want)
+	}
+	if obj := s.object("b", "new/sub/b.txt"); obj == nil || string(obj.data) != "b" {
+		t.Errorf("new/sub/b.txt is %v", obj)
+	}
+	copies := s.received(http.MethodPut, "")
+	deletes := s.received(http.MethodDelete, "")
+	if len(copies) != 2 || len(deletes) != 2 {
+		t.Fatalf("made %d copies and %d deletions, want 2 of each", len(copies), len(deletes))
+	}
+	for _, c := range copies {
+		if source, _ := url.PathUnescape(c.header.Get("X-Amz-Copy-Source")); source != "b/old/"+strings.TrimPrefix(c.key, "new/") {
+			t.Errorf("copied %s to %s", source, c.key)
+		}
+	}
+}
+
+func TestMvSingleObject(t *testing.T) {
+	s := newFakeS3(t, "b", "c")
+	s.put("b", "dir/a.txt", "a")
+	if _, err := run(t, "mv", "s3://b/dir/a.txt", "s3://c/"); err != nil {
+		t.Fatal(err)
+	}
+	if s.object("b", "dir/a.txt") != nil || s.object("c", "a.txt") == nil {
+		t.Errorf("left %v in b and %v in c", s.keys("b"), s.keys("c"))
+	}
+	s.put("b", "a.txt", "a")
+	if _, err := run(t, "mv", "s3://b/a.txt", "s3://b/a.txt"); err == nil {
+		t.Error("expected moving an object onto itself to fail")
+	}
+}
+
+func TestMvKeepsObjectsThatFailedToCopy(t *testing.T) {
+	s := newFakeS3(t, "b")
+	s.put("b", "old/a.txt", "a")
+	s.put("b", "old/b.txt", "b")
+	s.intercept = func(w http.ResponseWriter, r *http.Request) bool {
+		if source, _ := url.PathUnescape(r.Header.Get("X-Amz-Copy-Source")); strings.HasSuffix(source, "/b.txt") {
+			fakeError(w, http.StatusForbidden, "AccessDenied")
+			return true
+		}
+		return false
+	}
+	_, err := run(t, "mv", "s3://b/old/", "s3://b/new/")
+	if code := exitCode(err); code != exitPartial {
+		t.Fatalf("exited with %d (%v), want %d", code, err, exitPartial)
+	}
+	if got, want := strings.Join(s.keys("b"), " "), "new/a.txt old/b.txt"; got != want {
+		t.Errorf("left %s, want %s", got, want)
+	}
+}
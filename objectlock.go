@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// Object lock settings applied to uploads, for WORM compliance.
+// The bucket must have object lock enabled.
+var (
+	objectLockMode        string
+	objectLockRetainUntil string
+	legalHold             string
+)
+
+// objectLockSettings are the validated object lock flags, with
+// unset flags left nil so they are omitted from requests
+type objectLockSettings struct {
+	mode        *string
+	retainUntil *time.Time
+	legalHold   *string
+}
+
+// objectLock is parsed from the flags by parseObjectLock
+var objectLock objectLockSettings
+
+// parseObjectLock validates the object lock flags. A retention
+// mode is meaningless without a date to retain until and vice
+// versa, so they have to be given together.
+func parseObjectLock() (objectLockSettings, error) {
+	var settings objectLockSettings
+	if (objectLockMode == "") != (objectLockRetainUntil == "") {
+		return settings, fmt.Errorf("-object-lock-mode and -object-lock-retain-until must be given together")
+	}
+	if objectLockMode != "" {
+		mode := strings.ToUpper(objectLockMode)
+		if mode != s3.ObjectLockModeGovernance && mode != s3.ObjectLockModeCompliance {
+			return settings, fmt.Errorf("invalid -object-lock-mode '%s' (expected GOVERNANCE or COMPLIANCE)", objectLockMode)
+		}
+		retainUntil, err := time.Parse(time.RFC3339, objectLockRetainUntil)
+		if err != nil {
+			return settings, fmt.Errorf("invalid -object-lock-retain-until '%s' (expected RFC3339, e.g. 2030-01-02T15:04:05Z): %w", objectLockRetainUntil, err)
+		}
+		settings.mode = aws.String(mode)
+		settings.retainUntil = aws.Time(retainUntil)
+	}
+	switch strings.ToLower(legalHold) {
+	case "":
+	case "on":
+		settings.legalHold = aws.String(s3.ObjectLockLegalHoldStatusOn)
+	case "off":
+		settings.legalHold = aws.String(s3.ObjectLockLegalHoldStatusOff)
+	default:
+		return settings, fmt.Errorf("invalid -legal-hold '%s' (expected on or off)", legalHold)
+	}
+	return settings, nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestParseObjectLock(t *testing.T) {
+	defer func() { objectLockMode, objectLockRetainUntil, legalHold = "", "", "" }()
+	for _, tt := range []struct {
+		mode, retainUntil, legalHold string
+		ok                           bool
+	}{
+		{"", "", "", true},
+		{"governance", "2030-01-02T15:04:05Z", "", true},
+		{"COMPLIANCE", "2030-01-02T15:04:05Z", "on", true},
+		{"", "", "OFF", true},
+		{"GOVERNANCE", "", "", false},
+		{"", "2030-01-02T15:04:05Z", "", false},
+		{"FOREVER", "2030-01-02T15:04:05Z", "", false},
+		{"GOVERNANCE", "2030-01-02", "", false},
+		{"", "", "maybe", false},
+	} {
+		objectLockMode, objectLockRetainUntil, legalHold = tt.mode, tt.retainUntil, tt.legalHold
+		_, err := parseObjectLock()
+		if tt.ok != (err == nil) {
+			t.Errorf("%q, %q, %q: %v", tt.mode, tt.retainUntil, tt.legalHold, err)
+		}
+	}
+}
+
+func TestUploadObjectLock(t *testing.T) {
+	s := newFakeS3(t, "b")
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"a.txt": "a"})
+	if _, err := run(t,
+		"-object-lock-mode", "governance",
+		"-object-lock-retain-until", "2030-01-02T15:04:05Z",
+		"-legal-hold", "on",
+		filepath.Join(dir, "a.txt"), "s3://b/a.txt",
+	); err != nil {
+		t.Fatal(err)
+	}
+	obj := s.object("b", "a.txt")
+	for name, want := range map[string]string{
+		"X-Amz-Object-Lock-Mode":              "GOVERNANCE",
+		"X-Amz-Object-Lock-Retain-Until-Date": "2030-01-02T15:04:05Z",
+		"X-Amz-Object-Lock-Legal-Hold":        "ON",
+	} {
+		if got := obj.header.Get(name); got != want {
+			t.Errorf("%s is %q, want %q", name, got, want)
+		}
+	}
+	_, err := run(t, "-object-lock-mode", "governance", filepath.Join(dir, "a.txt"), "s3://b/a.txt")
+	if code := exitCode(err); code != exitUsage {
+		t.Errorf("a mode without a date exited with %d (%v), want %d", code, err, exitUsage)
+	}
+}
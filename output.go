@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// Output verbosity. -quiet suppresses all output, while
+// -only-show-errors keeps errors and the final summary so CI
+// logs only contain what went wrong.
+var (
+	quiet          bool
+	onlyShowErrors bool
+)
+
+// logSuccess prints a line about a completed transfer to stdout.
+// These lines are left out when -progress or -progress-bar is
+// drawing its status line, which would otherwise be broken up by
+// them.
+func logSuccess(format string, args ...interface{}) {
+	if quiet || onlyShowErrors || showProgress || showProgressBar {
+		return
+	}
+	fmt.Printf(format+"\n", args...)
+}
+
+// logWarning prints a line about something that was left out of a
+// job without failing it to stderr unless -quiet is given
+func logWarning(format string, args ...interface{}) {
+	if quiet {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "warning: "+format+"\n", args...)
+}
+
+// logError prints an error to stderr unless -quiet is given
+// and records it for -summary-out and -only-errors-exit-code
+func logError(err error) {
+	recordSummaryError(err)
+	recordJobError(err)
+	if quiet {
+		return
+	}
+	fmt.Fprintln(os.Stderr, err)
+}
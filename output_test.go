@@ -0,0 +1,44 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestOutputModes(t *testing.T) {
+	s := newFakeS3(t, "b")
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"a.txt": "a"})
+	for _, tt := range []struct {
+		flags []string
+		want  string
+	}{
+		{nil, "upload: " + filepath.Join(dir, "a.txt") + " to s3://b/a.txt\n"},
+		{[]string{"-quiet"}, ""},
+		{[]string{"-only-show-errors"}, ""},
+	} {
+		out, err := run(t, append(tt.flags, dir, "s3://b/")...)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.flags, err)
+		}
+		if out != tt.want {
+			t.Errorf("%s printed %q, want %q", tt.flags, out, tt.want)
+		}
+	}
+	if s.object("b", "a.txt") == nil {
+		t.Error("nothing was uploaded")
+	}
+}
+
+func TestLogError(t *testing.T) {
+	defer func() { quiet = false }()
+	for _, q := range []bool{false, true} {
+		quiet = q
+		stderr := captureStderr(t, func() { logError(errors.New("failed to upload 'a.txt'")) })
+		if want := !q; strings.Contains(stderr, "failed to upload") != want {
+			t.Errorf("-quiet=%v printed %q", q, stderr)
+		}
+	}
+}
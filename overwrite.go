@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+// overwritePolicy decides whether an upload replaces an existing
+// object at its key:
+//
+//	always        always upload (the default)
+//	never         skip files whose key exists
+//	if-newer      skip files not modified since the object was
+//	if-different  skip files with the same size and checksum or
+//	              ETag as the object, see checkObject
+//
+// Every policy but always makes a HEAD request per file.
+var overwritePolicy string
+
+const (
+	overwriteAlways      = "always"
+	overwriteNever       = "never"
+	overwriteIfNewer     = "if-newer"
+	overwriteIfDifferent = "if-different"
+)
+
+// skipExists is the reason files are skipped by -overwrite-policy
+// never
+const skipExists = "already exists"
+
+// checkOverwritePolicy validates -overwrite-policy
+func checkOverwritePolicy() error {
+	switch overwritePolicy {
+	case "", overwriteAlways, overwriteNever, overwriteIfNewer, overwriteIfDifferent:
+		return nil
+	}
+	return usageErrorf("-overwrite-policy must be always, never, if-newer or if-different")
+}
+
+// overwriteSkipReason returns why the file shouldn't be uploaded
+// to the key under -overwrite-policy, or "" if it should.
+func overwriteSkipReason(client s3iface.S3API, bucket string, key string, localPath string) (string, error) {
+	if overwritePolicy == "" || overwritePolicy == overwriteAlways {
+		return "", nil
+	}
+	target := fmt.Sprintf("s3://%s/%s", bucket, key)
+	ctx, cancel := jobContext()
+	defer cancel()
+	head, err := client.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket:       aws.String(bucket),
+		Key:          aws.String(key),
+		ChecksumMode: aws.String(s3.ChecksumModeEnabled),
+		RequestPayer: optionalString(requestPayer),
+
+		SSECustomerAlgorithm: sseCustomer.algorithm,
+		SSECustomerKey:       sseCustomer.key,
+		SSECustomerKeyMD5:    sseCustomer.keyMD5,
+	})
+	if err != nil {
+		if isNotFound(err) {
+			return "", nil
+		}
+		return "", fmt.Errorf("failed to stat '%s': %w", target, err)
+	}
+	if overwritePolicy == overwriteNever {
+		return skipExists, nil
+	}
+	info, err := os.Stat(localPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to stat source file '%s': %w", localPath, err)
+	}
+	if overwritePolicy == overwriteIfNewer {
+		if info.ModTime().After(aws.TimeValue(head.LastModified)) {
+			return "", nil
+		}
+		return skipUnchanged, nil
+	}
+	if err := compareWithObject(localPath, info, head, target); err == nil {
+		return skipUnchanged, nil
+	}
+	// Besides files that differ, those that can't be compared,
+	// e.g. as the object is encrypted with KMS, are uploaded to be
+	// on the safe side.
+	return "", nil
+}
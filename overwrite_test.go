@@ -0,0 +1,73 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOverwritePolicy(t *testing.T) {
+	now := time.Now()
+	for _, tt := range []struct {
+		policy string
+		// whether the object exists, holds the same data as the
+		// file and was modified after it
+		exists, same, newer bool
+		upload              bool
+	}{
+		{"always", true, true, true, true},
+		{"never", false, false, false, true},
+		{"never", true, false, false, false},
+		{"if-newer", false, false, false, true},
+		{"if-newer", true, false, false, true},
+		{"if-newer", true, false, true, false},
+		{"if-different", false, false, false, true},
+		{"if-different", true, false, true, true},
+		{"if-different", true, true, false, false},
+	} {
+		s := newFakeS3(t, "b")
+		dir := t.TempDir()
+		writeFiles(t, dir, map[string]string{"a.txt": "hello"})
+		path := filepath.Join(dir, "a.txt")
+		if err := os.Chtimes(path, now.Add(-time.Hour), now.Add(-time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+		if tt.exists {
+			data := "other"
+			if tt.same {
+				data = "hello"
+			}
+			s.put("b", "a.txt", data)
+			if !tt.newer {
+				s.object("b", "a.txt").modified = now.Add(-2 * time.Hour).UTC()
+			}
+		}
+		if _, err := run(t, "-overwrite-policy", tt.policy, dir, "s3://b/"); err != nil {
+			t.Fatalf("-overwrite-policy %s: %v", tt.policy, err)
+		}
+		if uploaded := len(s.received(http.MethodPut, "")) > 0; uploaded != tt.upload {
+			t.Errorf("-overwrite-policy %s with %+v uploaded = %v", tt.policy, tt, uploaded)
+		}
+	}
+	newFakeS3(t, "b")
+	if _, err := run(t, "-overwrite-policy", "sometimes", t.TempDir(), "s3://b/"); exitCode(err) != exitUsage {
+		t.Errorf("-overwrite-policy sometimes exited with %d (%v), want %d", exitCode(err), err, exitUsage)
+	}
+}
+
+func TestOverwritePolicyVerify(t *testing.T) {
+	s := newFakeS3(t, "b")
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"a.txt": "a", "b.txt": "b"})
+	s.put("b", "a.txt", "stale")
+	out, err := run(t, "-overwrite-policy", "never", "-verify", dir, "s3://b/")
+	if err != nil {
+		t.Fatalf("the skipped file was verified: %v", err)
+	}
+	if !strings.Contains(out, "verified 1 objects") {
+		t.Errorf("printed %q", out)
+	}
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+// Size of the parts of multipart uploads and ranged downloads,
+// and how many parts of each file are transferred concurrently.
+// Every one of the -parallelism transfers makes up to
+// -part-concurrency requests at a time.
+var (
+	partSize        int64
+	partConcurrency int
+)
+
+// newUploader creates an uploader with the configured parts
+func newUploader(sess *session.Session) *s3manager.Uploader {
+	return s3manager.NewUploader(sess, func(u *s3manager.Uploader) {
+		u.PartSize = partSize
+		u.Concurrency = partConcurrency
+	})
+}
+
+// newDownloader creates a downloader with the configured parts
+func newDownloader(sess *session.Session) *s3manager.Downloader {
+	return s3manager.NewDownloader(sess, func(d *s3manager.Downloader) {
+		d.PartSize = partSize
+		d.Concurrency = partConcurrency
+	})
+}
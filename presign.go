@@ -0,0 +1,123 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// Options of presign-post: how long the form may be used for and
+// the largest file it accepts, zero meaning no limit.
+var (
+	presignExpires time.Duration
+	presignMaxSize int64
+)
+
+// postForm is what presign-post prints: the URL a browser form
+// posts to and the fields it has to include, ahead of the file.
+type postForm struct {
+	URL    string            `json:"url"`
+	Fields map[string]string `json:"fields"`
+}
+
+// presignPost prints a signed POST policy allowing a browser to
+// upload straight to the bucket without credentials of its own.
+// If the target ends in a slash, any key beneath it may be used,
+// and the key field uses the ${filename} placeholder S3 replaces
+// with the name of the uploaded file.
+func presignPost(target string) error {
+	bucket, key, err := splitNameParts(target)
+	if err != nil {
+		return fmt.Errorf("failed to parse '%s': %w", target, err)
+	}
+	if presignExpires <= 0 {
+		return usageErrorf("-expires must be positive")
+	}
+	if presignMaxSize < 0 {
+		return usageErrorf("-max-size must not be negative")
+	}
+	if signatureVersion == signatureV2 {
+		return usageErrorf("presign-post only supports -signature-version %s", signatureV4)
+	}
+	sess := createSession()
+	creds, err := sess.Config.Credentials.Get()
+	if err != nil {
+		return fmt.Errorf("failed to get credentials: %w", err)
+	}
+	region := aws.StringValue(sess.Config.Region)
+	now := time.Now().UTC()
+	date := now.Format("20060102")
+	credential := fmt.Sprintf("%s/%s/%s/s3/aws4_request", creds.AccessKeyID, date, region)
+
+	fields := map[string]string{
+		"x-amz-algorithm":  "AWS4-HMAC-SHA256",
+		"x-amz-credential": credential,
+		"x-amz-date":       now.Format("20060102T150405Z"),
+	}
+	if creds.SessionToken != "" {
+		fields["x-amz-security-token"] = creds.SessionToken
+	}
+	conditions := []interface{}{
+		map[string]string{"bucket": bucket},
+	}
+	if key == "" || strings.HasSuffix(key, "/") {
+		conditions = append(conditions, []string{"starts-with", "$key", key})
+		fields["key"] = key + "${filename}"
+	} else {
+		conditions = append(conditions, map[string]string{"key": key})
+		fields["key"] = key
+	}
+	if presignMaxSize > 0 {
+		conditions = append(conditions, []interface{}{"content-length-range", 0, presignMaxSize})
+	}
+	for _, name := range []string{"x-amz-algorithm", "x-amz-credential", "x-amz-date", "x-amz-security-token"} {
+		if value, ok := fields[name]; ok {
+			conditions = append(conditions, map[string]string{name: value})
+		}
+	}
+	policy, err := json.Marshal(map[string]interface{}{
+		"expiration": now.Add(presignExpires).Format("2006-01-02T15:04:05.000Z"),
+		"conditions": conditions,
+	})
+	if err != nil {
+		return err
+	}
+	fields["policy"] = base64.StdEncoding.EncodeToString(policy)
+	fields["x-amz-signature"] = hex.EncodeToString(
+		hmacSHA256(signingKey(creds.SecretAccessKey, date, region), fields["policy"]),
+	)
+
+	// Building a request for the bucket yields its URL with the
+	// endpoint and addressing style in effect.
+	req, _ := s3.New(sess).HeadBucketRequest(&s3.HeadBucketInput{Bucket: aws.String(bucket)})
+	if err := req.Build(); err != nil {
+		return fmt.Errorf("failed to get the URL of '%s': %w", bucket, err)
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(&postForm{URL: req.HTTPRequest.URL.String(), Fields: fields})
+}
+
+// signingKey derives the Signature Version 4 key for S3 requests
+// made on the given day in the region
+func signingKey(secret string, date string, region string) []byte {
+	key := hmacSHA256([]byte("AWS4"+secret), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, "s3")
+	return hmacSHA256(key, "aws4_request")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
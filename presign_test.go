@@ -0,0 +1,100 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPresignPost(t *testing.T) {
+	s := newFakeS3(t, "b")
+	t.Setenv("AWS_REGION", "eu-west-1")
+	out, err := run(t, "presign-post", "-expires", "1h", "-max-size", "10485760", "s3://b/uploads/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var form postForm
+	if err := json.Unmarshal([]byte(out), &form); err != nil {
+		t.Fatalf("printed %q: %v", out, err)
+	}
+	if form.URL != s.URL+"/b" {
+		t.Errorf("posts to %s, want %s/b", form.URL, s.URL)
+	}
+	if got := form.Fields["key"]; got != "uploads/${filename}" {
+		t.Errorf("the key field is %q", got)
+	}
+	date := time.Now().UTC().Format("20060102")
+	if got, want := form.Fields["x-amz-credential"], "AKIDTEST/"+date+"/eu-west-1/s3/aws4_request"; got != want {
+		t.Errorf("the credential is %q, want %q", got, want)
+	}
+
+	data, err := base64.StdEncoding.DecodeString(form.Fields["policy"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var policy struct {
+		Expiration string
+		Conditions []interface{}
+	}
+	if err := json.Unmarshal(data, &policy); err != nil {
+		t.Fatal(err)
+	}
+	expiration, err := time.Parse(time.RFC3339, policy.Expiration)
+	if until := time.Until(expiration); err != nil || until < 59*time.Minute || until > time.Hour {
+		t.Errorf("the policy expires at %s", policy.Expiration)
+	}
+	conditions := fmt.Sprint(policy.Conditions)
+	for _, want := range []string{
+		"map[bucket:b]",
+		"[starts-with $key uploads/]",
+		"[content-length-range 0 1.048576e+07]",
+		"map[x-amz-algorithm:AWS4-HMAC-SHA256]",
+		"map[x-amz-date:" + form.Fields["x-amz-date"] + "]",
+	} {
+		if !strings.Contains(conditions, want) {
+			t.Errorf("the conditions %s lack %s", conditions, want)
+		}
+	}
+
+	key := []byte("AWS4secret")
+	for _, part := range []string{date, "eu-west-1", "s3", "aws4_request"} {
+		h := hmac.New(sha256.New, key)
+		h.Write([]byte(part))
+		key = h.Sum(nil)
+	}
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(form.Fields["policy"]))
+	if got, want := form.Fields["x-amz-signature"], hex.EncodeToString(h.Sum(nil)); got != want {
+		t.Errorf("the signature is %s, want %s", got, want)
+	}
+}
+
+func TestPresignPostSingleKey(t *testing.T) {
+	newFakeS3(t, "b")
+	out, err := run(t, "presign-post", "s3://b/avatar.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var form postForm
+	if err := json.Unmarshal([]byte(out), &form); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := base64.StdEncoding.DecodeString(form.Fields["policy"])
+	if form.Fields["key"] != "avatar.png" || !strings.Contains(string(data), `{"key":"avatar.png"}`) {
+		t.Errorf("the key field is %q and the policy %s", form.Fields["key"], data)
+	}
+	if strings.Contains(string(data), "content-length-range") {
+		t.Errorf("the policy limits the size without -max-size: %s", data)
+	}
+	for _, expires := range []string{"0s", "-1h"} {
+		if _, err := run(t, "presign-post", "-expires", expires, "s3://b/"); exitCode(err) != exitUsage {
+			t.Errorf("-expires %s exited with %d (%v), want %d", expires, exitCode(err), err, exitUsage)
+		}
+	}
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// showProgress is set by the -progress flag
+var showProgress bool
+
+// showProgressBar draws a progress bar in place of the -progress
+// line if stderr is a terminal, and otherwise logs the progress
+// periodically on lines of its own, e.g. for CI logs
+var showProgressBar bool
+
+// progressInterval is how often the progress line is redrawn
+const progressInterval = 500 * time.Millisecond
+
+// progressLogInterval is how often -progress-bar logs a line
+// when stderr isn't a terminal
+const progressLogInterval = 10 * time.Second
+
+// progressBarWidth is the number of characters in the bar
+const progressBarWidth = 30
+
+// progress aggregates the progress of all parallel jobs, which
+// is rendered as a single status line with -progress, exported
+// by the -metrics-addr endpoint and written by -summary-out.
+// Jobs report through the counting readers and writers below,
+// which only touch atomic counters, so it is safe to share
+// between any number of goroutines. All methods are no-ops on a
+// nil *progress, which is what callers get when none of them nor
+// -concurrency-auto, which tunes for the bytes counted here, is
+// enabled.
+type progress struct {
+	totalFiles  int64
+	totalBytes  int64
+	doneFiles   int64
+	failedFiles int64
+	bytes       int64
+	started     time.Time
+	stop        chan struct{}
+	wg          sync.WaitGroup
+
+	// parent is what the progress of an attempt counts into
+	parent *progress
+}
+
+// startProgress begins tracking progress for the given number
+// of files, zero meaning the total is not known. The status line
+// is only drawn with -progress, and not if output is restricted
+// by -quiet or -only-show-errors. Returns nil if there is
+// nothing to report progress to.
+func startProgress(totalFiles int) *progress {
+	render := (showProgress || showProgressBar) && !quiet && !onlyShowErrors
+	if !render && metricsAddr == "" && summaryOut == "" && !autoConcurrency {
+		return nil
+	}
+	p := &progress{
+		totalFiles: int64(totalFiles),
+		started:    time.Now(),
+	}
+	setMetricsProgress(p)
+	trackSummaryProgress(p)
+	if !render {
+		return p
+	}
+	draw, interval, inPlace := p.render, progressInterval, true
+	if showProgressBar {
+		if isTerminal(os.Stderr) {
+			draw = p.renderBar
+		} else {
+			draw, interval, inPlace = p.logLine, progressLogInterval, false
+		}
+	}
+	p.stop = make(chan struct{})
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				draw()
+			case <-p.stop:
+				draw()
+				if inPlace {
+					fmt.Fprintln(os.Stderr)
+				}
+				return
+			}
+		}
+	}()
+	return p
+}
+
+// setTotalBytes sets the number of bytes to transfer, if known
+// up front, so a percentage and ETA can be shown
+func (p *progress) setTotalBytes(n int64) {
+	if p == nil {
+		return
+	}
+	atomic.StoreInt64(&p.totalBytes, n)
+}
+
+// add records n transferred bytes
+func (p *progress) add(n int64) {
+	if p == nil {
+		return
+	}
+	atomic.AddInt64(&p.bytes, n)
+	if p.parent != nil {
+		p.parent.add(n)
+		return
+	}
+	atomic.AddInt64(&transferredBytes, n)
+}
+
+// attempt returns the progress of a single attempt at a job,
+// which counts its bytes into p until undo takes them back
+func (p *progress) attempt() *progress {
+	if p == nil {
+		return nil
+	}
+	return &progress{parent: p}
+}
+
+// undo takes the bytes counted by a failed attempt back out of
+// its parent, so the retry doesn't count them again. They
+// were still transferred, so -concurrency-auto keeps them.
+func (p *progress) undo() {
+	if p == nil || p.parent == nil {
+		return
+	}
+	n := atomic.SwapInt64(&p.bytes, 0)
+	for q := p.parent; q != nil; q = q.parent {
+		atomic.AddInt64(&q.bytes, -n)
+	}
+}
+
+// fileDone records the completion of a file, which failed
+// if err is not nil
+func (p *progress) fileDone(err error) {
+	if p == nil {
+		return
+	}
+	atomic.AddInt64(&p.doneFiles, 1)
+	if err != nil {
+		atomic.AddInt64(&p.failedFiles, 1)
+	}
+}
+
+// finish draws the final state and stops rendering
+func (p *progress) finish() {
+	if p == nil || p.stop == nil {
+		return
+	}
+	close(p.stop)
+	p.wg.Wait()
+}
+
+// render redraws the -progress line
+func (p *progress) render() {
+	fmt.Fprintf(os.Stderr, "\r%s   ", p.status())
+}
+
+// renderBar redraws the -progress-bar, filled by the share of
+// the bytes or files done, whichever total is known
+func (p *progress) renderBar() {
+	fraction := -1.0
+	if total := atomic.LoadInt64(&p.totalBytes); total > 0 {
+		fraction = float64(atomic.LoadInt64(&p.bytes)) / float64(total)
+	} else if p.totalFiles > 0 {
+		fraction = float64(atomic.LoadInt64(&p.doneFiles)) / float64(p.totalFiles)
+	}
+	if fraction < 0 {
+		p.render()
+		return
+	}
+	if fraction > 1 {
+		fraction = 1
+	}
+	filled := int(fraction * progressBarWidth)
+	bar := strings.Repeat("=", filled)
+	if filled < progressBarWidth {
+		bar += ">" + strings.Repeat(" ", progressBarWidth-filled-1)
+	}
+	fmt.Fprintf(os.Stderr, "\r[%s] %s   ", bar, p.status())
+}
+
+// logLine writes the progress on a line of its own
+func (p *progress) logLine() {
+	fmt.Fprintln(os.Stderr, p.status())
+}
+
+// status describes the progress so far
+func (p *progress) status() string {
+	bytes := atomic.LoadInt64(&p.bytes)
+	rate := float64(bytes) / time.Since(p.started).Seconds()
+	files := fmt.Sprintf("%d", atomic.LoadInt64(&p.doneFiles))
+	if p.totalFiles > 0 {
+		files += fmt.Sprintf("/%d", p.totalFiles)
+	}
+	eta := ""
+	if total := atomic.LoadInt64(&p.totalBytes); total > 0 {
+		eta = fmt.Sprintf(", %d%%", bytes*100/total)
+		if bytes > 0 && bytes < total {
+			remaining := time.Duration(float64(total-bytes) / rate * float64(time.Second))
+			eta += fmt.Sprintf(", ETA %s", remaining.Round(time.Second))
+		}
+	}
+	return fmt.Sprintf(
+		"%s files, %s, %s/s%s",
+		files,
+		formatBytes(bytes),
+		formatBytes(int64(rate)),
+		eta,
+	)
+}
+
+// formatBytes formats a byte count using binary units, e.g. "1.5 MiB"
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// progressReader counts the bytes read through it
+type progressReader struct {
+	r io.Reader
+	p *progress
+}
+
+func (r *progressReader) Read(b []byte) (int, error) {
+	n, err := r.r.Read(b)
+	r.p.add(int64(n))
+	return n, err
+}
+
+// progressWriterAt counts the bytes written through it. The
+// downloader writes parts concurrently, hence io.WriterAt.
+type progressWriterAt struct {
+	w io.WriterAt
+	p *progress
+}
+
+func (w *progressWriterAt) WriteAt(b []byte, off int64) (int, error) {
+	n, err := w.w.WriteAt(b, off)
+	w.p.add(int64(n))
+	return n, err
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFormatBytes(t *testing.T) {
+	for _, tt := range []struct {
+		n    int64
+		want string
+	}{
+		{0, "0 B"},
+		{1023, "1023 B"},
+		{1024, "1.0 KiB"},
+		{1536, "1.5 KiB"},
+		{5 << 20, "5.0 MiB"},
+		{3 << 40, "3.0 TiB"},
+	} {
+		if got := formatBytes(tt.n); got != tt.want {
+			t.Errorf("formatBytes(%d) = %q, want %q", tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestProgressAggregatesJobs(t *testing.T) {
+	p := &progress{totalFiles: 8}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			r := &progressReader{r: strings.NewReader(strings.Repeat("x", 1024)), p: p}
+			io.Copy(io.Discard, r)
+			var err error
+			if i == 0 {
+				err = errors.New("failed")
+			}
+			p.fileDone(err)
+		}(i)
+	}
+	wg.Wait()
+	if p.bytes != 8*1024 || p.doneFiles != 8 || p.failedFiles != 1 {
+		t.Errorf("counted %d bytes, %d files and %d failures", p.bytes, p.doneFiles, p.failedFiles)
+	}
+	if status := p.status(); !strings.HasPrefix(status, "8/8 files, 8.0 KiB, ") {
+		t.Errorf("status is %q", status)
+	}
+}
+
+func TestProgressDisabled(t *testing.T) {
+	showProgress, showProgressBar, autoConcurrency = false, false, false
+	metricsAddr, summaryOut = "", ""
+	if p := startProgress(1); p != nil {
+		t.Fatal("expected no progress to be tracked without -progress")
+	}
+	// The methods are no-ops on nil
+	var p *progress
+	p.add(1)
+	p.fileDone(nil)
+	p.setTotalBytes(1)
+	p.finish()
+}
+
+func TestMeasureDir(t *testing.T) {
+	defer func() { uploadExts = nil }()
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"a.jpg": "12345", "sub/b.jpg": "123", "sub/c.txt": "1234567"})
+	if files, bytes, err := measureDir(dir); err != nil || files != 3 || bytes != 15 {
+		t.Errorf("measureDir = %d files, %d bytes, %v, want 3 files, 15 bytes", files, bytes, err)
+	}
+	uploadExts = extList{".jpg"}
+	if files, bytes, err := measureDir(dir); err != nil || files != 2 || bytes != 8 {
+		t.Errorf("measureDir with -ext .jpg = %d files, %d bytes, %v, want 2 files, 8 bytes", files, bytes, err)
+	}
+}
+
+func TestProgressPercentage(t *testing.T) {
+	p := &progress{totalFiles: 3, started: time.Now().Add(-time.Second)}
+	if status := p.status(); strings.Contains(status, "%") {
+		t.Errorf("status %q shows a percentage without a total", status)
+	}
+	p.setTotalBytes(1000)
+	p.add(250)
+	if status := p.status(); !strings.Contains(status, ", 25%, ETA ") {
+		t.Errorf("status %q lacks the percentage and ETA", status)
+	}
+	p.add(750)
+	if status := p.status(); !strings.HasSuffix(status, ", 100%") {
+		t.Errorf("status %q of a finished transfer", status)
+	}
+}
+
+func TestProgressBarLogsLinesWithoutTerminal(t *testing.T) {
+	defer func() { showProgressBar = false }()
+	showProgress, showProgressBar, quiet, onlyShowErrors = false, true, false, false
+	stderr := captureStderr(t, func() {
+		p := startProgress(2)
+		p.add(1024)
+		p.fileDone(nil)
+		p.finish()
+	})
+	if strings.Contains(stderr, "\r") || !strings.HasSuffix(stderr, "\n") || strings.Count(stderr, "\n") != 1 {
+		t.Errorf("stderr isn't a terminal, but got %q", stderr)
+	}
+	if !strings.HasPrefix(stderr, "1/2 files, 1.0 KiB, ") {
+		t.Errorf("logged %q", stderr)
+	}
+}
+
+func TestRenderBar(t *testing.T) {
+	for _, tt := range []struct {
+		p    *progress
+		want string
+	}{
+		{&progress{totalFiles: 4, doneFiles: 1}, "\r[=======>                      ] 1/4 files"},
+		{&progress{totalBytes: 100, bytes: 50}, "\r[===============>              ] 0 files"},
+		{&progress{totalBytes: 100, bytes: 100}, "\r[==============================] 0 files"},
+		// Without any total there is nothing to fill the bar by
+		{&progress{doneFiles: 3}, "\r3 files"},
+	} {
+		tt.p.started = time.Now().Add(-time.Second)
+		if got := captureStderr(t, tt.p.renderBar); !strings.HasPrefix(got, tt.want) {
+			t.Errorf("drew %q, want it to start with %q", got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// provider selects the preset used to derive the endpoint from
+// the region when no endpoint is given explicitly
+var provider string
+
+// providerPreset describes how to reach an S3 compatible service
+type providerPreset struct {
+	// endpoint in which "{region}" is replaced with the region.
+	// Empty leaves the endpoint to the SDK.
+	endpoint string
+
+	// signingRegion is the region requests are signed for if it
+	// differs from the region in the endpoint
+	signingRegion string
+
+	// requiresRegion is set if the region names a datacenter
+	// that can't sensibly be defaulted
+	requiresRegion bool
+}
+
+var providers = map[string]providerPreset{
+	"aws": {},
+	"do": {
+		// The region is the datacenter, e.g. nyc3 or fra1
+		endpoint:       "https://{region}.digitaloceanspaces.com",
+		signingRegion:  "us-east-1",
+		requiresRegion: true,
+	},
+	"wasabi": {
+		endpoint: "https://s3.{region}.wasabisys.com",
+	},
+	"backblaze": {
+		// e.g. us-west-004
+		endpoint:       "https://s3.{region}.backblazeb2.com",
+		requiresRegion: true,
+	},
+}
+
+// providerNames returns the names of the presets for messages
+func providerNames() string {
+	var names []string
+	for name := range providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// checkProvider returns an error if the -provider is unknown
+// or needs a region that wasn't given.
+func checkProvider() error {
+	preset, ok := providers[provider]
+	if !ok {
+		return fmt.Errorf("unknown -provider '%s' (expected one of %s)", provider, providerNames())
+	}
+	if preset.requiresRegion && explicitEndpoint() == "" &&
+		region == "" && firstEnv("AWS_REGION", "AWS_DEFAULT_REGION") == "" {
+		return fmt.Errorf("-provider %s requires -region", provider)
+	}
+	return nil
+}
+
+// regionFromEndpoint returns the region to sign requests to an
+// endpoint of one of the presets for, e.g. us-east-1 for
+// nyc3.digitaloceanspaces.com and eu-central-1 for
+// s3.eu-central-1.wasabisys.com. It returns an empty string for
+// other endpoints.
+func regionFromEndpoint(endpoint string) string {
+	if !strings.Contains(endpoint, "://") {
+		endpoint = "https://" + endpoint
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return ""
+	}
+	host := strings.ToLower(u.Hostname())
+	for _, preset := range providers {
+		prefix, suffix, ok := strings.Cut(strings.TrimPrefix(preset.endpoint, "https://"), "{region}")
+		if !ok || len(host) <= len(prefix)+len(suffix) ||
+			!strings.HasPrefix(host, prefix) || !strings.HasSuffix(host, suffix) {
+			continue
+		}
+		if preset.signingRegion != "" {
+			// The same for every datacenter
+			return preset.signingRegion
+		}
+		if region := host[len(prefix) : len(host)-len(suffix)]; !strings.Contains(region, ".") {
+			return region
+		}
+	}
+	return ""
+}
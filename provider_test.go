@@ -0,0 +1,93 @@
+package main
+
+import "testing"
+
+func TestProviderPresets(t *testing.T) {
+	defer func(p, r string) { provider, region = p, r }(provider, region)
+	for _, env := range []string{"AWS_ENDPOINT_URL", "AWS_S3_ENDPOINT", "AWS_REGION", "AWS_DEFAULT_REGION"} {
+		t.Setenv(env, "")
+	}
+	for _, tt := range []struct {
+		provider, region      string
+		endpoint, signingWith string
+	}{
+		{"aws", "eu-west-1", "", "eu-west-1"},
+		{"aws", "", "", defaultRegion},
+		{"do", "fra1", "https://fra1.digitaloceanspaces.com", "us-east-1"},
+		{"wasabi", "eu-central-1", "https://s3.eu-central-1.wasabisys.com", "eu-central-1"},
+		{"backblaze", "us-west-004", "https://s3.us-west-004.backblazeb2.com", "us-west-004"},
+	} {
+		provider, region = tt.provider, tt.region
+		if err := checkProvider(); err != nil {
+			t.Errorf("-provider %s -region %q: %v", tt.provider, tt.region, err)
+			continue
+		}
+		endpoint, signingRegion := resolveEndpoint()
+		if endpoint != tt.endpoint || signingRegion != tt.signingWith {
+			t.Errorf("-provider %s -region %q uses %q signed for %q, want %q signed for %q",
+				tt.provider, tt.region, endpoint, signingRegion, tt.endpoint, tt.signingWith)
+		}
+	}
+}
+
+func TestCheckProvider(t *testing.T) {
+	defer func(p, r string) { provider, region = p, r }(provider, region)
+	for _, env := range []string{"AWS_ENDPOINT_URL", "AWS_S3_ENDPOINT", "AWS_REGION", "AWS_DEFAULT_REGION"} {
+		t.Setenv(env, "")
+	}
+	region = ""
+	for _, name := range []string{"do", "backblaze", "gcs"} {
+		provider = name
+		if err := checkProvider(); err == nil {
+			t.Errorf("-provider %s without -region was accepted", name)
+		}
+	}
+	provider = "do"
+	t.Setenv("AWS_ENDPOINT_URL", "https://nyc3.digitaloceanspaces.com")
+	if err := checkProvider(); err != nil {
+		t.Errorf("-provider do with an endpoint: %v", err)
+	}
+}
+
+func TestRegionFromEndpoint(t *testing.T) {
+	for _, tt := range []struct {
+		endpoint, want string
+	}{
+		{"https://nyc3.digitaloceanspaces.com", "us-east-1"},
+		{"https://ams3.digitaloceanspaces.com", "us-east-1"},
+		{"sgp1.digitaloceanspaces.com", "us-east-1"},
+		{"https://FRA1.DigitalOceanSpaces.com:443", "us-east-1"},
+		{"https://s3.eu-central-1.wasabisys.com", "eu-central-1"},
+		{"https://s3.us-west-004.backblazeb2.com", "us-west-004"},
+		{"https://digitaloceanspaces.com", ""},
+		{"https://a.b.s3.wasabisys.com", ""},
+		{"https://s3.a.b.wasabisys.com", ""},
+		{"https://minio.local:9000", ""},
+		{"http://[::1", ""},
+	} {
+		if got := regionFromEndpoint(tt.endpoint); got != tt.want {
+			t.Errorf("regionFromEndpoint(%q) = %q, want %q", tt.endpoint, got, tt.want)
+		}
+	}
+}
+
+func TestSigningRegionFor(t *testing.T) {
+	defer func(old string) { region = old }(region)
+	t.Setenv("AWS_REGION", "")
+	t.Setenv("AWS_DEFAULT_REGION", "")
+	region = ""
+	if got := signingRegionFor("https://nyc3.digitaloceanspaces.com"); got != "us-east-1" {
+		t.Errorf("inferred %q", got)
+	}
+	if got := signingRegionFor("https://minio.local"); got != defaultRegion {
+		t.Errorf("got %q for an unknown endpoint, want %q", got, defaultRegion)
+	}
+	t.Setenv("AWS_REGION", "eu-west-1")
+	if got := signingRegionFor("https://nyc3.digitaloceanspaces.com"); got != "eu-west-1" {
+		t.Errorf("got %q, want AWS_REGION to take precedence", got)
+	}
+	region = "ap-south-1"
+	if got := signingRegionFor("https://nyc3.digitaloceanspaces.com"); got != "ap-south-1" {
+		t.Errorf("got %q, want -region to take precedence", got)
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// Options of restore. The restored copy of an archived object
+// is readable for restoreDays, and the tier trades speed for
+// cost: Expedited, Standard or Bulk.
+var (
+	restoreDays int64
+	restoreTier string
+	restoreWait bool
+	waitTimeout time.Duration
+)
+
+// Bounds of the delay between checks of whether a restore has
+// finished, which doubles from the first to the second. Restores
+// take minutes at best and hours at worst.
+const (
+	restorePollMin = 15 * time.Second
+	restorePollMax = 5 * time.Minute
+)
+
+// restore requests a temporary copy of an object archived in
+// GLACIER or DEEP_ARCHIVE and, with -wait, blocks until the copy
+// is ready to be downloaded. Requesting a restore that is already
+// in progress is not an error, so restore can be run again just
+// to wait.
+func restore(target string) error {
+	bucket, key, err := splitNameParts(target)
+	if err != nil {
+		return fmt.Errorf("failed to parse '%s': %w", target, err)
+	}
+	if key == "" {
+		return usageErrorf("'%s' does not specify a key", target)
+	}
+	switch restoreTier {
+	case s3.TierStandard, s3.TierBulk, s3.TierExpedited:
+	default:
+		return usageErrorf("-tier must be %s, %s or %s", s3.TierStandard, s3.TierBulk, s3.TierExpedited)
+	}
+	if restoreDays < 1 {
+		return usageErrorf("-days must be at least 1")
+	}
+	s3Client := s3.New(createSession())
+
+	ctx, cancel := jobContext()
+	defer cancel()
+	if _, err := s3Client.RestoreObjectWithContext(ctx, &s3.RestoreObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+		RestoreRequest: &s3.RestoreRequest{
+			Days: aws.Int64(restoreDays),
+			GlacierJobParameters: &s3.GlacierJobParameters{
+				Tier: aws.String(restoreTier),
+			},
+		},
+		RequestPayer: optionalString(requestPayer),
+	}); err != nil {
+		if aerr, ok := err.(awserr.Error); !ok || aerr.Code() != "RestoreAlreadyInProgress" {
+			return fmt.Errorf("failed to restore '%s': %w", target, err)
+		}
+	}
+	logSuccess("restore: requested %s for %d days", target, restoreDays)
+	if !restoreWait {
+		return nil
+	}
+	return waitForRestore(s3Client, bucket, key)
+}
+
+// waitForRestore polls the object until its restore has finished
+// or -wait-timeout has passed.
+func waitForRestore(s3Client *s3.S3, bucket string, key string) error {
+	target := fmt.Sprintf("s3://%s/%s", bucket, key)
+	var deadline time.Time
+	if waitTimeout > 0 {
+		deadline = time.Now().Add(waitTimeout)
+	}
+	delay := restorePollMin
+	for {
+		ctx, cancel := jobContext()
+		out, err := s3Client.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+			Bucket:       aws.String(bucket),
+			Key:          aws.String(key),
+			RequestPayer: optionalString(requestPayer),
+		})
+		cancel()
+		if err != nil {
+			return fmt.Errorf("failed to stat '%s': %w", target, err)
+		}
+		done, err := restoreFinished(aws.StringValue(out.Restore))
+		if err != nil {
+			return fmt.Errorf("cannot wait for '%s': %w", target, err)
+		}
+		if done {
+			logSuccess("restore: %s is ready", target)
+			return nil
+		}
+		if !deadline.IsZero() && time.Now().Add(delay).After(deadline) {
+			return fmt.Errorf("restore of '%s' did not finish within %v", target, waitTimeout)
+		}
+		sleep(delay)
+		if delay *= 2; delay > restorePollMax {
+			delay = restorePollMax
+		}
+	}
+}
+
+// restoreFinished interprets the x-amz-restore header, which reads
+// ongoing-request="true" while the restore is running and
+// ongoing-request="false", expiry-date="..." once it's done.
+func restoreFinished(header string) (bool, error) {
+	switch {
+	case strings.Contains(header, `ongoing-request="false"`):
+		return true, nil
+	case strings.Contains(header, `ongoing-request="true"`):
+		return false, nil
+	case header == "":
+		return false, fmt.Errorf("no restore is in progress")
+	}
+	return false, fmt.Errorf("unrecognized restore status '%s'", header)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestRestoreFinished(t *testing.T) {
+	for _, tt := range []struct {
+		header string
+		done   bool
+		ok     bool
+	}{
+		{`ongoing-request="true"`, false, true},
+		{`ongoing-request="false", expiry-date="Fri, 21 Dec 2012 00:00:00 GMT"`, true, true},
+		{"", false, false},
+		{"something else", false, false},
+	} {
+		done, err := restoreFinished(tt.header)
+		if done != tt.done || tt.ok != (err == nil) {
+			t.Errorf("restoreFinished(%q) = %v, %v", tt.header, done, err)
+		}
+	}
+}
+
+// restoringFakeS3 returns a fake whose object a.txt reports its
+// restore as ongoing for the given number of HEAD requests and as
+// finished afterwards, or never with a negative number. It
+// returns the number of HEAD requests made.
+func restoringFakeS3(t *testing.T, ongoing int32) *int32 {
+	s := newFakeS3(t, "b")
+	s.put("b", "a.txt", "a", "X-Amz-Storage-Class", "GLACIER")
+	var heads int32
+	s.intercept = func(w http.ResponseWriter, r *http.Request) bool {
+		if r.Method == http.MethodHead {
+			if n := atomic.AddInt32(&heads, 1); ongoing < 0 || n <= ongoing {
+				w.Header().Set("X-Amz-Restore", `ongoing-request="true"`)
+			} else {
+				w.Header().Set("X-Amz-Restore", `ongoing-request="false", expiry-date="Fri, 21 Dec 2012 00:00:00 GMT"`)
+			}
+		}
+		return false
+	}
+	return &heads
+}
+
+func TestRestoreWait(t *testing.T) {
+	heads := restoringFakeS3(t, 2)
+	if _, err := run(t, "restore", "-wait", "s3://b/a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(heads); n != 3 {
+		t.Errorf("checked the restore %d times, want 3", n)
+	}
+
+	heads = restoringFakeS3(t, 0)
+	if _, err := run(t, "restore", "s3://b/a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(heads); n != 0 {
+		t.Errorf("checked the restore %d times without -wait", n)
+	}
+}
+
+func TestRestoreWaitTimeout(t *testing.T) {
+	heads := restoringFakeS3(t, -1)
+	// Sleeping is skipped, so the timeout is reached once the
+	// next delay of 30s would exceed it
+	_, err := run(t, "restore", "-wait", "-wait-timeout", "20s", "s3://b/a.txt")
+	if err == nil || !strings.Contains(err.Error(), "did not finish within 20s") {
+		t.Errorf("got %v, want a timeout", err)
+	}
+	if n := atomic.LoadInt32(heads); n != 2 {
+		t.Errorf("checked the restore %d times, want 2", n)
+	}
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+// resumableUpload makes files larger than a part record the
+// progress of their multipart upload in a state file, so that an
+// interrupted upload continues where it left off the next time
+// instead of sending every part again.
+var resumableUpload bool
+
+// uploadState is the state file of a resumable upload. The upload
+// is only resumed if the file still has the size and modification
+// time it had when the upload began.
+type uploadState struct {
+	Bucket   string    `json:"bucket"`
+	Key      string    `json:"key"`
+	UploadID string    `json:"upload_id"`
+	Size     int64     `json:"size"`
+	ModTime  time.Time `json:"mod_time"`
+	PartSize int64     `json:"part_size"`
+	// ETags of the completed parts by part number
+	Parts map[int64]string `json:"parts"`
+}
+
+// uploadStatePath returns where the state of uploading a file to
+// a key is kept. It's in the user's cache directory rather than
+// next to the file, as that would put it in directory uploads.
+func uploadStatePath(bucket string, key string, sourcePath string) (string, error) {
+	abs, err := filepath.Abs(sourcePath)
+	if err != nil {
+		return "", err
+	}
+	cache, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(abs + "\x00" + bucket + "\x00" + key))
+	return filepath.Join(cache, "s3util", "uploads", hex.EncodeToString(sum[:])+".json"), nil
+}
+
+// loadUploadState reads a state file, returning nil if it
+// doesn't exist
+func loadUploadState(path string) (*uploadState, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	state := &uploadState{}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("invalid upload state '%s': %w", path, err)
+	}
+	return state, nil
+}
+
+// save writes the state file, replacing it in one step so an
+// interruption never leaves it half written
+func (s *uploadState) save(path string) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// uploadResumable uploads a file in parts of -part-size, skipping
+// the parts that the state file says were already uploaded. The
+// state file is removed once the upload is complete, and also if
+// S3 no longer knows the upload, so the next attempt starts over.
+func uploadResumable(
+	ctx context.Context,
+	uploader *s3manager.Uploader,
+	input *s3manager.UploadInput,
+	f *os.File,
+	info os.FileInfo,
+	prog *progress,
+) error {
+	sourcePath := f.Name()
+	bucket, key := input.Bucket, input.Key
+	statePath, err := uploadStatePath(*bucket, *key, sourcePath)
+	if err != nil {
+		return fmt.Errorf("failed to locate upload state: %w", err)
+	}
+	state, err := loadUploadState(statePath)
+	if err != nil {
+		return err
+	}
+	size := info.Size()
+	partSize := uploader.PartSize
+	// s3manager raises the part size the same way for files that
+	// wouldn't fit in the maximum number of parts
+	if size/partSize >= int64(uploader.MaxUploadParts) {
+		partSize = size/int64(uploader.MaxUploadParts) + 1
+	}
+	if state != nil && (state.Size != size || !state.ModTime.Equal(info.ModTime()) || state.PartSize != partSize) {
+		// The file changed, so the parts uploaded so far are
+		// of no use. Failing to abort only leaves them to a
+		// lifecycle rule.
+		uploader.S3.AbortMultipartUploadWithContext(ctx, &s3.AbortMultipartUploadInput{
+			Bucket:   bucket,
+			Key:      key,
+			UploadId: aws.String(state.UploadID),
+		})
+		state = nil
+	}
+	if state == nil {
+		out, err := uploader.S3.CreateMultipartUploadWithContext(ctx, &s3.CreateMultipartUploadInput{
+			Bucket:          bucket,
+			Key:             key,
+			ContentType:     input.ContentType,
+			ContentLanguage: input.ContentLanguage,
+			Metadata:        input.Metadata,
+			StorageClass:    input.StorageClass,
+			ACL:             input.ACL,
+
+			WebsiteRedirectLocation: input.WebsiteRedirectLocation,
+
+			ServerSideEncryption:    input.ServerSideEncryption,
+			SSEKMSKeyId:             input.SSEKMSKeyId,
+			SSEKMSEncryptionContext: input.SSEKMSEncryptionContext,
+
+			SSECustomerAlgorithm: input.SSECustomerAlgorithm,
+			SSECustomerKey:       input.SSECustomerKey,
+			SSECustomerKeyMD5:    input.SSECustomerKeyMD5,
+
+			ObjectLockMode:            input.ObjectLockMode,
+			ObjectLockRetainUntilDate: input.ObjectLockRetainUntilDate,
+			ObjectLockLegalHoldStatus: input.ObjectLockLegalHoldStatus,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to start multipart upload: %w", err)
+		}
+		state = &uploadState{
+			Bucket:   *bucket,
+			Key:      *key,
+			UploadID: aws.StringValue(out.UploadId),
+			Size:     size,
+			ModTime:  info.ModTime(),
+			PartSize: partSize,
+			Parts:    make(map[int64]string),
+		}
+		if err := state.save(statePath); err != nil {
+			return fmt.Errorf("failed to save upload state: %w", err)
+		}
+	} else {
+		logSuccess("resuming upload of %s with %d parts done", sourcePath, len(state.Parts))
+	}
+
+	numParts := (size + partSize - 1) / partSize
+	partBounds := func(n int64) (int64, int64) {
+		off := (n - 1) * partSize
+		if off+partSize <= size {
+			return off, partSize
+		}
+		return off, size - off
+	}
+	var pending []int64
+	for n := int64(1); n <= numParts; n++ {
+		if _, ok := state.Parts[n]; ok {
+			if prog != nil {
+				_, length := partBounds(n)
+				prog.add(length)
+			}
+			continue
+		}
+		pending = append(pending, n)
+	}
+
+	var (
+		mu       sync.Mutex
+		firstErr error
+		wg       sync.WaitGroup
+	)
+	parts := make(chan int64)
+	for i := 0; i < uploader.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := range parts {
+				off, length := partBounds(n)
+				etag, err := uploadPart(ctx, uploader, bucket, key, state.UploadID, f, n, off, length, prog)
+				if err != nil {
+					forgetMissingUpload(err, statePath)
+					err = fmt.Errorf("part %d: %w", n, err)
+				}
+				mu.Lock()
+				if err == nil {
+					state.Parts[n] = etag
+					err = state.save(statePath)
+				}
+				if err != nil && firstErr == nil {
+					firstErr = err
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, n := range pending {
+		mu.Lock()
+		failed := firstErr != nil
+		mu.Unlock()
+		if failed {
+			break
+		}
+		parts <- n
+	}
+	close(parts)
+	wg.Wait()
+	if firstErr != nil {
+		return firstErr
+	}
+
+	completed := make([]*s3.CompletedPart, 0, len(state.Parts))
+	for n, etag := range state.Parts {
+		completed = append(completed, &s3.CompletedPart{
+			PartNumber: aws.Int64(n),
+			ETag:       aws.String(etag),
+		})
+	}
+	sort.Slice(completed, func(i, j int) bool {
+		return *completed[i].PartNumber < *completed[j].PartNumber
+	})
+	if _, err := uploader.S3.CompleteMultipartUploadWithContext(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          bucket,
+		Key:             key,
+		UploadId:        aws.String(state.UploadID),
+		MultipartUpload: &s3.CompletedMultipartUpload{Parts: completed},
+	}); err != nil {
+		forgetMissingUpload(err, statePath)
+		return fmt.Errorf("failed to complete multipart upload: %w", err)
+	}
+	os.Remove(statePath)
+	return nil
+}
+
+// uploadPart reads a part of the file through the progress and
+// bandwidth limits and uploads it, returning its ETag
+func uploadPart(
+	ctx context.Context,
+	uploader *s3manager.Uploader,
+	bucket *string,
+	key *string,
+	uploadID string,
+	f *os.File,
+	n int64,
+	off int64,
+	length int64,
+	prog *progress,
+) (string, error) {
+	var body io.Reader = io.NewSectionReader(f, off, length)
+	if prog != nil {
+		body = &progressReader{r: body, p: prog}
+	}
+	if limiters := fileLimiters(); limiters != nil {
+		body = &limitedReader{r: body, limiters: limiters}
+	}
+	// The body must be seekable to be signed and retried
+	buf := make([]byte, length)
+	if _, err := io.ReadFull(body, buf); err != nil {
+		return "", err
+	}
+	out, err := uploader.S3.UploadPartWithContext(ctx, &s3.UploadPartInput{
+		Bucket:        bucket,
+		Key:           key,
+		UploadId:      aws.String(uploadID),
+		PartNumber:    aws.Int64(n),
+		Body:          bytes.NewReader(buf),
+		ContentLength: aws.Int64(length),
+
+		SSECustomerAlgorithm: sseCustomer.algorithm,
+		SSECustomerKey:       sseCustomer.key,
+		SSECustomerKeyMD5:    sseCustomer.keyMD5,
+	})
+	if err != nil {
+		return "", err
+	}
+	return aws.StringValue(out.ETag), nil
+}
+
+// forgetMissingUpload removes the state file if the upload was
+// aborted or has expired, as it can't be resumed
+func forgetMissingUpload(err error, statePath string) {
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == s3.ErrCodeNoSuchUpload {
+		os.Remove(statePath)
+	}
+}
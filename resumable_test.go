@@ -0,0 +1,77 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestUploadStatePath(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	a, err := uploadStatePath("b", "a.bin", "a.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if same, _ := uploadStatePath("b", "a.bin", "a.bin"); same != a {
+		t.Errorf("the state path changed from %s to %s", a, same)
+	}
+	for _, other := range [][3]string{{"c", "a.bin", "a.bin"}, {"b", "b.bin", "a.bin"}, {"b", "a.bin", "b.bin"}} {
+		if path, _ := uploadStatePath(other[0], other[1], other[2]); path == a {
+			t.Errorf("%q shares the state path %s", other, a)
+		}
+	}
+}
+
+func TestResumableUpload(t *testing.T) {
+	s := newFakeS3(t, "b")
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	path := filepath.Join(t.TempDir(), "large.bin")
+	data := make([]byte, 12<<20)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	s.intercept = func(w http.ResponseWriter, r *http.Request) bool {
+		if r.Method == http.MethodPut && r.URL.Query().Get("partNumber") == "3" {
+			fakeError(w, http.StatusForbidden, "AccessDenied")
+			return true
+		}
+		return false
+	}
+	args := []string{"-resumable-upload", "-part-size", "5242880", "-part-concurrency", "1", "-max-retries", "0", path, "s3://b/large.bin"}
+	if _, err := run(t, args...); err == nil {
+		t.Fatal("expected the upload to be interrupted")
+	}
+	statePath, err := uploadStatePath("b", "large.bin", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state, err := loadUploadState(statePath)
+	if err != nil || state == nil || len(state.Parts) != 2 {
+		t.Fatalf("the state file holds %+v, %v, want 2 parts", state, err)
+	}
+
+	s.intercept = nil
+	if _, err := run(t, args...); err != nil {
+		t.Fatal(err)
+	}
+	sent := make(map[string]int)
+	for _, r := range s.received(http.MethodPut, "partNumber") {
+		sent[r.query.Get("partNumber")]++
+	}
+	if sent["1"] != 1 || sent["2"] != 1 || sent["3"] != 2 {
+		t.Errorf("sent the parts %v, want parts 1 and 2 once", sent)
+	}
+	if got := len(s.received(http.MethodPost, "uploads")); got != 1 {
+		t.Errorf("started %d multipart uploads, want 1", got)
+	}
+	if o := s.object("b", "large.bin"); o == nil || string(o.data) != string(data) {
+		t.Error("the object doesn't hold the file")
+	}
+	if _, err := os.Stat(statePath); !os.IsNotExist(err) {
+		t.Errorf("the state file was left behind: %v", err)
+	}
+}
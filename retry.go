@@ -0,0 +1,58 @@
+package main
+
+import (
+	"math/rand"
+	"time"
+)
+
+// The SDK already retries individual requests. These settings
+// retry a whole transfer, e.g. after a timeout or a connection
+// that broke off halfway through a multipart upload.
+var (
+	maxRetries     int
+	retryBaseDelay time.Duration
+	retryMaxDelay  time.Duration
+)
+
+// sleep is time.Sleep, swapped out where waiting is undesirable.
+var sleep = time.Sleep
+
+// retryDelay returns how long to wait before the given retry,
+// counting from 1. The delay is chosen at random between zero and
+// an exponentially growing ceiling ("full jitter"), so that jobs
+// which failed together don't all retry at the same moment.
+func retryDelay(retry int) time.Duration {
+	ceiling := retryMaxDelay
+	if shift := retry - 1; shift < 32 {
+		if d := retryBaseDelay << uint(shift); d >= 0 && d < ceiling {
+			ceiling = d
+		}
+	}
+	if ceiling <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(ceiling) + 1))
+}
+
+// withRetries runs a job and runs it again up to -max-retries
+// times for as long as it fails, returning the last error.
+func withRetries(job func() error) error {
+	err := job()
+	for retry := 1; err != nil && retry <= maxRetries; retry++ {
+		sleep(retryDelay(retry))
+		err = job()
+	}
+	return err
+}
+
+// withRetriesProgress is withRetries for jobs that count the bytes
+// they transfer into prog. Each attempt counts into its own
+// progress, which is undone when the attempt is retried.
+func withRetriesProgress(prog *progress, job func(*progress) error) error {
+	var attempt *progress
+	return withRetries(func() error {
+		attempt.undo()
+		attempt = prog.attempt()
+		return job(attempt)
+	})
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRetryDelay(t *testing.T) {
+	defer func(base, max time.Duration) { retryBaseDelay, retryMaxDelay = base, max }(retryBaseDelay, retryMaxDelay)
+	retryBaseDelay, retryMaxDelay = time.Second, 10*time.Second
+	for retry, ceiling := range map[int]time.Duration{
+		1:  time.Second,
+		2:  2 * time.Second,
+		3:  4 * time.Second,
+		4:  8 * time.Second,
+		5:  10 * time.Second,
+		64: 10 * time.Second,
+	} {
+		for i := 0; i < 100; i++ {
+			if d := retryDelay(retry); d < 0 || d > ceiling {
+				t.Fatalf("retryDelay(%d) = %s, want at most %s", retry, d, ceiling)
+			}
+		}
+	}
+	retryBaseDelay = 0
+	if d := retryDelay(1); d != 0 {
+		t.Errorf("retryDelay(1) = %s without a base delay", d)
+	}
+}
+
+func TestWithRetries(t *testing.T) {
+	defer func(old int) { maxRetries = old }(maxRetries)
+	defer func(old func(time.Duration)) { sleep = old }(sleep)
+	defer func(base, max time.Duration) { retryBaseDelay, retryMaxDelay = base, max }(retryBaseDelay, retryMaxDelay)
+	retryBaseDelay, retryMaxDelay = time.Second, time.Minute
+	var delays []time.Duration
+	sleep = func(d time.Duration) { delays = append(delays, d) }
+
+	maxRetries = 3
+	attempts := 0
+	err := withRetries(func() error {
+		if attempts++; attempts < 3 {
+			return errors.New("failed")
+		}
+		return nil
+	})
+	if err != nil || attempts != 3 || len(delays) != 2 {
+		t.Fatalf("got %v after %d attempts and %d delays", err, attempts, len(delays))
+	}
+	for i, d := range delays {
+		if ceiling := time.Second << uint(i); d < 0 || d > ceiling {
+			t.Errorf("delay %d is %s, want at most %s", i+1, d, ceiling)
+		}
+	}
+
+	attempts = 0
+	if err := withRetries(func() error { attempts++; return errors.New("failed") }); err == nil || attempts != 4 {
+		t.Errorf("got %v after %d attempts, want an error after 4", err, attempts)
+	}
+}
+
+func TestWithRetriesProgress(t *testing.T) {
+	defer func(old int) { maxRetries = old }(maxRetries)
+	defer func(old func(time.Duration)) { sleep = old }(sleep)
+	sleep = func(time.Duration) {}
+	maxRetries = 2
+	defer func(old int64) { transferredBytes = old }(transferredBytes)
+	transferredBytes = 0
+
+	f, err := os.Create(filepath.Join(t.TempDir(), "f"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	p := &progress{}
+	attempts := 0
+	err = withRetriesProgress(p, func(prog *progress) error {
+		r := &progressReader{r: strings.NewReader("hello"), p: prog}
+		if _, err := io.Copy(io.Discard, r); err != nil {
+			return err
+		}
+		w := &progressWriterAt{w: f, p: prog}
+		if _, err := w.WriteAt([]byte("abc"), 0); err != nil {
+			return err
+		}
+		if attempts++; attempts < 3 {
+			return errors.New("failed")
+		}
+		return nil
+	})
+	if err != nil || attempts != 3 {
+		t.Fatalf("got %v after %d attempts", err, attempts)
+	}
+	if p.bytes != 8 {
+		t.Errorf("counted %d bytes, want those of the last attempt, 8", p.bytes)
+	}
+	if transferredBytes != 24 {
+		t.Errorf("counted %d transferred bytes for -concurrency-auto, want 24", transferredBytes)
+	}
+
+	p = &progress{}
+	err = withRetriesProgress(p, func(prog *progress) error {
+		prog.add(5)
+		return errors.New("failed")
+	})
+	if err == nil || p.bytes != 5 {
+		t.Errorf("got %v with %d bytes counted, want an error with those of the last attempt, 5", err, p.bytes)
+	}
+	if err := withRetriesProgress(nil, func(prog *progress) error { prog.add(1); return nil }); err != nil {
+		t.Error(err)
+	}
+}
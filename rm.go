@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// recursive is set by -r
+var recursive bool
+
+// maxDeleteBatch is the most keys a single DeleteObjects
+// request accepts
+const maxDeleteBatch = 1000
+
+// rm deletes a single object, or with -r or a trailing
+// wildcard every object under the prefix. With -r the key is a
+// directory, so s3://mybucket/logs deletes logs/ but not logs-old/.
+func rm(target string) error {
+	bucket, key, err := splitNameParts(target)
+	if err != nil {
+		return fmt.Errorf("failed to parse '%s': %w", target, err)
+	}
+	s3Client := s3.New(createSession())
+
+	if !recursive && !strings.HasSuffix(key, "*") {
+		if key == "" {
+			return fmt.Errorf("'%s' does not specify a key (use -r to delete everything in the bucket)", target)
+		}
+		if _, err := s3Client.DeleteObject(&s3.DeleteObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+		}); err != nil {
+			return fmt.Errorf("failed to delete '%s': %w", target, err)
+		}
+		logSuccess("delete: %s", target)
+		return nil
+	}
+
+	prefix := strings.TrimSuffix(key, "*")
+	if prefix == key && key != "" && !strings.HasSuffix(key, "/") {
+		prefix += "/"
+	}
+	keys, err := listKeys(s3Client, bucket, prefix)
+	if err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	if err := confirm("delete %d objects from %s", len(keys), target); err != nil {
+		return err
+	}
+	return deleteKeys(s3Client, bucket, keys)
+}
+
+// deleteKeys deletes the keys in batches of up to 1000, running
+// the batches in parallel on the pool and reporting how many
+// objects have been deleted as each batch completes.
+func deleteKeys(s3Client *s3.S3, bucket string, keys []string) error {
+	var batches [][]string
+	for len(keys) > maxDeleteBatch {
+		batches = append(batches, keys[:maxDeleteBatch])
+		keys = keys[maxDeleteBatch:]
+	}
+	if len(keys) > 0 {
+		batches = append(batches, keys)
+	}
+	total := 0
+	for _, batch := range batches {
+		total += len(batch)
+	}
+
+	pool := newJobPool(parallelism, func(payload interface{}) interface{} {
+		return deleteBatch(s3Client, bucket, payload.([]string))
+	})
+	defer pool.Close()
+
+	var (
+		wg      sync.WaitGroup
+		errsMu  sync.Mutex
+		errs    []error
+		deleted int64
+	)
+	for _, batch := range batches {
+		batch := batch
+		startJob(&wg, func() {
+			batchErrs := pool.Process(batch).([]error)
+			n := atomic.AddInt64(&deleted, int64(len(batch)-len(batchErrs)))
+			logSuccess("deleted %d of %d objects", n, total)
+			errsMu.Lock()
+			errs = append(errs, batchErrs...)
+			errsMu.Unlock()
+		})
+	}
+	wg.Wait()
+	for _, err := range errs {
+		logError(err)
+	}
+	if len(errs) > 0 {
+		return &batchError{failed: len(errs), total: total, what: "deletions"}
+	}
+	return nil
+}
+
+// deleteBatch deletes up to 1000 keys with a single request and
+// returns an error for every key that could not be deleted.
+// DeleteObjects reports per-key failures in its response rather
+// than failing the whole request.
+func deleteBatch(s3Client *s3.S3, bucket string, keys []string) []error {
+	objects := make([]*s3.ObjectIdentifier, len(keys))
+	for i, key := range keys {
+		objects[i] = &s3.ObjectIdentifier{Key: aws.String(key)}
+	}
+	out, err := s3Client.DeleteObjects(&s3.DeleteObjectsInput{
+		Bucket: aws.String(bucket),
+		Delete: &s3.Delete{
+			Objects: objects,
+			Quiet:   aws.Bool(true),
+		},
+	})
+	if err != nil {
+		errs := make([]error, len(keys))
+		for i, key := range keys {
+			errs[i] = fmt.Errorf("failed to delete 's3://%s/%s': %w", bucket, key, err)
+		}
+		return errs
+	}
+	var errs []error
+	for _, e := range out.Errors {
+		errs = append(errs, fmt.Errorf(
+			"failed to delete 's3://%s/%s': %s: %s",
+			bucket,
+			aws.StringValue(e.Key),
+			aws.StringValue(e.Code),
+			aws.StringValue(e.Message),
+		))
+	}
+	return errs
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+func TestRmSingleObject(t *testing.T) {
+	s := newFakeS3(t, "b")
+	s.put("b", "a.txt", "a")
+	s.put("b", "a.txt2", "a")
+	if _, err := run(t, "rm", "s3://b/a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(s.keys("b"), " "); got != "a.txt2" {
+		t.Errorf("left %s", got)
+	}
+	if _, err := run(t, "rm", "s3://b/"); err == nil {
+		t.Error("expected an error for a bucket without -r")
+	}
+}
+
+func TestRmRecursiveBatches(t *testing.T) {
+	s := newFakeS3(t, "b")
+	for i := 0; i < 2*maxDeleteBatch+500; i++ {
+		s.put("b", fmt.Sprintf("dir/%05d", i), "")
+	}
+	s.put("b", "other", "")
+	out, err := run(t, "rm", "-r", "s3://b/dir/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(s.keys("b"), " "); got != "other" {
+		t.Errorf("left %d objects", len(s.keys("b")))
+	}
+	if n := len(s.received(http.MethodPost, "delete")); n != 3 {
+		t.Errorf("made %d DeleteObjects requests, want 3", n)
+	}
+	if !strings.Contains(out, "deleted 2500 of 2500 objects") {
+		t.Errorf("rm printed %q", out)
+	}
+}
+
+func TestRmRecursiveKeepsSiblingPrefixes(t *testing.T) {
+	s := newFakeS3(t, "b")
+	s.put("b", "logs/a.txt", "")
+	s.put("b", "logs/sub/b.txt", "")
+	s.put("b", "logs-old/c.txt", "")
+	s.put("b", "logs.txt", "")
+	if _, err := run(t, "rm", "-r", "s3://b/logs"); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(s.keys("b"), " "); got != "logs-old/c.txt logs.txt" {
+		t.Errorf("left %s", got)
+	}
+}
+
+func TestRmWildcard(t *testing.T) {
+	s := newFakeS3(t, "b")
+	s.put("b", "log-1", "")
+	s.put("b", "log-2", "")
+	s.put("b", "data", "")
+	if _, err := run(t, "rm", "s3://b/log-*"); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(s.keys("b"), " "); got != "data" {
+		t.Errorf("left %s", got)
+	}
+}
+
+func TestRmPartialFailure(t *testing.T) {
+	s := newFakeS3(t, "b")
+	for _, key := range []string{"a", "b", "c", "d"} {
+		s.put("b", key, "")
+	}
+	s.undeletable = map[string]bool{"b": true, "d": true}
+	_, err := run(t, "rm", "-r", "s3://b")
+	if code := exitCode(err); code != exitPartial {
+		t.Fatalf("exited with %d (%v), want %d", code, err, exitPartial)
+	}
+	if err.Error() != "2 of 4 deletions failed" {
+		t.Errorf("error is %q", err)
+	}
+	if got := strings.Join(s.keys("b"), " "); got != "b d" {
+		t.Errorf("left %s", got)
+	}
+}
+
+func TestDeleteBatchErrors(t *testing.T) {
+	s := newFakeS3(t, "b")
+	s.put("b", "a", "")
+	s.put("b", "b", "")
+	s.undeletable = map[string]bool{"b": true}
+	client := s3.New(createSession())
+	errs := deleteBatch(client, "b", []string{"a", "b"})
+	if len(errs) != 1 || errs[0].Error() != "failed to delete 's3://b/b': AccessDenied: Access Denied" {
+		t.Errorf("got %v", errs)
+	}
+	// A failed request fails every key
+	errs = deleteBatch(client, "missing", []string{"a", "b", "c"})
+	if len(errs) != 3 || !strings.Contains(errs[2].Error(), "failed to delete 's3://missing/c': NoSuchBucket") {
+		t.Errorf("got %v", errs)
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/Jeffail/tunny"
+)
+
+// singleThreaded runs jobs one after another on the calling
+// goroutine, in the order they are found, without a pool. This
+// makes the output deterministic and stack traces short, which
+// helps when debugging.
+var singleThreaded bool
+
+// jobPool processes the payloads of jobs
+type jobPool interface {
+	Process(payload interface{}) interface{}
+	Close()
+}
+
+// serialPool processes payloads on the calling goroutine
+type serialPool func(payload interface{}) interface{}
+
+func (p serialPool) Process(payload interface{}) interface{} {
+	return p(payload)
+}
+
+func (p serialPool) Close() {}
+
+// newJobPool returns a pool of the given size running fn, or fn
+// itself with -single-threaded
+func newJobPool(size int, fn func(payload interface{}) interface{}) jobPool {
+	if singleThreaded {
+		return serialPool(fn)
+	}
+	return tunny.NewFunc(size, fn)
+}
+
+// startJob runs job in a goroutine of its own once jobThrottle
+// lets it start, adding it to wg. Acquiring before spawning the
+// goroutine bounds the number of goroutines along with the
+// transfers. With -single-threaded the job is run right away
+// instead, and has finished when startJob returns.
+func startJob(wg *sync.WaitGroup, job func()) {
+	if singleThreaded {
+		job()
+		return
+	}
+	jobThrottle.acquire()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer jobThrottle.release()
+		job()
+	}()
+}
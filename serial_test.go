@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSerialPool(t *testing.T) {
+	defer func(old bool) { singleThreaded = old }(singleThreaded)
+	singleThreaded = true
+	pool := newJobPool(4, func(payload interface{}) interface{} { return payload.(int) * 2 })
+	defer pool.Close()
+	if _, ok := pool.(serialPool); !ok {
+		t.Fatalf("-single-threaded uses a %T", pool)
+	}
+	if got := pool.Process(21); got != 42 {
+		t.Errorf("processed 21 into %v", got)
+	}
+	var wg sync.WaitGroup
+	var order []int
+	for i := 0; i < 5; i++ {
+		i := i
+		startJob(&wg, func() { order = append(order, i) })
+		if len(order) != i+1 {
+			t.Fatalf("job %d hadn't run when startJob returned", i)
+		}
+	}
+	wg.Wait()
+	if fmt.Sprint(order) != "[0 1 2 3 4]" {
+		t.Errorf("ran the jobs in the order %v", order)
+	}
+}
+
+func TestSingleThreadedUpload(t *testing.T) {
+	s := newFakeS3(t, "b")
+	var inFlight, maxInFlight int32
+	s.intercept = func(w http.ResponseWriter, r *http.Request) bool {
+		if r.Method != http.MethodPut {
+			return false
+		}
+		n := atomic.AddInt32(&inFlight, 1)
+		defer atomic.AddInt32(&inFlight, -1)
+		if n > atomic.LoadInt32(&maxInFlight) {
+			atomic.StoreInt32(&maxInFlight, n)
+		}
+		time.Sleep(5 * time.Millisecond)
+		if strings.HasSuffix(r.URL.Path, "/f3.txt") {
+			fakeError(w, http.StatusForbidden, "AccessDenied")
+			return true
+		}
+		return false
+	}
+	dir := t.TempDir()
+	files := make(map[string]string)
+	for i := 0; i < 8; i++ {
+		files[fmt.Sprintf("f%d.txt", i)] = "x"
+	}
+	writeFiles(t, dir, files)
+	summaryPath := filepath.Join(t.TempDir(), "summary.json")
+	out, err := run(t, "-single-threaded", "-max-retries", "0", "-summary-out", summaryPath, dir, "s3://b/")
+	if code := exitCode(err); code != exitPartial {
+		t.Errorf("exited with %d (%v), want %d", code, err, exitPartial)
+	}
+	if maxInFlight != 1 {
+		t.Errorf("uploaded %d files at once", maxInFlight)
+	}
+	var keys []string
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		if i := strings.LastIndex(line, "s3://b/"); strings.HasPrefix(line, "upload: ") && i >= 0 {
+			keys = append(keys, line[i+len("s3://b/"):])
+		}
+	}
+	if got, want := strings.Join(keys, " "), "f0.txt f1.txt f2.txt f4.txt f5.txt f6.txt f7.txt"; got != want {
+		t.Errorf("uploaded %s, want %s in order", got, want)
+	}
+	var summary runSummary
+	if err := json.Unmarshal([]byte(readFile(t, summaryPath)), &summary); err != nil {
+		t.Fatal(err)
+	}
+	if summary.Files != 8 || summary.Failed != 1 {
+		t.Errorf("counted %d files and %d failures", summary.Files, summary.Failed)
+	}
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bytes"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+// e.g. "us-east-1"
+var region string
+
+// defaultRegion is used when the region is specified neither
+// by the -region flag nor by the environment.
+const defaultRegion = "us-east-1"
+
+// e.g. "nyc3.digitaloceanspaces.com"
+var endpoint string
+
+var forcePathStyle bool
+
+// path to a PEM encoded CA bundle
+var caBundle string
+
+// caBundlePEM is the content of the -ca-bundle, or of the file
+// named by AWS_CA_BUNDLE, read by loadCABundle. It is nil if
+// neither is given.
+var caBundlePEM []byte
+
+// loadCABundle reads the CA bundle up front, so that a missing or
+// invalid file is reported as such instead of failing to create
+// the session. The SDK reads AWS_CA_BUNDLE by itself, but only if
+// no bundle is passed in explicitly, so that is read here as well.
+func loadCABundle() error {
+	path, name := caBundle, "-ca-bundle"
+	if path == "" {
+		path, name = os.Getenv("AWS_CA_BUNDLE"), "AWS_CA_BUNDLE"
+	}
+	if path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", name, err)
+	}
+	if !x509.NewCertPool().AppendCertsFromPEM(data) {
+		return fmt.Errorf("%s '%s' contains no PEM encoded certificates", name, path)
+	}
+	caBundlePEM = data
+	return nil
+}
+
+// httpTimeout bounds each HTTP request to S3 from connecting to
+// reading the end of the response. Zero means no limit.
+var httpTimeout time.Duration
+
+// maxConnsPerHost limits the connections to each host, all of
+// which are kept for reuse. Zero doesn't limit them and keeps as
+// many as -parallelism and -part-concurrency together allow
+// concurrent requests.
+var maxConnsPerHost int
+
+// idleConnsPerHost returns the size of the connection pool. Go
+// keeps only two idle connections per host by default, so with
+// more concurrent requests than that connections are constantly
+// closed and reopened.
+func idleConnsPerHost() int {
+	if maxConnsPerHost > 0 {
+		return maxConnsPerHost
+	}
+	n := parallelism
+	if partConcurrency > 1 {
+		n *= partConcurrency
+	}
+	return n
+}
+
+// newHTTPClient returns a client with a connection pool sized by
+// idleConnsPerHost and limited by -max-conns-per-host, which gives
+// up on requests after -http-timeout, so a hung connection fails
+// and can be retried instead of blocking the transfer forever.
+func newHTTPClient() *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = idleConnsPerHost()
+	transport.MaxConnsPerHost = maxConnsPerHost
+	if transport.MaxIdleConns < transport.MaxIdleConnsPerHost {
+		transport.MaxIdleConns = transport.MaxIdleConnsPerHost
+	}
+	if httpTimeout > 0 {
+		transport.DialContext = (&net.Dialer{
+			Timeout:   httpTimeout,
+			KeepAlive: 30 * time.Second,
+		}).DialContext
+		transport.TLSHandshakeTimeout = httpTimeout
+		transport.ResponseHeaderTimeout = httpTimeout
+	}
+	return &http.Client{
+		Timeout:   httpTimeout,
+		Transport: transport,
+	}
+}
+
+// requestPayer is "requester" to acknowledge that reads from
+// requester pays buckets are charged to the caller. Such buckets
+// reject reads without it.
+var requestPayer string
+
+// passedFlags holds the names of the flags that were given on
+// the command line, as opposed to holding their defaults
+var passedFlags = make(map[string]bool)
+
+// recordPassedFlag is called by flag.FlagSet.Visit after parsing
+func recordPassedFlag(f *flag.Flag) {
+	passedFlags[f.Name] = true
+}
+
+// isFlagPassed reports whether the named flag was explicitly
+// given on the command line.
+func isFlagPassed(name string) bool {
+	return passedFlags[name]
+}
+
+// firstEnv returns the value of the first of the named
+// environment variables that is set and not empty.
+func firstEnv(names ...string) string {
+	for _, name := range names {
+		if value := os.Getenv(name); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// resolveRegion returns the region given by the -region flag,
+// falling back to AWS_REGION and then AWS_DEFAULT_REGION the
+// same way the AWS CLI does.
+func resolveRegion() string {
+	if region != "" {
+		return region
+	}
+	if value := firstEnv("AWS_REGION", "AWS_DEFAULT_REGION"); value != "" {
+		return value
+	}
+	return defaultRegion
+}
+
+// explicitEndpoint returns the endpoint given by the -endpoint
+// flag, falling back to AWS_ENDPOINT_URL and then AWS_S3_ENDPOINT.
+func explicitEndpoint() string {
+	if endpoint != "" {
+		return endpoint
+	}
+	return firstEnv("AWS_ENDPOINT_URL", "AWS_S3_ENDPOINT")
+}
+
+// resolveEndpoint returns the endpoint to use along with the
+// region to sign requests for. Without an explicit endpoint, both
+// are derived from the region by the -provider preset. An empty
+// endpoint leaves it to the SDK to pick the AWS endpoint.
+func resolveEndpoint() (string, string) {
+	if value := explicitEndpoint(); value != "" {
+		return value, signingRegionFor(value)
+	}
+	preset := providers[provider]
+	signingRegion := preset.signingRegion
+	if signingRegion == "" {
+		signingRegion = resolveRegion()
+	}
+	return strings.ReplaceAll(preset.endpoint, "{region}", resolveRegion()), signingRegion
+}
+
+// signingRegionFor returns the region to sign requests to an
+// explicit endpoint for. Unless a region is given, it is inferred
+// from the endpoint where that matches a -provider preset, as some
+// providers sign with a region other than the datacenter in their
+// host name.
+func signingRegionFor(endpoint string) string {
+	if region == "" && firstEnv("AWS_REGION", "AWS_DEFAULT_REGION") == "" {
+		if inferred := regionFromEndpoint(endpoint); inferred != "" {
+			return inferred
+		}
+	}
+	return resolveRegion()
+}
+
+// resolveForcePathStyle returns the -force-path-style flag if
+// it was given and AWS_S3_FORCE_PATH_STYLE otherwise.
+func resolveForcePathStyle() bool {
+	if isFlagPassed("force-path-style") {
+		return forcePathStyle
+	}
+	value, _ := strconv.ParseBool(os.Getenv("AWS_S3_FORCE_PATH_STYLE"))
+	return value
+}
+
+// newCredentials returns the credentials of the named shared
+// config profile, or those in the environment if it's empty
+func newCredentials(profile string) *credentials.Credentials {
+	if profile != "" {
+		return credentials.NewSharedCredentials("", profile)
+	}
+	return credentials.NewEnvCredentials()
+}
+
+// checkCredentials fails with a list of the places credentials
+// are read from if the session for the profile has none, rather
+// than leaving it to the SDK to fail once the first request is
+// made
+func checkCredentials(profile string) error {
+	sess := createSessionFor("", profile)
+	if _, err := sess.Config.Credentials.Get(); err != nil {
+		if profile != "" {
+			return fmt.Errorf("no credentials found for profile '%s' in the shared credentials file (AWS_SHARED_CREDENTIALS_FILE or ~/.aws/credentials): %w", profile, err)
+		}
+		return fmt.Errorf("no credentials found: set AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY (or AWS_ACCESS_KEY and AWS_SECRET_KEY) in the environment, or use -src-profile and -dst-profile to copy with shared config profiles: %w", err)
+	}
+	return nil
+}
+
+// createSession creates a session for the default endpoint
+// using credentials from the environment.
+func createSession() *session.Session {
+	return createSessionFor("", "")
+}
+
+// createSessionFor creates a session against the given endpoint,
+// using the credentials of the named shared config profile.
+// Empty values select the default endpoint and the environment
+// credentials respectively.
+func createSessionFor(endpoint string, profile string) *session.Session {
+	var signingRegion string
+	if endpoint == "" {
+		endpoint, signingRegion = resolveEndpoint()
+	} else {
+		signingRegion = signingRegionFor(endpoint)
+	}
+	var opts session.Options
+	if caBundlePEM != nil {
+		opts.CustomCABundle = bytes.NewReader(caBundlePEM)
+	}
+	// When an endpoint is given, the region is only used to sign
+	// requests. The SDK neither derives the host from the region
+	// nor the region from the host, so providers whose signing
+	// region differs from the datacenter in their host name work
+	// as expected, e.g.
+	//
+	//     -endpoint https://fra1.digitaloceanspaces.com -region us-east-1
+	//
+	// For the hosts of the -provider presets the region is
+	// inferred, so that -region can be left out above.
+	opts.Config = aws.Config{
+		Region:           aws.String(signingRegion),
+		Endpoint:         optionalString(endpoint),
+		S3ForcePathStyle: aws.Bool(resolveForcePathStyle() || signatureVersion == signatureV2),
+		// Requests to an access point go to the region in its ARN
+		S3UseARNRegion: aws.Bool(true),
+	}
+	opts.Config.HTTPClient = newHTTPClient()
+	opts.Config.Credentials = newCredentials(profile)
+	sess := session.Must(session.NewSessionWithOptions(opts))
+	sess.Handlers.AfterRetry.PushFront(observeThrottling)
+	sess.Handlers.Send.PushFront(countRetries)
+	sess.Handlers.AfterRetry.PushBack(explainRegionRedirect)
+	if len(customHeaders) > 0 {
+		sess.Handlers.Build.PushBack(addCustomHeaders)
+	}
+	if expectedBucketOwner != "" {
+		sess.Handlers.Build.PushBack(addExpectedBucketOwner)
+	}
+	if signatureVersion == signatureV2 {
+		useSignatureV2(sess)
+	}
+	return sess
+}
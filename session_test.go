@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestResolveRegion(t *testing.T) {
+	for _, tt := range []struct {
+		name          string
+		flag          string
+		awsRegion     string
+		defaultRegion string
+		want          string
+	}{
+		{"default", "", "", "", defaultRegion},
+		{"AWS_DEFAULT_REGION", "", "", "eu-west-1", "eu-west-1"},
+		{"AWS_REGION before AWS_DEFAULT_REGION", "", "eu-central-1", "eu-west-1", "eu-central-1"},
+		{"flag before environment", "ap-south-1", "eu-central-1", "eu-west-1", "ap-south-1"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("AWS_REGION", tt.awsRegion)
+			t.Setenv("AWS_DEFAULT_REGION", tt.defaultRegion)
+			defer func(old string) { region = old }(region)
+			region = tt.flag
+			if got := resolveRegion(); got != tt.want {
+				t.Errorf("resolveRegion() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExplicitEndpoint(t *testing.T) {
+	for _, tt := range []struct {
+		flag, endpointURL, s3Endpoint, want string
+	}{
+		{"", "", "", ""},
+		{"", "", "http://s3.local", "http://s3.local"},
+		{"", "http://a.local", "http://s3.local", "http://a.local"},
+		{"http://flag.local", "http://a.local", "http://s3.local", "http://flag.local"},
+	} {
+		t.Setenv("AWS_ENDPOINT_URL", tt.endpointURL)
+		t.Setenv("AWS_S3_ENDPOINT", tt.s3Endpoint)
+		endpoint = tt.flag
+		if got := explicitEndpoint(); got != tt.want {
+			t.Errorf("explicitEndpoint() = %q, want %q", got, tt.want)
+		}
+	}
+	endpoint = ""
+}
+
+func TestResolveForcePathStyle(t *testing.T) {
+	defer func() { passedFlags = make(map[string]bool) }()
+	t.Setenv("AWS_S3_FORCE_PATH_STYLE", "true")
+	passedFlags = make(map[string]bool)
+	if !resolveForcePathStyle() {
+		t.Error("AWS_S3_FORCE_PATH_STYLE=true was ignored")
+	}
+	passedFlags["force-path-style"] = true
+	forcePathStyle = false
+	if resolveForcePathStyle() {
+		t.Error("-force-path-style=false didn't override the environment")
+	}
+}
+
+func TestCABundle(t *testing.T) {
+	s := newFakeS3(t, "b")
+	s.put("b", "a.txt", "hello")
+	srv := httptest.NewTLSServer(http.HandlerFunc(s.serve))
+	defer srv.Close()
+	bundle := filepath.Join(t.TempDir(), "ca.pem")
+	cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	if err := os.WriteFile(bundle, cert, 0644); err != nil {
+		t.Fatal(err)
+	}
+	dest := filepath.Join(t.TempDir(), "a.txt")
+
+	t.Setenv("AWS_ENDPOINT_URL", srv.URL)
+	if _, err := run(t, "s3://b/a.txt", dest); err == nil {
+		t.Fatal("expected the server's certificate to be untrusted without a CA bundle")
+	}
+	t.Setenv("AWS_CA_BUNDLE", bundle)
+	if _, err := run(t, "s3://b/a.txt", dest); err != nil {
+		t.Fatalf("AWS_CA_BUNDLE: %v", err)
+	}
+	t.Setenv("AWS_CA_BUNDLE", "")
+	if _, err := run(t, "-ca-bundle", bundle, "s3://b/a.txt", dest); err != nil {
+		t.Fatalf("-ca-bundle: %v", err)
+	}
+}
+
+func TestInvalidCABundle(t *testing.T) {
+	newFakeS3(t, "b")
+	dir := t.TempDir()
+	invalid := filepath.Join(dir, "invalid.pem")
+	if err := os.WriteFile(invalid, []byte("not a certificate"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, bundle := range []string{filepath.Join(dir, "missing.pem"), invalid} {
+		_, err := run(t, "-ca-bundle", bundle, "s3://b/a.txt", dir)
+		if code := exitCode(err); code != exitUsage {
+			t.Errorf("-ca-bundle %s exited with %d (%v), want %d", bundle, code, err, exitUsage)
+		}
+	}
+}
+
+func TestSigningRegionIndependentOfEndpoint(t *testing.T) {
+	s := newFakeS3(t, "b")
+	t.Setenv("AWS_REGION", "")
+	t.Setenv("AWS_DEFAULT_REGION", "")
+	if _, err := run(t, "ls", "-region", "fra1", "s3://b"); err != nil {
+		t.Fatal(err)
+	}
+	requests := s.received(http.MethodGet, "")
+	if len(requests) == 0 {
+		t.Fatal("no request reached the endpoint")
+	}
+	if auth := requests[0].header.Get("Authorization"); !strings.Contains(auth, "/fra1/s3/aws4_request") {
+		t.Errorf("request signed as %q, want the region fra1", auth)
+	}
+}
+
+func TestHTTPTimeout(t *testing.T) {
+	defer func(old time.Duration) { httpTimeout = old }(httpTimeout)
+	httpTimeout = 0
+	if client := newHTTPClient(); client.Timeout != 0 {
+		t.Errorf("client times out after %s without -http-timeout", client.Timeout)
+	}
+	httpTimeout = time.Minute
+	client := newHTTPClient()
+	if client.Timeout != time.Minute {
+		t.Errorf("client times out after %s, want 1m", client.Timeout)
+	}
+	if transport := client.Transport.(*http.Transport); transport.ResponseHeaderTimeout != time.Minute || transport.TLSHandshakeTimeout != time.Minute {
+		t.Errorf("transport times out after %s waiting for headers and %s for TLS, want 1m",
+			transport.ResponseHeaderTimeout, transport.TLSHandshakeTimeout)
+	}
+}
+
+func TestHTTPTimeoutFailsHungRequests(t *testing.T) {
+	s := newFakeS3(t, "b")
+	s.put("b", "a.txt", "hello")
+	s.intercept = func(w http.ResponseWriter, r *http.Request) bool {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+		return true
+	}
+	started := time.Now()
+	_, err := run(t, "-http-timeout", "200ms", "-max-retries", "0", "s3://b/a.txt", filepath.Join(t.TempDir(), "a.txt"))
+	if err == nil {
+		t.Fatal("expected the hung request to fail")
+	}
+	if elapsed := time.Since(started); elapsed > 4*time.Second {
+		t.Errorf("the request failed after %s despite the timeout", elapsed)
+	}
+}
+
+func TestMaxConnsPerHost(t *testing.T) {
+	defer func(c, p, pc int) { maxConnsPerHost, parallelism, partConcurrency = c, p, pc }(maxConnsPerHost, parallelism, partConcurrency)
+	for _, tt := range []struct {
+		flag, parallelism, partConcurrency int
+		want                               int
+	}{
+		{0, 10, 1, 10},
+		{0, 10, 5, 50},
+		{0, 200, 1, 200},
+		{32, 10, 5, 32},
+	} {
+		maxConnsPerHost, parallelism, partConcurrency = tt.flag, tt.parallelism, tt.partConcurrency
+		transport := newHTTPClient().Transport.(*http.Transport)
+		if transport.MaxIdleConnsPerHost != tt.want {
+			t.Errorf("-max-conns-per-host %d -parallelism %d -part-concurrency %d keeps %d connections, want %d",
+				tt.flag, tt.parallelism, tt.partConcurrency, transport.MaxIdleConnsPerHost, tt.want)
+		}
+		if transport.MaxConnsPerHost != tt.flag {
+			t.Errorf("-max-conns-per-host %d allows %d connections", tt.flag, transport.MaxConnsPerHost)
+		}
+		if transport.MaxIdleConns < transport.MaxIdleConnsPerHost {
+			t.Errorf("keeps %d idle connections in total, fewer than %d per host", transport.MaxIdleConns, transport.MaxIdleConnsPerHost)
+		}
+	}
+	_, err := run(t, "-max-conns-per-host", "-1", "ls", "s3://b")
+	if code := exitCode(err); code != exitUsage {
+		t.Errorf("-max-conns-per-host -1 exited with %d (%v), want %d", code, err, exitUsage)
+	}
+}
+
+func TestCheckCredentials(t *testing.T) {
+	for _, name := range []string{"AWS_ACCESS_KEY_ID", "AWS_ACCESS_KEY", "AWS_SECRET_ACCESS_KEY", "AWS_SECRET_KEY"} {
+		t.Setenv(name, "")
+	}
+	shared := filepath.Join(t.TempDir(), "credentials")
+	if err := os.WriteFile(shared, []byte("[backup]\naws_access_key_id = AKIDTEST\naws_secret_access_key = secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", shared)
+
+	err := checkCredentials("")
+	if err == nil || !strings.Contains(err.Error(), "AWS_ACCESS_KEY_ID") || !strings.Contains(err.Error(), "-src-profile") ||
+		!strings.Contains(err.Error(), "EnvAccessKeyNotFound") {
+		t.Errorf("without credentials got %v", err)
+	}
+	if err := checkCredentials("backup"); err != nil {
+		t.Errorf("profile backup: %v", err)
+	}
+	if err := checkCredentials("missing"); err == nil || !strings.Contains(err.Error(), "profile 'missing'") {
+		t.Errorf("a missing profile got %v", err)
+	}
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDTEST")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	if err := checkCredentials(""); err != nil {
+		t.Errorf("credentials in the environment: %v", err)
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+// metadataSidecar writes the attributes of each downloaded object
+// next to the file as <file>.meta.json, so that they survive a
+// round trip through the local filesystem
+var metadataSidecar bool
+
+// restoreSidecar uploads files with the attributes in their
+// sidecars, e.g. to migrate a bucket by way of a local copy. The
+// sidecars themselves aren't uploaded.
+var restoreSidecar bool
+
+// sidecarSuffix is appended to a file's name for its sidecar
+const sidecarSuffix = ".meta.json"
+
+// skipSidecar is the reason sidecars aren't uploaded
+const skipSidecar = "metadata sidecar"
+
+// objectSidecar is the content of a sidecar file. The ACL is in
+// the format of -acl-from-file, without an owner so that the
+// owner of the new object is kept.
+type objectSidecar struct {
+	ContentType  string            `json:"content_type,omitempty"`
+	Metadata     map[string]string `json:"metadata,omitempty"`
+	ETag         string            `json:"etag,omitempty"`
+	StorageClass string            `json:"storage_class,omitempty"`
+	ACL          *aclFileContents  `json:"acl,omitempty"`
+}
+
+// newObjectSidecar records the attributes of an object, reading
+// its ACL in another request. S3 leaves out the storage class of
+// STANDARD objects.
+func newObjectSidecar(
+	ctx context.Context,
+	client s3iface.S3API,
+	bucket *string,
+	key *string,
+	head *s3.HeadObjectOutput,
+) (*objectSidecar, error) {
+	acl, err := client.GetObjectAclWithContext(ctx, &s3.GetObjectAclInput{
+		Bucket:       bucket,
+		Key:          key,
+		VersionId:    optionalString(versionID),
+		RequestPayer: optionalString(requestPayer),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get ACL of '%s': %w", *key, err)
+	}
+	sidecar := &objectSidecar{
+		ContentType:  aws.StringValue(head.ContentType),
+		Metadata:     aws.StringValueMap(head.Metadata),
+		ETag:         aws.StringValue(head.ETag),
+		StorageClass: aws.StringValue(head.StorageClass),
+		ACL:          &aclFileContents{},
+	}
+	for _, g := range acl.Grants {
+		sidecar.ACL.Grants = append(sidecar.ACL.Grants, fromGrant(g))
+	}
+	return sidecar, nil
+}
+
+// writeSidecar writes the sidecar of the file at path
+func writeSidecar(path string, sidecar *objectSidecar) error {
+	data, err := json.MarshalIndent(sidecar, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path+sidecarSuffix, append(data, '\n'), 0644)
+}
+
+// loadSidecar reads the sidecar of the file at path, returning
+// nil if it has none
+func loadSidecar(path string) (*objectSidecar, error) {
+	data, err := os.ReadFile(path + sidecarSuffix)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	sidecar := &objectSidecar{}
+	if err := json.Unmarshal(data, sidecar); err != nil {
+		return nil, fmt.Errorf("invalid sidecar '%s': %w", path+sidecarSuffix, err)
+	}
+	return sidecar, nil
+}
+
+// isSidecar reports whether the file at path is the sidecar of
+// another file
+func isSidecar(path string) bool {
+	if !strings.HasSuffix(path, sidecarSuffix) {
+		return false
+	}
+	_, err := os.Stat(strings.TrimSuffix(path, sidecarSuffix))
+	return err == nil
+}
+
+// applySidecar sets the content type, metadata and storage class
+// of an upload from the file's sidecar. Flags given explicitly
+// take precedence, as does the metadata s3util sets itself.
+func applySidecar(input *s3manager.UploadInput, sourcePath string) error {
+	sidecar, err := loadSidecar(sourcePath)
+	if err != nil || sidecar == nil {
+		return err
+	}
+	if contentType == "" && sidecar.ContentType != "" {
+		input.ContentType = aws.String(sidecar.ContentType)
+	}
+	if storageClass == "" && sidecar.StorageClass != "" {
+		input.StorageClass = aws.String(sidecar.StorageClass)
+	}
+	if len(sidecar.Metadata) > 0 {
+		metadata := aws.StringMap(sidecar.Metadata)
+		for name, value := range input.Metadata {
+			metadata[name] = value
+		}
+		input.Metadata = metadata
+	}
+	return nil
+}
+
+// sidecarACL returns the ACL in the file's sidecar, or nil if it
+// has none
+func sidecarACL(sourcePath string) (*s3.AccessControlPolicy, error) {
+	sidecar, err := loadSidecar(sourcePath)
+	if err != nil || sidecar == nil || sidecar.ACL == nil || len(sidecar.ACL.Grants) == 0 {
+		return nil, err
+	}
+	policy, err := sidecar.ACL.toPolicy()
+	if err != nil {
+		return nil, fmt.Errorf("invalid ACL in sidecar '%s': %w", sourcePath+sidecarSuffix, err)
+	}
+	return policy, nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// readerACL grants a canonical user read access, besides the owner
+const readerACL = `<AccessControlPolicy><Owner><ID>` + fakeOwner + `</ID></Owner><AccessControlList>` +
+	`<Grant><Grantee xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xsi:type="CanonicalUser"><ID>` + fakeOwner + `</ID></Grantee><Permission>FULL_CONTROL</Permission></Grant>` +
+	`<Grant><Grantee xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xsi:type="CanonicalUser"><ID>reader</ID></Grantee><Permission>READ</Permission></Grant>` +
+	`</AccessControlList></AccessControlPolicy>`
+
+func TestDownloadMetadataSidecar(t *testing.T) {
+	s := newFakeS3(t, "b")
+	s.put("b", "report.csv", "a,b",
+		"Content-Type", "text/csv",
+		"X-Amz-Meta-Owner", "finance",
+		"X-Amz-Storage-Class", "STANDARD_IA")
+	s.object("b", "report.csv").acl = []byte(readerACL)
+	dir := t.TempDir()
+	if _, err := run(t, "-with-metadata-sidecar", "s3://b/report.csv", dir); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "report.csv.meta.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sidecar objectSidecar
+	if err := json.Unmarshal(data, &sidecar); err != nil {
+		t.Fatal(err)
+	}
+	if sidecar.ContentType != "text/csv" || sidecar.Metadata["Owner"] != "finance" ||
+		sidecar.StorageClass != "STANDARD_IA" || sidecar.ETag != s.object("b", "report.csv").etag {
+		t.Errorf("the sidecar holds %s", data)
+	}
+	if sidecar.ACL == nil || len(sidecar.ACL.Grants) != 2 || sidecar.ACL.Owner != nil ||
+		sidecar.ACL.Grants[1] != (aclFileGrant{aclFileGrantee{Type: "CanonicalUser", ID: "reader"}, "READ"}) {
+		t.Errorf("the sidecar holds the ACL %+v", sidecar.ACL)
+	}
+	if got := readFile(t, filepath.Join(dir, "report.csv")); got != "a,b" {
+		t.Errorf("downloaded %q", got)
+	}
+
+	if _, err := run(t, "s3://b/report.csv", filepath.Join(dir, "plain.csv")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "plain.csv.meta.json")); !os.IsNotExist(err) {
+		t.Errorf("a sidecar was written without -with-metadata-sidecar: %v", err)
+	}
+}
+
+func TestMetadataSidecarRoundTrip(t *testing.T) {
+	s := newFakeS3(t, "b", "c")
+	s.put("b", "report.csv", "a,b",
+		"Content-Type", "text/csv",
+		"X-Amz-Meta-Owner", "finance",
+		"X-Amz-Storage-Class", "STANDARD_IA")
+	s.object("b", "report.csv").acl = []byte(readerACL)
+	s.put("b", "notes.txt", "notes", "Content-Type", "text/x-notes")
+	dir := t.TempDir()
+	if _, err := run(t, "-with-metadata-sidecar", "s3://b/*", dir); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := run(t, "-from-metadata-sidecar", dir, "s3://c/"); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(s.keys("c"), " "); got != "notes.txt report.csv" {
+		t.Fatalf("uploaded %s, want the sidecars left out", got)
+	}
+	for _, key := range []string{"report.csv", "notes.txt"} {
+		src, dst := s.object("b", key), s.object("c", key)
+		for _, name := range []string{"Content-Type", "X-Amz-Meta-Owner", "X-Amz-Storage-Class"} {
+			if got, want := dst.header.Get(name), src.header.Get(name); got != want {
+				t.Errorf("%s has the %s %q, want %q", key, name, got, want)
+			}
+		}
+		if string(dst.data) != string(src.data) {
+			t.Errorf("%s holds %q", key, dst.data)
+		}
+	}
+	if acl := string(s.object("c", "report.csv").acl); !strings.Contains(acl, "<ID>reader</ID>") {
+		t.Errorf("report.csv has the ACL %s", acl)
+	}
+
+	if _, err := run(t, "-from-metadata-sidecar", "-content-type", "text/plain", filepath.Join(dir, "report.csv"), "s3://c/flag.csv"); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.object("c", "flag.csv").header.Get("Content-Type"); got != "text/plain" {
+		t.Errorf("-content-type was overridden by the sidecar with %q", got)
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/corehandlers"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+// signatureVersion selects how requests are signed. Some older
+// S3 compatible servers only understand v2, which AWS itself has
+// long stopped accepting in most regions.
+var signatureVersion string
+
+const (
+	signatureV2 = "v2"
+	signatureV4 = "v4"
+)
+
+// checkSignatureVersion returns an error if -signature-version
+// is unknown
+func checkSignatureVersion() error {
+	switch signatureVersion {
+	case "", signatureV2, signatureV4:
+		return nil
+	}
+	return fmt.Errorf("invalid -signature-version '%s' (expected v2 or v4)", signatureVersion)
+}
+
+// useSignatureV2 makes the session's requests signed with v2. The
+// S3 client always appends the v4 signer to the handlers it copies
+// from the session, so instead of replacing it the v4 signature
+// is replaced right before each request is sent.
+func useSignatureV2(sess *session.Session) {
+	sess.Handlers.Send.RemoveByName(corehandlers.SendHandler.Name)
+	sess.Handlers.Send.PushBack(signV2)
+	sess.Handlers.Send.PushBackNamed(corehandlers.SendHandler)
+}
+
+// signV2 is a send handler signing a request the way S3 expects
+// with signature version 2, see
+// https://docs.aws.amazon.com/AmazonS3/latest/userguide/RESTAuthentication.html
+func signV2(r *request.Request) {
+	if r.Config.Credentials == credentials.AnonymousCredentials {
+		return
+	}
+	creds, err := r.Config.Credentials.GetWithContext(r.Context())
+	if err != nil {
+		r.Error = err
+		return
+	}
+	header := r.HTTPRequest.Header
+	header.Del("Authorization")
+	header.Del("X-Amz-Date")
+	header.Del("X-Amz-Content-Sha256")
+	header.Del("X-Amz-Security-Token")
+	if creds.SessionToken != "" {
+		header.Set("X-Amz-Security-Token", creds.SessionToken)
+	}
+	header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+
+	mac := hmac.New(sha1.New, []byte(creds.SecretAccessKey))
+	mac.Write([]byte(stringToSignV2(r.HTTPRequest)))
+	signature := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	header.Set("Authorization", "AWS "+creds.AccessKeyID+":"+signature)
+}
+
+// stringToSignV2 returns what a request's v2 signature covers
+func stringToSignV2(req *http.Request) string {
+	var b strings.Builder
+	b.WriteString(req.Method + "\n")
+	b.WriteString(req.Header.Get("Content-MD5") + "\n")
+	b.WriteString(req.Header.Get("Content-Type") + "\n")
+	b.WriteString(req.Header.Get("Date") + "\n")
+
+	var names []string
+	for name := range req.Header {
+		if lower := strings.ToLower(name); strings.HasPrefix(lower, "x-amz-") {
+			names = append(names, name)
+		}
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return strings.ToLower(names[i]) < strings.ToLower(names[j])
+	})
+	for _, name := range names {
+		values := make([]string, len(req.Header[name]))
+		for i, v := range req.Header[name] {
+			values[i] = strings.TrimSpace(v)
+		}
+		b.WriteString(strings.ToLower(name) + ":" + strings.Join(values, ",") + "\n")
+	}
+
+	// Path-style addressing is forced with v2, as the bucket
+	// is only signed as part of the path
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	b.WriteString(path)
+	query := req.URL.Query()
+	var params []string
+	for name := range query {
+		if signedSubresources[name] {
+			params = append(params, name)
+		}
+	}
+	sort.Strings(params)
+	for i, name := range params {
+		if i == 0 {
+			b.WriteString("?")
+		} else {
+			b.WriteString("&")
+		}
+		b.WriteString(name)
+		if value := query.Get(name); value != "" {
+			b.WriteString("=" + value)
+		}
+	}
+	return b.String()
+}
+
+// signedSubresources are the query parameters a v2 signature
+// covers, all others are left out
+var signedSubresources = map[string]bool{
+	"acl":                          true,
+	"cors":                         true,
+	"delete":                       true,
+	"legal-hold":                   true,
+	"lifecycle":                    true,
+	"location":                     true,
+	"logging":                      true,
+	"notification":                 true,
+	"object-lock":                  true,
+	"partNumber":                   true,
+	"policy":                       true,
+	"requestPayment":               true,
+	"response-cache-control":       true,
+	"response-content-disposition": true,
+	"response-content-encoding":    true,
+	"response-content-language":    true,
+	"response-content-type":        true,
+	"response-expires":             true,
+	"restore":                      true,
+	"retention":                    true,
+	"tagging":                      true,
+	"torrent":                      true,
+	"uploadId":                     true,
+	"uploads":                      true,
+	"versionId":                    true,
+	"versioning":                   true,
+	"versions":                     true,
+	"website":                      true,
+}
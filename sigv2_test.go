@@ -0,0 +1,92 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestStringToSignV2(t *testing.T) {
+	// The example from the S3 documentation
+	req := httptest.NewRequest(http.MethodGet, "/awsexamplebucket1/photos/puppy.jpg", nil)
+	req.Header.Set("Date", "Tue, 27 Mar 2007 19:36:42 +0000")
+	want := "GET\n\n\nTue, 27 Mar 2007 19:36:42 +0000\n/awsexamplebucket1/photos/puppy.jpg"
+	if got := stringToSignV2(req); got != want {
+		t.Errorf("stringToSignV2 = %q, want %q", got, want)
+	}
+	mac := hmac.New(sha1.New, []byte("wJalrXUtnFEMI/K7MDENG/bPxRfiCYEXAMPLEKEY"))
+	mac.Write([]byte(want))
+	if got := base64.StdEncoding.EncodeToString(mac.Sum(nil)); got != "qgk2+6Sv9/oM7G3qLEjTH1a1l1g=" {
+		t.Errorf("signature = %s", got)
+	}
+
+	req = httptest.NewRequest(http.MethodPut, "/b/a%20b.txt?uploadId=1&partNumber=2&x-id=UploadPart", nil)
+	req.Header.Set("Date", "Tue, 27 Mar 2007 21:06:08 +0000")
+	req.Header.Set("Content-Type", "text/plain")
+	req.Header.Set("Content-MD5", "XUFAKrxLKna5cZ2REBfFkg==")
+	req.Header.Set("X-Amz-Meta-Reviewer", " jane ")
+	req.Header.Add("X-Amz-Meta-Reviewer", "joe")
+	req.Header.Set("x-amz-acl", "private")
+	want = "PUT\nXUFAKrxLKna5cZ2REBfFkg==\ntext/plain\nTue, 27 Mar 2007 21:06:08 +0000\n" +
+		"x-amz-acl:private\nx-amz-meta-reviewer:jane,joe\n" +
+		"/b/a%20b.txt?partNumber=2&uploadId=1"
+	if got := stringToSignV2(req); got != want {
+		t.Errorf("stringToSignV2 = %q, want %q", got, want)
+	}
+}
+
+func TestCheckSignatureVersion(t *testing.T) {
+	defer func() { signatureVersion = "" }()
+	for _, tt := range []struct {
+		version string
+		ok      bool
+	}{
+		{"", true},
+		{"v2", true},
+		{"v4", true},
+		{"V4", false},
+		{"s3v4", false},
+	} {
+		signatureVersion = tt.version
+		if err := checkSignatureVersion(); tt.ok != (err == nil) {
+			t.Errorf("-signature-version %q: %v", tt.version, err)
+		}
+	}
+}
+
+func TestSignatureV2(t *testing.T) {
+	s := newFakeS3(t, "b")
+	s.put("b", "a.txt", "hello")
+	if _, err := run(t, "ls", "-signature-version", "v2", "s3://b"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := run(t, "ls", "s3://b"); err != nil {
+		t.Fatal(err)
+	}
+	requests := s.received(http.MethodGet, "")
+	if len(requests) != 2 {
+		t.Fatalf("sent %d requests, want 2", len(requests))
+	}
+
+	v2 := requests[0]
+	auth := v2.header.Get("Authorization")
+	if !strings.HasPrefix(auth, "AWS AKIDTEST:") || v2.header.Get("X-Amz-Content-Sha256") != "" {
+		t.Fatalf("-signature-version v2 signed with %q", auth)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/"+v2.bucket, nil)
+	req.URL.RawQuery = v2.query.Encode()
+	req.Header = v2.header
+	mac := hmac.New(sha1.New, []byte("secret"))
+	mac.Write([]byte(stringToSignV2(req)))
+	if want := "AWS AKIDTEST:" + base64.StdEncoding.EncodeToString(mac.Sum(nil)); auth != want {
+		t.Errorf("signed with %q, want %q", auth, want)
+	}
+
+	if auth := requests[1].header.Get("Authorization"); !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=AKIDTEST/") {
+		t.Errorf("signed with %q by default, want v4", auth)
+	}
+}
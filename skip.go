@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Reasons files are skipped rather than transferred
+const (
+	skipUnchanged = "unchanged"
+	skipDuplicate = "duplicate name"
+	skipExtension = "other extension"
+)
+
+// skipTally counts the files that were skipped for each reason,
+// so users can tell why fewer files were transferred than there
+// are in the source.
+type skipTally struct {
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+// skip records that a file was skipped
+func (t *skipTally) skip(reason string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.counts == nil {
+		t.counts = make(map[string]int)
+	}
+	t.counts[reason]++
+}
+
+// String summarizes the tally with the most common reasons first,
+// e.g. "skipped 7 files: 4 unchanged, 3 duplicate name"
+func (t *skipTally) String() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	reasons := make([]string, 0, len(t.counts))
+	total := 0
+	for reason, n := range t.counts {
+		reasons = append(reasons, reason)
+		total += n
+	}
+	sort.Slice(reasons, func(i, j int) bool {
+		if t.counts[reasons[i]] != t.counts[reasons[j]] {
+			return t.counts[reasons[i]] > t.counts[reasons[j]]
+		}
+		return reasons[i] < reasons[j]
+	})
+	parts := make([]string, len(reasons))
+	for i, reason := range reasons {
+		parts[i] = fmt.Sprintf("%d %s", t.counts[reason], reason)
+	}
+	return fmt.Sprintf("skipped %d files: %s", total, strings.Join(parts, ", "))
+}
+
+// report prints the summary if anything was skipped
+func (t *skipTally) report() {
+	t.mu.Lock()
+	empty := len(t.counts) == 0
+	t.mu.Unlock()
+	if !empty && !quiet {
+		fmt.Println(t)
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSkipTally(t *testing.T) {
+	var tally skipTally
+	for _, reason := range []string{skipExtension, skipUnchanged, skipUnchanged, skipDuplicate, skipUnchanged, skipDuplicate} {
+		tally.skip(reason)
+	}
+	if got, want := tally.String(), "skipped 6 files: 3 unchanged, 2 duplicate name, 1 other extension"; got != want {
+		t.Errorf("summarized %q, want %q", got, want)
+	}
+}
+
+func TestUploadReportsSkippedFiles(t *testing.T) {
+	newFakeS3(t, "b")
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"a/x.png": "", "b/x.png": "", "c/x.png": "", "y.png": "",
+		"notes.txt": "",
+	})
+	out, err := run(t, "-flatten", "-on-collision", "skip", "-ext", ".png", dir, "s3://b/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "skipped 3 files: 2 duplicate name, 1 other extension"; !strings.Contains(out, want) {
+		t.Errorf("printed %q, want it to contain %q", out, want)
+	}
+	out, err = run(t, "-ext", ".png", dir, "s3://b/all/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "skipped 1 files: 1 other extension"; !strings.Contains(out, want) {
+		t.Errorf("printed %q, want it to contain %q", out, want)
+	}
+}
+
+func TestSyncReportsUnchangedFiles(t *testing.T) {
+	newFakeS3(t, "b")
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"a.txt": "a", "b.txt": "b"})
+	// Listings only have a precision of seconds
+	past := time.Now().Add(-time.Hour)
+	for _, name := range []string{"a.txt", "b.txt"} {
+		if err := os.Chtimes(filepath.Join(dir, name), past, past); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := run(t, "sync", dir, "s3://b/"); err != nil {
+		t.Fatal(err)
+	}
+	out, err := run(t, "sync", dir, "s3://b/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "skipped 2 files: 2 unchanged"; !strings.Contains(out, want) {
+		t.Errorf("printed %q, want it to contain %q", out, want)
+	}
+}
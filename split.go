@@ -0,0 +1,310 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+// splitSize uploads a file as pieces of this many bytes, each an
+// object of its own, for providers that cap the size of objects.
+// Zero uploads files whole.
+var splitSize int64
+
+// reassemble downloads the pieces of a file uploaded with
+// -split-size and joins them into the file again
+var reassemble bool
+
+// pieceSuffix separates the key of a split file from the number
+// of each piece, e.g. backup.img.part0003
+const pieceSuffix = ".part"
+
+// pieceKey returns the key of the nth piece of a split file
+func pieceKey(key string, n int) string {
+	return fmt.Sprintf("%s%s%04d", key, pieceSuffix, n)
+}
+
+// uploadSplit uploads a file as consecutive pieces of -split-size.
+// Every piece is a separate upload, so -part-size still applies to
+// each of them.
+func uploadSplit(
+	uploader *s3manager.Uploader,
+	bucket *string,
+	key string,
+	sourcePath string,
+	size int64,
+) error {
+	pieces := int((size + splitSize - 1) / splitSize)
+	if pieces == 0 {
+		// An empty file is still stored as a piece, so that
+		// -reassemble finds it
+		pieces = 1
+	}
+	prog := startProgress(pieces)
+	prog.setTotalBytes(size)
+
+	pool := newJobPool(parallelism, func(payload interface{}) interface{} {
+		n := payload.(int)
+		off := int64(n) * splitSize
+		length := splitSize
+		if off+length > size {
+			length = size - off
+		}
+		return uploadPiece(uploader, bucket, aws.String(pieceKey(key, n)), sourcePath, off, length, prog)
+	})
+	defer pool.Close()
+
+	var (
+		wg     sync.WaitGroup
+		errsMu sync.Mutex
+		errs   []error
+	)
+	for n := 0; n < pieces; n++ {
+		n := n
+		startJob(&wg, func() {
+			if err, ok := pool.Process(n).(error); ok && err != nil {
+				errsMu.Lock()
+				errs = append(errs, err)
+				errsMu.Unlock()
+			}
+		})
+	}
+	wg.Wait()
+	prog.finish()
+	for _, err := range errs {
+		logError(err)
+	}
+	if len(errs) > 0 {
+		return &batchError{failed: len(errs), total: pieces, what: "piece uploads"}
+	}
+	return nil
+}
+
+// uploadPiece uploads length bytes of a file from off as an object
+func uploadPiece(
+	uploader *s3manager.Uploader,
+	bucket *string,
+	key *string,
+	sourcePath string,
+	off int64,
+	length int64,
+	prog *progress,
+) (err error) {
+	defer func() { prog.fileDone(err) }()
+	return withRetriesProgress(prog, func(prog *progress) error {
+		f, err := openWithRetry(sourcePath)
+		if err != nil {
+			return fmt.Errorf("failed to read source file '%s': %w", sourcePath, err)
+		}
+		defer f.Close()
+		var body io.Reader = io.NewSectionReader(f, off, length)
+		if prog != nil {
+			body = &progressReader{r: body, p: prog}
+		}
+		if limiters := fileLimiters(); limiters != nil {
+			body = &limitedReader{r: body, limiters: limiters}
+		}
+		ctx, cancel := jobContext()
+		defer cancel()
+		if _, err := uploader.UploadWithContext(ctx, &s3manager.UploadInput{
+			Bucket:       bucket,
+			Key:          key,
+			Body:         body,
+			StorageClass: optionalString(storageClass),
+			ACL:          optionalString(cannedACL),
+
+			ServerSideEncryption:    serverSideEncryption.algorithm,
+			SSEKMSKeyId:             serverSideEncryption.kmsKeyID,
+			SSEKMSEncryptionContext: serverSideEncryption.encryptionContext,
+
+			SSECustomerAlgorithm: sseCustomer.algorithm,
+			SSECustomerKey:       sseCustomer.key,
+			SSECustomerKeyMD5:    sseCustomer.keyMD5,
+		}); err != nil {
+			return fmt.Errorf("failed to upload '%s' at offset %d: %w", sourcePath, off, err)
+		}
+		logSuccess("upload: %s (bytes %d-%d) to s3://%s/%s", sourcePath, off, off+length, *bucket, *key)
+		return nil
+	})
+}
+
+// piece is an object holding part of a split file
+type piece struct {
+	key  string
+	size int64
+	off  int64
+}
+
+// listPieces returns the pieces of a split file in order, failing
+// if any of them is missing
+func listPieces(s3Client *s3.S3, bucket string, key string) ([]piece, error) {
+	objects, err := listObjects(s3Client, bucket, key+pieceSuffix)
+	if err != nil {
+		return nil, err
+	}
+	numbered := make(map[int]*s3.Object)
+	for _, obj := range objects {
+		digits := strings.TrimPrefix(*obj.Key, key+pieceSuffix)
+		n, err := strconv.Atoi(digits)
+		if err != nil || n < 0 || digits != fmt.Sprintf("%04d", n) {
+			// Some other object sharing the prefix
+			continue
+		}
+		numbered[n] = obj
+	}
+	if len(numbered) == 0 {
+		return nil, fmt.Errorf("no pieces of 's3://%s/%s' found (expected e.g. %s)", bucket, key, pieceKey(key, 0))
+	}
+	numbers := make([]int, 0, len(numbered))
+	for n := range numbered {
+		numbers = append(numbers, n)
+	}
+	sort.Ints(numbers)
+	pieces := make([]piece, len(numbers))
+	var off int64
+	for i, n := range numbers {
+		if n != i {
+			return nil, fmt.Errorf("piece '%s' of 's3://%s/%s' is missing", pieceKey(key, i), bucket, key)
+		}
+		size := aws.Int64Value(numbered[n].Size)
+		pieces[i] = piece{key: *numbered[n].Key, size: size, off: off}
+		off += size
+	}
+	return pieces, nil
+}
+
+// offsetWriterAt writes at an offset into the file a piece is
+// downloaded to
+type offsetWriterAt struct {
+	w   io.WriterAt
+	off int64
+}
+
+func (o *offsetWriterAt) WriteAt(p []byte, off int64) (int, error) {
+	return o.w.WriteAt(p, o.off+off)
+}
+
+// downloadSplit downloads the pieces of a file uploaded with
+// -split-size into a temporary file, which is renamed to destPath
+// once every piece has arrived
+func downloadSplit(
+	s3Client *s3.S3,
+	downloader *s3manager.Downloader,
+	bucket string,
+	key string,
+	destPath string,
+) error {
+	pieces, err := listPieces(s3Client, bucket, key)
+	if err != nil {
+		return err
+	}
+	last := pieces[len(pieces)-1]
+	size := last.off + last.size
+
+	destDir := filepath.Dir(destPath)
+	if err := os.MkdirAll(destDir, 0755); err != nil {
+		return fmt.Errorf("failed to create directory '%s': %w", destDir, err)
+	}
+	stagingDir := tempDir
+	if stagingDir == "" {
+		stagingDir = destDir
+	}
+	f, err := os.CreateTemp(stagingDir, "."+filepath.Base(destPath)+".s3util-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file for '%s': %w", destPath, err)
+	}
+	tmpPath := f.Name()
+	defer os.Remove(tmpPath)
+	defer f.Close()
+	if err := f.Truncate(size); err != nil {
+		return fmt.Errorf("failed to allocate '%s': %w", tmpPath, err)
+	}
+
+	prog := startProgress(len(pieces))
+	prog.setTotalBytes(size)
+
+	pool := newJobPool(parallelism, func(payload interface{}) interface{} {
+		p := payload.(*piece)
+		return downloadPiece(downloader, bucket, p, f, prog)
+	})
+	defer pool.Close()
+
+	var (
+		wg     sync.WaitGroup
+		errsMu sync.Mutex
+		errs   []error
+	)
+	for i := range pieces {
+		p := &pieces[i]
+		startJob(&wg, func() {
+			if err, ok := pool.Process(p).(error); ok && err != nil {
+				errsMu.Lock()
+				errs = append(errs, err)
+				errsMu.Unlock()
+			}
+		})
+	}
+	wg.Wait()
+	prog.finish()
+	for _, err := range errs {
+		logError(err)
+	}
+	if len(errs) > 0 {
+		return &batchError{failed: len(errs), total: len(pieces), what: "piece downloads"}
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to write '%s': %w", tmpPath, err)
+	}
+	if destPath, err = moveIntoPlace(tmpPath, destPath); err != nil {
+		return fmt.Errorf("failed to move download into place at '%s': %w", destPath, err)
+	}
+	logSuccess("download: %d pieces of s3://%s/%s to %s", len(pieces), bucket, key, destPath)
+	return nil
+}
+
+// downloadPiece downloads a piece to its offset in the file
+func downloadPiece(
+	downloader *s3manager.Downloader,
+	bucket string,
+	p *piece,
+	f *os.File,
+	prog *progress,
+) (err error) {
+	defer func() { prog.fileDone(err) }()
+	return withRetriesProgress(prog, func(prog *progress) error {
+		var w io.WriterAt = &offsetWriterAt{w: f, off: p.off}
+		if prog != nil {
+			w = &progressWriterAt{w: w, p: prog}
+		}
+		if limiters := fileLimiters(); limiters != nil {
+			w = &limitedWriterAt{w: w, limiters: limiters}
+		}
+		ctx, cancel := jobContext()
+		defer cancel()
+		n, err := downloader.DownloadWithContext(ctx, w, &s3.GetObjectInput{
+			Bucket:       aws.String(bucket),
+			Key:          aws.String(p.key),
+			RequestPayer: optionalString(requestPayer),
+
+			SSECustomerAlgorithm: sseCustomer.algorithm,
+			SSECustomerKey:       sseCustomer.key,
+			SSECustomerKeyMD5:    sseCustomer.keyMD5,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to download '%s': %w", p.key, err)
+		}
+		if n != p.size {
+			return fmt.Errorf("'%s' changed while downloading it (%d bytes instead of %d)", p.key, n, p.size)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPieceKey(t *testing.T) {
+	for _, tt := range []struct {
+		n    int
+		want string
+	}{
+		{0, "backup.img.part0000"},
+		{42, "backup.img.part0042"},
+		{12345, "backup.img.part12345"},
+	} {
+		if got := pieceKey("backup.img", tt.n); got != tt.want {
+			t.Errorf("pieceKey(%d) = %q, want %q", tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestSplitRoundTrip(t *testing.T) {
+	s := newFakeS3(t, "b")
+	dir := t.TempDir()
+	data := make([]byte, 2500)
+	for i := range data {
+		data[i] = byte(i * 13)
+	}
+	path := filepath.Join(dir, "backup.img")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := run(t, "-split-size", "1000", path, "s3://b/backup.img"); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(s.keys("b"), " "); got != "backup.img.part0000 backup.img.part0001 backup.img.part0002" {
+		t.Fatalf("uploaded %s", got)
+	}
+	if got := len(s.object("b", "backup.img.part0002").data); got != 500 {
+		t.Errorf("the last piece holds %d bytes, want 500", got)
+	}
+	// Not a piece, despite the prefix
+	s.put("b", "backup.img.part0000.sha256", "checksum")
+
+	dest := filepath.Join(t.TempDir(), "restored.img")
+	if _, err := run(t, "-reassemble", "s3://b/backup.img", dest); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, dest); got != string(data) {
+		t.Error("the reassembled file differs from the original")
+	}
+
+	s.mu.Lock()
+	delete(s.buckets["b"], "backup.img.part0001")
+	s.mu.Unlock()
+	missing := filepath.Join(t.TempDir(), "missing.img")
+	_, err := run(t, "-reassemble", "s3://b/backup.img", missing)
+	if err == nil || !strings.Contains(err.Error(), "backup.img.part0001") {
+		t.Errorf("reassembling without a piece got %v", err)
+	}
+	if _, err := os.Stat(missing); !os.IsNotExist(err) {
+		t.Errorf("a file was left behind: %v", err)
+	}
+}
+
+func TestSplitEmptyFile(t *testing.T) {
+	s := newFakeS3(t, "b")
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"empty": ""})
+	if _, err := run(t, "-split-size", "1000", filepath.Join(dir, "empty"), "s3://b/empty"); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(s.keys("b"), " "); got != "empty.part0000" {
+		t.Errorf("uploaded %s", got)
+	}
+	dest := filepath.Join(dir, "restored")
+	if _, err := run(t, "-reassemble", "s3://b/empty", dest); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, dest); got != "" {
+		t.Errorf("reassembled %q", got)
+	}
+	if _, err := run(t, "-split-size", "1000", dir, "s3://b/"); exitCode(err) != exitUsage {
+		t.Errorf("splitting a directory exited with %d (%v), want %d", exitCode(err), err, exitUsage)
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// Server-side encryption of uploads. The encryption context is
+// given as comma separated key=value pairs, e.g.
+//
+//	-sse aws:kms -sse-kms-encryption-context project=foo,stage=prod
+var (
+	sse                     string
+	sseKMSKeyID             string
+	sseKMSEncryptionContext string
+)
+
+// sseSettings are the validated encryption flags, with unset
+// flags left nil so they are omitted from requests
+type sseSettings struct {
+	algorithm         *string
+	kmsKeyID          *string
+	encryptionContext *string
+}
+
+// serverSideEncryption is parsed from the flags by parseSSE
+var serverSideEncryption sseSettings
+
+// parseSSE validates the server-side encryption flags. The KMS
+// key and encryption context only apply to aws:kms.
+func parseSSE() (sseSettings, error) {
+	var settings sseSettings
+	switch sse {
+	case "", s3.ServerSideEncryptionAes256, s3.ServerSideEncryptionAwsKms:
+	default:
+		return settings, fmt.Errorf("invalid -sse '%s' (expected %s or %s)", sse, s3.ServerSideEncryptionAes256, s3.ServerSideEncryptionAwsKms)
+	}
+	if sse != s3.ServerSideEncryptionAwsKms && (sseKMSKeyID != "" || sseKMSEncryptionContext != "") {
+		return settings, fmt.Errorf("-sse-kms-key-id and -sse-kms-encryption-context require -sse %s", s3.ServerSideEncryptionAwsKms)
+	}
+	settings.algorithm = optionalString(sse)
+	settings.kmsKeyID = optionalString(sseKMSKeyID)
+	if sseKMSEncryptionContext != "" {
+		context, err := encodeEncryptionContext(sseKMSEncryptionContext)
+		if err != nil {
+			return settings, err
+		}
+		settings.encryptionContext = aws.String(context)
+	}
+	return settings, nil
+}
+
+// encodeEncryptionContext turns key=value pairs into the base64
+// encoded JSON object S3 expects in the encryption context header.
+func encodeEncryptionContext(pairs string) (string, error) {
+	context := make(map[string]string)
+	for _, pair := range strings.Split(pairs, ",") {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return "", fmt.Errorf("invalid -sse-kms-encryption-context pair '%s' (expected key=value)", pair)
+		}
+		context[kv[0]] = kv[1]
+	}
+	// Maps are marshalled with sorted keys, so the header is the
+	// same for the same context.
+	data, err := json.Marshal(context)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(data), nil
+}
+
+// sseCustomerKey is the base64 encoded 256-bit key of objects
+// encrypted with SSE-C. S3 keeps only its MD5, so the key has to be
+// sent with every request that reads or writes such an object.
+var sseCustomerKey string
+
+// sseCustomerSettings are the request fields for -sse-customer-key,
+// all nil if it isn't given
+type sseCustomerSettings struct {
+	algorithm *string
+	key       *string
+	keyMD5    *string
+}
+
+// sseCustomer is parsed from -sse-customer-key by parseSSECustomer
+var sseCustomer sseCustomerSettings
+
+// parseSSECustomer decodes -sse-customer-key. The SDK takes the
+// raw key and encodes it for the header itself.
+func parseSSECustomer() (sseCustomerSettings, error) {
+	var settings sseCustomerSettings
+	if sseCustomerKey == "" {
+		return settings, nil
+	}
+	if sse != "" {
+		return settings, fmt.Errorf("-sse cannot be combined with -sse-customer-key")
+	}
+	key, err := base64.StdEncoding.DecodeString(sseCustomerKey)
+	if err != nil || len(key) != 32 {
+		return settings, fmt.Errorf("invalid -sse-customer-key (expected a base64 encoded 32 byte key)")
+	}
+	sum := md5.Sum(key)
+	settings.algorithm = aws.String(s3.ServerSideEncryptionAes256)
+	settings.key = aws.String(string(key))
+	settings.keyMD5 = aws.String(base64.StdEncoding.EncodeToString(sum[:]))
+	return settings, nil
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+func TestEncodeEncryptionContext(t *testing.T) {
+	for _, tt := range []struct {
+		pairs string
+		want  string // JSON
+	}{
+		{"project=foo", `{"project":"foo"}`},
+		{"stage=prod,project=foo", `{"project":"foo","stage":"prod"}`},
+		{"url=a=b", `{"url":"a=b"}`},
+		{"empty=", `{"empty":""}`},
+		{"foo", ""},
+		{"=foo", ""},
+		{"a=b,", ""},
+	} {
+		got, err := encodeEncryptionContext(tt.pairs)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("encodeEncryptionContext(%q) = %q, expected an error", tt.pairs, got)
+			}
+			continue
+		}
+		if want := base64.StdEncoding.EncodeToString([]byte(tt.want)); err != nil || got != want {
+			t.Errorf("encodeEncryptionContext(%q) = %q, %v, want %q", tt.pairs, got, err, want)
+		}
+	}
+}
+
+func TestParseSSE(t *testing.T) {
+	defer func() { sse, sseKMSKeyID, sseKMSEncryptionContext = "", "", "" }()
+	for _, tt := range []struct {
+		sse, keyID, context string
+		ok                  bool
+	}{
+		{"", "", "", true},
+		{"AES256", "", "", true},
+		{"aws:kms", "key", "project=foo", true},
+		{"aes256", "", "", false},
+		{"", "", "project=foo", false},
+		{"AES256", "", "project=foo", false},
+		{"AES256", "key", "", false},
+		{"aws:kms", "", "foo", false},
+	} {
+		sse, sseKMSKeyID, sseKMSEncryptionContext = tt.sse, tt.keyID, tt.context
+		if _, err := parseSSE(); tt.ok != (err == nil) {
+			t.Errorf("-sse %q -sse-kms-key-id %q -sse-kms-encryption-context %q: %v", tt.sse, tt.keyID, tt.context, err)
+		}
+	}
+}
+
+func TestUploadEncryptionContext(t *testing.T) {
+	s := newFakeS3(t, "b")
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"a.txt": "a"})
+	if _, err := run(t, "-sse", "aws:kms", "-sse-kms-encryption-context", "project=foo",
+		filepath.Join(dir, "a.txt"), "s3://b/a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	put := s.received(http.MethodPut, "")[0]
+	if got := put.header.Get("X-Amz-Server-Side-Encryption"); got != "aws:kms" {
+		t.Errorf("sent the encryption %q", got)
+	}
+	want := base64.StdEncoding.EncodeToString([]byte(`{"project":"foo"}`))
+	if got := put.header.Get("X-Amz-Server-Side-Encryption-Context"); got != want {
+		t.Errorf("sent the encryption context %q, want %q", got, want)
+	}
+	_, err := run(t, "-sse-kms-encryption-context", "project=foo", filepath.Join(dir, "a.txt"), "s3://b/a.txt")
+	if code := exitCode(err); code != exitUsage {
+		t.Errorf("an encryption context without -sse aws:kms exited with %d (%v), want %d", code, err, exitUsage)
+	}
+}
+
+func TestParseSSECustomer(t *testing.T) {
+	defer func() { sse, sseCustomerKey = "", "" }()
+	key := bytes.Repeat([]byte{7}, 32)
+	encoded := base64.StdEncoding.EncodeToString(key)
+	for _, tt := range []struct {
+		sse, key string
+		ok       bool
+	}{
+		{"", "", true},
+		{"", encoded, true},
+		{"AES256", encoded, false},
+		{"", base64.StdEncoding.EncodeToString(key[:16]), false},
+		{"", "not base64!", false},
+	} {
+		sse, sseCustomerKey = tt.sse, tt.key
+		settings, err := parseSSECustomer()
+		if tt.ok != (err == nil) {
+			t.Errorf("-sse %q -sse-customer-key %q: %v", tt.sse, tt.key, err)
+		}
+		if tt.ok && tt.key == "" && settings.key != nil {
+			t.Error("settings without -sse-customer-key")
+		}
+	}
+	sseCustomerKey = encoded
+	settings, _ := parseSSECustomer()
+	sum := md5.Sum(key)
+	if aws.StringValue(settings.algorithm) != "AES256" || aws.StringValue(settings.key) != string(key) ||
+		aws.StringValue(settings.keyMD5) != base64.StdEncoding.EncodeToString(sum[:]) {
+		t.Errorf("got %+v", settings)
+	}
+}
+
+func TestSSECustomerRoundTrip(t *testing.T) {
+	s := newFakeS3(t, "b")
+	key := bytes.Repeat([]byte{7}, 32)
+	encoded := base64.StdEncoding.EncodeToString(key)
+	sum := md5.Sum(key)
+	keyMD5 := base64.StdEncoding.EncodeToString(sum[:])
+	// S3 refuses to read an SSE-C object without its key
+	s.intercept = func(w http.ResponseWriter, r *http.Request) bool {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead || r.URL.Path != "/b/secret.txt" {
+			return false
+		}
+		if r.Header.Get("X-Amz-Server-Side-Encryption-Customer-Key-Md5") != keyMD5 {
+			fakeError(w, http.StatusBadRequest, "InvalidRequest")
+			return true
+		}
+		return false
+	}
+	// The SDK only sends customer keys over HTTPS
+	srv := httptest.NewTLSServer(http.HandlerFunc(s.serve))
+	defer srv.Close()
+	dir := t.TempDir()
+	t.Setenv("AWS_ENDPOINT_URL", srv.URL)
+	t.Setenv("AWS_CA_BUNDLE", filepath.Join(dir, "ca.pem"))
+	writeFiles(t, dir, map[string]string{
+		"secret.txt": "secret",
+		"ca.pem":     string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})),
+	})
+	if _, err := run(t, "-sse-customer-key", encoded, filepath.Join(dir, "secret.txt"), "s3://b/"); err != nil {
+		t.Fatal(err)
+	}
+	put := s.received(http.MethodPut, "")
+	if len(put) != 1 || put[0].header.Get("X-Amz-Server-Side-Encryption-Customer-Key") != encoded ||
+		put[0].header.Get("X-Amz-Server-Side-Encryption-Customer-Key-Md5") != keyMD5 {
+		t.Fatalf("the upload was sent without the key: %+v", put)
+	}
+
+	dest := filepath.Join(t.TempDir(), "secret.txt")
+	if _, err := run(t, "-max-retries", "0", "s3://b/secret.txt", dest); err == nil {
+		t.Error("expected reading without the key to fail")
+	}
+	if _, err := run(t, "-sse-customer-key", encoded, "s3://b/secret.txt", dest); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, dest); got != "secret" {
+		t.Errorf("downloaded %q", got)
+	}
+	if _, err := run(t, "stat", "-sse-customer-key", encoded, "s3://b/secret.txt"); err != nil {
+		t.Errorf("stat: %v", err)
+	}
+	if _, err := run(t, "stat", "s3://b/secret.txt"); err == nil {
+		t.Error("expected stat without the key to fail")
+	}
+}
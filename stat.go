@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// stat prints the attributes of a single object
+func stat(target string) error {
+	bucket, key, err := splitNameParts(target)
+	if err != nil {
+		return fmt.Errorf("failed to parse '%s': %w", target, err)
+	}
+	if key == "" {
+		return fmt.Errorf("'%s' does not specify a key", target)
+	}
+	out, err := s3.New(createSession()).HeadObject(&s3.HeadObjectInput{
+		Bucket:       aws.String(bucket),
+		Key:          aws.String(key),
+		VersionId:    optionalString(versionID),
+		RequestPayer: optionalString(requestPayer),
+
+		SSECustomerAlgorithm: sseCustomer.algorithm,
+		SSECustomerKey:       sseCustomer.key,
+		SSECustomerKeyMD5:    sseCustomer.keyMD5,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to stat '%s': %w", target, err)
+	}
+	fmt.Printf("Key:           %s\n", key)
+	fmt.Printf("Size:          %d\n", aws.Int64Value(out.ContentLength))
+	fmt.Printf("Last Modified: %s\n", aws.TimeValue(out.LastModified).Format(time.RFC3339))
+	fmt.Printf("ETag:          %s\n", aws.StringValue(out.ETag))
+	fmt.Printf("Content Type:  %s\n", aws.StringValue(out.ContentType))
+	if out.StorageClass != nil {
+		fmt.Printf("Storage Class: %s\n", *out.StorageClass)
+	}
+	if out.VersionId != nil {
+		fmt.Printf("Version ID:    %s\n", *out.VersionId)
+	}
+	names := make([]string, 0, len(out.Metadata))
+	for name := range out.Metadata {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Printf("Metadata:      %s=%s\n", name, aws.StringValue(out.Metadata[name]))
+	}
+	return nil
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestStatVersion(t *testing.T) {
+	s := newFakeS3(t, "b")
+	s.put("b", "a.txt", "hello", "Content-Type", "text/plain", "X-Amz-Meta-Owner", "alice")
+	out, err := run(t, "stat", "-version-id", "v1", "s3://b/a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"Size:          5\n",
+		"Content Type:  text/plain\n",
+		"Version ID:    v1\n",
+		"Metadata:      Owner=alice\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("stat printed %q, missing %q", out, want)
+		}
+	}
+	if _, err := run(t, "stat", "-version-id", "v2", "s3://b/a.txt"); err == nil {
+		t.Error("expected stat of a missing version to fail")
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+// stdinSource is the source path that uploads stdin
+const stdinSource = "-"
+
+// contentLength is the size of stdin given with -content-length,
+// which lets it be uploaded with a single PutObject instead of a
+// multipart upload buffering every part. -1 means unknown.
+var contentLength int64 = -1
+
+// maxPutObjectSize is the largest object a single PutObject can
+// upload
+const maxPutObjectSize = 5 << 30
+
+// lengthReader reads exactly the -content-length from stdin. S3
+// would only see a truncated body, so running out early is an
+// error of its own.
+type lengthReader struct {
+	r         io.Reader
+	remaining int64
+	err       error
+}
+
+func (l *lengthReader) Read(p []byte) (int, error) {
+	if l.remaining <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > l.remaining {
+		p = p[:l.remaining]
+	}
+	n, err := l.r.Read(p)
+	l.remaining -= int64(n)
+	if err == io.EOF && l.remaining > 0 {
+		l.err = fmt.Errorf("stdin ended %d bytes short of -content-length %d", l.remaining, contentLength)
+		err = l.err
+	}
+	return n, err
+}
+
+// uploadStdin uploads stdin to an object. Without -content-length
+// the size isn't known, so it is uploaded in parts of -part-size.
+func uploadStdin(bucket string, key string) (err error) {
+	if key == "" || key[len(key)-1] == '/' {
+		return usageErrorf("uploading stdin requires a key to upload it to, e.g. s3://%s/%sfile", bucket, key)
+	}
+	if contentLength > maxPutObjectSize {
+		return usageErrorf("-content-length cannot exceed %d bytes, the most a single PutObject can upload (leave it out to upload in parts)", int64(maxPutObjectSize))
+	}
+	var acl *s3.AccessControlPolicy
+	if aclFile != "" {
+		if acl, err = loadACLPolicy(aclFile); err != nil {
+			return err
+		}
+	}
+	prog := startProgress(1)
+	if contentLength >= 0 {
+		prog.setTotalBytes(contentLength)
+	}
+	defer func() {
+		prog.fileDone(err)
+		prog.finish()
+	}()
+	// Hiding that stdin is an *os.File keeps the uploader from
+	// seeking in it, which fails for pipes
+	var body io.Reader = struct{ io.Reader }{os.Stdin}
+	var lr *lengthReader
+	if contentLength >= 0 {
+		lr = &lengthReader{r: os.Stdin, remaining: contentLength}
+		body = lr
+	}
+	if prog != nil {
+		body = &progressReader{r: body, p: prog}
+	}
+	if limiters := fileLimiters(); limiters != nil {
+		body = &limitedReader{r: body, limiters: limiters}
+	}
+	ctx, cancel := jobContext()
+	defer cancel()
+	uploader := newUploader(createSession())
+	if err := checkUploadPreconditions(ctx, uploader.S3, aws.String(bucket), aws.String(key)); err != nil {
+		return err
+	}
+	if lr == nil {
+		_, err = uploader.UploadWithContext(ctx, &s3manager.UploadInput{
+			Bucket:          aws.String(bucket),
+			Key:             aws.String(key),
+			Body:            body,
+			ContentType:     optionalString(contentType),
+			ContentLanguage: optionalString(contentLanguage),
+			StorageClass:    optionalString(storageClass),
+			ACL:             optionalString(cannedACL),
+
+			WebsiteRedirectLocation: optionalString(websiteRedirect),
+
+			ServerSideEncryption:    serverSideEncryption.algorithm,
+			SSEKMSKeyId:             serverSideEncryption.kmsKeyID,
+			SSEKMSEncryptionContext: serverSideEncryption.encryptionContext,
+
+			SSECustomerAlgorithm: sseCustomer.algorithm,
+			SSECustomerKey:       sseCustomer.key,
+			SSECustomerKeyMD5:    sseCustomer.keyMD5,
+
+			ObjectLockMode:            objectLock.mode,
+			ObjectLockRetainUntilDate: objectLock.retainUntil,
+			ObjectLockLegalHoldStatus: objectLock.legalHold,
+		})
+	} else {
+		_, err = uploader.S3.PutObjectWithContext(ctx, &s3.PutObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+			// Stdin can't be rewound, so the body is neither
+			// hashed for the signature nor retried.
+			Body:            aws.ReadSeekCloser(body),
+			ContentLength:   aws.Int64(contentLength),
+			ContentType:     optionalString(contentType),
+			ContentLanguage: optionalString(contentLanguage),
+			StorageClass:    optionalString(storageClass),
+			ACL:             optionalString(cannedACL),
+
+			WebsiteRedirectLocation: optionalString(websiteRedirect),
+
+			ServerSideEncryption:    serverSideEncryption.algorithm,
+			SSEKMSKeyId:             serverSideEncryption.kmsKeyID,
+			SSEKMSEncryptionContext: serverSideEncryption.encryptionContext,
+
+			SSECustomerAlgorithm: sseCustomer.algorithm,
+			SSECustomerKey:       sseCustomer.key,
+			SSECustomerKeyMD5:    sseCustomer.keyMD5,
+
+			ObjectLockMode:            objectLock.mode,
+			ObjectLockRetainUntilDate: objectLock.retainUntil,
+			ObjectLockLegalHoldStatus: objectLock.legalHold,
+		}, request.WithSetRequestHeaders(map[string]string{
+			"X-Amz-Content-Sha256": "UNSIGNED-PAYLOAD",
+		}), func(r *request.Request) {
+			r.Retryer = client.NoOpRetryer{}
+		})
+		if lr.err != nil {
+			err = lr.err
+		} else if err == nil {
+			// Whatever is left over wasn't uploaded
+			if n, _ := os.Stdin.Read(make([]byte, 1)); n > 0 {
+				err = fmt.Errorf("stdin is longer than -content-length %d, of which only that much was uploaded", contentLength)
+			}
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to upload stdin: %w", err)
+	}
+	if acl != nil {
+		if err := putObjectACL(uploader.S3, aws.String(bucket), aws.String(key), acl); err != nil {
+			return err
+		}
+	}
+	logSuccess("upload: stdin to s3://%s/%s", bucket, key)
+	return nil
+}
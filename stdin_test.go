@@ -0,0 +1,121 @@
+package main
+
+import (
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestUploadStdin(t *testing.T) {
+	s := newFakeS3(t, "b")
+	var err error
+	withStdin(t, "streamed", func() { _, err = run(t, "-", "s3://b/stream.txt") })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o := s.object("b", "stream.txt"); o == nil || string(o.data) != "streamed" {
+		t.Errorf("stream.txt holds %+v", o)
+	}
+	for _, dest := range []string{"s3://b", "s3://b/dir/"} {
+		withStdin(t, "streamed", func() { _, err = run(t, "-", dest) })
+		if exitCode(err) != exitUsage {
+			t.Errorf("uploading stdin to %s exited with %d (%v), want %d", dest, exitCode(err), err, exitUsage)
+		}
+	}
+}
+
+func TestUploadStdinContentLength(t *testing.T) {
+	s := newFakeS3(t, "b")
+	var err error
+	withStdin(t, "hello", func() { _, err = run(t, "-content-length", "5", "-", "s3://b/a.txt") })
+	if err != nil {
+		t.Fatal(err)
+	}
+	puts := s.received(http.MethodPut, "")
+	if len(puts) != 1 || puts[0].header.Get("Content-Length") != "5" {
+		t.Fatalf("sent %+v, want a single PutObject of 5 bytes", puts)
+	}
+	if got := len(s.received(http.MethodPost, "uploads")); got != 0 {
+		t.Errorf("started %d multipart uploads", got)
+	}
+	if o := s.object("b", "a.txt"); o == nil || string(o.data) != "hello" {
+		t.Errorf("a.txt holds %+v", o)
+	}
+
+	for _, tt := range []struct {
+		length, want string
+	}{
+		{"10", "short of -content-length"},
+		{"3", "longer than -content-length"},
+	} {
+		withStdin(t, "hello", func() { _, err = run(t, "-content-length", tt.length, "-", "s3://b/b.txt") })
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("-content-length %s of 5 bytes got %v", tt.length, err)
+		}
+	}
+
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"a.txt": "hello"})
+	if _, err := run(t, "-content-length", "5", filepath.Join(dir, "a.txt"), "s3://b/"); exitCode(err) != exitUsage {
+		t.Errorf("-content-length with a file exited with %d (%v), want %d", exitCode(err), err, exitUsage)
+	}
+}
+
+func TestUploadStdinObjectFlags(t *testing.T) {
+	s := newFakeS3(t, "b")
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"acl.json": `{"grants": [{"grantee": {"type": "CanonicalUser", "id": "reader"}, "permission": "READ"}]}`,
+	})
+	for _, length := range []string{"-1", "8"} {
+		key := "stream" + length
+		var err error
+		withStdin(t, "streamed", func() {
+			_, err = run(t, "-content-length", length,
+				"-website-redirect", "/new",
+				"-object-lock-mode", "GOVERNANCE",
+				"-object-lock-retain-until", "2030-01-02T15:04:05Z",
+				"-acl-from-file", filepath.Join(dir, "acl.json"),
+				"-", "s3://b/"+key)
+		})
+		if err != nil {
+			t.Fatalf("-content-length %s: %v", length, err)
+		}
+		o := s.object("b", key)
+		if o == nil {
+			t.Fatalf("-content-length %s: nothing was uploaded", length)
+		}
+		for name, want := range map[string]string{
+			"X-Amz-Website-Redirect-Location":     "/new",
+			"X-Amz-Object-Lock-Mode":              "GOVERNANCE",
+			"X-Amz-Object-Lock-Retain-Until-Date": "2030-01-02T15:04:05Z",
+		} {
+			if got := o.header.Get(name); got != want {
+				t.Errorf("-content-length %s: %s is %q, want %q", length, name, got, want)
+			}
+		}
+		if !strings.Contains(string(o.acl), "<ID>reader</ID>") {
+			t.Errorf("-content-length %s: ACL is %s", length, o.acl)
+		}
+
+		withStdin(t, "replaced", func() { _, err = run(t, "-content-length", length, "-if-none-match", "*", "-", "s3://b/"+key) })
+		if err == nil || !strings.Contains(err.Error(), "already exists") {
+			t.Errorf("-content-length %s: -if-none-match * got %v", length, err)
+		}
+		if o := s.object("b", key); o == nil || string(o.data) != "streamed" {
+			t.Errorf("-content-length %s: -if-none-match * left %+v", length, o)
+		}
+	}
+
+	for _, flag := range []string{"-content-md5", "-preserve-mode", "-from-metadata-sidecar", "-resumable-upload"} {
+		var err error
+		withStdin(t, "streamed", func() { _, err = run(t, flag, "-", "s3://b/refused") })
+		if exitCode(err) != exitUsage {
+			t.Errorf("%s exited with %d (%v), want %d", flag, exitCode(err), err, exitUsage)
+		}
+	}
+	if o := s.object("b", "refused"); o != nil {
+		t.Errorf("refused was uploaded: %+v", o)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// summaryOut is the file the summary of the run is written to as
+// JSON by -summary-out, regardless of what is printed to stdout.
+// Empty disables it.
+var summaryOut string
+
+var (
+	summaryMu         sync.Mutex
+	summaryProgresses []*progress
+	summaryErrors     []string
+)
+
+// runSummary is the JSON written by -summary-out
+type runSummary struct {
+	Command         string         `json:"command"`
+	Started         string         `json:"started"`
+	Finished        string         `json:"finished"`
+	DurationSeconds float64        `json:"duration_seconds"`
+	Files           int64          `json:"files"`
+	Succeeded       int64          `json:"succeeded"`
+	Failed          int64          `json:"failed"`
+	Bytes           int64          `json:"bytes"`
+	Retries         int64          `json:"retries"`
+	ExitCode        int            `json:"exit_code"`
+	Error           string         `json:"error,omitempty"`
+	ErrorCounts     map[string]int `json:"error_counts,omitempty"`
+	Errors          []string       `json:"errors,omitempty"`
+}
+
+// trackSummaryProgress adds the counts of a transfer, as started
+// by startProgress, to the summary. Should a command start several,
+// all of them are added up.
+func trackSummaryProgress(p *progress) {
+	if summaryOut == "" {
+		return
+	}
+	summaryMu.Lock()
+	defer summaryMu.Unlock()
+	summaryProgresses = append(summaryProgresses, p)
+}
+
+// recordSummaryError adds an error of a single file or object to
+// the summary
+func recordSummaryError(err error) {
+	if summaryOut == "" {
+		return
+	}
+	summaryMu.Lock()
+	defer summaryMu.Unlock()
+	summaryErrors = append(summaryErrors, err.Error())
+}
+
+// errorKind groups error messages by what failed, which is
+// the part before the quoted path, e.g. "failed to upload" for
+// "failed to upload 'foo.txt': ..."
+func errorKind(message string) string {
+	if i := strings.Index(message, " '"); i > 0 {
+		return message[:i]
+	}
+	if i := strings.Index(message, ":"); i > 0 {
+		return message[:i]
+	}
+	return message
+}
+
+// writeSummary writes the summary of a command that started at
+// the given time and returned err to -summary-out.
+func writeSummary(command string, started time.Time, err error) error {
+	finished := time.Now()
+	summary := runSummary{
+		Command:         command,
+		Started:         started.UTC().Format(time.RFC3339Nano),
+		Finished:        finished.UTC().Format(time.RFC3339Nano),
+		DurationSeconds: finished.Sub(started).Seconds(),
+		Retries:         atomic.LoadInt64(&retryCount),
+		ExitCode:        exitCode(err),
+	}
+	if err != nil {
+		summary.Error = err.Error()
+	}
+	summaryMu.Lock()
+	for _, p := range summaryProgresses {
+		summary.Files += atomic.LoadInt64(&p.doneFiles)
+		summary.Failed += atomic.LoadInt64(&p.failedFiles)
+		summary.Bytes += atomic.LoadInt64(&p.bytes)
+	}
+	summary.Errors = summaryErrors
+	summaryMu.Unlock()
+	summary.Succeeded = summary.Files - summary.Failed
+	if len(summary.Errors) > 0 {
+		summary.ErrorCounts = make(map[string]int)
+		for _, message := range summary.Errors {
+			summary.ErrorCounts[errorKind(message)]++
+		}
+	}
+
+	data, err := json.MarshalIndent(&summary, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(summaryOut, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write summary: %w", err)
+	}
+	return nil
+}
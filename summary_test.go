@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestErrorKind(t *testing.T) {
+	for message, want := range map[string]string{
+		"failed to upload 'a.txt': AccessDenied": "failed to upload",
+		"failed to list: timeout":                "failed to list",
+		"aborted":                                "aborted",
+	} {
+		if got := errorKind(message); got != want {
+			t.Errorf("errorKind(%q) = %q, want %q", message, got, want)
+		}
+	}
+}
+
+func TestSummaryOut(t *testing.T) {
+	s := newFakeS3(t, "b")
+	s.intercept = func(w http.ResponseWriter, r *http.Request) bool {
+		if r.Method == http.MethodPut && strings.HasSuffix(r.URL.Path, "/bad.txt") {
+			fakeError(w, http.StatusForbidden, "AccessDenied")
+			return true
+		}
+		return false
+	}
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"a.txt": "hello", "b.txt": "hi", "bad.txt": "x"})
+	out := filepath.Join(t.TempDir(), "summary.json")
+	_, err := run(t, "-summary-out", out, "-max-retries", "0", dir, "s3://b/up/")
+	if code := exitCode(err); code != exitPartial {
+		t.Fatalf("exited with %d (%v), want %d", code, err, exitPartial)
+	}
+	// The bytes include those sent of bad.txt before S3 refused it
+	var summary runSummary
+	if err := json.Unmarshal([]byte(readFile(t, out)), &summary); err != nil {
+		t.Fatal(err)
+	}
+	if summary.Command != "cp" || summary.Files != 3 || summary.Succeeded != 2 || summary.Failed != 1 ||
+		summary.Bytes != 8 || summary.ExitCode != exitPartial || summary.Error != "1 of 3 uploads failed" {
+		t.Errorf("summary is %+v", summary)
+	}
+	if len(summary.Errors) != 1 || !strings.Contains(summary.Errors[0], "bad.txt") {
+		t.Errorf("summary lists the errors %q", summary.Errors)
+	}
+	if len(summary.ErrorCounts) != 1 {
+		t.Errorf("summary counts the errors %v", summary.ErrorCounts)
+	}
+	started, err1 := time.Parse(time.RFC3339Nano, summary.Started)
+	finished, err2 := time.Parse(time.RFC3339Nano, summary.Finished)
+	if err1 != nil || err2 != nil || finished.Before(started) || summary.DurationSeconds < 0 {
+		t.Errorf("ran from %s to %s for %fs", summary.Started, summary.Finished, summary.DurationSeconds)
+	}
+}
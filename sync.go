@@ -0,0 +1,242 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// syncDelete is set by -delete
+var syncDelete bool
+
+// dryRun is set by -dry-run
+var dryRun bool
+
+type syncAction int
+
+const (
+	syncAdd syncAction = iota
+	syncChange
+	syncRemove
+)
+
+// symbol is how an action is shown in a -dry-run plan
+func (a syncAction) symbol() string {
+	switch a {
+	case syncAdd:
+		return "+"
+	case syncChange:
+		return "~"
+	default:
+		return "-"
+	}
+}
+
+// syncItem is a single change needed to bring the bucket in
+// line with the local directory
+type syncItem struct {
+	action    syncAction
+	key       string
+	localPath string
+}
+
+// localFile is a file found while walking the sync source
+type localFile struct {
+	path string
+	info os.FileInfo
+}
+
+// planSync compares the local files with the remote objects,
+// both keyed by their path relative to the sync root, and
+// returns the changes in key order. A file is uploaded if it is
+// missing remotely, or if its size differs or it was modified
+// after the object. Objects without a local file are deleted
+// only when withDelete is set. Unchanged files are counted in
+// skipped.
+func planSync(
+	local map[string]localFile,
+	remote map[string]*s3.Object,
+	keyPrefix string,
+	withDelete bool,
+	skipped *skipTally,
+) []syncItem {
+	var plan []syncItem
+	for rel, file := range local {
+		item := syncItem{key: joinKey(keyPrefix, rel), localPath: file.path}
+		obj, ok := remote[rel]
+		if !ok {
+			item.action = syncAdd
+		} else if aws.Int64Value(obj.Size) != file.info.Size() ||
+			file.info.ModTime().After(aws.TimeValue(obj.LastModified)) {
+			item.action = syncChange
+		} else {
+			skipped.skip(skipUnchanged)
+			continue
+		}
+		plan = append(plan, item)
+	}
+	if withDelete {
+		for rel := range remote {
+			if _, ok := local[rel]; !ok {
+				plan = append(plan, syncItem{action: syncRemove, key: joinKey(keyPrefix, rel)})
+			}
+		}
+	}
+	sort.Slice(plan, func(i, j int) bool {
+		return plan[i].key < plan[j].key
+	})
+	return plan
+}
+
+// joinKey prefixes a relative key, if there is a prefix
+func joinKey(prefix string, rel string) string {
+	if prefix == "" {
+		return rel
+	}
+	return prefix + "/" + rel
+}
+
+// syncDir uploads new and changed files from a local directory
+// to an s3 prefix and, with -delete, removes objects that no
+// longer exist locally. With -dry-run the plan is printed as
+// a diff ("+" new, "~" changed, "-" deleted) and nothing is
+// changed.
+func syncDir(source string, dest string) error {
+	bucket, key, err := splitNameParts(dest)
+	if err != nil {
+		return fmt.Errorf("failed to parse destination: %w", err)
+	}
+	keyPrefix := strings.TrimSuffix(key, "/")
+	sourcePath, err := filepath.Abs(source)
+	if err != nil {
+		return fmt.Errorf("failed to get absolute path of source: %w", err)
+	}
+
+	local := make(map[string]localFile)
+	if err := filepath.Walk(sourcePath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(sourcePath, path)
+		if err != nil {
+			return err
+		}
+		local[filepath.ToSlash(rel)] = localFile{path: path, info: info}
+		return nil
+	}); err != nil {
+		return fmt.Errorf("failed to walk source directory: %w", err)
+	}
+
+	sess := createSession()
+	s3Client := s3.New(sess)
+	listPrefix := ""
+	if keyPrefix != "" {
+		listPrefix = keyPrefix + "/"
+	}
+	remote := make(map[string]*s3.Object)
+	if err := s3Client.ListObjectsV2Pages(
+		&s3.ListObjectsV2Input{
+			Bucket:       aws.String(bucket),
+			Prefix:       aws.String(listPrefix),
+			RequestPayer: optionalString(requestPayer),
+		},
+		func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+			for _, obj := range page.Contents {
+				remote[strings.TrimPrefix(aws.StringValue(obj.Key), listPrefix)] = obj
+			}
+			return true
+		},
+	); err != nil {
+		return fmt.Errorf("failed to list '%s': %w", dest, err)
+	}
+
+	var skipped skipTally
+	plan := planSync(local, remote, keyPrefix, syncDelete, &skipped)
+
+	if dryRun {
+		counts := make(map[syncAction]int)
+		for _, item := range plan {
+			fmt.Printf("%s s3://%s/%s\n", item.action.symbol(), bucket, item.key)
+			counts[item.action]++
+		}
+		fmt.Printf(
+			"%d to upload, %d to update, %d to delete\n",
+			counts[syncAdd],
+			counts[syncChange],
+			counts[syncRemove],
+		)
+		skipped.report()
+		return nil
+	}
+
+	var uploads []syncItem
+	var deletions []string
+	for _, item := range plan {
+		if item.action == syncRemove {
+			deletions = append(deletions, item.key)
+		} else {
+			uploads = append(uploads, item)
+		}
+	}
+
+	if len(deletions) > 0 {
+		if err := confirm("delete %d objects from %s", len(deletions), dest); err != nil {
+			return err
+		}
+	}
+
+	prog := startProgress(len(uploads))
+	uploader := newUploader(sess)
+	pool := newJobPool(parallelism, func(payload interface{}) interface{} {
+		item := payload.(*syncItem)
+		return uploadSingleFile(
+			uploader,
+			aws.String(bucket),
+			aws.String(item.key),
+			item.localPath,
+			prog,
+		)
+	})
+	defer pool.Close()
+
+	var (
+		wg     sync.WaitGroup
+		errsMu sync.Mutex
+		errs   []error
+	)
+	for i := range uploads {
+		item := &uploads[i]
+		startJob(&wg, func() {
+			if err, ok := pool.Process(item).(error); ok && err != nil {
+				errsMu.Lock()
+				errs = append(errs, err)
+				errsMu.Unlock()
+			}
+		})
+	}
+	wg.Wait()
+	prog.finish()
+	skipped.report()
+	for _, err := range errs {
+		logError(err)
+	}
+
+	if len(deletions) > 0 {
+		if err := deleteKeys(s3Client, bucket, deletions); err != nil {
+			return err
+		}
+	}
+	if len(errs) > 0 {
+		return &batchError{failed: len(errs), total: len(uploads), what: "uploads"}
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// fileInfo is the os.FileInfo of a file that doesn't exist
+type fileInfo struct {
+	size    int64
+	modTime time.Time
+}
+
+func (f fileInfo) Name() string       { return "" }
+func (f fileInfo) Size() int64        { return f.size }
+func (f fileInfo) Mode() os.FileMode  { return 0644 }
+func (f fileInfo) ModTime() time.Time { return f.modTime }
+func (f fileInfo) IsDir() bool        { return false }
+func (f fileInfo) Sys() interface{}   { return nil }
+
+func TestPlanSync(t *testing.T) {
+	now := time.Now()
+	local := map[string]localFile{
+		"new.txt":       {path: "/src/new.txt", info: fileInfo{1, now}},
+		"same.txt":      {path: "/src/same.txt", info: fileInfo{4, now.Add(-time.Hour)}},
+		"resized.txt":   {path: "/src/resized.txt", info: fileInfo{5, now.Add(-time.Hour)}},
+		"dir/newer.txt": {path: "/src/dir/newer.txt", info: fileInfo{4, now}},
+	}
+	remote := map[string]*s3.Object{
+		"same.txt":      {Size: aws.Int64(4), LastModified: aws.Time(now)},
+		"resized.txt":   {Size: aws.Int64(4), LastModified: aws.Time(now)},
+		"dir/newer.txt": {Size: aws.Int64(4), LastModified: aws.Time(now.Add(-time.Hour))},
+		"gone.txt":      {Size: aws.Int64(4), LastModified: aws.Time(now)},
+	}
+	describe := func(plan []syncItem) string {
+		var lines []string
+		for _, item := range plan {
+			lines = append(lines, item.action.symbol()+" "+item.key+" "+item.localPath)
+		}
+		return strings.Join(lines, "\n")
+	}
+
+	var skipped skipTally
+	plan := planSync(local, remote, "p", false, &skipped)
+	want := "~ p/dir/newer.txt /src/dir/newer.txt\n" +
+		"+ p/new.txt /src/new.txt\n" +
+		"~ p/resized.txt /src/resized.txt"
+	if got := describe(plan); got != want {
+		t.Errorf("plan is\n%s\nwant\n%s", got, want)
+	}
+	if got := skipped.String(); got != "skipped 1 files: 1 unchanged" {
+		t.Errorf("tally is %q", got)
+	}
+
+	plan = planSync(local, remote, "", true, &skipTally{})
+	want = "~ dir/newer.txt /src/dir/newer.txt\n" +
+		"- gone.txt \n" +
+		"+ new.txt /src/new.txt\n" +
+		"~ resized.txt /src/resized.txt"
+	if got := describe(plan); got != want {
+		t.Errorf("plan with -delete is\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestSync(t *testing.T) {
+	s := newFakeS3(t, "b")
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"a.txt": "a", "sub/b.txt": "b"})
+	old := time.Now().Add(-time.Hour)
+	os.Chtimes(filepath.Join(dir, "a.txt"), old, old)
+	s.put("b", "p/a.txt", "a")
+	s.put("b", "p/stale.txt", "x")
+
+	out, err := run(t, "sync", "-delete", "-dry-run", dir, "s3://b/p")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "- s3://b/p/stale.txt\n+ s3://b/p/sub/b.txt\n1 to upload, 0 to update, 1 to delete\nskipped 1 files: 1 unchanged\n"
+	if out != want {
+		t.Errorf("dry run printed %q, want %q", out, want)
+	}
+	if got := strings.Join(s.keys("b"), " "); got != "p/a.txt p/stale.txt" {
+		t.Fatalf("dry run changed the bucket to %s", got)
+	}
+
+	if _, err := run(t, "sync", "-delete", dir, "s3://b/p"); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(s.keys("b"), " "); got != "p/a.txt p/sub/b.txt" {
+		t.Errorf("synced to %s", got)
+	}
+}
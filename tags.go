@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// tagList collects repeated -tag key=value flags
+type tagList []string
+
+func (t *tagList) String() string {
+	return strings.Join(*t, ",")
+}
+
+func (t *tagList) Set(value string) error {
+	kv := strings.SplitN(value, "=", 2)
+	if len(kv) != 2 || kv[0] == "" {
+		return fmt.Errorf("invalid tag '%s' (expected key=value)", value)
+	}
+	*t = append(*t, value)
+	return nil
+}
+
+// Tags of copied objects. By default a copy keeps the tags of its
+// source. With -replace-tags they are replaced by the -tag flags,
+// or removed if there are none.
+var (
+	replaceTags bool
+	copyTags    tagList
+)
+
+// checkTags validates the tagging flags
+func checkTags() error {
+	if len(copyTags) > 0 && !replaceTags {
+		return usageErrorf("-tag requires -replace-tags")
+	}
+	return nil
+}
+
+// encodeTags formats key=value pairs as the URL query string S3
+// expects in the tagging header.
+func encodeTags(pairs []string) string {
+	values := url.Values{}
+	for _, pair := range pairs {
+		kv := strings.SplitN(pair, "=", 2)
+		values.Add(kv[0], kv[1])
+	}
+	return values.Encode()
+}
+
+// taggingDirective returns the directive and tags of a server-side
+// copy.
+func taggingDirective() (*string, *string) {
+	if !replaceTags {
+		return aws.String(s3.TaggingDirectiveCopy), nil
+	}
+	return aws.String(s3.TaggingDirectiveReplace), optionalString(encodeTags(copyTags))
+}
+
+// tagsOf returns the tags of an object in the format of the
+// tagging header, for copies that can't be made server-side.
+// Providers without tagging, or credentials that may not read
+// it, copy the object without tags rather than failing.
+func tagsOf(s3Client *s3.S3, bucket string, key string) (*string, error) {
+	ctx, cancel := jobContext()
+	defer cancel()
+	out, err := s3Client.GetObjectTaggingWithContext(ctx, &s3.GetObjectTaggingInput{
+		Bucket:       aws.String(bucket),
+		Key:          aws.String(key),
+		RequestPayer: optionalString(requestPayer),
+	})
+	if aerr, ok := err.(awserr.Error); ok && (aerr.Code() == "NotImplemented" || aerr.Code() == "AccessDenied") {
+		logWarning("copying '%s' without its tags: %s", key, aerr.Code())
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to get tags of '%s': %w", key, err)
+	}
+	values := url.Values{}
+	for _, tag := range out.TagSet {
+		values.Add(aws.StringValue(tag.Key), aws.StringValue(tag.Value))
+	}
+	return optionalString(values.Encode()), nil
+}
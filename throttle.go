@@ -0,0 +1,106 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
+// throttleCooldown is the minimum time between two reductions
+// of the concurrency limit, so that a burst of throttled
+// responses caused by a single overload only counts once.
+const throttleCooldown = time.Second
+
+// jobThrottle gates how many jobs are fed to the pool at once
+var jobThrottle *throttle
+
+// throttle limits the number of jobs in flight, adapting to
+// S3 throttling (SlowDown, 503) with additive increase and
+// multiplicative decrease: a throttled response halves the
+// limit, and every limit-many jobs that complete afterwards
+// raise it by one again, up to the configured parallelism.
+type throttle struct {
+	mu           sync.Mutex
+	cond         *sync.Cond
+	max          int
+	limit        int
+	inFlight     int
+	completed    int
+	lastDecrease time.Time
+}
+
+func newThrottle(max int) *throttle {
+	if max < 1 {
+		max = 1
+	}
+	t := &throttle{max: max, limit: max}
+	t.cond = sync.NewCond(&t.mu)
+	return t
+}
+
+// acquire blocks until another job may start
+func (t *throttle) acquire() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for t.inFlight >= t.limit {
+		t.cond.Wait()
+	}
+	t.inFlight++
+}
+
+// release marks a job started with acquire as finished
+func (t *throttle) release() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.inFlight--
+	t.completed++
+	if t.completed >= t.limit && t.limit < t.max {
+		t.limit++
+		t.completed = 0
+	}
+	t.cond.Broadcast()
+}
+
+// setMax changes the most jobs allowed in flight, for
+// -concurrency-auto
+func (t *throttle) setMax(max int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.max = max
+	t.limit = max
+	t.completed = 0
+	t.cond.Broadcast()
+}
+
+// running returns the number of jobs currently in flight
+func (t *throttle) running() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.inFlight
+}
+
+// throttled records a throttled response from S3
+func (t *throttle) throttled() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if time.Since(t.lastDecrease) < throttleCooldown {
+		return
+	}
+	t.lastDecrease = time.Now()
+	t.completed = 0
+	if t.limit > 1 {
+		t.limit /= 2
+	}
+}
+
+// observeThrottling is a request handler reporting every
+// throttled attempt, including those the SDK goes on to retry
+// by itself, to the job throttle. It has to run before the
+// SDK's own AfterRetry handler, which clears the error of
+// attempts that will be retried.
+func observeThrottling(r *request.Request) {
+	if jobThrottle != nil && r.IsErrorThrottle() {
+		jobThrottle.throttled()
+	}
+}
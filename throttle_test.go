@@ -0,0 +1,81 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestThrottleBacksOff(t *testing.T) {
+	th := newThrottle(8)
+	th.throttled()
+	if th.limit != 4 {
+		t.Fatalf("limit after throttling is %d, want 4", th.limit)
+	}
+	// Throttled responses within the cooldown only count once
+	th.throttled()
+	if th.limit != 4 {
+		t.Fatalf("limit after throttling twice is %d, want 4", th.limit)
+	}
+	th.lastDecrease = time.Now().Add(-throttleCooldown)
+	th.throttled()
+	if th.limit != 2 {
+		t.Fatalf("limit after the cooldown is %d, want 2", th.limit)
+	}
+	// Every limit-many completed jobs raise the limit by one
+	for _, want := range []int{2, 3, 3, 3, 4} {
+		th.acquire()
+		th.release()
+		if th.limit != want {
+			t.Fatalf("limit is %d, want %d", th.limit, want)
+		}
+	}
+}
+
+func TestThrottleLimitsJobs(t *testing.T) {
+	th := newThrottle(2)
+	th.acquire()
+	th.acquire()
+	acquired := make(chan struct{})
+	go func() {
+		th.acquire()
+		close(acquired)
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("a third job started with a limit of two")
+	case <-time.After(50 * time.Millisecond):
+	}
+	th.release()
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Fatal("no job started after one was released")
+	}
+	if n := th.running(); n != 2 {
+		t.Errorf("%d jobs running, want 2", n)
+	}
+}
+
+func TestThrottleSetMax(t *testing.T) {
+	th := newThrottle(1)
+	th.acquire()
+	acquired := make(chan struct{})
+	go func() {
+		th.acquire()
+		close(acquired)
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("a second job started with a limit of one")
+	case <-time.After(50 * time.Millisecond):
+	}
+	th.setMax(2)
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Fatal("no job started after the limit was raised")
+	}
+	if th.limit != 2 || th.max != 2 {
+		t.Errorf("the limit is %d of at most %d, want 2", th.limit, th.max)
+	}
+}
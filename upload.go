@@ -0,0 +1,827 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+// Preconditions checked with a HEAD request before each upload,
+// named after the HTTP headers they mimic. "*" matches any
+// existing object, anything else is compared to its ETag.
+var (
+	ifMatch     string
+	ifNoneMatch string
+)
+
+// checkUploadPreconditions returns an error if -if-match or
+// -if-none-match forbid uploading to the key. The check and the
+// upload are separate requests, so this narrows the window for
+// races between writers but cannot close it entirely.
+func checkUploadPreconditions(
+	ctx context.Context,
+	client s3iface.S3API,
+	bucket *string,
+	key *string,
+) error {
+	if ifMatch == "" && ifNoneMatch == "" {
+		return nil
+	}
+	exists := true
+	etag := ""
+	out, err := client.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket:       bucket,
+		Key:          key,
+		RequestPayer: optionalString(requestPayer),
+
+		SSECustomerAlgorithm: sseCustomer.algorithm,
+		SSECustomerKey:       sseCustomer.key,
+		SSECustomerKeyMD5:    sseCustomer.keyMD5,
+	})
+	if err != nil {
+		if !isNotFound(err) {
+			return fmt.Errorf("failed to check whether '%s' exists: %w", *key, err)
+		}
+		exists = false
+	} else {
+		etag = aws.StringValue(out.ETag)
+	}
+	if ifNoneMatch != "" && exists && etagMatches(ifNoneMatch, etag) {
+		return fmt.Errorf("not uploading '%s': object already exists", *key)
+	}
+	if ifMatch != "" && !(exists && etagMatches(ifMatch, etag)) {
+		return fmt.Errorf("not uploading '%s': existing object does not match -if-match", *key)
+	}
+	return nil
+}
+
+// etagMatches compares an ETag against a precondition value,
+// ignoring the quotes S3 wraps ETags in.
+func etagMatches(condition string, etag string) bool {
+	if condition == "*" {
+		return true
+	}
+	return strings.Trim(condition, `"`) == strings.Trim(etag, `"`)
+}
+
+// flatten uploads the files of a directory directly beneath the
+// destination prefix, without their subdirectories
+var flatten bool
+
+// onCollision decides what -flatten does with files sharing a
+// base name
+var onCollision string
+
+const (
+	collisionError     = "error"
+	collisionRename    = "rename"
+	collisionSkip      = "skip"
+	collisionOverwrite = "overwrite"
+)
+
+// explicitKey is the key a single file is uploaded to, given
+// with -key instead of in the destination, e.g.
+//
+//	s3util cp foo.txt s3://mybucket -key data/latest.txt
+var explicitKey string
+
+// noFollow refuses to upload a source path that is a symlink
+var noFollow bool
+
+// noGuessContentType leaves the content type of uploads unset,
+// so S3 stores them as binary/octet-stream
+var noGuessContentType bool
+
+// contentType is set by -content-type to override the guess
+var contentType string
+
+// uploadContentType returns the content type given by the
+// -content-type flag, or else the MIME type registered for the
+// file's extension. It is nil if neither is known.
+func uploadContentType(path string) *string {
+	if contentType != "" {
+		return aws.String(contentType)
+	}
+	if noGuessContentType {
+		return nil
+	}
+	return optionalString(mime.TypeByExtension(filepath.Ext(path)))
+}
+
+// trimExtension is removed from the end of generated keys, e.g.
+// ".html" to serve about.html as /about
+var trimExtension string
+
+// extList collects repeated -ext flags, e.g. -ext .jpg -ext .png,
+// normalized to lowercase with a leading dot
+type extList []string
+
+func (e *extList) String() string {
+	return strings.Join(*e, ",")
+}
+
+func (e *extList) Set(value string) error {
+	if value == "" || value == "." {
+		return fmt.Errorf("empty extension")
+	}
+	*e = append(*e, "."+strings.ToLower(strings.TrimPrefix(value, ".")))
+	return nil
+}
+
+// uploadExts restricts directory uploads to files with these
+// extensions, unless it is empty
+var uploadExts extList
+
+// hasUploadExt reports whether a file may be uploaded by -ext
+func hasUploadExt(name string) bool {
+	if len(uploadExts) == 0 {
+		return true
+	}
+	ext := strings.ToLower(filepath.Ext(name))
+	for _, allowed := range uploadExts {
+		if ext == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// lowercaseKeys lowercases the keys generated for uploaded files,
+// for providers and downstream systems that trip over case
+var lowercaseKeys bool
+
+// lowercaseKey lowercases a generated key with -lowercase-keys.
+// Files whose keys only differ in case would overwrite each other
+// once lowercased, so the keys given so far are tracked in seen,
+// mapped to the key they were lowercased from.
+func lowercaseKey(seen map[string]string, key string) (string, error) {
+	if !lowercaseKeys {
+		return key, nil
+	}
+	lowered := strings.ToLower(key)
+	if original, ok := seen[lowered]; ok && original != key {
+		return "", fmt.Errorf("both '%s' and '%s' would be uploaded as '%s' (see -lowercase-keys)", original, key, lowered)
+	}
+	seen[lowered] = key
+	return lowered, nil
+}
+
+// trimKey removes -trim-extension from a generated key, unless
+// nothing would remain of it.
+func trimKey(key string) string {
+	if trimExtension == "" || path.Base(key) == trimExtension {
+		return key
+	}
+	return strings.TrimSuffix(key, trimExtension)
+}
+
+// e.g. "en-US" or "de, en"
+var contentLanguage string
+
+// websiteRedirect makes uploads redirects of a bucket configured
+// as a static website, to a path in the bucket such as "/new" or
+// to a URL
+var websiteRedirect string
+
+// checkWebsiteRedirect validates -website-redirect
+func checkWebsiteRedirect() error {
+	if websiteRedirect == "" ||
+		strings.HasPrefix(websiteRedirect, "/") ||
+		strings.HasPrefix(websiteRedirect, "http://") ||
+		strings.HasPrefix(websiteRedirect, "https://") {
+		return nil
+	}
+	return usageErrorf("-website-redirect must start with /, http:// or https://")
+}
+
+// sendContentMD5 is set by -content-md5
+var sendContentMD5 bool
+
+// contentMD5 returns the base64 encoded MD5 of the rest of the
+// file, as expected in the Content-MD5 header, and rewinds it.
+// S3 rejects uploads whose body doesn't match the header, which
+// catches corruption in transit.
+func contentMD5(f io.ReadSeeker) (string, error) {
+	h := md5.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(h.Sum(nil)), nil
+}
+
+func uploadSingleFile(
+	uploader *s3manager.Uploader,
+	bucket *string, // sent in as string pointer for effiency's sake
+	key *string,
+	sourcePath string,
+	prog *progress,
+) (err error) {
+	defer func() { prog.fileDone(err) }()
+	return withRetriesProgress(prog, func(prog *progress) error {
+		return uploadAttempt(uploader, bucket, key, sourcePath, prog)
+	})
+}
+
+// uploadAttempt makes a single attempt at uploading a file.
+func uploadAttempt(
+	uploader *s3manager.Uploader,
+	bucket *string,
+	key *string,
+	sourcePath string,
+	prog *progress,
+) error {
+	f, err := openWithRetry(sourcePath)
+	if err != nil {
+		return fmt.Errorf("failed to read source file '%s': %w", sourcePath, err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat source file '%s': %w", sourcePath, err)
+	}
+	var md5Sum *string
+	var opts []func(*s3manager.Uploader)
+	if sendContentMD5 {
+		// Content-MD5 only applies to uploads made with a single
+		// PutObject. The uploader ignores it for multipart ones.
+		if info.Size() <= uploader.PartSize {
+			sum, err := contentMD5(&retryingFile{f})
+			if err != nil {
+				return fmt.Errorf("failed to checksum '%s': %w", sourcePath, err)
+			}
+			md5Sum = aws.String(sum)
+		}
+		if info.Size() == uploader.PartSize {
+			// Unless it can seek in the body, e.g. with -progress,
+			// the uploader can't tell exactly -part-size bytes from
+			// more and would upload them in parts
+			opts = append(opts, func(u *s3manager.Uploader) { u.PartSize++ })
+		}
+	}
+	src := &retryingFile{f}
+	var body io.Reader = src
+	if prog != nil {
+		body = &progressReader{r: src, p: prog}
+	}
+	if limiters := fileLimiters(); limiters != nil {
+		body = &limitedReader{r: body, limiters: limiters}
+	}
+	var metadata map[string]*string
+	if clientSideKey != nil {
+		if body, metadata, err = newEncryptingReader(clientSideKey, body); err != nil {
+			return err
+		}
+	}
+	if preserveMode {
+		metadata = setModeMetadata(metadata, info.Mode())
+	}
+	ctx, cancel := jobContext()
+	defer cancel()
+	if err := checkUploadPreconditions(ctx, uploader.S3, bucket, key); err != nil {
+		return err
+	}
+	input := &s3manager.UploadInput{
+		Bucket:          bucket,
+		Key:             key,
+		Body:            body,
+		ContentMD5:      md5Sum,
+		ContentType:     uploadContentType(sourcePath),
+		ContentLanguage: optionalString(contentLanguage),
+		Metadata:        metadata,
+		StorageClass:    optionalString(storageClass),
+		ACL:             optionalString(cannedACL),
+
+		WebsiteRedirectLocation: optionalString(websiteRedirect),
+
+		ServerSideEncryption:    serverSideEncryption.algorithm,
+		SSEKMSKeyId:             serverSideEncryption.kmsKeyID,
+		SSEKMSEncryptionContext: serverSideEncryption.encryptionContext,
+
+		SSECustomerAlgorithm: sseCustomer.algorithm,
+		SSECustomerKey:       sseCustomer.key,
+		SSECustomerKeyMD5:    sseCustomer.keyMD5,
+
+		ObjectLockMode:            objectLock.mode,
+		ObjectLockRetainUntilDate: objectLock.retainUntil,
+		ObjectLockLegalHoldStatus: objectLock.legalHold,
+	}
+	if restoreSidecar {
+		if err := applySidecar(input, sourcePath); err != nil {
+			return err
+		}
+	}
+	if resumableUpload && info.Size() > uploader.PartSize {
+		err = uploadResumable(ctx, uploader, input, f, info, prog)
+	} else {
+		_, err = uploader.UploadWithContext(ctx, input, opts...)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to upload '%s': %w", sourcePath, err)
+	}
+	logSuccess("upload: %s to s3://%s/%s", sourcePath, *bucket, *key)
+	return nil
+}
+
+func upload(source string, dest string) error {
+	bucketName, key, err := splitNameParts(dest)
+	if err != nil {
+		return fmt.Errorf("failed to parse s3 output name parts: %w", err)
+	}
+	if explicitKey != "" {
+		if key != "" {
+			return usageErrorf("-key cannot be combined with the key '%s' in the destination", key)
+		}
+		if expandArchive || contentAddressed {
+			return usageErrorf("-key cannot be combined with -expand-archive or -content-addressed")
+		}
+		key = explicitKey
+	}
+	if source == stdinSource {
+		if expandArchive || contentAddressed || splitSize > 0 || clientSideKey != nil {
+			return usageErrorf("uploading stdin cannot be combined with -expand-archive, -content-addressed, -split-size or -encrypt-client-side")
+		}
+		// Stdin can only be read once, as it is uploaded, and has
+		// no file to take a checksum, mode or sidecar from
+		if sendContentMD5 || preserveMode || restoreSidecar || resumableUpload {
+			return usageErrorf("uploading stdin cannot be combined with -content-md5, -preserve-mode, -from-metadata-sidecar or -resumable-upload")
+		}
+		return uploadStdin(bucketName, key)
+	} else if contentLength >= 0 {
+		return usageErrorf("-content-length only applies to uploads from stdin (%s)", stdinSource)
+	}
+	if expandArchive {
+		return uploadArchive(source, bucketName, key)
+	}
+	bucket := aws.String(bucketName)
+
+	uploader := newUploader(createSession())
+
+	var acl *s3.AccessControlPolicy
+	if aclFile != "" {
+		if acl, err = loadACLPolicy(aclFile); err != nil {
+			return err
+		}
+	}
+
+	var matches []string
+	var info os.FileInfo
+	var sourcePath string
+	if isGlobPattern(source) {
+		matches, err = filepath.Glob(source)
+		if err != nil {
+			return fmt.Errorf("invalid source pattern '%s': %w", source, err)
+		}
+		if len(matches) == 0 {
+			return fmt.Errorf("no files match '%s'", source)
+		}
+	} else {
+		// A symlink given as the source is followed, so the file
+		// it points to is uploaded under the name of the link,
+		// unless -no-follow is given.
+		if noFollow {
+			if linkInfo, err := os.Lstat(source); err == nil && linkInfo.Mode()&os.ModeSymlink != 0 {
+				return fmt.Errorf("'%s' is a symlink (not following it because of -no-follow)", source)
+			}
+		}
+		info, err = os.Stat(source)
+		if err != nil {
+			return fmt.Errorf("failed to stat input path '%s': %w", source, err)
+		}
+
+		sourcePath, err = filepath.Abs(source)
+		if err != nil {
+			return fmt.Errorf("failed to get absolute path of source: %w", err)
+		}
+	}
+	if explicitKey != "" && (matches != nil || info.IsDir()) {
+		return usageErrorf("-key only applies to uploads of a single file")
+	}
+	if splitSize > 0 && (matches != nil || info.IsDir() || contentAddressed) {
+		return usageErrorf("-split-size only applies to uploads of a single file")
+	}
+
+	var totalFiles int
+	var totalBytes int64
+	if (precomputeTotal || maxFiles > 0) && info != nil && info.IsDir() {
+		if totalFiles, totalBytes, err = measureDir(filepath.Clean(sourcePath)); err != nil {
+			return fmt.Errorf("failed to walk source directory: %w", err)
+		}
+		if maxFiles > 0 && totalFiles > maxFiles {
+			return fmt.Errorf("'%s' contains %d files, more than -max-files %d (upload only some of them with -ext, or raise -max-files)", source, totalFiles, maxFiles)
+		}
+	}
+
+	keyPrefix := ""
+
+	type uploadJob struct {
+		inputFullPath string
+		outputKey     string
+		emptyDir      bool // upload a marker instead of the file
+
+		// skipped is set once the file turns out to be stored
+		// already or is left alone by -overwrite-policy, so that
+		// -verify doesn't check objects this run didn't write
+		skipped bool
+	}
+
+	// Jobs are fed to the pool through a bounded channel as
+	// they are found, rather than collected up front, so memory
+	// use stays constant no matter how many files are uploaded.
+	jobs := make(chan uploadJob, parallelism)
+	var produceErr error
+	var skipped skipTally
+
+	// Except with -flatten or -lowercase-keys, whose keys can
+	// collide. Their jobs are held back until every key has been
+	// generated, so that a collision fails the upload before
+	// anything is uploaded rather than halfway through.
+	holdJobs := flatten || lowercaseKeys
+	var held []uploadJob
+	queue := func(job uploadJob) {
+		if holdJobs {
+			held = append(held, job)
+		} else {
+			jobs <- job
+		}
+	}
+	release := func() {
+		if produceErr == nil {
+			for _, job := range held {
+				jobs <- job
+			}
+		}
+		close(jobs)
+	}
+
+	if matches != nil {
+		// Input is a glob pattern. Every matching file is
+		// uploaded under the destination key using its base
+		// name, e.g.
+		//
+		//     s3util 'images/*.png' s3://mybucket/pics/
+		//
+		// Result: s3://mybucket/pics/foo.png
+		//         s3://mybucket/pics/bar.png
+		//
+		// Directories are not descended into.
+		keyPrefix = strings.TrimSuffix(key, "/")
+		lowered := make(map[string]string)
+		go func() {
+			defer release()
+			produceErr = func() error {
+				numFiles := 0
+				for _, match := range matches {
+					matchInfo, err := os.Stat(match)
+					if err != nil {
+						return fmt.Errorf("failed to stat '%s': %w", match, err)
+					}
+					if matchInfo.IsDir() {
+						continue
+					}
+					if restoreSidecar && isSidecar(match) {
+						skipped.skip(skipSidecar)
+						continue
+					}
+					fullPath, err := filepath.Abs(match)
+					if err != nil {
+						return fmt.Errorf("failed to get full path of '%s': %w", match, err)
+					}
+					outputKey, err := lowercaseKey(lowered, trimKey(matchInfo.Name()))
+					if err != nil {
+						return err
+					}
+					queue(uploadJob{
+						inputFullPath: fullPath,
+						outputKey:     outputKey,
+					})
+					numFiles++
+				}
+				if numFiles == 0 {
+					return fmt.Errorf("'%s' only matches directories", source)
+				}
+				return nil
+			}()
+		}()
+	} else if info.IsDir() {
+		// Specifying a target of s3://mybucket/myprefix and an input
+		// path that is a folder will result in some input file `foo.txt`
+		// being uploaded to s3://mybucket/myprefix/foo.txt
+		// Example (upload current directory, prefix all keys with "images")
+		//
+		//     s3util . s3://mybucket/images
+		//
+		// Result: s3://mybucket/images/foo.png
+		//         s3://mybucket/images/bar.png
+		//         s3://mybucket/images/subdirectory/baz.jpg
+		//         ...
+		//
+		// Keys are relative to the cleaned source path, so that
+		// ./images and ./images/ produce the same keys, and
+		// always use forward slashes regardless of the OS.
+		//
+		// With -flatten every file is uploaded directly under the
+		// prefix using its base name, e.g. s3://mybucket/images/baz.jpg
+		keyPrefix = strings.TrimSuffix(key, "/")
+		sourcePath = filepath.Clean(sourcePath)
+		seen := make(map[string]bool)
+		lowered := make(map[string]string)
+
+		go func() {
+			defer release()
+			if err := filepath.Walk(
+				sourcePath,
+				func(path string, info os.FileInfo, err error) error {
+					if err != nil {
+						return err
+					}
+					if info.IsDir() {
+						if !keepEmptyDirs || flatten || path == sourcePath {
+							return nil
+						}
+						if empty, err := isEmptyDir(path); err != nil || !empty {
+							return err
+						}
+						relPath, err := filepath.Rel(sourcePath, path)
+						if err != nil {
+							return fmt.Errorf("failed to get relative path of '%s': %w", path, err)
+						}
+						queue(uploadJob{
+							inputFullPath: path,
+							outputKey:     filepath.ToSlash(relPath) + "/",
+							emptyDir:      true,
+						})
+						return nil
+					}
+
+					if !hasUploadExt(info.Name()) {
+						skipped.skip(skipExtension)
+						return nil
+					}
+					if restoreSidecar && isSidecar(path) {
+						skipped.skip(skipSidecar)
+						return nil
+					}
+
+					fullPath, err := filepath.Abs(path)
+					if err != nil {
+						return fmt.Errorf("failed to get full path of '%s': %w", info.Name(), err)
+					}
+
+					relPath, err := filepath.Rel(sourcePath, fullPath)
+					if err != nil {
+						return fmt.Errorf("failed to get relative path of '%s': %w", fullPath, err)
+					}
+					outputKey := trimKey(filepath.ToSlash(relPath))
+					if flatten {
+						// Lowercased names are flattened, so that
+						// -on-collision applies to clashes in case
+						name := trimKey(info.Name())
+						if lowercaseKeys {
+							name = strings.ToLower(name)
+						}
+						if outputKey, err = flattenKey(seen, name); err != nil {
+							return fmt.Errorf("cannot flatten '%s': %w", fullPath, err)
+						} else if outputKey == "" {
+							skipped.skip(skipDuplicate)
+							return nil
+						}
+					} else if outputKey, err = lowercaseKey(lowered, outputKey); err != nil {
+						return err
+					}
+
+					queue(uploadJob{
+						inputFullPath: fullPath,
+						outputKey:     outputKey,
+					})
+
+					return nil
+				},
+			); err != nil {
+				produceErr = fmt.Errorf("failed to walk source directory: %w", err)
+			}
+		}()
+	} else {
+		// Input is a specific file. Output path will either
+		// be just an s3 bucket - in which case we'll use
+		// the file name as the key - or it will be a key
+		// that we will use verbatim.
+		if contentAddressed {
+			// The key is only a prefix for the hash
+			keyPrefix = strings.TrimSuffix(key, "/")
+		} else if key == "" {
+			// No key was specified. Use the file name as the key.
+			key = trimKey(info.Name())
+			if lowercaseKeys {
+				key = strings.ToLower(key)
+			}
+		}
+		if splitSize > 0 {
+			return uploadSplit(uploader, bucket, key, sourcePath, info.Size())
+		}
+		jobs <- uploadJob{
+			inputFullPath: sourcePath,
+			outputKey:     key,
+		}
+		close(jobs)
+	}
+
+	// The total isn't known until the walk is over, unless it
+	// is measured beforehand
+	prog := startProgress(totalFiles)
+	prog.setTotalBytes(totalBytes)
+
+	// applyACL sets the ACL of an uploaded file's object given by
+	// -acl-from-file or its sidecar, if any
+	applyACL := func(key *string, path string) error {
+		policy := acl
+		if policy == nil && restoreSidecar {
+			var err error
+			if policy, err = sidecarACL(path); err != nil {
+				return err
+			}
+		}
+		if policy == nil {
+			return nil
+		}
+		return putObjectACL(uploader.S3, bucket, key, policy)
+	}
+
+	pool := newJobPool(parallelism, func(payload interface{}) interface{} {
+		j := payload.(*uploadJob)
+		// Uploading to the root of a bucket has no prefix, and
+		// its keys must not start with a slash.
+		if contentAddressed && !j.emptyDir {
+			casKey, err := contentKey(j.inputFullPath)
+			if err != nil {
+				return err
+			}
+			j.outputKey = casKey
+			casKeyWithPrefix := joinKey(keyPrefix, casKey)
+			exists, err := objectExists(uploader.S3, bucket, aws.String(casKeyWithPrefix))
+			if err != nil {
+				return err
+			} else if exists {
+				logSuccess("skip: %s is already stored as s3://%s/%s", j.inputFullPath, *bucket, casKeyWithPrefix)
+				skipped.skip(skipStored)
+				prog.fileDone(nil)
+				j.skipped = true
+				return nil
+			}
+		}
+		key := aws.String(joinKey(keyPrefix, j.outputKey))
+		if !j.emptyDir {
+			reason, err := overwriteSkipReason(uploader.S3, *bucket, *key, j.inputFullPath)
+			if err != nil {
+				return err
+			} else if reason != "" {
+				skipped.skip(reason)
+				prog.fileDone(nil)
+				j.skipped = true
+				return nil
+			}
+		}
+		if j.emptyDir {
+			if err := putDirMarker(uploader.S3, bucket, key, prog); err != nil {
+				return err
+			}
+		} else if err := uploadSingleFile(
+			uploader,
+			bucket,
+			key,
+			j.inputFullPath,
+			prog); err != nil {
+			return err
+		}
+		return applyACL(key, j.inputFullPath)
+	})
+	defer pool.Close()
+
+	var (
+		wg       sync.WaitGroup
+		errsMu   sync.Mutex
+		errs     []error
+		numJobs  int
+		uploaded []uploadedFile
+	)
+	for job := range jobs {
+		numJobs++
+		job := job
+		startJob(&wg, func() {
+			err, _ := pool.Process(&job).(error)
+			errsMu.Lock()
+			defer errsMu.Unlock()
+			if err != nil {
+				errs = append(errs, err)
+			} else if !job.emptyDir && !job.skipped {
+				uploaded = append(uploaded, uploadedFile{
+					path: job.inputFullPath,
+					key:  joinKey(keyPrefix, job.outputKey),
+				})
+			}
+		})
+	}
+	wg.Wait()
+	prog.finish()
+	skipped.report()
+	for _, err := range errs {
+		logError(err)
+	}
+	if produceErr != nil {
+		return produceErr
+	}
+	if len(errs) > 0 {
+		return &batchError{failed: len(errs), total: numJobs, what: "uploads"}
+	}
+	if verifyUploads {
+		return verifyUploaded(uploader.S3, bucketName, uploaded, func(f uploadedFile) error {
+			key := aws.String(f.key)
+			if err := uploadSingleFile(uploader, bucket, key, f.path, nil); err != nil {
+				return err
+			}
+			return applyACL(key, f.path)
+		})
+	}
+
+	return nil
+}
+
+// precomputeTotal walks a source directory before uploading it to
+// measure the total for -progress, at the cost of a second walk
+var precomputeTotal bool
+
+// maxFiles refuses to upload a directory of more files than this,
+// in case the wrong one was given. Zero means no limit.
+var maxFiles int
+
+// measureDir returns the number and total size of the files in a
+// directory that would be uploaded
+func measureDir(dir string) (int, int64, error) {
+	var files int
+	var bytes int64
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && hasUploadExt(info.Name()) && !(restoreSidecar && isSidecar(path)) {
+			files++
+			bytes += info.Size()
+		}
+		return nil
+	})
+	return files, bytes, err
+}
+
+// flattenKey returns the key a file is uploaded as with -flatten,
+// given the base names used so far. An empty key means the file
+// is skipped because of -on-collision skip.
+func flattenKey(seen map[string]bool, name string) (string, error) {
+	if !seen[name] {
+		seen[name] = true
+		return name, nil
+	}
+	switch onCollision {
+	case collisionSkip:
+		return "", nil
+	case collisionOverwrite:
+		// Whichever of the files is uploaded last wins
+		return name, nil
+	case collisionRename:
+		ext := path.Ext(name)
+		base := strings.TrimSuffix(name, ext)
+		for i := 1; ; i++ {
+			candidate := fmt.Sprintf("%s-%d%s", base, i, ext)
+			if !seen[candidate] {
+				seen[candidate] = true
+				return candidate, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("another file is also named '%s' (see -on-collision)", name)
+}
+
+// isGlobPattern reports whether the source path should be
+// expanded with filepath.Glob. Paths that exist as-is are
+// taken literally even if they contain pattern characters.
+func isGlobPattern(source string) bool {
+	if !strings.ContainsAny(source, "*?[") {
+		return false
+	}
+	_, err := os.Stat(source)
+	return os.IsNotExist(err)
+}
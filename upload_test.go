@@ -0,0 +1,520 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPerFileTimeout(t *testing.T) {
+	s := newFakeS3(t, "b")
+	s.intercept = func(w http.ResponseWriter, r *http.Request) bool {
+		if strings.HasSuffix(r.URL.Path, "/slow.txt") {
+			// The connection is only watched once the body is read
+			io.Copy(io.Discard, r.Body)
+			select {
+			case <-r.Context().Done():
+			case <-time.After(5 * time.Second):
+			}
+			return true
+		}
+		return false
+	}
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"fast.txt": "fast", "slow.txt": "slow"})
+	started := time.Now()
+	_, err := run(t, "-per-file-timeout", "200ms", "-max-retries", "0", dir, "s3://b/dir/")
+	if elapsed := time.Since(started); elapsed > 3*time.Second {
+		t.Errorf("the upload took %s despite the timeout", elapsed)
+	}
+	if code := exitCode(err); code != exitPartial {
+		t.Fatalf("exited with %d (%v), want %d", code, err, exitPartial)
+	}
+	if s.object("b", "dir/fast.txt") == nil {
+		t.Error("fast.txt wasn't uploaded")
+	}
+	if s.object("b", "dir/slow.txt") != nil {
+		t.Error("slow.txt was uploaded")
+	}
+}
+
+func TestIsGlobPattern(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"[literal].txt": ""})
+	for _, tt := range []struct {
+		source string
+		want   bool
+	}{
+		{filepath.Join(dir, "*.txt"), true},
+		{filepath.Join(dir, "a?.txt"), true},
+		{filepath.Join(dir, "a.txt"), false},
+		{filepath.Join(dir, "[literal].txt"), false},
+	} {
+		if got := isGlobPattern(tt.source); got != tt.want {
+			t.Errorf("isGlobPattern(%q) = %v, want %v", tt.source, got, tt.want)
+		}
+	}
+}
+
+func TestUploadGlob(t *testing.T) {
+	s := newFakeS3(t, "b")
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"a.png":       "a",
+		"b.png":       "b",
+		"c.txt":       "c",
+		"sub/d.png":   "d",
+		"dir.png/e.x": "e",
+	})
+	if _, err := run(t, filepath.Join(dir, "*.png"), "s3://b/pics/"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := strings.Join(s.keys("b"), " "), "pics/a.png pics/b.png"; got != want {
+		t.Errorf("uploaded %s, want %s", got, want)
+	}
+	if _, err := run(t, filepath.Join(dir, "*.gif"), "s3://b/pics/"); err == nil {
+		t.Error("expected an error for a pattern matching nothing")
+	}
+}
+
+func TestEtagMatches(t *testing.T) {
+	for _, tt := range []struct {
+		condition, etag string
+		want            bool
+	}{
+		{"*", `"abc"`, true},
+		{"abc", `"abc"`, true},
+		{`"abc"`, `"abc"`, true},
+		{"abd", `"abc"`, false},
+	} {
+		if got := etagMatches(tt.condition, tt.etag); got != tt.want {
+			t.Errorf("etagMatches(%q, %q) = %v, want %v", tt.condition, tt.etag, got, tt.want)
+		}
+	}
+}
+
+func TestUploadPreconditions(t *testing.T) {
+	s := newFakeS3(t, "b")
+	s.put("b", "existing.txt", "old")
+	etag := strings.Trim(s.object("b", "existing.txt").etag, `"`)
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"a.txt": "new"})
+	file := filepath.Join(dir, "a.txt")
+	for _, tt := range []struct {
+		flag, value, key string
+		uploaded         bool
+	}{
+		{"-if-none-match", "*", "existing.txt", false},
+		{"-if-none-match", "*", "missing.txt", true},
+		{"-if-match", "*", "missing2.txt", false},
+		{"-if-match", "wrong", "existing.txt", false},
+		{"-if-match", etag, "existing.txt", true},
+	} {
+		_, err := run(t, tt.flag, tt.value, "-max-retries", "0", file, "s3://b/"+tt.key)
+		if tt.uploaded != (err == nil) {
+			t.Errorf("%s %s to %s: %v", tt.flag, tt.value, tt.key, err)
+		}
+		obj := s.object("b", tt.key)
+		if tt.uploaded != (obj != nil && string(obj.data) == "new") {
+			t.Errorf("%s %s to %s: object is %v", tt.flag, tt.value, tt.key, obj)
+		}
+	}
+}
+
+func TestUploadDirectoryStreamsJobs(t *testing.T) {
+	s := newFakeS3(t, "b")
+	dir := t.TempDir()
+	files := make(map[string]string)
+	var want []string
+	for i := 0; i < 25; i++ {
+		name := fmt.Sprintf("d%d/f%02d.txt", i%3, i)
+		files[name] = name
+		want = append(want, "up/"+name)
+	}
+	sort.Strings(want)
+	writeFiles(t, dir, files)
+	if _, err := run(t, "-parallelism", "2", dir, "s3://b/up"); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.keys("b"); strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("uploaded %v, want %v", got, want)
+	}
+	for _, key := range want {
+		if got := string(s.object("b", key).data); got != strings.TrimPrefix(key, "up/") {
+			t.Errorf("%s holds %q", key, got)
+		}
+	}
+}
+
+func TestUploadDirectoryKeys(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"images/a.png": "a", "images/sub/b.png": "b"})
+	images := filepath.Join(dir, "images")
+	for _, source := range []string{
+		images,
+		images + string(filepath.Separator),
+		filepath.Join(images, ".") + string(filepath.Separator) + ".",
+		filepath.Join(images, "sub") + string(filepath.Separator) + "..",
+	} {
+		s := newFakeS3(t, "b")
+		if _, err := run(t, source, "s3://b/pics"); err != nil {
+			t.Fatalf("%s: %v", source, err)
+		}
+		if got, want := strings.Join(s.keys("b"), " "), "pics/a.png pics/sub/b.png"; got != want {
+			t.Errorf("%s uploaded %s, want %s", source, got, want)
+		}
+	}
+}
+
+func TestContentMD5(t *testing.T) {
+	s := newFakeS3(t, "b")
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"a.txt": "hello"})
+	file := filepath.Join(dir, "a.txt")
+	if _, err := run(t, "-content-md5", file, "s3://b/a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	puts := s.received(http.MethodPut, "")
+	if len(puts) != 1 {
+		t.Fatalf("expected a single PutObject, got %d requests", len(puts))
+	}
+	// base64 of the MD5 of "hello"
+	if got, want := puts[0].header.Get("Content-Md5"), "XUFAKrxLKna5cZ2REBfFkg=="; got != want {
+		t.Errorf("Content-MD5 is %q, want %q", got, want)
+	}
+}
+
+func TestContentMD5OfExactlyOnePart(t *testing.T) {
+	s := newFakeS3(t, "b")
+	dir := t.TempDir()
+	data := strings.Repeat("x", 5<<20)
+	writeFiles(t, dir, map[string]string{"a.bin": data})
+	sum := md5.Sum([]byte(data))
+	want := base64.StdEncoding.EncodeToString(sum[:])
+	for i, flags := range [][]string{nil, {"-progress"}} {
+		args := append([]string{"-content-md5", "-part-size", "5242880"}, flags...)
+		if _, err := run(t, append(args, filepath.Join(dir, "a.bin"), "s3://b/a.bin")...); err != nil {
+			t.Fatal(err)
+		}
+		puts := s.received(http.MethodPut, "")
+		if multipart := len(s.received(http.MethodPost, "uploads")); len(puts) != i+1 || multipart != 0 {
+			t.Fatalf("%v: expected a single PutObject, got %d PUTs and %d multipart uploads", flags, len(puts)-i, multipart)
+		}
+		if got := puts[i].header.Get("Content-Md5"); got != want {
+			t.Errorf("%v: Content-MD5 is %q, want %q", flags, got, want)
+		}
+	}
+}
+
+func TestUploadSymlinkedFile(t *testing.T) {
+	s := newFakeS3(t, "b")
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"target.txt": "target"})
+	link := filepath.Join(dir, "link.txt")
+	if err := os.Symlink(filepath.Join(dir, "target.txt"), link); err != nil {
+		t.Skip(err)
+	}
+	if _, err := run(t, link, "s3://b/"); err != nil {
+		t.Fatal(err)
+	}
+	if obj := s.object("b", "link.txt"); obj == nil || string(obj.data) != "target" {
+		t.Errorf("uploaded %v under the link's name", s.keys("b"))
+	}
+	if _, err := run(t, "-no-follow", link, "s3://b/other.txt"); err == nil {
+		t.Error("expected -no-follow to refuse the symlink")
+	}
+	if s.object("b", "other.txt") != nil {
+		t.Error("uploaded the symlink's target despite -no-follow")
+	}
+}
+
+func TestUploadToBucketRoot(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"foo.txt": "foo", "sub/bar.txt": "bar"})
+	for _, dest := range []string{"s3://b", "s3://b/"} {
+		s := newFakeS3(t, "b")
+		if _, err := run(t, dir, dest); err != nil {
+			t.Fatalf("%s: %v", dest, err)
+		}
+		if got, want := strings.Join(s.keys("b"), " "), "foo.txt sub/bar.txt"; got != want {
+			t.Errorf("%s uploaded %s, want %s", dest, got, want)
+		}
+	}
+}
+
+func TestUploadContentType(t *testing.T) {
+	s := newFakeS3(t, "b")
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"index.html": "<p>"})
+	for _, tt := range []struct {
+		args []string
+		file string
+		want string
+	}{
+		{nil, "index.html", "text/html; charset=utf-8"},
+		{[]string{"-content-type", "text/plain"}, "index.html", "text/plain"},
+		{[]string{"-no-guess-content-type"}, "index.html", ""},
+		{[]string{"-no-guess-content-type", "-content-type", "text/plain"}, "index.html", "text/plain"},
+	} {
+		args := append(tt.args, filepath.Join(dir, tt.file), "s3://b/"+tt.file)
+		if _, err := run(t, args...); err != nil {
+			t.Fatal(err)
+		}
+		puts := s.received(http.MethodPut, "")
+		if got := puts[len(puts)-1].header.Get("Content-Type"); got != tt.want {
+			t.Errorf("%v sent the content type %q, want %q", tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestUploadFlatten(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"a/x.txt": "a", "b/x.txt": "b", "b/c/y.txt": "y"})
+	for _, tt := range []struct {
+		onCollision string
+		keys        string
+		ok          bool
+	}{
+		{"error", "", false},
+		{"skip", "flat/x.txt flat/y.txt", true},
+		{"overwrite", "flat/x.txt flat/y.txt", true},
+		{"rename", "flat/x-1.txt flat/x.txt flat/y.txt", true},
+	} {
+		s := newFakeS3(t, "b")
+		_, err := run(t, "-flatten", "-on-collision", tt.onCollision, dir, "s3://b/flat/")
+		if tt.ok != (err == nil) {
+			t.Errorf("-on-collision %s: %v", tt.onCollision, err)
+		}
+		if !tt.ok {
+			// The clash is found before anything is uploaded
+			if n := len(s.received(http.MethodPut, "")); n != 0 {
+				t.Errorf("-on-collision %s uploaded %d files before failing", tt.onCollision, n)
+			}
+			continue
+		}
+		if got := strings.Join(s.keys("b"), " "); got != tt.keys {
+			t.Errorf("-on-collision %s uploaded %s, want %s", tt.onCollision, got, tt.keys)
+		}
+		if tt.onCollision == "skip" || tt.onCollision == "rename" {
+			if got := string(s.object("b", "flat/x.txt").data); got != "a" {
+				t.Errorf("-on-collision %s uploaded %q as x.txt, want the first file", tt.onCollision, got)
+			}
+		}
+	}
+}
+
+func TestUploadContentLanguage(t *testing.T) {
+	s := newFakeS3(t, "b")
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"index.html": "<p>"})
+	file := filepath.Join(dir, "index.html")
+	if _, err := run(t, "-content-language", "de-DE", file, "s3://b/de.html"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := run(t, file, "s3://b/plain.html"); err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]string{"de.html": "de-DE", "plain.html": ""} {
+		if got := s.object("b", key).header.Get("Content-Language"); got != want {
+			t.Errorf("%s has the Content-Language %q, want %q", key, got, want)
+		}
+	}
+}
+
+func TestTrimKey(t *testing.T) {
+	defer func(old string) { trimExtension = old }(trimExtension)
+	trimExtension = ".html"
+	for key, want := range map[string]string{
+		"about.html":     "about",
+		"blog/post.html": "blog/post",
+		"style.css":      "style.css",
+		"page.html.bak":  "page.html.bak",
+		"dir/.html":      "dir/.html",
+	} {
+		if got := trimKey(key); got != want {
+			t.Errorf("trimKey(%q) = %q, want %q", key, got, want)
+		}
+	}
+}
+
+func TestUploadTrimExtension(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"about.html": "<p>", "blog/post.html": "<p>", "style.css": "p{}"})
+	for _, tt := range []struct {
+		flags       []string
+		contentType string
+	}{
+		{nil, "text/html; charset=utf-8"},
+		{[]string{"-content-type", "text/html"}, "text/html"},
+	} {
+		s := newFakeS3(t, "b")
+		args := append(append([]string{"-trim-extension", ".html"}, tt.flags...), dir, "s3://b/")
+		if _, err := run(t, args...); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := strings.Join(s.keys("b"), " "), "about blog/post style.css"; got != want {
+			t.Errorf("%v uploaded %s, want %s", tt.flags, got, want)
+		}
+		if got := s.object("b", "about").header.Get("Content-Type"); got != tt.contentType {
+			t.Errorf("%v uploaded about with the content type %q, want %q", tt.flags, got, tt.contentType)
+		}
+	}
+}
+
+func TestLowercaseKey(t *testing.T) {
+	defer func() { lowercaseKeys = false }()
+	seen := make(map[string]string)
+	lowercaseKeys = false
+	if got, err := lowercaseKey(seen, "Docs/README.md"); err != nil || got != "Docs/README.md" {
+		t.Errorf("without -lowercase-keys got %q, %v", got, err)
+	}
+	lowercaseKeys = true
+	for _, tt := range []struct {
+		key, want string
+		clash     bool
+	}{
+		{"Docs/README.md", "docs/readme.md", false},
+		{"Docs/README.md", "docs/readme.md", false},
+		{"docs/Readme.md", "", true},
+		{"Docs/Other.md", "docs/other.md", false},
+	} {
+		got, err := lowercaseKey(seen, tt.key)
+		if got != tt.want || tt.clash != (err != nil) {
+			t.Errorf("lowercaseKey(%q) = %q, %v, want %q", tt.key, got, err, tt.want)
+		}
+	}
+}
+
+func TestUploadLowercaseKeys(t *testing.T) {
+	s := newFakeS3(t, "b")
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"Images/Logo.PNG": "png", "index.HTML": "html"})
+	if _, err := run(t, "-lowercase-keys", dir, "s3://b/Site/"); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(s.keys("b"), " "); got != "Site/images/logo.png Site/index.html" {
+		t.Errorf("uploaded %s", got)
+	}
+
+	writeFiles(t, dir, map[string]string{"images/logo.png": "other"})
+	if _, err := run(t, "-lowercase-keys", dir, "s3://b/clash/"); err == nil {
+		t.Error("expected the files to clash")
+	}
+	for _, key := range s.keys("b") {
+		if strings.HasPrefix(key, "clash/") {
+			t.Errorf("uploaded %s before the clash was found", key)
+		}
+	}
+}
+
+func TestExtList(t *testing.T) {
+	var exts extList
+	for _, value := range []string{".jpg", "PNG", ".Tar.GZ"} {
+		if err := exts.Set(value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := exts.String(); got != ".jpg,.png,.tar.gz" {
+		t.Errorf("got %s", got)
+	}
+	for _, value := range []string{"", "."} {
+		if err := exts.Set(value); err == nil {
+			t.Errorf("-ext %q was accepted", value)
+		}
+	}
+}
+
+func TestUploadExt(t *testing.T) {
+	s := newFakeS3(t, "b")
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"a.jpg":       "a",
+		"sub/b.PNG":   "b",
+		"c.txt":       "c",
+		"d.jpg.bak":   "d",
+		"noextension": "e",
+	})
+	if _, err := run(t, "-ext", ".jpg", "-ext", "png", dir, "s3://b/"); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(s.keys("b"), " "); got != "a.jpg sub/b.PNG" {
+		t.Errorf("uploaded %s", got)
+	}
+}
+
+func TestUploadExplicitKey(t *testing.T) {
+	s := newFakeS3(t, "b")
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"foo.txt": "foo", "sub/bar.txt": "bar"})
+	if _, err := run(t, filepath.Join(dir, "foo.txt"), "s3://b", "-key", "data/latest.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(s.keys("b"), " "); got != "data/latest.txt" {
+		t.Errorf("uploaded %s, want data/latest.txt", got)
+	}
+	for _, args := range [][]string{
+		{"-key", "data/latest.txt", filepath.Join(dir, "foo.txt"), "s3://b/other.txt"},
+		{"-key", "data/latest.txt", dir, "s3://b"},
+		{"-key", "data/latest.txt", "-content-addressed", filepath.Join(dir, "foo.txt"), "s3://b"},
+	} {
+		if _, err := run(t, args...); exitCode(err) != exitUsage {
+			t.Errorf("%q exited with %d (%v), want %d", args, exitCode(err), err, exitUsage)
+		}
+	}
+	if got := len(s.keys("b")); got != 1 {
+		t.Errorf("refused uploads left %d objects", got)
+	}
+}
+
+func TestUploadWebsiteRedirect(t *testing.T) {
+	s := newFakeS3(t, "b")
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"old": ""})
+	if _, err := run(t, "-website-redirect", "/new", filepath.Join(dir, "old"), "s3://b/old"); err != nil {
+		t.Fatal(err)
+	}
+	if o := s.object("b", "old"); o == nil || o.header.Get("X-Amz-Website-Redirect-Location") != "/new" {
+		t.Errorf("old was uploaded as %+v", o)
+	}
+	if _, err := run(t, filepath.Join(dir, "old"), "s3://b/plain"); err != nil {
+		t.Fatal(err)
+	}
+	if o := s.object("b", "plain"); o == nil || o.header.Get("X-Amz-Website-Redirect-Location") != "" {
+		t.Errorf("plain was uploaded as a redirect: %+v", o)
+	}
+	for _, redirect := range []string{"https://example.com/new", "http://example.com"} {
+		if _, err := run(t, "-website-redirect", redirect, filepath.Join(dir, "old"), "s3://b/old"); err != nil {
+			t.Errorf("-website-redirect %s: %v", redirect, err)
+		}
+	}
+	if _, err := run(t, "-website-redirect", "new", filepath.Join(dir, "old"), "s3://b/old"); exitCode(err) != exitUsage {
+		t.Errorf("-website-redirect new exited with %d (%v), want %d", exitCode(err), err, exitUsage)
+	}
+}
+
+func TestUploadMaxFiles(t *testing.T) {
+	s := newFakeS3(t, "b")
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"a.txt": "a", "b.txt": "b", "sub/c.txt": "c"})
+	_, err := run(t, "-max-files", "2", dir, "s3://b/")
+	if err == nil || !strings.Contains(err.Error(), "3 files, more than -max-files 2") {
+		t.Errorf("just over the limit got %v", err)
+	}
+	if got := len(s.received(http.MethodPut, "")); got != 0 {
+		t.Errorf("uploaded %d files before refusing", got)
+	}
+	if _, err := run(t, "-max-files", "3", dir, "s3://b/"); err != nil {
+		t.Errorf("at the limit: %v", err)
+	}
+	if got := len(s.keys("b")); got != 3 {
+		t.Errorf("uploaded %d files, want 3", got)
+	}
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+// verifyObject checks a local file against an object without
+// downloading it
+func verifyObject(localPath string, target string) error {
+	bucket, key, err := splitNameParts(target)
+	if err != nil {
+		return fmt.Errorf("failed to parse '%s': %w", target, err)
+	}
+	if key == "" {
+		return usageErrorf("'%s' does not specify a key", target)
+	}
+	if err := checkObject(s3.New(createSession()), bucket, key, localPath); err != nil {
+		return err
+	}
+	logSuccess("match: %s and %s", localPath, target)
+	return nil
+}
+
+// checkObject returns an error unless the object matches the
+// file, comparing the size and then either the additional
+// checksum stored with the object or its ETag. The ETag is the
+// MD5 of the data for objects uploaded in one piece, and the MD5
+// of the parts' MD5s for those uploaded in parts, which can only
+// be reproduced knowing the part size. -part-size is tried first,
+// then the size s3manager raises it to for very large files and
+// finally the size most tools would have picked for that number of
+// parts.
+func checkObject(client s3iface.S3API, bucket string, key string, localPath string) error {
+	target := fmt.Sprintf("s3://%s/%s", bucket, key)
+	info, err := os.Stat(localPath)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := jobContext()
+	defer cancel()
+	head, err := client.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket:       aws.String(bucket),
+		Key:          aws.String(key),
+		ChecksumMode: aws.String(s3.ChecksumModeEnabled),
+		RequestPayer: optionalString(requestPayer),
+
+		SSECustomerAlgorithm: sseCustomer.algorithm,
+		SSECustomerKey:       sseCustomer.key,
+		SSECustomerKeyMD5:    sseCustomer.keyMD5,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to stat '%s': %w", target, err)
+	}
+	return compareWithObject(localPath, info, head, target)
+}
+
+// compareWithObject compares a file with the HEAD response of an
+// object, as described for checkObject
+func compareWithObject(localPath string, info os.FileInfo, head *s3.HeadObjectOutput, target string) error {
+	if size := aws.Int64Value(head.ContentLength); size != info.Size() {
+		return mismatchErrorf("'%s' is %d bytes but '%s' is %d", localPath, info.Size(), target, size)
+	}
+
+	if checksum := fullObjectChecksum(head); checksum != nil {
+		if err := checksum.verify(localPath); err != nil {
+			return mismatchErrorf("'%s' and '%s': %v", localPath, target, err)
+		}
+		return nil
+	}
+
+	if aws.StringValue(head.ServerSideEncryption) == s3.ServerSideEncryptionAwsKms ||
+		head.SSECustomerAlgorithm != nil {
+		return fmt.Errorf("cannot verify '%s': the ETag of an object encrypted with KMS or a customer key is not its MD5 and it has no checksum", target)
+	}
+	etag := strings.Trim(aws.StringValue(head.ETag), `"`)
+	parts := 0
+	if i := strings.Index(etag, "-"); i >= 0 {
+		var err error
+		if parts, err = strconv.Atoi(etag[i+1:]); err != nil || parts < 1 {
+			return fmt.Errorf("cannot verify '%s': unrecognized ETag '%s'", target, etag)
+		}
+	}
+	for _, size := range candidatePartSizes(info.Size(), parts) {
+		actual, err := fileETag(localPath, size, parts)
+		if err != nil {
+			return err
+		}
+		if actual == etag {
+			return nil
+		}
+	}
+	if parts > 0 {
+		return mismatchErrorf("'%s' and '%s' (or the object was uploaded with parts of an unusual size, see -part-size)", localPath, target)
+	}
+	return mismatchErrorf("'%s' and '%s'", localPath, target)
+}
+
+// mismatchError is returned by checkObject if the object's data
+// differs from the file, as opposed to it being impossible to tell
+type mismatchError struct {
+	err error
+}
+
+func (e *mismatchError) Error() string {
+	return "mismatch: " + e.err.Error()
+}
+
+// mismatchErrorf formats a mismatchError
+func mismatchErrorf(format string, args ...interface{}) error {
+	return &mismatchError{fmt.Errorf(format, args...)}
+}
+
+// candidatePartSizes returns the part sizes that could have
+// produced an object of the given size in the given number of
+// parts, or a single size of zero if it wasn't uploaded in parts.
+func candidatePartSizes(size int64, parts int) []int64 {
+	if parts == 0 {
+		return []int64{0}
+	}
+	fits := func(partSize int64) bool {
+		return partSize > 0 && (size+partSize-1)/partSize == int64(parts)
+	}
+	var sizes []int64
+	add := func(partSize int64) {
+		if !fits(partSize) {
+			return
+		}
+		for _, s := range sizes {
+			if s == partSize {
+				return
+			}
+		}
+		sizes = append(sizes, partSize)
+	}
+	add(partSize)
+	// Files too large for -part-size are uploaded by s3manager in
+	// the fewest parts it allows, which are of an odd size
+	if partSize > 0 && size/partSize >= s3manager.MaxUploadParts {
+		add(size/s3manager.MaxUploadParts + 1)
+	}
+	// Round up to a whole MiB, as the part size is usually chosen
+	const mib = 1024 * 1024
+	guess := (size + int64(parts) - 1) / int64(parts)
+	add((guess + mib - 1) / mib * mib)
+	return sizes
+}
+
+// fileETag computes the ETag S3 gives a file uploaded in parts of
+// the given size, or in one piece if parts is zero.
+func fileETag(path string, partSize int64, parts int) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if parts == 0 {
+		h := md5.New()
+		if _, err := io.Copy(h, f); err != nil {
+			return "", err
+		}
+		return hex.EncodeToString(h.Sum(nil)), nil
+	}
+	sums := md5.New()
+	for i := 0; i < parts; i++ {
+		h := md5.New()
+		if _, err := io.CopyN(h, f, partSize); err != nil && err != io.EOF {
+			return "", err
+		}
+		sums.Write(h.Sum(nil))
+	}
+	return fmt.Sprintf("%s-%d", hex.EncodeToString(sums.Sum(nil)), parts), nil
+}
+
+// verifyUploads checks every uploaded object against its file
+// once all of them have been uploaded
+var verifyUploads bool
+
+// mismatchRetries is how many times a file whose object doesn't
+// match it is uploaded again, in case it was corrupted in transit
+var mismatchRetries int
+
+// uploadedFile is a file and the key it was uploaded to
+type uploadedFile struct {
+	path string
+	key  string
+}
+
+// verifyUploaded checks the uploaded objects in parallel,
+// reporting every one that doesn't match its file. With
+// -retry-on-checksum-mismatch such files are uploaded again with
+// reupload and checked once more.
+func verifyUploaded(
+	client s3iface.S3API,
+	bucket string,
+	files []uploadedFile,
+	reupload func(f uploadedFile) error,
+) error {
+	pool := newJobPool(parallelism, func(payload interface{}) interface{} {
+		f := payload.(uploadedFile)
+		err := checkObject(client, bucket, f.key, f.path)
+		for i := 0; i < mismatchRetries; i++ {
+			var mismatch *mismatchError
+			if !errors.As(err, &mismatch) {
+				break
+			}
+			logError(fmt.Errorf("uploading '%s' again: %w", f.path, err))
+			if err = reupload(f); err == nil {
+				err = checkObject(client, bucket, f.key, f.path)
+			}
+		}
+		return err
+	})
+	defer pool.Close()
+
+	var (
+		wg     sync.WaitGroup
+		errsMu sync.Mutex
+		errs   []error
+	)
+	for _, f := range files {
+		f := f
+		startJob(&wg, func() {
+			if err, ok := pool.Process(f).(error); ok && err != nil {
+				errsMu.Lock()
+				errs = append(errs, err)
+				errsMu.Unlock()
+			}
+		})
+	}
+	wg.Wait()
+	for _, err := range errs {
+		logError(err)
+	}
+	if len(errs) > 0 {
+		return &batchError{failed: len(errs), total: len(files), what: "verifications"}
+	}
+	if !quiet {
+		fmt.Printf("verified %d objects\n", len(files))
+	}
+	return nil
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCandidatePartSizes(t *testing.T) {
+	defer func(old int64) { partSize = old }(partSize)
+	partSize = 8 << 20
+	const mib = 1 << 20
+	for _, tt := range []struct {
+		size  int64
+		parts int
+		want  []int64
+	}{
+		{100, 0, []int64{0}},
+		{20 * mib, 3, []int64{8 * mib, 7 * mib}},
+		{20 * mib, 4, []int64{5 * mib}},
+		{16 * mib, 2, []int64{8 * mib}},
+	} {
+		got := candidatePartSizes(tt.size, tt.parts)
+		if len(got) != len(tt.want) {
+			t.Errorf("candidatePartSizes(%d, %d) = %v, want %v", tt.size, tt.parts, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("candidatePartSizes(%d, %d) = %v, want %v", tt.size, tt.parts, got, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestFileETag(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a.txt")
+	if err := os.WriteFile(path, []byte("hello world"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := fileETag(path, 0, 0); err != nil || got != "5eb63bbbe01eeed093cb22bb8f5acdc3" {
+		t.Errorf("fileETag of a single part = %q, %v", got, err)
+	}
+	// The MD5 of the MD5s of "hello", " worl" and "d"
+	if got, err := fileETag(path, 5, 3); err != nil || got != "df349a9519959b17a605009540f4b31d-3" {
+		t.Errorf("fileETag of 3 parts = %q, %v", got, err)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	s := newFakeS3(t, "b")
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"a.txt": "hello", "other.txt": "hellO", "short.txt": "hell"})
+	s.put("b", "a.txt", "hello")
+	sum := sha256.Sum256([]byte("hello"))
+	s.put("b", "checksummed.txt", "hello", "X-Amz-Checksum-Sha256", base64.StdEncoding.EncodeToString(sum[:]))
+	multipart, err := fileETag(filepath.Join(dir, "a.txt"), 3, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.put("b", "multipart.txt", "hello")
+	s.object("b", "multipart.txt").etag = `"` + multipart + `"`
+
+	for _, tt := range []struct {
+		file, key string
+		mismatch  bool
+	}{
+		{"a.txt", "a.txt", false},
+		{"other.txt", "a.txt", true},
+		{"short.txt", "a.txt", true},
+		{"a.txt", "checksummed.txt", false},
+		{"other.txt", "checksummed.txt", true},
+		{"other.txt", "multipart.txt", true},
+	} {
+		_, err := run(t, "verify", filepath.Join(dir, tt.file), "s3://b/"+tt.key)
+		var mismatch *mismatchError
+		if tt.mismatch != errors.As(err, &mismatch) || (!tt.mismatch && err != nil) {
+			t.Errorf("verifying %s against %s: %v", tt.file, tt.key, err)
+		}
+	}
+	if _, err := run(t, "verify", "-part-size", "3", filepath.Join(dir, "a.txt"), "s3://b/multipart.txt"); err != nil {
+		t.Errorf("verifying against a multipart ETag: %v", err)
+	}
+	if got := len(s.received(http.MethodGet, "")); got != 0 {
+		t.Errorf("verify downloaded %d times", got)
+	}
+}
+
+func TestUploadVerify(t *testing.T) {
+	s := newFakeS3(t, "b")
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"a.txt": "a", "b.txt": "b", "sub/c.txt": "c"})
+	out, err := run(t, "-verify", dir, "s3://b/backup/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "verified 3 objects") {
+		t.Errorf("printed %q", out)
+	}
+	if got := len(s.received(http.MethodHead, "")); got < 3 {
+		t.Errorf("checked %d objects, want 3", got)
+	}
+
+	s.intercept = func(w http.ResponseWriter, r *http.Request) bool {
+		if r.Method == http.MethodHead && r.URL.Path == "/b/backup/b.txt" {
+			s.mu.Lock()
+			s.buckets["b"]["backup/b.txt"].etag = `"0123456789abcdef0123456789abcdef"`
+			s.mu.Unlock()
+		}
+		return false
+	}
+	stderr := captureStderr(t, func() { _, err = run(t, "-verify", dir, "s3://b/backup/") })
+	var batch *batchError
+	if !errors.As(err, &batch) || batch.failed != 1 || batch.total != 3 {
+		t.Fatalf("got %v, want 1 of 3 verifications failed", err)
+	}
+	if !strings.Contains(stderr, "b.txt") {
+		t.Errorf("the mismatch wasn't reported: %q", stderr)
+	}
+}
+
+func TestUploadRetryOnChecksumMismatch(t *testing.T) {
+	s := newFakeS3(t, "b")
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"a.txt": "a", "b.txt": "b"})
+	corrupted := false
+	s.intercept = func(w http.ResponseWriter, r *http.Request) bool {
+		if r.Method == http.MethodHead && r.URL.Path == "/b/a.txt" && !corrupted {
+			corrupted = true
+			s.mu.Lock()
+			s.buckets["b"]["a.txt"].data = []byte("x")
+			s.buckets["b"]["a.txt"].etag = `"0123456789abcdef0123456789abcdef"`
+			s.mu.Unlock()
+		}
+		return false
+	}
+	if _, err := run(t, "-verify", "-retry-on-checksum-mismatch", "2", dir, "s3://b/"); err != nil {
+		t.Fatal(err)
+	}
+	puts := 0
+	for _, r := range s.received(http.MethodPut, "") {
+		if r.key == "a.txt" {
+			puts++
+		}
+	}
+	if puts != 2 {
+		t.Errorf("a.txt was uploaded %d times, want 2", puts)
+	}
+	if o := s.object("b", "a.txt"); string(o.data) != "a" {
+		t.Errorf("a.txt holds %q", o.data)
+	}
+
+	if _, err := run(t, "-retry-on-checksum-mismatch", "1", dir, "s3://b/"); exitCode(err) != exitUsage {
+		t.Errorf("-retry-on-checksum-mismatch without -verify exited with %d (%v)", exitCode(err), err)
+	}
+}
+
+func TestCandidatePartSizesRaisedBySDK(t *testing.T) {
+	defer func(old int64) { partSize = old }(partSize)
+	partSize = 5 << 20
+	// Too large for 10,000 parts of 5 MiB
+	const size = 60000 << 20
+	got := candidatePartSizes(size, 10000)
+	if len(got) != 2 || got[0] != size/10000+1 || got[1] != 6<<20 {
+		t.Errorf("candidatePartSizes(%d, 10000) = %v", int64(size), got)
+	}
+}
+
+func TestVerifyMultipartUpload(t *testing.T) {
+	s := newFakeS3(t, "b")
+	path := filepath.Join(t.TempDir(), "large.bin")
+	data := make([]byte, 11<<20)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := run(t, "-part-size", "5242880", path, "s3://b/large.bin"); err != nil {
+		t.Fatal(err)
+	}
+	etag, err := fileETag(path, 5<<20, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o := s.object("b", "large.bin"); o == nil || o.etag != `"`+etag+`"` {
+		t.Fatalf("the object's ETag is %+v, want the composite %s", o, etag)
+	}
+	if _, err := run(t, "verify", "-part-size", "5242880", path, "s3://b/large.bin"); err != nil {
+		t.Errorf("verifying the multipart upload: %v", err)
+	}
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// Build information, injected at build time with e.g.
+//
+//	go build -ldflags "-X main.version=v1.0.0 -X main.commit=$(git rev-parse --short HEAD) -X main.date=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+var (
+	version = "dev"
+	commit  = "unknown"
+	date    = "unknown"
+)
+
+// showVersion is set by the -version flag
+var showVersion bool
+
+func printVersion() {
+	fmt.Printf("s3util %s (commit %s, built %s, aws-sdk-go %s)\n", version, commit, date, aws.SDKVersion)
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestVersionFlag(t *testing.T) {
+	for _, args := range [][]string{
+		{"version"},
+		{"-version", "ls", "s3://mybucket"},
+	} {
+		out, err := run(t, args...)
+		if err != nil {
+			t.Fatalf("%q: %v", args, err)
+		}
+		if !strings.HasPrefix(out, "s3util "+version+" (commit "+commit) {
+			t.Errorf("%q printed %q", args, out)
+		}
+	}
+}